@@ -67,3 +67,60 @@ func CPOST(category uint8, relativePath string, handler ...*gin.HandlerFunc) err
 		return ErrInvalidCategory
 	}
 }
+
+func AuthedCPUT(category uint8, relativePath, userGroup string, handler ...*gin.HandlerFunc) error {
+	acFuncs, acErr := getAccessControlFunc(userGroup)
+	if acErr != nil {
+		return acErr
+	}
+
+	return CPUT(category, relativePath, append(acFuncs, handler...)...)
+}
+
+// CPUT() stands for Categorized PUT
+// Not validating the authentication header.
+func CPUT(category uint8, relativePath string, handler ...*gin.HandlerFunc) error {
+	if category, exist := availableCategories[category]; exist {
+		return put(category+relativePath, handler...)
+	} else {
+		return ErrInvalidCategory
+	}
+}
+
+func AuthedCPATCH(category uint8, relativePath, userGroup string, handler ...*gin.HandlerFunc) error {
+	acFuncs, acErr := getAccessControlFunc(userGroup)
+	if acErr != nil {
+		return acErr
+	}
+
+	return CPATCH(category, relativePath, append(acFuncs, handler...)...)
+}
+
+// CPATCH() stands for Categorized PATCH
+// Not validating the authentication header.
+func CPATCH(category uint8, relativePath string, handler ...*gin.HandlerFunc) error {
+	if category, exist := availableCategories[category]; exist {
+		return patch(category+relativePath, handler...)
+	} else {
+		return ErrInvalidCategory
+	}
+}
+
+func AuthedCDELETE(category uint8, relativePath, userGroup string, handler ...*gin.HandlerFunc) error {
+	acFuncs, acErr := getAccessControlFunc(userGroup)
+	if acErr != nil {
+		return acErr
+	}
+
+	return CDELETE(category, relativePath, append(acFuncs, handler...)...)
+}
+
+// CDELETE() stands for Categorized DELETE
+// Not validating the authentication header.
+func CDELETE(category uint8, relativePath string, handler ...*gin.HandlerFunc) error {
+	if category, exist := availableCategories[category]; exist {
+		return del(category+relativePath, handler...)
+	} else {
+		return ErrInvalidCategory
+	}
+}
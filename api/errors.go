@@ -12,6 +12,10 @@ var (
 	ErrRepeatGetPath  error = errors.New("api: repeated path for GET method")
 	ErrRepeatPostPath error = errors.New("api: repeated path for POST method")
 
+	ErrRepeatPutPath    error = errors.New("api: repeated path for PUT method")
+	ErrRepeatPatchPath  error = errors.New("api: repeated path for PATCH method")
+	ErrRepeatDeletePath error = errors.New("api: repeated path for DELETE method")
+
 	ErrUnknownUserGroup error = errors.New("api: usergroup has no known access control function")
 
 	ErrInvalidUserGroup          error = errors.New("api: invalid usergroup")
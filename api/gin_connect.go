@@ -43,6 +43,30 @@ func FinalizeGinEngine(router *gin.Engine, pathPrefix string) {
 		router.POST(pathPrefix+path, sliceHandler...)
 	}
 
+	for path, handlers := range mapPut {
+		sliceHandler := []gin.HandlerFunc{}
+		for _, handler := range handlers {
+			sliceHandler = append(sliceHandler, *handler)
+		}
+		router.PUT(pathPrefix+path, sliceHandler...)
+	}
+
+	for path, handlers := range mapPatch {
+		sliceHandler := []gin.HandlerFunc{}
+		for _, handler := range handlers {
+			sliceHandler = append(sliceHandler, *handler)
+		}
+		router.PATCH(pathPrefix+path, sliceHandler...)
+	}
+
+	for path, handlers := range mapDelete {
+		sliceHandler := []gin.HandlerFunc{}
+		for _, handler := range handlers {
+			sliceHandler = append(sliceHandler, *handler)
+		}
+		router.DELETE(pathPrefix+path, sliceHandler...)
+	}
+
 	// TODO: Clean up
 	router.GET(pathPrefix+"internal/response", func(c *gin.Context) {
 		cmd := c.Query("cmd")
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// Keys in gin.Context set by AcceptImpersonation while impersonating.
+// ContextKeyUserID is then the target, and ContextKeySessionID is bound to
+// the impersonation session, so that the admin's step-up tokens do not apply.
+const (
+	ContextKeyImpersonatorID = "ulysses_impersonator_id" // uint64
+	ContextKeyImpersonation  = "ulysses_impersonation"   // *auth.Impersonation
+)
+
+// HeaderImpersonationToken carries the token returned by auth.StartImpersonation()
+const HeaderImpersonationToken = "X-Impersonation-Token"
+
+// AcceptImpersonation creates an Access Control Func letting a GLOBAL_ADMIN act as
+// another user with an impersonation token. It should be appended after the Access
+// Control Funcs setting ContextKeyUserID and ContextKeySessionID, and before the
+// ones relying on them.
+//
+// Requests of read-only sessions may only be GET, HEAD or OPTIONS. Every request is
+// recorded in the audit trail, and refused if it cannot be.
+//
+// e.g.: api.AppendAccessControlFuncs("user", &userFunc, api.AcceptImpersonation())
+func AcceptImpersonation() *gin.HandlerFunc {
+	var acFunc gin.HandlerFunc = func(c *gin.Context) {
+		token := c.GetHeader(HeaderImpersonationToken)
+		if token == "" {
+			return
+		}
+		adminUserID := c.GetUint64(ContextKeyUserID)
+		if adminUserID == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, MessageResponse(ERROR, "AUTH_FAILED"))
+			return
+		}
+
+		impersonation, err := auth.VerifyImpersonation(adminUserID, token)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, MessageResponse(ERROR, "IMPERSONATION_INVALID"))
+			return
+		}
+
+		err = auth.RecordImpersonatedRequest(impersonation, c.ClientIP(), c.Request.UserAgent(), map[string]interface{}{
+			"method":    c.Request.Method,
+			"path":      c.Request.URL.Path,
+			"read_only": impersonation.ReadOnly,
+		})
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, MessageResponse(ERROR, "INTERNAL_ERROR"))
+			return
+		}
+		safeMethod := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.Request.Method == http.MethodOptions
+		if impersonation.ReadOnly && !safeMethod {
+			c.AbortWithStatusJSON(http.StatusForbidden, MessageResponse(ERROR, "IMPERSONATION_READ_ONLY"))
+			return
+		}
+
+		c.Set(ContextKeyUserID, impersonation.TargetUserID)
+		c.Set(ContextKeySessionID, "impersonation/"+impersonation.ID)
+		c.Set(ContextKeyImpersonatorID, impersonation.AdminUserID)
+		c.Set(ContextKeyImpersonation, impersonation)
+	}
+	return &acFunc
+}
+
+// DenyImpersonation creates an Access Control Func refusing requests made with an
+// impersonation token, for sensitive actions such as wallet spending or MFA changes.
+// The token is refused even where AcceptImpersonation is not used, as the admin
+// would otherwise act as themselves.
+//
+// e.g.: api.AppendAccessControlFuncs("mfa", &userFunc, api.DenyImpersonation())
+func DenyImpersonation() *gin.HandlerFunc {
+	var acFunc gin.HandlerFunc = func(c *gin.Context) {
+		if Impersonated(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, MessageResponse(ERROR, "IMPERSONATION_FORBIDDEN"))
+			return
+		}
+	}
+	return &acFunc
+}
+
+// Impersonated reports whether the request is made with an impersonation token.
+func Impersonated(c *gin.Context) bool {
+	_, exists := c.Get(ContextKeyImpersonation)
+	return exists || c.GetHeader(HeaderImpersonationToken) != ""
+}
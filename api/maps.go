@@ -10,4 +10,9 @@ var (
 	mapMutex sync.RWMutex                  = sync.RWMutex{}
 	mapPost  map[string][]*gin.HandlerFunc = map[string][]*gin.HandlerFunc{}
 	mapGet   map[string][]*gin.HandlerFunc = map[string][]*gin.HandlerFunc{}
+
+	// PUT, PATCH and DELETE are for protocols requiring them, e.g. SCIM
+	mapPut    map[string][]*gin.HandlerFunc = map[string][]*gin.HandlerFunc{}
+	mapPatch  map[string][]*gin.HandlerFunc = map[string][]*gin.HandlerFunc{}
+	mapDelete map[string][]*gin.HandlerFunc = map[string][]*gin.HandlerFunc{}
 )
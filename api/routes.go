@@ -27,3 +27,42 @@ func post(relativePath string, handler ...*gin.HandlerFunc) error {
 		return nil
 	}
 }
+
+// Warning: this function by-default registers routes which have no AUTHORIZATION
+func put(relativePath string, handler ...*gin.HandlerFunc) error {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+
+	if _, conflict := mapPut[relativePath]; conflict {
+		return ErrRepeatPutPath
+	} else {
+		mapPut[relativePath] = handler
+		return nil
+	}
+}
+
+// Warning: this function by-default registers routes which have no AUTHORIZATION
+func patch(relativePath string, handler ...*gin.HandlerFunc) error {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+
+	if _, conflict := mapPatch[relativePath]; conflict {
+		return ErrRepeatPatchPath
+	} else {
+		mapPatch[relativePath] = handler
+		return nil
+	}
+}
+
+// Warning: this function by-default registers routes which have no AUTHORIZATION
+func del(relativePath string, handler ...*gin.HandlerFunc) error {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+
+	if _, conflict := mapDelete[relativePath]; conflict {
+		return ErrRepeatDeletePath
+	} else {
+		mapDelete[relativePath] = handler
+		return nil
+	}
+}
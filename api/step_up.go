@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// Keys in gin.Context to be set by the authenticating Access Control Funcs,
+// before any Access Control Func relying on them.
+const (
+	ContextKeyUserID    = "ulysses_user_id"    // uint64
+	ContextKeySessionID = "ulysses_session_id" // string
+)
+
+// HeaderStepUpToken carries the token returned by auth.MFASubmitStepUpChallenge()
+const HeaderStepUpToken = "X-Step-Up-Token"
+
+// RequireStepUp creates an Access Control Func requiring a step-up token covering the scope,
+// issued no earlier than maxAge ago. It should be appended after the Access Control Funcs
+// setting ContextKeyUserID and ContextKeySessionID.
+//
+// Requests made with an impersonation token are refused: sensitive actions
+// are not taken on behalf of another user.
+//
+// e.g.: api.AppendAccessControlFuncs("wallet_admin", &userFunc, api.RequireStepUp(auth.STEPUP_WALLET, 5*time.Minute))
+func RequireStepUp(scope string, maxAge time.Duration) *gin.HandlerFunc {
+	var acFunc gin.HandlerFunc = func(c *gin.Context) {
+		if Impersonated(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, MessageResponse(ERROR, "IMPERSONATION_FORBIDDEN"))
+			return
+		}
+
+		userID := c.GetUint64(ContextKeyUserID)
+		sessionID := c.GetString(ContextKeySessionID)
+		if userID == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, MessageResponse(ERROR, "AUTH_FAILED"))
+			return
+		}
+
+		token := c.GetHeader(HeaderStepUpToken)
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, MessageResponse(ERROR, "STEP_UP_REQUIRED"))
+			return
+		}
+
+		if auth.VerifyStepUp(userID, sessionID, token, scope, maxAge) != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, MessageResponse(ERROR, "STEP_UP_REQUIRED"))
+			return
+		}
+	}
+	return &acFunc
+}
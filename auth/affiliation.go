@@ -15,6 +15,17 @@ var (
 	ErrAffiliationCountryISOEmpty     = errors.New("auth: affiliation country iso is empty")
 	ErrAffiliationZipCodeEmpty        = errors.New("auth: affiliation zip code is empty")
 	ErrAffiliationContactEmailEmpty   = errors.New("auth: affiliation contact email is empty")
+	ErrAffiliationParentNotFound      = errors.New("auth: affiliation parent does not exist")
+	ErrAffiliationCycle               = errors.New("auth: affiliation parent would create a cycle")
+	ErrAffiliationTooDeep             = errors.New("auth: affiliation parent would make the tree too deep")
+	ErrAffiliationMoveNotPermitted    = errors.New("auth: actor may not move the affiliation between the parents")
+	ErrAffiliationDeletePolicyUnknown = errors.New("auth: unknown policy for children of deleted affiliation")
+)
+
+// Policies for children when deleting an affiliation
+const (
+	AFFILIATION_DELETE_REPARENT uint8 = iota + 1 // children are moved to the parent of the deleted affiliation
+	AFFILIATION_DELETE_CASCADE                   // all descendants are deleted as well
 )
 
 type Affiliation struct {
@@ -32,8 +43,12 @@ type Affiliation struct {
 	ContactEmail   string
 }
 
+func (affiliation *Affiliation) ID() uint64 {
+	return affiliation.id
+}
+
 func GetAffiliationByID(id uint64) (*Affiliation, error) {
-	return getAffiliationByID(id)
+	return store.GetAffiliationByID(id)
 }
 
 func CreateAffiliation(affiliation *Affiliation) error {
@@ -65,10 +80,14 @@ func CreateAffiliation(affiliation *Affiliation) error {
 	if affiliation.ContactEmail == "" {
 		return ErrAffiliationContactEmailEmpty
 	}
+	if err := affiliation.validateParent(affiliation.ParentID); err != nil {
+		return err
+	}
 
-	return newAffiliation(affiliation)
+	return store.NewAffiliation(affiliation)
 }
 
+// UpdateAffiliation saves all fields but ParentID: use Move instead.
 func (affiliation *Affiliation) UpdateAffiliation() error {
 	// Check if all fields are valid
 	if affiliation.Name == "" {
@@ -98,14 +117,113 @@ func (affiliation *Affiliation) UpdateAffiliation() error {
 	if affiliation.ContactEmail == "" {
 		return ErrAffiliationContactEmailEmpty
 	}
-
-	return updateAffiliation(affiliation)
+	return store.UpdateAffiliation(affiliation)
 }
 
 func (affiliation *Affiliation) ParentAffiliation() (*Affiliation, error) {
-	affiliation, err := getAffiliationByID(affiliation.ParentID)
+	affiliation, err := store.GetAffiliationByID(affiliation.ParentID)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	return affiliation, err
 }
+
+func (affiliation *Affiliation) ChildAffiliations() ([]*Affiliation, error) {
+	return store.ListChildAffiliations(affiliation.id)
+}
+
+// Descendants lists all affiliations under the affiliation, closer ones first.
+func (affiliation *Affiliation) Descendants() ([]*Affiliation, error) {
+	return store.ListDescendantAffiliations(affiliation.id)
+}
+
+// Ancestors lists all affiliations above the affiliation, from the parent to the root.
+func (affiliation *Affiliation) Ancestors() ([]*Affiliation, error) {
+	return store.ListAncestorAffiliations(affiliation.id)
+}
+
+// Move sets a new parent for the affiliation. 0 makes it a root affiliation.
+// The new parent must exist and be neither the affiliation nor one of its
+// descendants, which the store checks atomically with the move.
+//
+// The actor must manage both the old and the new parent (see CanManage), so
+// only GLOBAL_ADMIN may move an affiliation from or to the root.
+func (affiliation *Affiliation) Move(actor *User, newParentID uint64) error {
+	current, err := store.GetAffiliationByID(affiliation.id)
+	if err != nil {
+		return err
+	}
+	for _, parentID := range []uint64{current.ParentID, newParentID} {
+		canManage, err := CanManage(actor, &User{AffiliationID: parentID})
+		if err != nil {
+			return err
+		}
+		if !canManage {
+			return ErrAffiliationMoveNotPermitted
+		}
+	}
+
+	oldParentID, err := store.MoveAffiliation(affiliation.id, newParentID)
+	if err != nil {
+		return err
+	}
+	affiliation.ParentID = newParentID
+
+	return auditAffiliation(AUDIT_AFFILIATION_MOVE, actor.id, affiliation.id, map[string]interface{}{"old_parent_id": oldParentID, "new_parent_id": newParentID})
+}
+
+// Delete removes the affiliation. childrenPolicy decides what happens to the children:
+//   - AFFILIATION_DELETE_REPARENT moves them to the parent of the deleted affiliation
+//   - AFFILIATION_DELETE_CASCADE deletes all descendants as well
+//
+// Members of any deleted affiliation are detached from it, losing all AFFILIATION_* roles,
+// including temporary grants.
+func (affiliation *Affiliation) Delete(childrenPolicy uint8) error {
+	affiliationIDs := []uint64{affiliation.id}
+	var reparentTo *uint64
+	switch childrenPolicy {
+	case AFFILIATION_DELETE_REPARENT:
+		reparentTo = &affiliation.ParentID
+	case AFFILIATION_DELETE_CASCADE:
+		descendants, err := affiliation.Descendants()
+		if err != nil {
+			return err
+		}
+		for _, descendant := range descendants {
+			affiliationIDs = append(affiliationIDs, descendant.id)
+		}
+	default:
+		return ErrAffiliationDeletePolicyUnknown
+	}
+
+	for _, affiliationID := range affiliationIDs {
+		memberIDs, err := store.ListUserIDByAffiliationID(affiliationID)
+		if err != nil {
+			return err
+		}
+		for _, memberID := range memberIDs {
+			member := &User{id: memberID}
+			if err = member.revokeTemporaryGrants(0, AFFILIATION_ROLES, "affiliation deleted"); err != nil {
+				return err
+			}
+		}
+	}
+	return store.DeleteAffiliations(affiliationIDs, reparentTo)
+}
+
+// validateParent checks that the parent of a new affiliation exists.
+// Moves are checked by the store, see Move.
+func (affiliation *Affiliation) validateParent(parentID uint64) error {
+	if parentID == 0 {
+		return nil // root
+	}
+
+	exist, err := store.AffiliationExists(parentID)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return ErrAffiliationParentNotFound
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Security-relevant events are recorded in the audit trail. Events raised
+// inside this package carry the IP where it is known (e.g. VerifyLogin) but
+// never the user agent: the API layer may record its own events with
+// RecordAuditEvent to include both.
+//
+// PII in the audit trail is limited in time: IPs and user agents are cleared
+// after AuditIPRetention by ScrubAuditIPs, and erasing a user scrubs them from
+// the events by or about the user along with the keys of Detail registered
+// with RegAuditPIIDetailKey.
+
+// Audit event types
+const (
+	AUDIT_LOGIN                = "login"
+	AUDIT_LOGIN_FAILED         = "login_failed"
+	AUDIT_MFA_CHALLENGE        = "mfa_challenge"
+	AUDIT_MFA_FAILED           = "mfa_failed"
+	AUDIT_MFA_ENROLL           = "mfa_enroll"
+	AUDIT_MFA_REMOVE           = "mfa_remove"
+	AUDIT_LOCKOUT              = "lockout"
+	AUDIT_ROLE_CHANGE          = "role_change"
+	AUDIT_ROLE_GRANT           = "role_grant"
+	AUDIT_ROLE_GRANT_REVOKE    = "role_grant_revoke"
+	AUDIT_KEY_ADD              = "key_add"
+	AUDIT_KEY_REVOKE           = "key_revoke"
+	AUDIT_EMAIL_CHANGE         = "email_change"
+	AUDIT_AFFILIATION_MOVE     = "affiliation_move"
+	AUDIT_CUSTOM_ROLE_CREATE   = "custom_role_create"
+	AUDIT_CUSTOM_ROLE_UPDATE   = "custom_role_update"
+	AUDIT_CUSTOM_ROLE_DELETE   = "custom_role_delete"
+	AUDIT_CUSTOM_ROLE_ASSIGN   = "custom_role_assign"
+	AUDIT_CUSTOM_ROLE_UNASSIGN = "custom_role_unassign"
+	AUDIT_DEACTIVATE           = "deactivate"
+	AUDIT_REACTIVATE           = "reactivate"
+	AUDIT_ERASE                = "erase"
+	AUDIT_IDENTITY_LINK        = "identity_link"
+	AUDIT_IDENTITY_UNLINK      = "identity_unlink"
+
+	// The actor is the admin and the subject is the target
+	AUDIT_IMPERSONATION_START   = "impersonation_start"
+	AUDIT_IMPERSONATION_END     = "impersonation_end"
+	AUDIT_IMPERSONATION_REQUEST = "impersonation_request"
+)
+
+const (
+	DefaultAuditQueryLimit = 100
+)
+
+var (
+	AuditIPRetention = 180 * 24 * time.Hour // 0 to keep forever
+
+	// auditPIIDetailKeys are the keys of AuditEvent.Detail holding PII, which
+	// are removed from the events by or about a user when the user is erased.
+	auditPIIMutex      sync.RWMutex = sync.RWMutex{}
+	auditPIIDetailKeys []string     = []string{"email", "old_email", "new_email"}
+)
+
+// RegAuditPIIDetailKey registers a key of AuditEvent.Detail holding PII, e.g.
+// in events recorded by the API layer, to be removed on erasure.
+func RegAuditPIIDetailKey(key string) {
+	auditPIIMutex.Lock()
+	defer auditPIIMutex.Unlock()
+
+	for _, k := range auditPIIDetailKeys {
+		if k == key {
+			return
+		}
+	}
+	auditPIIDetailKeys = append(auditPIIDetailKeys, key)
+}
+
+func getAuditPIIDetailKeys() []string {
+	auditPIIMutex.RLock()
+	defer auditPIIMutex.RUnlock()
+
+	return append([]string{}, auditPIIDetailKeys...)
+}
+
+// scrubAuditDetail removes piiKeys from the detail of an event. It reports
+// false if there were none, or if the detail is not a JSON object.
+func scrubAuditDetail(detail []byte, piiKeys []string) ([]byte, bool, error) {
+	var fields map[string]interface{}
+	if json.Unmarshal(detail, &fields) != nil {
+		return detail, false, nil
+	}
+	found := false
+	for _, key := range piiKeys {
+		if _, ok := fields[key]; ok {
+			delete(fields, key)
+			found = true
+		}
+	}
+	if !found {
+		return detail, false, nil
+	}
+	scrubbed, err := json.Marshal(fields)
+	return scrubbed, err == nil, err
+}
+
+// ScrubAuditIPs clears the IP and the user agent of the events older than
+// AuditIPRetention. It should be called at a daily basis.
+func ScrubAuditIPs() error {
+	if AuditIPRetention == 0 {
+		return nil
+	}
+	return store.ScrubAuditIPs(time.Now().Add(-AuditIPRetention))
+}
+
+type AuditEvent struct {
+	id            uint64
+	EventType     string          `json:"event_type"`
+	ActorUserID   uint64          `json:"actor"`   // 0 if unknown or the system
+	SubjectUserID uint64          `json:"subject"` // 0 if not about a user, e.g. AUDIT_AFFILIATION_MOVE
+	AffiliationID uint64          `json:"affiliation"`
+	IP            string          `json:"ip"`
+	UserAgent     string          `json:"user_agent"`
+	Detail        json.RawMessage `json:"detail"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+func (event *AuditEvent) ID() uint64 {
+	return event.id
+}
+
+// AuditFilter selects audit events. Zero fields are ignored.
+type AuditFilter struct {
+	ActorUserID   uint64
+	SubjectUserID uint64
+	AffiliationID uint64
+	EventTypes    []string
+	Since         time.Time // inclusive
+	Until         time.Time // exclusive
+	Limit         uint      // DefaultAuditQueryLimit if 0
+	Offset        uint
+}
+
+// RecordAuditEvent saves the event. If CreatedAt is zero, it is set to now.
+// If AffiliationID is zero, it is set to the affiliation of the subject.
+func RecordAuditEvent(event *AuditEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	if len(event.Detail) == 0 {
+		event.Detail = json.RawMessage("{}")
+	}
+	if event.AffiliationID == 0 && event.SubjectUserID != 0 {
+		subject, err := store.GetUserByID(event.SubjectUserID)
+		if err == nil {
+			event.AffiliationID = subject.AffiliationID
+		}
+	}
+	return store.NewAuditEvent(event)
+}
+
+// audit records an event raised inside this package.
+func audit(eventType string, actorUserID, subjectUserID uint64, ip string, detail map[string]interface{}) error {
+	detailJson, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	return RecordAuditEvent(&AuditEvent{
+		EventType:     eventType,
+		ActorUserID:   actorUserID,
+		SubjectUserID: subjectUserID,
+		IP:            ip,
+		Detail:        detailJson,
+	})
+}
+
+// auditAffiliation records an event about an affiliation rather than a user.
+func auditAffiliation(eventType string, actorUserID, affiliationID uint64, detail map[string]interface{}) error {
+	detailJson, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	return RecordAuditEvent(&AuditEvent{
+		EventType:     eventType,
+		ActorUserID:   actorUserID,
+		AffiliationID: affiliationID,
+		Detail:        detailJson,
+	})
+}
+
+// QueryAuditEvents lists matching events, latest first.
+func QueryAuditEvents(filter AuditFilter) ([]*AuditEvent, error) {
+	if filter.Limit == 0 {
+		filter.Limit = DefaultAuditQueryLimit
+	}
+	return store.QueryAuditEvents(filter)
+}
+
+// SecurityHistory lists the events about the user, latest first.
+// It is meant to be shown to the user themselves.
+func (user *User) SecurityHistory(since time.Time, limit, offset uint) ([]*AuditEvent, error) {
+	return QueryAuditEvents(AuditFilter{
+		SubjectUserID: user.id,
+		Since:         since,
+		Limit:         limit,
+		Offset:        offset,
+	})
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+const testIP = "192.0.2.1"
+
+func setupMemoryStore(t *testing.T) {
+	if err := Setup(NewMemoryStore()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestUser creates a user with a fresh key, and verifies its email.
+func newTestUser(t *testing.T, email string, role Role, affiliationID uint64) (*User, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{
+		Email:         email,
+		PublicKey:     base64.StdEncoding.EncodeToString(pub),
+		Role:          role,
+		AffiliationID: affiliationID,
+	}
+	if err = user.Create(); err != nil {
+		t.Fatalf("Create %s: %v", email, err)
+	}
+	token, err := user.BeginEmailVerification()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = user.VerifyEmail(token); err != nil {
+		t.Fatal(err)
+	}
+	return user, priv
+}
+
+func sign(t *testing.T, priv ed25519.PrivateKey, msg string) string {
+	signature, err := signer.Sign(msg, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+func newTestAffiliation(t *testing.T, name string, owner *User, parentID uint64) *Affiliation {
+	affiliation := &Affiliation{
+		Name:           name,
+		ParentID:       parentID,
+		OwnerUserID:    owner.id,
+		SharedWalletID: 1,
+		StreetAddress:  "1 Example Street",
+		City:           "Example",
+		State:          "EX",
+		CountryISO:     "US",
+		ZipCode:        "00000",
+		ContactEmail:   "contact@example.com",
+	}
+	if err := CreateAffiliation(affiliation); err != nil {
+		t.Fatal(err)
+	}
+	return affiliation
+}
+
+func TestUserCreateUpdate(t *testing.T) {
+	setupMemoryStore(t)
+	user, _ := newTestUser(t, "alice@example.com", GLOBAL_PRODUCTION_USER, 0)
+
+	if err := (&User{Email: "alice@example.com"}).Create(); err != ErrEmailExists {
+		t.Errorf("duplicate: got %v, want %v", err, ErrEmailExists)
+	}
+
+	// Role is not saved by Update
+	user.Role = GLOBAL_ADMIN
+	if err := user.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != GLOBAL_PRODUCTION_USER {
+		t.Errorf("Role not reloaded: %v", user.Role)
+	}
+	stored, err := GetUserByID(user.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Role != GLOBAL_PRODUCTION_USER || stored.Email != "alice@example.com" {
+		t.Errorf("unexpected stored user %+v", stored)
+	}
+
+	// AffiliationID may not change while holding affiliation roles
+	member, _ := newTestUser(t, "bob@example.com", AFFILIATION_ACCOUNT_USER, 3)
+	member.AffiliationID = 4
+	if err = member.Update(); err != ErrAffiliationRolesHeld {
+		t.Errorf("affiliation change: got %v, want %v", err, ErrAffiliationRolesHeld)
+	}
+}
+
+func TestVerifyLogin(t *testing.T) {
+	setupMemoryStore(t)
+	user, priv := newTestUser(t, "alice@example.com", GLOBAL_PRODUCTION_USER, 0)
+
+	result, err := user.VerifyLogin("challenge", sign(t, priv, "challenge"), testIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.User.id != user.id || result.Key == nil || result.Key.PublicKey != user.PublicKey {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	if _, err = user.VerifyLogin("challenge", sign(t, priv, "another"), testIP); err != ErrUserKeyNoMatch {
+		t.Errorf("bad signature: got %v, want %v", err, ErrUserKeyNoMatch)
+	}
+	// the failure delays the next attempt
+	if _, err = user.VerifyLogin("challenge", sign(t, priv, "challenge"), testIP); err != ErrBackoff {
+		t.Errorf("after failure: got %v, want %v", err, ErrBackoff)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	setupMemoryStore(t)
+	defaultPolicy := GetLockoutPolicy()
+	defer SetLockoutPolicy(defaultPolicy)
+	policy := defaultPolicy
+	policy.MaxFailures = 3
+	if err := SetLockoutPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []LockoutEvent
+	RegLockoutHandler(func(event LockoutEvent) {
+		events = append(events, event)
+	})
+	for i := 0; i < 3; i++ {
+		if err := RecordAuthFailure(1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CheckLockout(1, testIP); err != ErrLockedOut {
+		t.Fatalf("got %v, want %v", err, ErrLockedOut)
+	}
+	if len(events) != 1 || events[0].UserID != 1 || events[0].UnlockToken == "" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	if err := UnlockUserByToken(1, events[0].UnlockToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckLockout(1, testIP); err != nil {
+		t.Errorf("after unlock: %v", err)
+	}
+	if err := UnlockUserByToken(1, events[0].UnlockToken); err != ErrUnlockTokenBad {
+		t.Errorf("token reused: got %v, want %v", err, ErrUnlockTokenBad)
+	}
+}
+
+func TestInvitationAccept(t *testing.T) {
+	setupMemoryStore(t)
+	owner, _ := newTestUser(t, "owner@example.com", GLOBAL_PRODUCTION_USER, 0)
+	affiliation := newTestAffiliation(t, "Example", owner, 0)
+	admin, _ := newTestUser(t, "admin@example.com", AFFILIATION_ACCOUNT_ADMIN|AFFILIATION_ACCOUNT_USER, affiliation.id)
+	invitee, _ := newTestUser(t, "carol@example.com", GLOBAL_PRODUCTION_USER, 0)
+
+	if _, err := affiliation.Invite(admin, invitee.Email, AFFILIATION_BILLING_USER); err != ErrInvitationNotPermitted {
+		t.Errorf("role not held: got %v, want %v", err, ErrInvitationNotPermitted)
+	}
+	invitation, err := affiliation.Invite(admin, invitee.Email, AFFILIATION_ACCOUNT_USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := GetAffiliationInvitationByToken(invitation.Token())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := newTestUser(t, "mallory@example.com", GLOBAL_PRODUCTION_USER, 0)
+	if err = found.Accept(other); err != ErrInvitationEmailMismatch {
+		t.Errorf("other user: got %v, want %v", err, ErrInvitationEmailMismatch)
+	}
+	if err = found.Accept(invitee); err != nil {
+		t.Fatal(err)
+	}
+	if invitee.AffiliationID != affiliation.id || invitee.Role != GLOBAL_PRODUCTION_USER|AFFILIATION_ACCOUNT_USER {
+		t.Errorf("unexpected invitee %+v", invitee)
+	}
+	if err = found.Accept(invitee); err != ErrInvitationNotPending {
+		t.Errorf("accepted twice: got %v, want %v", err, ErrInvitationNotPending)
+	}
+}
+
+func TestAffiliationMove(t *testing.T) {
+	setupMemoryStore(t)
+	admin, _ := newTestUser(t, "admin@example.com", GLOBAL_ADMIN, 0)
+	root := newTestAffiliation(t, "Root", admin, 0)
+	child := newTestAffiliation(t, "Child", admin, root.id)
+	grandchild := newTestAffiliation(t, "Grandchild", admin, child.id)
+
+	if err := root.Move(admin, grandchild.id); err != ErrAffiliationCycle {
+		t.Errorf("under descendant: got %v, want %v", err, ErrAffiliationCycle)
+	}
+	if err := grandchild.Move(admin, root.id); err != nil {
+		t.Fatal(err)
+	}
+	children, err := root.ChildAffiliations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 2 {
+		t.Errorf("got %d children, want 2", len(children))
+	}
+
+	member, _ := newTestUser(t, "bob@example.com", AFFILIATION_ACCOUNT_ADMIN, child.id)
+	if err = grandchild.Move(member, child.id); err != ErrAffiliationMoveNotPermitted {
+		t.Errorf("member of one parent: got %v, want %v", err, ErrAffiliationMoveNotPermitted)
+	}
+}
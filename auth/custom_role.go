@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// A CustomRole is an admin-defined named set of registered permissions,
+// assigned to users or to affiliations (applying to all their users).
+// Custom roles complement the built-in Role bits, never replace them.
+//
+// Only GLOBAL_ADMIN may define custom roles. They are assigned by GLOBAL_ADMIN,
+// or by an AFFILIATION_ACCOUNT_ADMIN managing the user or the affiliation
+// (see CanManage) who holds all permissions of the custom role. Every change
+// is recorded in the audit trail.
+
+var (
+	ErrCustomRoleNameEmpty        = errors.New("auth: custom role name is empty")
+	ErrCustomRoleNotPermitted     = errors.New("auth: actor may not define custom roles")
+	ErrCustomRoleAssignNotAllowed = errors.New("auth: actor may not assign the custom role")
+)
+
+type CustomRole struct {
+	id          uint64
+	Name        string       `json:"name"` // unique
+	Description string       `json:"description"`
+	Permissions []Permission `json:"permissions"`
+}
+
+func (customRole *CustomRole) ID() uint64 {
+	return customRole.id
+}
+
+func NewCustomRole(actor *User, name, description string, permissions []Permission) (*CustomRole, error) {
+	if err := checkCustomRoleActor(actor); err != nil {
+		return nil, err
+	}
+	customRole := &CustomRole{
+		Name:        name,
+		Description: description,
+		Permissions: permissions,
+	}
+	if err := customRole.validate(); err != nil {
+		return nil, err
+	}
+
+	err := store.NewCustomRole(customRole)
+	if err != nil {
+		return nil, err
+	}
+	return customRole, customRole.audit(AUDIT_CUSTOM_ROLE_CREATE, actor, 0, 0)
+}
+
+func GetCustomRoleByID(customRoleID uint64) (*CustomRole, error) {
+	return store.GetCustomRoleByID(customRoleID)
+}
+
+func GetCustomRoleByName(name string) (*CustomRole, error) {
+	return store.GetCustomRoleByName(name)
+}
+
+func ListCustomRoles() ([]*CustomRole, error) {
+	return store.ListCustomRoles()
+}
+
+func (customRole *CustomRole) validate() error {
+	if customRole.Name == "" {
+		return ErrCustomRoleNameEmpty
+	}
+	if customRole.Permissions == nil {
+		customRole.Permissions = []Permission{}
+	}
+	return checkPermissionsRegistered(customRole.Permissions)
+}
+
+// checkCustomRoleActor allows GLOBAL_ADMIN only.
+func checkCustomRoleActor(actor *User) error {
+	role, err := actor.EffectiveRole()
+	if err != nil {
+		return err
+	}
+	if !role.Includes(GLOBAL_ADMIN) {
+		return ErrCustomRoleNotPermitted
+	}
+	return nil
+}
+
+// checkAssigner checks that actor may manage target and holds all permissions
+// of the custom role, unless they are GLOBAL_ADMIN.
+func (customRole *CustomRole) checkAssigner(actor, target *User) error {
+	canManage, err := CanManage(actor, target)
+	if err != nil {
+		return err
+	}
+	if !canManage {
+		return ErrCustomRoleAssignNotAllowed
+	}
+	role, err := actor.EffectiveRole()
+	if err != nil {
+		return err
+	}
+	if role.Includes(GLOBAL_ADMIN) {
+		return nil
+	}
+	holds, err := actor.HasPermission(customRole.Permissions...)
+	if err != nil {
+		return err
+	}
+	if !holds {
+		return ErrCustomRoleAssignNotAllowed
+	}
+	return nil
+}
+
+// audit records an event about the custom role, with the user or the
+// affiliation it is assigned to or unassigned from, if any.
+func (customRole *CustomRole) audit(eventType string, actor *User, subjectUserID, affiliationID uint64) error {
+	detailJson, err := json.Marshal(map[string]interface{}{
+		"custom_role_id": customRole.id,
+		"name":           customRole.Name,
+		"permissions":    customRole.Permissions,
+	})
+	if err != nil {
+		return err
+	}
+	return RecordAuditEvent(&AuditEvent{
+		EventType:     eventType,
+		ActorUserID:   actor.id,
+		SubjectUserID: subjectUserID,
+		AffiliationID: affiliationID,
+		Detail:        detailJson,
+	})
+}
+
+// Update saves changes to Name, Description and Permissions on behalf of actor.
+func (customRole *CustomRole) Update(actor *User) error {
+	if err := checkCustomRoleActor(actor); err != nil {
+		return err
+	}
+	if err := customRole.validate(); err != nil {
+		return err
+	}
+	if err := store.UpdateCustomRole(customRole); err != nil {
+		return err
+	}
+	return customRole.audit(AUDIT_CUSTOM_ROLE_UPDATE, actor, 0, 0)
+}
+
+// Delete also removes the custom role from all users and affiliations.
+func (customRole *CustomRole) Delete(actor *User) error {
+	if err := checkCustomRoleActor(actor); err != nil {
+		return err
+	}
+	if err := store.DeleteCustomRole(customRole.id); err != nil {
+		return err
+	}
+	return customRole.audit(AUDIT_CUSTOM_ROLE_DELETE, actor, 0, 0)
+}
+
+func (customRole *CustomRole) AssignToUser(actor, user *User) error {
+	if err := customRole.checkAssigner(actor, user); err != nil {
+		return err
+	}
+	if err := store.AssignCustomRoleToUser(customRole.id, user.id); err != nil {
+		return err
+	}
+	return customRole.audit(AUDIT_CUSTOM_ROLE_ASSIGN, actor, user.id, 0)
+}
+
+func (customRole *CustomRole) UnassignFromUser(actor, user *User) error {
+	if err := customRole.checkAssigner(actor, user); err != nil {
+		return err
+	}
+	if err := store.UnassignCustomRoleFromUser(customRole.id, user.id); err != nil {
+		return err
+	}
+	return customRole.audit(AUDIT_CUSTOM_ROLE_UNASSIGN, actor, user.id, 0)
+}
+
+// AssignToAffiliation assigns the custom role to all users of the affiliation.
+// An AFFILIATION_ACCOUNT_ADMIN must manage the members of the affiliation.
+func (customRole *CustomRole) AssignToAffiliation(actor *User, affiliation *Affiliation) error {
+	if err := customRole.checkAssigner(actor, &User{AffiliationID: affiliation.id}); err != nil {
+		return err
+	}
+	if err := store.AssignCustomRoleToAffiliation(customRole.id, affiliation.id); err != nil {
+		return err
+	}
+	return customRole.audit(AUDIT_CUSTOM_ROLE_ASSIGN, actor, 0, affiliation.id)
+}
+
+func (customRole *CustomRole) UnassignFromAffiliation(actor *User, affiliation *Affiliation) error {
+	if err := customRole.checkAssigner(actor, &User{AffiliationID: affiliation.id}); err != nil {
+		return err
+	}
+	if err := store.UnassignCustomRoleFromAffiliation(customRole.id, affiliation.id); err != nil {
+		return err
+	}
+	return customRole.audit(AUDIT_CUSTOM_ROLE_UNASSIGN, actor, 0, affiliation.id)
+}
+
+// CustomRoles lists custom roles assigned to the user, directly or
+// through their affiliation.
+func (user *User) CustomRoles() ([]*CustomRole, error) {
+	return store.ListCustomRolesByUser(user.id, user.AffiliationID)
+}
+
+// CustomRoles lists custom roles assigned to the affiliation.
+func (affiliation *Affiliation) CustomRoles() ([]*CustomRole, error) {
+	return store.ListCustomRolesByAffiliation(affiliation.id)
+}
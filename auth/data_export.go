@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ExportUserData collects all personal data of a user for privacy requests.
+// The bundle consists of named sections, each a list of flat records.
+// The auth package exports its own sections; other packages add theirs with
+// RegDataExporter, e.g. billing registers "billing.products" etc. in Setup().
+
+type DataExportRecord map[string]interface{}
+
+// DataExporter returns sections of the user's data, keyed by section name.
+// Section names should be prefixed with the package name, e.g. "billing.wallets".
+type DataExporter func(userID uint64) (map[string][]DataExportRecord, error)
+
+type DataExportBundle struct {
+	UserID      uint64                        `json:"user_id"`
+	GeneratedAt time.Time                     `json:"generated_at"`
+	Sections    map[string][]DataExportRecord `json:"sections"`
+}
+
+var (
+	dataExporterMutex    sync.RWMutex            = sync.RWMutex{}
+	dataExporterRegistry map[string]DataExporter = map[string]DataExporter{}
+
+	ErrDataExporterRepeated = errors.New("auth: data exporter is registered already")
+	ErrDataExportSection    = errors.New("auth: data export section is repeated")
+)
+
+// RegDataExporter registers an exporter. The name must be unique.
+func RegDataExporter(name string, exporter DataExporter) error {
+	dataExporterMutex.Lock()
+	defer dataExporterMutex.Unlock()
+
+	if _, ok := dataExporterRegistry[name]; ok {
+		return ErrDataExporterRepeated
+	}
+	dataExporterRegistry[name] = exporter
+	return nil
+}
+
+// DataExportRecords converts a slice of structs (or a single struct) to records
+// by their JSON representation. Unexported fields are not included.
+func DataExportRecords(v interface{}) ([]DataExportRecord, error) {
+	vJson, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []DataExportRecord
+	if len(vJson) > 0 && vJson[0] == '[' {
+		err = json.Unmarshal(vJson, &records)
+	} else {
+		var record DataExportRecord
+		err = json.Unmarshal(vJson, &record)
+		records = []DataExportRecord{record}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if records == nil {
+		records = []DataExportRecord{}
+	}
+	return records, nil
+}
+
+// ExportUserData runs the auth exporter and all registered exporters.
+func ExportUserData(userID uint64) (*DataExportBundle, error) {
+	bundle := &DataExportBundle{
+		UserID:      userID,
+		GeneratedAt: time.Now(),
+		Sections:    map[string][]DataExportRecord{},
+	}
+
+	sections, err := exportAuthUserData(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err = bundle.add(sections); err != nil {
+		return nil, err
+	}
+
+	dataExporterMutex.RLock()
+	defer dataExporterMutex.RUnlock()
+
+	for name, exporter := range dataExporterRegistry {
+		sections, err = exporter(userID)
+		if err != nil {
+			return nil, fmt.Errorf("auth: data exporter %s: %w", name, err)
+		}
+		if err = bundle.add(sections); err != nil {
+			return nil, err
+		}
+	}
+	return bundle, nil
+}
+
+func (bundle *DataExportBundle) add(sections map[string][]DataExportRecord) error {
+	for name, records := range sections {
+		if _, ok := bundle.Sections[name]; ok {
+			return ErrDataExportSection
+		}
+		bundle.Sections[name] = records
+	}
+	return nil
+}
+
+func (bundle *DataExportBundle) JSON() ([]byte, error) {
+	return json.MarshalIndent(bundle, "", "  ")
+}
+
+// Zip packs one CSV file per section, plus the full bundle as JSON.
+// The columns of a CSV are the union of the record keys, sorted.
+func (bundle *DataExportBundle) Zip() ([]byte, error) {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+
+	bundleJson, err := bundle.JSON()
+	if err != nil {
+		return nil, err
+	}
+	jsonFile, err := zipWriter.Create("bundle.json")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = jsonFile.Write(bundleJson); err != nil {
+		return nil, err
+	}
+
+	for name, records := range bundle.Sections {
+		csvFile, err := zipWriter.Create(name + ".csv")
+		if err != nil {
+			return nil, err
+		}
+		if err = writeDataExportCSV(csvFile, records); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = zipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeDataExportCSV(w io.Writer, records []DataExportRecord) error {
+	var columnSet map[string]bool = map[string]bool{}
+	for _, record := range records {
+		for column := range record {
+			columnSet[column] = true
+		}
+	}
+	var columns []string = []string{}
+	for column := range columnSet {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(columns); err != nil {
+		return err
+	}
+	for _, record := range records {
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			value, ok := record[column]
+			if !ok || value == nil {
+				continue
+			}
+			switch v := value.(type) {
+			case string:
+				row[i] = v
+			case map[string]interface{}, []interface{}:
+				valueJson, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				row[i] = string(valueJson)
+			default:
+				row[i] = fmt.Sprint(v)
+			}
+		}
+		if err := csvWriter.Write(row); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+/************ Auth Sections ************/
+
+// exportAuthUserData exports the user, their info, affiliation membership,
+// keys, external identities, roles and MFA metadata. MFA secrets are never
+// exported.
+func exportAuthUserData(userID uint64) (map[string][]DataExportRecord, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	sections := map[string][]DataExportRecord{}
+
+	emailVerified, err := user.EmailVerified()
+	if err != nil {
+		return nil, err
+	}
+	sections["auth.user"] = []DataExportRecord{{
+		"id":             user.id,
+		"email":          user.Email,
+		"email_verified": emailVerified,
+		"public_key":     user.PublicKey,
+		"role":           user.Role,
+		"affiliation":    user.AffiliationID,
+	}}
+
+	sections["auth.user_info"] = []DataExportRecord{}
+	info, err := user.Info()
+	if err == nil {
+		if sections["auth.user_info"], err = DataExportRecords(info); err != nil {
+			return nil, err
+		}
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	sections["auth.affiliation"] = []DataExportRecord{}
+	if user.AffiliationID != 0 {
+		affiliation, err := GetAffiliationByID(user.AffiliationID)
+		if err != nil {
+			return nil, err
+		}
+		sections["auth.affiliation"] = []DataExportRecord{{
+			"id":    affiliation.id,
+			"name":  affiliation.Name,
+			"roles": user.Role & AFFILIATION_ROLES,
+		}}
+	}
+
+	keys, err := user.Keys()
+	if err != nil {
+		return nil, err
+	}
+	if sections["auth.user_keys"], err = DataExportRecords(keys); err != nil {
+		return nil, err
+	}
+
+	identities, err := user.ExternalIdentities()
+	if err != nil {
+		return nil, err
+	}
+	if sections["auth.external_identities"], err = DataExportRecords(identities); err != nil {
+		return nil, err
+	}
+
+	grants, err := user.RoleGrantHistory()
+	if err != nil {
+		return nil, err
+	}
+	if sections["auth.role_grants"], err = DataExportRecords(grants); err != nil {
+		return nil, err
+	}
+
+	customRoles, err := user.CustomRoles()
+	if err != nil {
+		return nil, err
+	}
+	if sections["auth.custom_roles"], err = DataExportRecords(customRoles); err != nil {
+		return nil, err
+	}
+
+	preferredMFA, err := PreferredMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+	mfaTypes, err := EnabledMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+	sections["auth.mfa"] = []DataExportRecord{}
+	for _, mfaType := range mfaTypes {
+		record := DataExportRecord{
+			"type":      mfaType,
+			"preferred": mfaType == preferredMFA,
+		}
+		if info, err := MFAInfo(mfaType); err == nil {
+			record["display_name"] = info.DisplayName
+		}
+		sections["auth.mfa"] = append(sections["auth.mfa"], record)
+	}
+
+	return sections, nil
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Instead of Wipe(), a user is deactivated first: they may no longer sign in,
+// and are erased at ErasureAt unless reactivated, if the erasure is scheduled. Erasure anonymizes the
+// user: PII is scrubbed, but the user row is kept under a pseudonym so that
+// financial records (wallets, billing records) remain.
+//
+// Erasure is refused while any registered ErasureCheck fails, e.g. billing
+// refuses while the user has active products or a non-zero wallet balance.
+
+const (
+	anonymizedEmailDomain = "@erased.invalid"
+	pseudonymBytes        = 16
+)
+
+// ErasureNever as the erasureDelay of Deactivate() suspends the user without
+// scheduling the erasure. See RescheduleErasure() to schedule it later.
+const ErasureNever time.Duration = -1
+
+var (
+	DefaultErasureDelay = 30 * 24 * time.Hour
+
+	// stored as ErasureAt of users never to be erased, as it is NOT NULL
+	erasureNeverAt = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	erasureCheckMutex    sync.RWMutex            = sync.RWMutex{}
+	erasureCheckRegistry map[string]ErasureCheck = map[string]ErasureCheck{}
+
+	ErrUserDeactivated      = errors.New("auth: user is deactivated")
+	ErrUserNotDeactivated   = errors.New("auth: user is not deactivated")
+	ErrUserErased           = errors.New("auth: user is erased")
+	ErrErasureCheckRepeated = errors.New("auth: erasure check is registered already")
+)
+
+type Deactivation struct {
+	UserID        uint64     `json:"user_id"`
+	Reason        string     `json:"reason"`
+	DeactivatedAt time.Time  `json:"deactivated_at"`
+	ErasureAt     time.Time  `json:"erasure_at"` // scheduled
+	ErasedAt      *time.Time `json:"erased_at"`
+	Pseudonym     string     `json:"pseudonym"` // set on erasure
+}
+
+// ErasureScheduled is false if the user was deactivated with ErasureNever.
+func (deactivation *Deactivation) ErasureScheduled() bool {
+	return deactivation.ErasureAt.Before(erasureNeverAt)
+}
+
+// ErasureCheck returns an error if the user may not be erased yet.
+type ErasureCheck func(userID uint64) error
+
+// RegErasureCheck registers a pre-erasure check. The name must be unique.
+func RegErasureCheck(name string, check ErasureCheck) error {
+	erasureCheckMutex.Lock()
+	defer erasureCheckMutex.Unlock()
+
+	if _, ok := erasureCheckRegistry[name]; ok {
+		return ErrErasureCheckRepeated
+	}
+	erasureCheckRegistry[name] = check
+	return nil
+}
+
+// CheckErasure runs all registered pre-erasure checks.
+func CheckErasure(userID uint64) error {
+	erasureCheckMutex.RLock()
+	defer erasureCheckMutex.RUnlock()
+
+	for name, check := range erasureCheckRegistry {
+		if err := check(userID); err != nil {
+			return fmt.Errorf("auth: erasure check %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// CheckUserActive returns ErrUserDeactivated (or ErrUserErased) if the user
+// may not sign in.
+func CheckUserActive(userID uint64) error {
+	deactivation, err := store.GetDeactivation(userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if deactivation.ErasedAt != nil {
+		return ErrUserErased
+	}
+	return ErrUserDeactivated
+}
+
+// checkUserDeactivated is nil only if the user is deactivated but not erased yet
+func checkUserDeactivated(userID uint64) error {
+	err := CheckUserActive(userID)
+	if err == ErrUserDeactivated {
+		return nil
+	}
+	if err == nil {
+		return ErrUserNotDeactivated
+	}
+	return err
+}
+
+// Deactivation returns nil if the user is active.
+func (user *User) Deactivation() (*Deactivation, error) {
+	deactivation, err := store.GetDeactivation(user.id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return deactivation, err
+}
+
+// Deactivate blocks the user from signing in on behalf of the actor, and
+// schedules the erasure after erasureDelay, or DefaultErasureDelay if
+// erasureDelay is 0. With ErasureNever, the erasure is not scheduled.
+// actorUserID is 0 for the system.
+func (user *User) Deactivate(actorUserID uint64, reason string, erasureDelay time.Duration) (*Deactivation, error) {
+	if erasureDelay == 0 {
+		erasureDelay = DefaultErasureDelay
+	}
+	if err := CheckUserActive(user.id); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	deactivation := &Deactivation{
+		UserID:        user.id,
+		Reason:        reason,
+		DeactivatedAt: now,
+		ErasureAt:     now.Add(erasureDelay),
+	}
+	if erasureDelay == ErasureNever {
+		deactivation.ErasureAt = erasureNeverAt
+	}
+	err := store.NewDeactivation(deactivation)
+	if err != nil {
+		return nil, err
+	}
+	detail := map[string]interface{}{"reason": reason, "erasure_at": nil}
+	if deactivation.ErasureScheduled() {
+		detail["erasure_at"] = deactivation.ErasureAt
+	}
+	return deactivation, audit(AUDIT_DEACTIVATE, actorUserID, user.id, "", detail)
+}
+
+// RescheduleErasure changes the erasure date of a deactivated user.
+func (user *User) RescheduleErasure(erasureAt time.Time) error {
+	if err := checkUserDeactivated(user.id); err != nil {
+		return err
+	}
+	return store.UpdateErasureAt(user.id, erasureAt)
+}
+
+// Reactivate restores a deactivated user before the erasure, on behalf of
+// the actor. actorUserID is 0 for the system.
+func (user *User) Reactivate(actorUserID uint64) error {
+	if err := checkUserDeactivated(user.id); err != nil {
+		return err
+	}
+	if err := store.DeleteDeactivation(user.id); err != nil {
+		return err
+	}
+	return audit(AUDIT_REACTIVATE, actorUserID, user.id, "", nil)
+}
+
+// Erase anonymizes a deactivated user immediately, subject to CheckErasure.
+func (user *User) Erase() error {
+	if err := checkUserDeactivated(user.id); err != nil {
+		return err
+	}
+	if err := CheckErasure(user.id); err != nil {
+		return err
+	}
+
+	pseudonym, err := randomToken(pseudonymBytes)
+	if err != nil {
+		return err
+	}
+	err = store.AnonymizeUser(user.id, pseudonym, time.Now())
+	if err != nil {
+		return err
+	}
+	err = Ephemeral().DeletePrefix(userEphemeralPrefix(user.id))
+	if err != nil {
+		return err
+	}
+
+	user.Email = pseudonym + anonymizedEmailDomain
+	user.PublicKey = ""
+	user.Role = ROLELESS
+	user.AffiliationID = 0
+	user.pubKey = nil
+	return audit(AUDIT_ERASE, 0, user.id, "", map[string]interface{}{"pseudonym": pseudonym})
+}
+
+// EraseDeactivatedUsers erases all users whose erasure date has passed.
+// Users failing CheckErasure are kept, and retried on the next call.
+// It should be called at a daily basis.
+func EraseDeactivatedUsers() []error {
+	var errs []error
+
+	userIDs, err := store.ListUserIDsDueForErasure(time.Now())
+	if err != nil {
+		return append(errs, err)
+	}
+
+	for _, userID := range userIDs {
+		user, err := GetUserByID(userID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err = user.Erase(); err != nil {
+			errs = append(errs, fmt.Errorf("auth: erasing user %d: %w", userID, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,118 @@
+package auth
+
+import "errors"
+
+// Delegated administration:
+// 	- GLOBAL_ADMIN may manage any user and grant/revoke any role.
+// 	- AFFILIATION_ACCOUNT_ADMIN may only manage users in their own affiliation
+// 	(and its descendants), and may only grant/revoke AFFILIATION_* roles they
+// 	hold themselves. They may never touch GLOBAL_* roles, nor manage users
+// 	holding any role beyond the AFFILIATION_* ones.
+// 	- Nobody else may manage users.
+
+// RoleDelta describes a role change
+type RoleDelta struct {
+	Grant  Role `json:"grant"`
+	Revoke Role `json:"revoke"`
+}
+
+var (
+	ErrManageNotPermitted = errors.New("auth: actor may not manage the target user")
+	ErrGrantNotPermitted  = errors.New("auth: actor may not grant or revoke the roles")
+)
+
+// Apply returns the role after the change
+func (delta RoleDelta) Apply(role Role) Role {
+	return role.RemoveRole(delta.Revoke).AddRole(delta.Grant)
+}
+
+// CanManage checks if actor may manage target, e.g. assigning roles.
+// The effective roles of both, including temporary grants, are considered.
+func CanManage(actor, target *User) (bool, error) {
+	actorRole, err := actor.EffectiveRole()
+	if err != nil {
+		return false, err
+	}
+	if actorRole.Includes(GLOBAL_ADMIN) {
+		return true, nil
+	}
+	if !actorRole.Includes(AFFILIATION_ACCOUNT_ADMIN) || actor.AffiliationID == 0 || target.AffiliationID == 0 {
+		return false, nil
+	}
+	targetRole, err := target.EffectiveRole()
+	if err != nil {
+		return false, err
+	}
+	if !AFFILIATION_ROLES.Includes(targetRole) {
+		return false, nil // e.g. GLOBAL_ADMIN in the affiliation
+	}
+	if target.AffiliationID == actor.AffiliationID {
+		return true, nil
+	}
+
+	actorAffiliation, err := GetAffiliationByID(actor.AffiliationID)
+	if err != nil {
+		return false, err
+	}
+	descendants, err := actorAffiliation.Descendants()
+	if err != nil {
+		return false, err
+	}
+	for _, descendant := range descendants {
+		if descendant.id == target.AffiliationID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CanGrant checks if actor may apply roleDelta to target.
+func CanGrant(actor, target *User, roleDelta RoleDelta) (bool, error) {
+	canManage, err := CanManage(actor, target)
+	if err != nil || !canManage {
+		return false, err
+	}
+	actorRole, err := actor.EffectiveRole()
+	if err != nil {
+		return false, err
+	}
+	if actorRole.Includes(GLOBAL_ADMIN) {
+		return true, nil
+	}
+
+	touched := roleDelta.Grant | roleDelta.Revoke
+	if !AFFILIATION_ROLES.Includes(touched) {
+		return false, nil // GLOBAL_* or EXEMPT_*
+	}
+	if !actorRole.Includes(touched) {
+		return false, nil // actor does not hold them
+	}
+	return true, nil
+}
+
+func checkRoleDelta(actor, target *User, roleDelta RoleDelta) error {
+	canManage, err := CanManage(actor, target)
+	if err != nil {
+		return err
+	}
+	if !canManage {
+		return ErrManageNotPermitted
+	}
+
+	canGrant, err := CanGrant(actor, target, roleDelta)
+	if err != nil {
+		return err
+	}
+	if !canGrant {
+		return ErrGrantNotPermitted
+	}
+	return nil
+}
+
+// UpdateRoles is the checked path for permanent role changes. The change is
+// recorded in the role grant history with the reason. Only the role is saved:
+// other unsaved changes to the user are not written.
+func (user *User) UpdateRoles(actor *User, roleDelta RoleDelta, reason string) error {
+	_, err := user.changeRoles(actor, roleDelta, reason)
+	return err
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// A user's email is verified once they follow the link with the token
+// sent by BeginEmailVerification. The verification is bound to the address:
+// if Email is later changed by Update(), it is no longer verified.
+//
+// To change the email, BeginEmailChange issues one token for the current
+// address and one for the new address. The change is applied once both
+// are confirmed, and the new address is verified at the same time.
+
+const (
+	emailVerifyTmpExtension        = "email_verify"
+	emailChangeCurrentTmpExtension = "email_change_cur"
+	emailChangeNewTmpExtension     = "email_change_new"
+	emailTokenBytes                = 16 // hex-encoded into 32 chars
+)
+
+var (
+	DefaultEmailTokenLifetime = 24 * time.Hour
+
+	ErrEmailTokenBad         = errors.New("auth: email token is invalid")
+	ErrEmailTokenExpired     = errors.New("auth: email token expired")
+	ErrEmailAlreadyVerified  = errors.New("auth: email is verified already")
+	ErrEmailUnchanged        = errors.New("auth: new email is the current email")
+	ErrEmailExists           = errors.New("auth: email already exists")
+	ErrEmailChangedMeanwhile = errors.New("auth: email was changed since the token was issued")
+)
+
+type emailToken struct {
+	Email       string    `json:"email"` // the address the token was sent to
+	NewEmail    string    `json:"new_email,omitempty"`
+	Counterpart string    `json:"counterpart,omitempty"` // token for the other address
+	Confirmed   bool      `json:"confirmed,omitempty"`
+	IssuedAt    time.Time `json:"issued_at"`
+	extension   string
+	token       string
+	raw         string // as stored, for CompareAndSwap
+}
+
+func newEmailToken(userID uint64, extension string, content *emailToken) (string, error) {
+	token, err := randomToken(emailTokenBytes)
+	if err != nil {
+		return "", err
+	}
+	content.token = token
+	content.extension = extension
+	return token, addEmailToken(userID, content)
+}
+
+func emailTokenKey(userID uint64, extension, token string) string {
+	return UserEphemeralKey(userID, extension, token)
+}
+
+func addEmailToken(userID uint64, content *emailToken) error {
+	contentJson, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	return Ephemeral().Add(emailTokenKey(userID, content.extension, content.token), string(contentJson), DefaultEmailTokenLifetime)
+}
+
+func readEmailToken(userID uint64, extension, token string) (*emailToken, error) {
+	if len(token) != 2*emailTokenBytes {
+		return nil, ErrEmailTokenBad
+	}
+	contentJson, err := Ephemeral().Get(emailTokenKey(userID, extension, token))
+	if err != nil {
+		return nil, ErrEmailTokenBad
+	}
+
+	var content emailToken
+	err = json.Unmarshal([]byte(contentJson), &content)
+	if err != nil {
+		return nil, err
+	}
+	content.extension = extension
+	content.token = token
+	content.raw = contentJson
+
+	// DefaultEmailTokenLifetime may have been shortened since
+	if time.Now().After(content.IssuedAt.Add(DefaultEmailTokenLifetime)) {
+		_ = Ephemeral().Delete(emailTokenKey(userID, extension, token))
+		return nil, ErrEmailTokenExpired
+	}
+	return &content, nil
+}
+
+// EmailVerified is true if the current Email has been verified.
+func (user *User) EmailVerified() (bool, error) {
+	email, _, err := store.GetVerifiedEmail(user.id)
+	if err != nil {
+		return false, err
+	}
+	return email != "" && strings.EqualFold(email, user.Email), nil
+}
+
+// BeginEmailVerification issues a token for the current Email.
+// The caller is responsible for sending it to the Email, e.g. in a link.
+func (user *User) BeginEmailVerification() (string, error) {
+	verified, err := user.EmailVerified()
+	if err != nil {
+		return "", err
+	}
+	if verified {
+		return "", ErrEmailAlreadyVerified
+	}
+
+	return newEmailToken(user.id, emailVerifyTmpExtension, &emailToken{
+		Email:    user.Email,
+		IssuedAt: time.Now(),
+	})
+}
+
+// VerifyEmail is called when the user follows the link.
+func (user *User) VerifyEmail(token string) error {
+	content, err := readEmailToken(user.id, emailVerifyTmpExtension, token)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(content.Email, user.Email) {
+		return ErrEmailChangedMeanwhile
+	}
+	if _, err = Ephemeral().Take(emailTokenKey(user.id, emailVerifyTmpExtension, token)); err != nil {
+		return ErrEmailTokenBad
+	}
+
+	return store.SetVerifiedEmail(user.id, user.Email, time.Now())
+}
+
+// BeginEmailChange issues two tokens: currentToken is to be sent to the
+// current Email and newToken to newEmail. Both must be confirmed by
+// ConfirmEmailChange.
+func (user *User) BeginEmailChange(newEmail string) (currentToken, newToken string, err error) {
+	if newEmail == "" {
+		return "", "", errors.New("auth: email must not be empty")
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		return "", "", ErrEmailUnchanged
+	}
+	exists, err := store.EmailExists(newEmail)
+	if err != nil {
+		return "", "", err
+	}
+	if exists {
+		return "", "", ErrEmailExists
+	}
+
+	now := time.Now()
+	currentContent := &emailToken{
+		Email:    user.Email,
+		NewEmail: newEmail,
+		IssuedAt: now,
+	}
+	newContent := &emailToken{
+		Email:    newEmail,
+		NewEmail: newEmail,
+		IssuedAt: now,
+	}
+
+	// Tokens are generated first to cross-reference each other
+	if currentContent.token, err = randomToken(emailTokenBytes); err != nil {
+		return "", "", err
+	}
+	if newContent.token, err = randomToken(emailTokenBytes); err != nil {
+		return "", "", err
+	}
+	currentContent.extension = emailChangeCurrentTmpExtension
+	currentContent.Counterpart = newContent.token
+	newContent.extension = emailChangeNewTmpExtension
+	newContent.Counterpart = currentContent.token
+
+	if err = addEmailToken(user.id, currentContent); err != nil {
+		return "", "", err
+	}
+	if err = addEmailToken(user.id, newContent); err != nil {
+		return "", "", err
+	}
+	return currentContent.token, newContent.token, nil
+}
+
+// ConfirmEmailChange accepts either token issued by BeginEmailChange.
+// It returns true when both are confirmed and the Email has been changed.
+func (user *User) ConfirmEmailChange(token string) (bool, error) {
+	content, err := readEmailToken(user.id, emailChangeCurrentTmpExtension, token)
+	counterpartExtension := emailChangeNewTmpExtension
+	if err == ErrEmailTokenBad {
+		content, err = readEmailToken(user.id, emailChangeNewTmpExtension, token)
+		counterpartExtension = emailChangeCurrentTmpExtension
+	}
+	if err != nil {
+		return false, err
+	}
+
+	counterpart, err := readEmailToken(user.id, counterpartExtension, content.Counterpart)
+	if err != nil {
+		return false, err
+	}
+
+	// Both tokens were issued for the current Email
+	currentEmail := content.Email
+	if content.extension == emailChangeNewTmpExtension {
+		currentEmail = counterpart.Email
+	}
+	if !strings.EqualFold(currentEmail, user.Email) {
+		return false, ErrEmailChangedMeanwhile
+	}
+
+	contentKey := emailTokenKey(user.id, content.extension, content.token)
+	if !counterpart.Confirmed {
+		if content.Confirmed {
+			return false, nil
+		}
+		content.Confirmed = true
+		contentJson, err := json.Marshal(content)
+		if err != nil {
+			return false, err
+		}
+		ttl := time.Until(content.IssuedAt.Add(DefaultEmailTokenLifetime))
+		swapped, err := Ephemeral().CompareAndSwap(contentKey, content.raw, string(contentJson), ttl)
+		if err != nil {
+			return false, err
+		}
+		if !swapped {
+			return false, ErrEmailTokenBad
+		}
+		return false, nil
+	}
+
+	exists, err := store.EmailExists(content.NewEmail)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, ErrEmailExists
+	}
+
+	// Only one caller may apply the change
+	if _, err = Ephemeral().Take(contentKey); err != nil {
+		return false, ErrEmailTokenBad
+	}
+	_ = Ephemeral().Delete(emailTokenKey(user.id, counterpart.extension, counterpart.token))
+
+	err = store.ChangeVerifiedEmail(user.id, content.NewEmail, time.Now())
+	if err != nil {
+		return false, err
+	}
+	oldEmail := user.Email
+	user.Email = content.NewEmail
+	return true, audit(AUDIT_EMAIL_CHANGE, user.id, user.id, "", map[string]interface{}{"old_email": oldEmail, "new_email": user.Email})
+}
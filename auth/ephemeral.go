@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EphemeralStore keeps short-lived entries such as tokens and WebAuthn
+// sessions. Every entry has its own TTL: an expired entry is never returned,
+// and is removed by PurgeExpired, which the janitor started by Setup calls
+// every EphemeralJanitorInterval.
+type EphemeralStore interface {
+	// Add fails with ErrEphemeralExists if the key holds an entry already.
+	Add(key, value string, ttl time.Duration) error
+	// Set adds the entry, or overwrites it along with its TTL.
+	Set(key, value string, ttl time.Duration) error
+	Get(key string) (string, error)
+	// Take gets and deletes the entry atomically: of concurrent callers,
+	// only one gets the value. The others get ErrEphemeralNotFound.
+	Take(key string) (string, error)
+	// CompareAndSwap sets the value and TTL only if the current value is old.
+	CompareAndSwap(key, old, new string, ttl time.Duration) (bool, error)
+	Delete(key string) error
+	DeletePrefix(prefix string) error
+	PurgeExpired() error
+}
+
+const (
+	MaxEphemeralKeyLength = 255
+
+	tmpEntryLifetime = 24 * time.Hour
+)
+
+var (
+	EphemeralJanitorInterval = 10 * time.Minute
+
+	stopEphemeralJanitor func()
+
+	ErrEphemeralNotFound   = errors.New("auth: ephemeral entry does not exist or expired")
+	ErrEphemeralExists     = errors.New("auth: ephemeral entry exists already")
+	ErrEphemeralKeyTooLong = errors.New("auth: ephemeral key is too long")
+	ErrEphemeralTTL        = errors.New("auth: ephemeral TTL must be positive")
+)
+
+// Ephemeral returns the EphemeralStore of the store passed to Setup.
+func Ephemeral() EphemeralStore {
+	return store.Ephemeral()
+}
+
+// UserEphemeralKey namespaces a key under the user, so that the entries of
+// the user are deleted on erasure.
+func UserEphemeralKey(userID uint64, namespace, token string) string {
+	return fmt.Sprintf("%s%s/%s", userEphemeralPrefix(userID), namespace, token)
+}
+
+func userEphemeralPrefix(userID uint64) string {
+	return fmt.Sprintf("user/%d/", userID)
+}
+
+func checkEphemeralEntry(key string, ttl time.Duration) error {
+	if len(key) > MaxEphemeralKeyLength {
+		return ErrEphemeralKeyTooLong
+	}
+	if ttl <= 0 {
+		return ErrEphemeralTTL
+	}
+	return nil
+}
+
+// StartEphemeralJanitor calls PurgeExpired every interval until stop is called.
+// Errors are ignored: the purge is retried at the next interval.
+func StartEphemeralJanitor(ephemeral EphemeralStore, interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				_ = ephemeral.PurgeExpired()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+/************ Deprecated ************/
+
+// Temporary entries are ephemeral entries under UserEphemeralKey, with a TTL of a day.
+
+// Deprecated: use Ephemeral().Add
+func InsertTmpEntry(userID uint64, extentionType, indexKey, storedValue string) error {
+	return Ephemeral().Add(UserEphemeralKey(userID, extentionType, indexKey), storedValue, tmpEntryLifetime)
+}
+
+// Deprecated: use Ephemeral().Get
+func ReadTmpEntry(userID uint64, extentionType, indexKey string) (string, error) {
+	return Ephemeral().Get(UserEphemeralKey(userID, extentionType, indexKey))
+}
+
+// Deprecated: use Ephemeral().CompareAndSwap. The TTL restarts.
+func UpdateTmpEntry(userID uint64, extentionType, indexKey, storedValue string) error {
+	key := UserEphemeralKey(userID, extentionType, indexKey)
+	oldValue, err := Ephemeral().Get(key)
+	if err != nil {
+		return err
+	}
+	_, err = Ephemeral().CompareAndSwap(key, oldValue, storedValue, tmpEntryLifetime)
+	return err
+}
+
+// Deprecated: use Ephemeral().Delete
+func DeleteTmpEntry(userID uint64, extentionType, indexKey string) error {
+	return Ephemeral().Delete(UserEphemeralKey(userID, extentionType, indexKey))
+}
+
+// Deprecated: expired entries are purged by the janitor.
+func PurgeExpiredTmpEntry() error {
+	return Ephemeral().PurgeExpired()
+}
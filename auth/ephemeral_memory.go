@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryEphemeralEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// MemoryEphemeralStore keeps ephemeral entries in memory. It serves
+// MemoryStore, and may be used on its own, e.g. for a single-node cache.
+type MemoryEphemeralStore struct {
+	mutex   sync.Mutex
+	entries map[string]memoryEphemeralEntry
+}
+
+func NewMemoryEphemeralStore() *MemoryEphemeralStore {
+	return &MemoryEphemeralStore{
+		entries: map[string]memoryEphemeralEntry{},
+	}
+}
+
+// get returns the entry if it exists and is not expired
+func (e *MemoryEphemeralStore) get(key string) (memoryEphemeralEntry, bool) {
+	entry, ok := e.entries[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return memoryEphemeralEntry{}, false
+	}
+	return entry, true
+}
+
+func (e *MemoryEphemeralStore) Add(key, value string, ttl time.Duration) error {
+	if err := checkEphemeralEntry(key, ttl); err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, ok := e.get(key); ok {
+		return ErrEphemeralExists
+	}
+	e.entries[key] = memoryEphemeralEntry{value, time.Now().Add(ttl)}
+	return nil
+}
+
+func (e *MemoryEphemeralStore) Set(key, value string, ttl time.Duration) error {
+	if err := checkEphemeralEntry(key, ttl); err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.entries[key] = memoryEphemeralEntry{value, time.Now().Add(ttl)}
+	return nil
+}
+
+func (e *MemoryEphemeralStore) Get(key string) (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	entry, ok := e.get(key)
+	if !ok {
+		return "", ErrEphemeralNotFound
+	}
+	return entry.value, nil
+}
+
+func (e *MemoryEphemeralStore) Take(key string) (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	entry, ok := e.get(key)
+	if !ok {
+		return "", ErrEphemeralNotFound
+	}
+	delete(e.entries, key)
+	return entry.value, nil
+}
+
+func (e *MemoryEphemeralStore) CompareAndSwap(key, old, new string, ttl time.Duration) (bool, error) {
+	if err := checkEphemeralEntry(key, ttl); err != nil {
+		return false, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	entry, ok := e.get(key)
+	if !ok || entry.value != old {
+		return false, nil
+	}
+	e.entries[key] = memoryEphemeralEntry{new, time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (e *MemoryEphemeralStore) Delete(key string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	delete(e.entries, key)
+	return nil
+}
+
+func (e *MemoryEphemeralStore) DeletePrefix(prefix string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for key := range e.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(e.entries, key)
+		}
+	}
+	return nil
+}
+
+func (e *MemoryEphemeralStore) PurgeExpired() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	for key, entry := range e.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(e.entries, key)
+		}
+	}
+	return nil
+}
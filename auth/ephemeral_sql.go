@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// sqlEphemeralStore works on both MySQL and SQLite. Times are saved in UTC,
+// as SQLite compares them as strings.
+type sqlEphemeralStore struct {
+	s *sqlStore
+}
+
+func (e *sqlEphemeralStore) exec(query string, args ...interface{}) (int64, error) {
+	stmt, err := e.s.statement(query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (e *sqlEphemeralStore) Add(key, value string, ttl time.Duration) error {
+	if err := checkEphemeralEntry(key, ttl); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+
+	// An expired entry may still hold the key
+	_, err := e.exec(`DELETE FROM dbprefix_auth_ephemeral WHERE entryKey = ? AND expiresAt <= ?;`, key, now)
+	if err != nil {
+		return err
+	}
+	_, err = e.exec(`INSERT INTO dbprefix_auth_ephemeral (entryKey, entryValue, expiresAt) VALUES (?, ?, ?);`, key, value, now.Add(ttl))
+	if err != nil {
+		if _, getErr := e.Get(key); getErr == nil {
+			return ErrEphemeralExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (e *sqlEphemeralStore) Set(key, value string, ttl time.Duration) error {
+	if err := checkEphemeralEntry(key, ttl); err != nil {
+		return err
+	}
+	_, err := e.exec(`REPLACE INTO dbprefix_auth_ephemeral (entryKey, entryValue, expiresAt) VALUES (?, ?, ?);`, key, value, time.Now().UTC().Add(ttl))
+	return err
+}
+
+func (e *sqlEphemeralStore) Get(key string) (string, error) {
+	stmtGetEphemeral, err := e.s.statement(`SELECT entryValue FROM dbprefix_auth_ephemeral WHERE entryKey = ? AND expiresAt > ?;`)
+	if err != nil {
+		return "", err
+	}
+	defer stmtGetEphemeral.Close()
+
+	var value string
+	err = stmtGetEphemeral.QueryRow(key, time.Now().UTC()).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", ErrEphemeralNotFound
+	}
+	return value, err
+}
+
+// Take deletes the entry only if it still holds the value read: the delete
+// is the atomic step deciding which caller takes it.
+func (e *sqlEphemeralStore) Take(key string) (string, error) {
+	value, err := e.Get(key)
+	if err != nil {
+		return "", err
+	}
+	affected, err := e.exec(`DELETE FROM dbprefix_auth_ephemeral WHERE entryKey = ? AND entryValue = ?;`, key, value)
+	if err != nil {
+		return "", err
+	}
+	if affected != 1 {
+		return "", ErrEphemeralNotFound
+	}
+	return value, nil
+}
+
+func (e *sqlEphemeralStore) CompareAndSwap(key, old, new string, ttl time.Duration) (bool, error) {
+	if err := checkEphemeralEntry(key, ttl); err != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+	affected, err := e.exec(`UPDATE dbprefix_auth_ephemeral SET entryValue = ?, expiresAt = ? WHERE entryKey = ? AND entryValue = ? AND expiresAt > ?;`, new, now.Add(ttl), key, old, now)
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (e *sqlEphemeralStore) Delete(key string) error {
+	_, err := e.exec(`DELETE FROM dbprefix_auth_ephemeral WHERE entryKey = ?;`, key)
+	return err
+}
+
+func (e *sqlEphemeralStore) DeletePrefix(prefix string) error {
+	pattern := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(prefix) + "%"
+	_, err := e.exec(`DELETE FROM dbprefix_auth_ephemeral WHERE entryKey LIKE ? ESCAPE '!';`, pattern)
+	return err
+}
+
+func (e *sqlEphemeralStore) PurgeExpired() error {
+	_, err := e.exec(`DELETE FROM dbprefix_auth_ephemeral WHERE expiresAt <= ?;`, time.Now().UTC())
+	return err
+}
@@ -25,7 +25,7 @@ func stagingDB() (*sql.DB, error) {
 	// dsn = fmt.Sprintf("user:password@tcp(localhost:5555)/dbname?tls=skip-verify&autocommit=true")
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?loc=Local", Username, Password, Host, Port, Database)
 
-	dsn += "&autocommit=true"
+	dsn += "&autocommit=true&parseTime=true"
 
 	var db *sql.DB
 	var err error
@@ -44,7 +44,9 @@ func init() {
 		panic(err)
 	}
 
-	auth.Setup(db, "ulysses_")
+	if err = auth.Setup(auth.NewMySQLStore(db, "ulysses_")); err != nil {
+		panic(err)
+	}
 }
 
 type registerInit struct {
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+// An external identity is an account at an identity provider, e.g. an OpenID
+// Connect issuer, linked to a user. A user may sign in with any linked
+// identity instead of an Ed25519 key. See package auth/oidc.
+//
+// An identity is identified by its provider and the subject the provider
+// assigned to it, and may be linked to one user only.
+
+var (
+	ErrExternalIdentityNotFound = errors.New("auth: no such external identity")
+	ErrExternalIdentityLinked   = errors.New("auth: external identity is linked to a user already")
+	ErrExternalIdentityBad      = errors.New("auth: external identity requires a provider and a subject")
+)
+
+type ExternalIdentity struct {
+	id         uint64
+	UserID     uint64     `json:"user_id"`
+	Provider   string     `json:"provider"`
+	Subject    string     `json:"subject"`
+	Email      string     `json:"email"` // as asserted by the provider when linked
+	LinkedAt   time.Time  `json:"linked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func (identity *ExternalIdentity) ID() uint64 {
+	return identity.id
+}
+
+// GetUserByExternalIdentity returns the user linked to the identity,
+// or ErrExternalIdentityNotFound.
+func GetUserByExternalIdentity(provider, subject string) (*User, error) {
+	identity, err := store.GetExternalIdentity(provider, subject)
+	if err != nil {
+		return nil, err
+	}
+	return GetUserByID(identity.UserID)
+}
+
+// ExternalIdentities lists the identities linked to the user, oldest first.
+func (user *User) ExternalIdentities() ([]*ExternalIdentity, error) {
+	return store.ListExternalIdentities(user.id)
+}
+
+// LinkExternalIdentity links the identity to the user. The caller is
+// responsible for having authenticated both, e.g. the user is signed in
+// and the identity comes from a completed OpenID Connect flow.
+func (user *User) LinkExternalIdentity(provider, subject, email string) (*ExternalIdentity, error) {
+	if provider == "" || subject == "" {
+		return nil, ErrExternalIdentityBad
+	}
+	existing, err := store.GetExternalIdentity(provider, subject)
+	if err == nil {
+		if existing.UserID == user.id {
+			return existing, nil
+		}
+		return nil, ErrExternalIdentityLinked
+	} else if err != ErrExternalIdentityNotFound {
+		return nil, err
+	}
+
+	identity := &ExternalIdentity{
+		UserID:   user.id,
+		Provider: provider,
+		Subject:  subject,
+		Email:    email,
+		LinkedAt: time.Now(),
+	}
+	err = store.NewExternalIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+	err = audit(AUDIT_IDENTITY_LINK, user.id, user.id, "", map[string]interface{}{"provider": provider, "subject": subject})
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// UnlinkExternalIdentity removes the link. The user keeps signing in with
+// their keys and any other linked identity.
+func (user *User) UnlinkExternalIdentity(provider, subject string) error {
+	identity, err := store.GetExternalIdentity(provider, subject)
+	if err != nil {
+		return err
+	}
+	if identity.UserID != user.id {
+		return ErrExternalIdentityNotFound
+	}
+	err = store.DeleteExternalIdentity(identity.id)
+	if err != nil {
+		return err
+	}
+	return audit(AUDIT_IDENTITY_UNLINK, user.id, user.id, "", map[string]interface{}{"provider": provider, "subject": subject})
+}
+
+// UnlinkProviderIdentities removes every identity of the provider on behalf
+// of the actor, e.g. when the IdP of an affiliation is replaced, so that
+// NameIDs asserted by the new one are not trusted for the old links.
+func UnlinkProviderIdentities(actorUserID uint64, provider string) error {
+	identities, err := store.ListProviderIdentities(provider)
+	if err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		if err = store.DeleteExternalIdentity(identity.id); err != nil {
+			return err
+		}
+		err = audit(AUDIT_IDENTITY_UNLINK, actorUserID, identity.UserID, "", map[string]interface{}{"provider": provider, "subject": identity.Subject})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyExternalLogin signs the user in with a linked identity which the
+// caller has authenticated. It works like VerifyLogin: deactivated users may
+// not sign in, attempts are subject to CheckLockout(), and MFA is up to the
+// caller, e.g. with MFABeginLogin(). The result holds the identity.
+func (user *User) VerifyExternalLogin(provider, subject, ip string) (*LoginResult, error) {
+	if err := CheckUserActive(user.id); err != nil {
+		return nil, err
+	}
+
+	var identity *ExternalIdentity
+	err := guardAuth(user.id, ip, func() error {
+		var err error
+		identity, err = store.GetExternalIdentity(provider, subject)
+		if err == nil && identity.UserID != user.id {
+			err = ErrExternalIdentityNotFound
+		}
+		return err
+	})
+	if err != nil {
+		if auditErr := audit(AUDIT_LOGIN_FAILED, user.id, user.id, ip, map[string]interface{}{"provider": provider, "error": err.Error()}); auditErr != nil {
+			return nil, auditErr
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if err = store.TouchExternalIdentity(identity.id, now); err != nil {
+		return nil, err
+	}
+	identity.LastUsedAt = &now
+	if err = audit(AUDIT_LOGIN, user.id, user.id, ip, map[string]interface{}{"provider": provider, "identity_id": identity.id}); err != nil {
+		return nil, err
+	}
+
+	result, err := user.newLoginResult()
+	if err != nil {
+		return nil, err
+	}
+	result.Identity = identity
+	return result, nil
+}
+
+// SyncExternalRoles sets the AFFILIATION_* roles of the user to the ones
+// asserted by a linked identity, e.g. mapped from a SAML attribute of the
+// corporate IdP of the affiliation. Other roles are kept. The change is
+// recorded as made by the system.
+func (user *User) SyncExternalRoles(provider string, roles Role) error {
+	roles &= AFFILIATION_ROLES
+	current := user.Role & AFFILIATION_ROLES
+	if roles == current {
+		return nil
+	}
+	_, err := user.applyRoleDelta(0, RoleDelta{Grant: roles &^ current, Revoke: current &^ roles}, "synced from external identity provider "+provider)
+	return err
+}
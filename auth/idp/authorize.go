@@ -0,0 +1,300 @@
+package idp
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// OAuthError is an error of the authorization code flow, reported to the
+// client with its code as defined in RFC 6749 and OpenID Connect Core.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("idp: %s: %s", e.Code, e.Description)
+}
+
+var (
+	ErrInvalidRequest          = &OAuthError{"invalid_request", "the request is missing or has an invalid parameter"}
+	ErrInvalidClient           = &OAuthError{"invalid_client", "client authentication failed"}
+	ErrInvalidGrant            = &OAuthError{"invalid_grant", "the code is invalid, expired, used, or was issued to another client"}
+	ErrUnsupportedGrantType    = &OAuthError{"unsupported_grant_type", "only authorization_code is supported"}
+	ErrUnsupportedResponseType = &OAuthError{"unsupported_response_type", "only code is supported"}
+	ErrInvalidScope            = &OAuthError{"invalid_scope", "the openid scope is required"}
+	ErrAccessDenied            = &OAuthError{"access_denied", "the user may not sign in to this client"}
+)
+
+var supportedScopes = []string{"openid", "email", "profile"}
+
+// AuthorizationRequest holds the query parameters of an authentication
+// request, forwarded by the frontend at Config.AuthorizationURL.
+type AuthorizationRequest struct {
+	ResponseType        string `form:"response_type" json:"response_type"`
+	ClientID            string `form:"client_id" json:"client_id"`
+	RedirectURI         string `form:"redirect_uri" json:"redirect_uri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	Nonce               string `form:"nonce" json:"nonce"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+}
+
+// authorizationCode is saved in the ephemeral store of auth under the code.
+type authorizationCode struct {
+	ClientID      string   `json:"client_id"`
+	RedirectURI   string   `json:"redirect_uri"`
+	UserID        uint64   `json:"user_id"`
+	Scopes        []string `json:"scopes"`
+	Nonce         string   `json:"nonce"`
+	CodeChallenge string   `json:"code_challenge"`
+	AuthTime      int64    `json:"auth_time"`
+}
+
+func codeKey(code string) string {
+	return "idp/code/" + code
+}
+
+// Authorize issues an authorization code to the signed-in user, and returns
+// where to redirect the browser to. The redirect carries either the code or,
+// for errors the client should learn about, an OAuthError.
+//
+// If the client or the redirect URI is invalid, err is returned instead, and
+// the browser must not be redirected.
+func Authorize(user *auth.User, req *AuthorizationRequest) (redirectTo string, err error) {
+	client, err := store.getClient(req.ClientID)
+	if err != nil {
+		return "", err
+	}
+	if !client.Active() {
+		return "", ErrClientDisabled
+	}
+	if !client.hasRedirectURI(req.RedirectURI) {
+		return "", ErrRedirectURIBad
+	}
+
+	code, oauthErr, err := issueCode(client, user, req)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	if oauthErr != nil {
+		query.Set("error", oauthErr.Code)
+		query.Set("error_description", oauthErr.Description)
+	} else {
+		query.Set("code", code)
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+
+	separator := "?"
+	if strings.Contains(req.RedirectURI, "?") {
+		separator = "&"
+	}
+	return req.RedirectURI + separator + query.Encode(), nil
+}
+
+func issueCode(client *Client, user *auth.User, req *AuthorizationRequest) (code string, oauthErr *OAuthError, err error) {
+	if req.ResponseType != "code" {
+		return "", ErrUnsupportedResponseType, nil
+	}
+	scopes := []string{}
+	for _, scope := range strings.Fields(req.Scope) {
+		if hasScope(supportedScopes, scope) && !hasScope(scopes, scope) { // unknown scopes are ignored
+			scopes = append(scopes, scope)
+		}
+	}
+	if !hasScope(scopes, "openid") {
+		return "", ErrInvalidScope, nil
+	}
+	// PKCE: S256 only, required for public clients
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != "S256" {
+		return "", ErrInvalidRequest, nil
+	}
+	if client.Public && req.CodeChallenge == "" {
+		return "", ErrInvalidRequest, nil
+	}
+
+	if err = auth.CheckUserActive(user.ID()); err != nil {
+		return "", ErrAccessDenied, nil
+	}
+	allowed, err := client.allowsUser(user)
+	if err != nil {
+		return "", nil, err
+	}
+	if !allowed {
+		return "", ErrAccessDenied, nil
+	}
+
+	code, err = randomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+	codeJson, err := json.Marshal(authorizationCode{
+		ClientID:      client.ClientID,
+		RedirectURI:   req.RedirectURI,
+		UserID:        user.ID(),
+		Scopes:        scopes,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      time.Now().Unix(),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if err = auth.Ephemeral().Add(codeKey(code), string(codeJson), AuthorizationCodeLifetime); err != nil {
+		return "", nil, err
+	}
+
+	err = audit(AUDIT_IDP_AUTHORIZE, user.ID(), user.ID(), map[string]interface{}{"client_id": client.ClientID, "scope": strings.Join(scopes, " ")})
+	if err != nil {
+		return "", nil, err
+	}
+	return code, nil, nil
+}
+
+// TokenResponse is the successful response of the token endpoint.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// AuthenticateClient authenticates a client at the token endpoint. Public
+// clients give no secret.
+func AuthenticateClient(clientID, secret string) (*Client, error) {
+	client, err := store.getClient(clientID)
+	if err == ErrClientNotFound {
+		return nil, ErrInvalidClient
+	} else if err != nil {
+		return nil, err
+	}
+	if !client.Active() {
+		return nil, ErrInvalidClient
+	}
+	if client.Public {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+		return client, nil
+	}
+	if client.verifySecret(secret) != nil {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// ExchangeCode redeems the code issued to the authenticated client for an
+// ID token and an access token. A code is redeemed at most once.
+func ExchangeCode(client *Client, code, redirectURI, codeVerifier string) (*TokenResponse, error) {
+	codeJson, err := auth.Ephemeral().Take(codeKey(code))
+	if err == auth.ErrEphemeralNotFound {
+		return nil, ErrInvalidGrant
+	} else if err != nil {
+		return nil, err
+	}
+	var issued authorizationCode
+	if err = json.Unmarshal([]byte(codeJson), &issued); err != nil {
+		return nil, err
+	}
+	if issued.ClientID != client.ClientID || issued.RedirectURI != redirectURI {
+		return nil, ErrInvalidGrant
+	}
+	if issued.CodeChallenge != "" || codeVerifier != "" {
+		challenge := sha256.Sum256([]byte(codeVerifier))
+		if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(challenge[:])), []byte(issued.CodeChallenge)) != 1 {
+			return nil, ErrInvalidGrant
+		}
+	}
+
+	// The user may have been deactivated since
+	if err = auth.CheckUserActive(issued.UserID); err != nil {
+		return nil, ErrInvalidGrant
+	}
+	user, err := auth.GetUserByID(issued.UserID)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := userClaims(user, issued.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims["iss"] = config.Issuer
+	claims["aud"] = client.ClientID
+	claims["azp"] = client.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(IDTokenLifetime).Unix()
+	claims["auth_time"] = issued.AuthTime
+	if issued.Nonce != "" {
+		claims["nonce"] = issued.Nonce
+	}
+	idToken, err := signJWT(jwtTypeIDToken, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := strings.Join(issued.Scopes, " ")
+	accessToken, err := signJWT(jwtTypeAccessToken, map[string]interface{}{
+		"iss":       config.Issuer,
+		"sub":       subject(user.ID()),
+		"aud":       config.Issuer,
+		"client_id": client.ClientID,
+		"scope":     scope,
+		"iat":       now.Unix(),
+		"exp":       now.Add(AccessTokenLifetime).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(AccessTokenLifetime / time.Second),
+		IDToken:     idToken,
+		Scope:       scope,
+	}, nil
+}
+
+// UserInfo returns the claims about the user of the access token.
+func UserInfo(accessToken string) (map[string]interface{}, error) {
+	claims, err := verifyAccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	client, err := store.getClient(claims.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Active() {
+		return nil, ErrClientDisabled
+	}
+
+	userID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if err = auth.CheckUserActive(userID); err != nil {
+		return nil, err
+	}
+	user, err := auth.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return userClaims(user, strings.Fields(claims.Scope))
+}
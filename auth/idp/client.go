@@ -0,0 +1,185 @@
+package idp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// A Client is a service which signs users in with Ulysses. Confidential
+// clients authenticate with their secret at the token endpoint. Public
+// clients, e.g. single-page apps, have no secret and must use PKCE.
+//
+// A client of an affiliation only signs in users of the affiliation
+// or its descendants. AffiliationID 0 allows all users.
+
+var (
+	ErrClientNotFound     = errors.New("idp: client not found")
+	ErrClientDisabled     = errors.New("idp: client is disabled")
+	ErrClientBad          = errors.New("idp: client requires a name and at least one redirect URI")
+	ErrRedirectURIBad     = errors.New("idp: redirect URI must be absolute and have no fragment")
+	ErrClientSecretBad    = errors.New("idp: client secret mismatch")
+	ErrClientPublicSecret = errors.New("idp: public client has no secret")
+)
+
+type Client struct {
+	ClientID      string     `json:"client_id"`
+	secretHash    string     // hex of SHA-256, empty for public clients
+	Name          string     `json:"name"`
+	RedirectURIs  []string   `json:"redirect_uris"`
+	Public        bool       `json:"public"`
+	AffiliationID uint64     `json:"affiliation_id"`
+	CreatedBy     uint64     `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DisabledAt    *time.Time `json:"disabled_at"`
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Client secrets are random, so a fast hash suffices.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func validRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	return err == nil && u.IsAbs() && u.Host != "" && u.Fragment == ""
+}
+
+// RegisterClient registers a client on behalf of the actor, who must hold
+// PermissionManageClients. The secret is returned only here: save it in the
+// client. It is empty for public clients.
+func RegisterClient(actor *auth.User, name string, redirectURIs []string, public bool, affiliationID uint64) (client *Client, secret string, err error) {
+	if err = checkActor(actor); err != nil {
+		return nil, "", err
+	}
+	if name == "" || len(redirectURIs) == 0 {
+		return nil, "", ErrClientBad
+	}
+	for _, redirectURI := range redirectURIs {
+		if !validRedirectURI(redirectURI) {
+			return nil, "", ErrRedirectURIBad
+		}
+	}
+	if affiliationID != 0 {
+		if _, err = auth.GetAffiliationByID(affiliationID); err != nil {
+			return nil, "", err
+		}
+	}
+
+	clientID, err := randomString(16)
+	if err != nil {
+		return nil, "", err
+	}
+	client = &Client{
+		ClientID:      clientID,
+		Name:          name,
+		RedirectURIs:  redirectURIs,
+		Public:        public,
+		AffiliationID: affiliationID,
+		CreatedBy:     actor.ID(),
+		CreatedAt:     time.Now(),
+	}
+	if !public {
+		if secret, err = randomString(32); err != nil {
+			return nil, "", err
+		}
+		client.secretHash = hashSecret(secret)
+	}
+
+	if err = store.newClient(client); err != nil {
+		return nil, "", err
+	}
+	err = audit(AUDIT_IDP_CLIENT_REGISTER, actor.ID(), 0, map[string]interface{}{"client_id": clientID, "name": name, "affiliation_id": affiliationID})
+	if err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+func GetClient(clientID string) (*Client, error) {
+	return store.getClient(clientID)
+}
+
+// ListClients lists all clients including disabled ones, oldest first.
+func ListClients() ([]*Client, error) {
+	return store.listClients()
+}
+
+// Disable stops the client from signing users in. Tokens issued already
+// remain valid until they expire.
+func (client *Client) Disable(actor *auth.User) error {
+	if err := checkActor(actor); err != nil {
+		return err
+	}
+	if client.DisabledAt != nil {
+		return nil
+	}
+	now := time.Now()
+	if err := store.disableClient(client.ClientID, now); err != nil {
+		return err
+	}
+	client.DisabledAt = &now
+	return audit(AUDIT_IDP_CLIENT_DISABLE, actor.ID(), 0, map[string]interface{}{"client_id": client.ClientID})
+}
+
+func (client *Client) Active() bool {
+	return client.DisabledAt == nil
+}
+
+func (client *Client) hasRedirectURI(redirectURI string) bool {
+	for _, registered := range client.RedirectURIs {
+		if registered == redirectURI { // exact match, as required by OpenID Connect
+			return true
+		}
+	}
+	return false
+}
+
+func (client *Client) verifySecret(secret string) error {
+	if client.Public {
+		return ErrClientPublicSecret
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(client.secretHash)) != 1 {
+		return ErrClientSecretBad
+	}
+	return nil
+}
+
+// allowsUser checks the user belongs to the affiliation of the client.
+func (client *Client) allowsUser(user *auth.User) (bool, error) {
+	if client.AffiliationID == 0 || user.AffiliationID == client.AffiliationID {
+		return true, nil
+	}
+	if user.AffiliationID == 0 {
+		return false, nil
+	}
+	affiliation, err := auth.GetAffiliationByID(user.AffiliationID)
+	if err != nil {
+		return false, err
+	}
+	ancestors, err := affiliation.Ancestors()
+	if err != nil {
+		return false, err
+	}
+	for _, ancestor := range ancestors {
+		if ancestor.ID() == client.AffiliationID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,295 @@
+package idp
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/TunnelWork/Ulysses.Lib/api"
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+	"github.com/TunnelWork/Ulysses.Lib/security"
+	"github.com/gin-gonic/gin"
+)
+
+// Endpoints in the Auth category of package api, relative to Config.Issuer:
+//
+//	GET  oidc/.well-known/openid-configuration
+//	GET  oidc/jwks
+//	POST oidc/authorize       (userGroup) called by the frontend at Config.AuthorizationURL
+//	POST oidc/token
+//	GET  oidc/userinfo        also POST
+//	POST oidc/client/register (adminGroup)
+//	GET  oidc/client/list     (adminGroup)
+//	POST oidc/client/disable  (adminGroup)
+//
+// The Access Control Funcs of userGroup and adminGroup must set
+// api.ContextKeyUserID.
+
+// RegisterEndpoints registers the endpoints with package api.
+func RegisterEndpoints(userGroup, adminGroup string) error {
+	routes := []struct {
+		method    string
+		path      string
+		userGroup string // empty for public endpoints
+		handler   *gin.HandlerFunc
+	}{
+		{http.MethodGet, "oidc/.well-known/openid-configuration", "", &discoveryHandler},
+		{http.MethodGet, "oidc/jwks", "", &jwksHandler},
+		{http.MethodPost, "oidc/authorize", userGroup, &authorizeHandler},
+		{http.MethodPost, "oidc/token", "", &tokenHandler},
+		{http.MethodGet, "oidc/userinfo", "", &userInfoHandler},
+		{http.MethodPost, "oidc/userinfo", "", &userInfoHandler},
+		{http.MethodPost, "oidc/client/register", adminGroup, &registerClientHandler},
+		{http.MethodGet, "oidc/client/list", adminGroup, &listClientsHandler},
+		{http.MethodPost, "oidc/client/disable", adminGroup, &disableClientHandler},
+	}
+
+	var err error
+	for _, route := range routes {
+		switch {
+		case route.method == http.MethodGet && route.userGroup == "":
+			err = api.CGET(api.Auth, route.path, route.handler)
+		case route.method == http.MethodGet:
+			err = api.AuthedCGET(api.Auth, route.path, route.userGroup, route.handler)
+		case route.userGroup == "":
+			err = api.CPOST(api.Auth, route.path, route.handler)
+		default:
+			err = api.AuthedCPOST(api.Auth, route.path, route.userGroup, route.handler)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/************ Protocol Endpoints ************/
+
+var discoveryHandler gin.HandlerFunc = func(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                config.Issuer,
+		"authorization_endpoint":                config.AuthorizationURL,
+		"token_endpoint":                        config.Issuer + "/token",
+		"userinfo_endpoint":                     config.Issuer + "/userinfo",
+		"jwks_uri":                              config.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"EdDSA"},
+		"scopes_supported":                      supportedScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "role", "affiliation_id", "email", "email_verified", "name", "given_name", "family_name"},
+	})
+}
+
+var jwksHandler gin.HandlerFunc = func(c *gin.Context) {
+	keys := []gin.H{}
+	for _, key := range security.PublicSigningKeys() {
+		keys = append(keys, gin.H{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"use": "sig",
+			"alg": "EdDSA",
+			"kid": key.KeyID,
+			"x":   base64.RawURLEncoding.EncodeToString(key.PublicKey),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// authorizeHandler refuses impersonated requests: the tokens issued would
+// outlive the impersonation session.
+var authorizeHandler gin.HandlerFunc = func(c *gin.Context) {
+	if api.Impersonated(c) {
+		c.JSON(http.StatusForbidden, api.MessageResponse(api.ERROR, "IMPERSONATION_FORBIDDEN"))
+		return
+	}
+	var req AuthorizationRequest
+	if c.ShouldBind(&req) != nil {
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "BAD_REQUEST"))
+		return
+	}
+	user, err := auth.GetUserByID(c.GetUint64(api.ContextKeyUserID))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, api.MessageResponse(api.ERROR, "AUTH_FAILED"))
+		return
+	}
+
+	redirectTo, err := Authorize(user, &req)
+	if err == ErrClientNotFound || err == ErrClientDisabled || err == ErrRedirectURIBad {
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "OIDC_CLIENT_INVALID"))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, api.MessageResponse(api.ERROR, "INTERNAL_ERROR"))
+		return
+	}
+	c.JSON(http.StatusOK, api.PayloadResponse(api.SUCCESS, gin.H{"redirect_to": redirectTo}))
+}
+
+// tokenHandler accepts client_secret_basic, client_secret_post, and none
+// for public clients.
+var tokenHandler gin.HandlerFunc = func(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	if c.PostForm("grant_type") != "authorization_code" {
+		oauthErrorResponse(c, ErrUnsupportedGrantType)
+		return
+	}
+	clientID, secret, ok := c.Request.BasicAuth()
+	if ok {
+		// RFC 6749 2.3.1: form-urlencoded before basic encoding
+		var idErr, secretErr error
+		clientID, idErr = url.QueryUnescape(clientID)
+		secret, secretErr = url.QueryUnescape(secret)
+		if idErr != nil || secretErr != nil {
+			oauthErrorResponse(c, ErrInvalidClient)
+			return
+		}
+	} else {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	client, err := AuthenticateClient(clientID, secret)
+	if err != nil {
+		oauthErrorResponse(c, err)
+		return
+	}
+	tokens, err := ExchangeCode(client, c.PostForm("code"), c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+	if err != nil {
+		oauthErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+var userInfoHandler gin.HandlerFunc = func(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_request"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	claims, err := UserInfo(strings.TrimPrefix(authHeader, "Bearer "))
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.JSON(http.StatusOK, claims)
+}
+
+func oauthErrorResponse(c *gin.Context, err error) {
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	status := http.StatusBadRequest
+	if oauthErr == ErrInvalidClient {
+		status = http.StatusUnauthorized
+		c.Header("WWW-Authenticate", `Basic realm="oidc"`)
+	}
+	c.JSON(status, gin.H{
+		"error":             oauthErr.Code,
+		"error_description": oauthErr.Description,
+	})
+}
+
+/************ Admin Endpoints ************/
+
+type registerClientForm struct {
+	Name          string   `json:"name" binding:"required"`
+	RedirectURIs  []string `json:"redirect_uris" binding:"required"`
+	Public        bool     `json:"public"`
+	AffiliationID uint64   `json:"affiliation_id"`
+}
+
+func actorOf(c *gin.Context) (*auth.User, bool) {
+	actor, err := auth.GetUserByID(c.GetUint64(api.ContextKeyUserID))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, api.MessageResponse(api.ERROR, "AUTH_FAILED"))
+		return nil, false
+	}
+	return actor, true
+}
+
+func adminErrorResponse(c *gin.Context, err error) {
+	switch err {
+	case ErrForbidden:
+		c.JSON(http.StatusForbidden, api.MessageResponse(api.ERROR, "PERMISSION_DENIED"))
+	case ErrClientBad, ErrRedirectURIBad:
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "OIDC_CLIENT_INVALID"))
+	case ErrClientNotFound:
+		c.JSON(http.StatusNotFound, api.MessageResponse(api.ERROR, "OIDC_CLIENT_NOT_FOUND"))
+	default:
+		c.JSON(http.StatusInternalServerError, api.MessageResponse(api.ERROR, "INTERNAL_ERROR"))
+	}
+}
+
+var registerClientHandler gin.HandlerFunc = func(c *gin.Context) {
+	var form registerClientForm
+	if c.ShouldBindJSON(&form) != nil {
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "BAD_REQUEST"))
+		return
+	}
+	actor, ok := actorOf(c)
+	if !ok {
+		return
+	}
+
+	client, secret, err := RegisterClient(actor, form.Name, form.RedirectURIs, form.Public, form.AffiliationID)
+	if err != nil {
+		adminErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, api.PayloadResponse(api.SUCCESS, gin.H{
+		"client":        client,
+		"client_secret": secret,
+	}))
+}
+
+var listClientsHandler gin.HandlerFunc = func(c *gin.Context) {
+	actor, ok := actorOf(c)
+	if !ok {
+		return
+	}
+	if err := checkActor(actor); err != nil {
+		adminErrorResponse(c, err)
+		return
+	}
+
+	clients, err := ListClients()
+	if err != nil {
+		adminErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, api.PayloadResponse(api.SUCCESS, clients))
+}
+
+var disableClientHandler gin.HandlerFunc = func(c *gin.Context) {
+	var form struct {
+		ClientID string `json:"client_id" binding:"required"`
+	}
+	if c.ShouldBindJSON(&form) != nil {
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "BAD_REQUEST"))
+		return
+	}
+	actor, ok := actorOf(c)
+	if !ok {
+		return
+	}
+
+	client, err := GetClient(form.ClientID)
+	if err == nil {
+		err = client.Disable(actor)
+	}
+	if err != nil {
+		adminErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, api.MessageResponse(api.SUCCESS, "OIDC_CLIENT_DISABLED"))
+}
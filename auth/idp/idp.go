@@ -0,0 +1,118 @@
+// Package idp makes Ulysses an OpenID Connect provider, so that provisioned
+// services (dashboards, VPN portals, etc.) can sign users in with their
+// Ulysses accounts instead of keeping their own.
+//
+// Services are registered as clients by admins (RegisterClient). The
+// authorization code flow is supported, with PKCE required for public
+// clients. ID tokens and access tokens are JWTs signed with EdDSA by the
+// active key of package security, and published in the JWKS. Besides the
+// standard claims, tokens carry the role bits and the AffiliationID of
+// the user. See userClaims.
+//
+// The endpoints are registered in the Auth category of package api by
+// RegisterEndpoints.
+package idp
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+const (
+	// PermissionManageClients allows registering and disabling clients.
+	// Granted to GLOBAL_ADMIN.
+	PermissionManageClients auth.Permission = "auth.idp.client.manage"
+)
+
+// Audit event types
+const (
+	AUDIT_IDP_CLIENT_REGISTER = "idp_client_register"
+	AUDIT_IDP_CLIENT_DISABLE  = "idp_client_disable"
+	AUDIT_IDP_AUTHORIZE       = "idp_authorize"
+)
+
+var (
+	AuthorizationCodeLifetime = time.Minute
+	IDTokenLifetime           = time.Hour
+	AccessTokenLifetime       = time.Hour
+
+	ErrConfigIncomplete = errors.New("idp: config requires Issuer and AuthorizationURL")
+	ErrForbidden        = errors.New("idp: actor may not manage clients")
+	ErrStoreUnsupported = errors.New("idp: store of package auth is not supported")
+)
+
+// Config of the provider.
+type Config struct {
+	// Issuer is the URL the endpoints are served under, i.e. the path prefix
+	// passed to api.FinalizeGinEngine followed by "auth/oidc", e.g.
+	// "https://example.com/api/auth/oidc".
+	Issuer string
+	// AuthorizationURL is the page of the frontend where a signed-in user
+	// approves the authorization request. It forwards the query to the
+	// authorize endpoint, and the browser to the redirect it gets back.
+	AuthorizationURL string
+}
+
+var config Config
+
+// Setup() of idp package requires:
+// - Previous Setup() of auth package, with authStore
+// - An active key in package security, see security.AddSigningKey
+//
+// The clients are kept in the database of authStore if it is an
+// auth.SQLStore, or in memory with an auth.MemoryStore.
+func Setup(authStore auth.Store, conf Config) error {
+	if conf.Issuer == "" || conf.AuthorizationURL == "" {
+		return ErrConfigIncomplete
+	}
+	conf.Issuer = strings.TrimSuffix(conf.Issuer, "/")
+
+	s, err := newClientStore(authStore)
+	if err != nil {
+		return err
+	}
+	if err = s.init(); err != nil {
+		return err
+	}
+	config = conf
+	store = s
+
+	if err := auth.RegPermission(PermissionManageClients, "Register and disable OpenID Connect clients"); err != nil && err != auth.ErrPermissionRepeated {
+		return err
+	}
+	return auth.RegBuiltinRolePermissions(auth.GLOBAL_ADMIN, PermissionManageClients)
+}
+
+// Issuer returns the configured issuer, e.g. for clients to discover.
+func Issuer() string {
+	return config.Issuer
+}
+
+func checkActor(actor *auth.User) error {
+	allowed, err := actor.HasPermission(PermissionManageClients)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// audit records an event in the audit trail of package auth.
+func audit(eventType string, actorUserID, subjectUserID uint64, detail map[string]interface{}) error {
+	detailJson, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	return auth.RecordAuditEvent(&auth.AuditEvent{
+		EventType:     eventType,
+		ActorUserID:   actorUserID,
+		SubjectUserID: subjectUserID,
+		Detail:        detailJson,
+	})
+}
@@ -0,0 +1,64 @@
+package idp
+
+import (
+	"database/sql"
+	"strings"
+)
+
+/************ Table Definitions ************/
+
+const (
+	clientTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_idp_client (
+        client_id VARCHAR(64) NOT NULL,
+        secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+        name VARCHAR(64) NOT NULL,
+        redirect_uris TEXT NOT NULL,
+        public BOOLEAN NOT NULL DEFAULT FALSE,
+        affiliation_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+        created_by BIGINT UNSIGNED NOT NULL,
+        created_at DATETIME NOT NULL,
+        disabled_at DATETIME NULL DEFAULT NULL,
+        PRIMARY KEY (client_id)
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+	sqliteClientTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_idp_client (
+        client_id VARCHAR(64) NOT NULL PRIMARY KEY,
+        secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+        name VARCHAR(64) NOT NULL,
+        redirect_uris TEXT NOT NULL,
+        public BOOLEAN NOT NULL DEFAULT FALSE,
+        affiliation_id INTEGER NOT NULL DEFAULT 0,
+        created_by INTEGER NOT NULL,
+        created_at DATETIME NOT NULL,
+        disabled_at DATETIME NULL DEFAULT NULL
+    );`
+)
+
+// sqlStore keeps the clients in the database of an auth.SQLStore.
+type sqlStore struct {
+	db        *sql.DB
+	tblPrefix string
+	sqlite    bool
+}
+
+/************ Helper Functions ************/
+func (s *sqlStore) statement(query string) (*sql.Stmt, error) {
+	prefixUpdatedQuery := strings.ReplaceAll(query, "dbprefix_", s.tblPrefix)
+
+	return s.db.Prepare(prefixUpdatedQuery)
+}
+
+/************ Table Creations ************/
+func (s *sqlStore) init() error {
+	tblCreation := clientTblCreation
+	if s.sqlite {
+		tblCreation = sqliteClientTblCreation
+	}
+	stmt, err := s.statement(tblCreation)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec()
+	return err
+}
@@ -0,0 +1,91 @@
+package idp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+/************ Client Database ************/
+
+func scanClient(scanner interface{ Scan(...interface{}) error }) (*Client, error) {
+	var client Client
+	var redirectURIsJson string
+	var disabledAt sql.NullTime
+	err := scanner.Scan(&client.ClientID, &client.secretHash, &client.Name, &redirectURIsJson, &client.Public, &client.AffiliationID, &client.CreatedBy, &client.CreatedAt, &disabledAt)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(redirectURIsJson), &client.RedirectURIs); err != nil {
+		return nil, err
+	}
+	if disabledAt.Valid {
+		client.DisabledAt = &disabledAt.Time
+	}
+	return &client, nil
+}
+
+func (s *sqlStore) newClient(client *Client) error {
+	redirectURIsJson, err := json.Marshal(client.RedirectURIs)
+	if err != nil {
+		return err
+	}
+
+	stmtInsertClient, err := s.statement(`INSERT INTO dbprefix_idp_client (client_id, secret_hash, name, redirect_uris, public, affiliation_id, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertClient.Close()
+
+	_, err = stmtInsertClient.Exec(client.ClientID, client.secretHash, client.Name, string(redirectURIsJson), client.Public, client.AffiliationID, client.CreatedBy, client.CreatedAt)
+	return err
+}
+
+func (s *sqlStore) getClient(clientID string) (*Client, error) {
+	stmtGetClient, err := s.statement(`SELECT client_id, secret_hash, name, redirect_uris, public, affiliation_id, created_by, created_at, disabled_at FROM dbprefix_idp_client WHERE client_id = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetClient.Close()
+
+	client, err := scanClient(stmtGetClient.QueryRow(clientID))
+	if err == sql.ErrNoRows {
+		return nil, ErrClientNotFound
+	}
+	return client, err
+}
+
+func (s *sqlStore) listClients() ([]*Client, error) {
+	stmtListClient, err := s.statement(`SELECT client_id, secret_hash, name, redirect_uris, public, affiliation_id, created_by, created_at, disabled_at FROM dbprefix_idp_client ORDER BY created_at ASC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListClient.Close()
+
+	rows, err := stmtListClient.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []*Client = []*Client{}
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+func (s *sqlStore) disableClient(clientID string, disabledAt time.Time) error {
+	stmtDisableClient, err := s.statement(`UPDATE dbprefix_idp_client SET disabled_at = ? WHERE client_id = ? AND disabled_at IS NULL;`)
+	if err != nil {
+		return err
+	}
+	defer stmtDisableClient.Close()
+
+	_, err = stmtDisableClient.Exec(disabledAt, clientID)
+	return err
+}
@@ -0,0 +1,34 @@
+package idp
+
+import (
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// clientStore keeps the clients along with the store of package auth: in its
+// database with an auth.SQLStore, or in memory with an auth.MemoryStore.
+type clientStore interface {
+	init() error
+	newClient(client *Client) error
+	getClient(clientID string) (*Client, error) // ErrClientNotFound if none
+	listClients() ([]*Client, error)            // oldest first
+	disableClient(clientID string, disabledAt time.Time) error
+}
+
+var (
+	_ clientStore = (*sqlStore)(nil)
+	_ clientStore = (*memoryStore)(nil)
+)
+
+var store clientStore
+
+func newClientStore(authStore auth.Store) (clientStore, error) {
+	switch s := authStore.(type) {
+	case auth.SQLStore:
+		return &sqlStore{db: s.DB(), tblPrefix: s.TablePrefix(), sqlite: s.SQLite()}, nil
+	case *auth.MemoryStore:
+		return newMemoryStore(), nil
+	}
+	return nil, ErrStoreUnsupported
+}
@@ -0,0 +1,74 @@
+package idp
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// memoryStore keeps the clients with an auth.MemoryStore, for unit tests.
+type memoryStore struct {
+	mutex   sync.RWMutex
+	clients map[string]Client
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{clients: map[string]Client{}}
+}
+
+func (s *memoryStore) init() error {
+	return nil
+}
+
+func (s *memoryStore) newClient(client *Client) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.clients[client.ClientID]; ok {
+		return auth.ErrStoreDuplicate
+	}
+	stored := *client
+	stored.RedirectURIs = append([]string{}, client.RedirectURIs...)
+	stored.DisabledAt = nil
+	s.clients[client.ClientID] = stored
+	return nil
+}
+
+func (s *memoryStore) getClient(clientID string) (*Client, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	client, ok := s.clients[clientID]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+	client.RedirectURIs = append([]string{}, client.RedirectURIs...)
+	return &client, nil
+}
+
+func (s *memoryStore) listClients() ([]*Client, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var clients []*Client = []*Client{}
+	for _, client := range s.clients {
+		client := client
+		client.RedirectURIs = append([]string{}, client.RedirectURIs...)
+		clients = append(clients, &client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].CreatedAt.Before(clients[j].CreatedAt) })
+	return clients, nil
+}
+
+func (s *memoryStore) disableClient(clientID string, disabledAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if client, ok := s.clients[clientID]; ok && client.DisabledAt == nil {
+		client.DisabledAt = &disabledAt
+		s.clients[clientID] = client
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package idp
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+	"github.com/TunnelWork/Ulysses.Lib/security"
+)
+
+const (
+	jwtTypeIDToken     = "JWT"
+	jwtTypeAccessToken = "at+jwt" // RFC 9068
+)
+
+var (
+	ErrTokenInvalid = errors.New("idp: token is malformed, not signed by us, or expired")
+)
+
+// signJWT signs the claims with the active key of package security.
+func signJWT(typ string, claims map[string]interface{}) (string, error) {
+	key, err := security.ActiveSigningKey()
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(map[string]string{
+		"alg": "EdDSA",
+		"typ": typ,
+		"kid": key.KeyID,
+	})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(key.Sign([]byte(signed))), nil
+}
+
+// accessTokenClaims are the claims of the access tokens issued by us.
+type accessTokenClaims struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	Expiry   int64  `json:"exp"`
+}
+
+// verifyAccessToken checks an access token was issued by us and not expired.
+func verifyAccessToken(token string) (*accessTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Typ string `json:"typ"`
+		Kid string `json:"kid"`
+	}
+	if json.Unmarshal(headerJson, &header) != nil || header.Alg != "EdDSA" || header.Typ != jwtTypeAccessToken {
+		return nil, ErrTokenInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !security.VerifySignature(header.Kid, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	var claims accessTokenClaims
+	if json.Unmarshal(payload, &claims) != nil {
+		return nil, ErrTokenInvalid
+	}
+	if claims.Issuer != config.Issuer || time.Now().Unix() >= claims.Expiry {
+		return nil, ErrTokenInvalid
+	}
+	return &claims, nil
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// userClaims returns the claims about the user which the scopes allow:
+//   - always: sub, role (the bits of the effective role), affiliation_id
+//   - email: email, email_verified
+//   - profile: name, given_name, family_name
+func userClaims(user *auth.User, scopes []string) (map[string]interface{}, error) {
+	role, err := user.EffectiveRole()
+	if err != nil {
+		return nil, err
+	}
+	claims := map[string]interface{}{
+		"sub":            subject(user.ID()),
+		"role":           uint32(role),
+		"affiliation_id": user.AffiliationID,
+	}
+
+	if hasScope(scopes, "email") {
+		verified, err := user.EmailVerified()
+		if err != nil {
+			return nil, err
+		}
+		claims["email"] = user.Email
+		claims["email_verified"] = verified
+	}
+	if hasScope(scopes, "profile") {
+		info, err := user.Info()
+		if err == nil {
+			claims["given_name"] = info.FirstName
+			claims["family_name"] = info.LastName
+			claims["name"] = strings.TrimSpace(info.FirstName + " " + info.LastName)
+		} else if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// subject is the sub claim of the user: the user ID in decimal.
+func subject(userID uint64) string {
+	return strconv.FormatUint(userID, 10)
+}
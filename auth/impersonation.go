@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// An impersonation session lets a GLOBAL_ADMIN act as another user, e.g. for
+// support staff to see what a customer sees. It is bound to the admin,
+// expires, may be read-only, and is recorded in the audit trail as well as
+// every request made with it: impersonation requires a store with the audit
+// trail. The target sees it in their SecurityHistory.
+//
+// The API layer decides which actions may not be taken while impersonating.
+// See api.AcceptImpersonation and api.DenyImpersonation.
+
+const (
+	impersonationTmpExtension = "impersonation"
+	impersonationTokenBytes   = 16 // hex-encoded into 32 chars
+	impersonationIDBytes      = 8
+)
+
+var (
+	DefaultImpersonationLifetime = 30 * time.Minute
+	MaxImpersonationLifetime     = 2 * time.Hour
+
+	ErrImpersonationNotPermitted = errors.New("auth: actor may not impersonate the target user")
+	ErrImpersonationReasonEmpty  = errors.New("auth: impersonation requires a reason")
+	ErrImpersonationTooLong      = errors.New("auth: impersonation lifetime exceeds MaxImpersonationLifetime")
+	ErrImpersonationInvalid      = errors.New("auth: impersonation token is invalid")
+	ErrImpersonationExpired      = errors.New("auth: impersonation token expired")
+)
+
+type Impersonation struct {
+	ID           string    `json:"id"` // not secret: identifies the session in the audit trail
+	Token        string    `json:"token"`
+	AdminUserID  uint64    `json:"admin_user_id"`
+	TargetUserID uint64    `json:"target_user_id"`
+	Reason       string    `json:"reason"`
+	ReadOnly     bool      `json:"read_only"`
+	IssuedAt     time.Time `json:"issued_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// checkImpersonation checks that admin holds GLOBAL_ADMIN, that target
+// does not, and that both are active.
+func checkImpersonation(admin, target *User) error {
+	if admin.id == target.id {
+		return ErrImpersonationNotPermitted
+	}
+	adminRole, err := admin.EffectiveRole()
+	if err != nil {
+		return err
+	}
+	targetRole, err := target.EffectiveRole()
+	if err != nil {
+		return err
+	}
+	if !adminRole.Includes(GLOBAL_ADMIN) || targetRole.Includes(GLOBAL_ADMIN) {
+		return ErrImpersonationNotPermitted
+	}
+	if err = CheckUserActive(admin.id); err != nil {
+		return err
+	}
+	return CheckUserActive(target.id)
+}
+
+// StartImpersonation mints an impersonation token for admin to act as
+// target, valid for lifetime (DefaultImpersonationLifetime if 0). The reason,
+// e.g. a support ticket, is recorded in the audit trail.
+func StartImpersonation(admin, target *User, reason string, readOnly bool, lifetime time.Duration) (*Impersonation, error) {
+	if reason == "" {
+		return nil, ErrImpersonationReasonEmpty
+	}
+	if lifetime == 0 {
+		lifetime = DefaultImpersonationLifetime
+	}
+	if lifetime < 0 || lifetime > MaxImpersonationLifetime {
+		return nil, ErrImpersonationTooLong
+	}
+	if err := checkImpersonation(admin, target); err != nil {
+		return nil, err
+	}
+
+	tokenStr, err := randomToken(impersonationTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+	id, err := randomToken(impersonationIDBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	impersonation := &Impersonation{
+		ID:           id,
+		Token:        tokenStr,
+		AdminUserID:  admin.id,
+		TargetUserID: target.id,
+		Reason:       reason,
+		ReadOnly:     readOnly,
+		IssuedAt:     now,
+		ExpiresAt:    now.Add(lifetime),
+	}
+
+	// Without the audit trail, there is no impersonation
+	err = recordImpersonationEvent(impersonation, AUDIT_IMPERSONATION_START, "", "", map[string]interface{}{
+		"reason":     reason,
+		"read_only":  readOnly,
+		"expires_at": impersonation.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	impersonationJson, err := json.Marshal(impersonation)
+	if err != nil {
+		return nil, err
+	}
+	err = Ephemeral().Add(UserEphemeralKey(admin.id, impersonationTmpExtension, impersonation.Token), string(impersonationJson), lifetime)
+	if err != nil {
+		return nil, err
+	}
+	return impersonation, nil
+}
+
+// VerifyImpersonation returns the impersonation session of the token if it
+// was minted for the admin and is not expired. The roles and the status of
+// both users are checked again, so that a demoted admin or a deactivated
+// target ends the session.
+func VerifyImpersonation(adminUserID uint64, token string) (*Impersonation, error) {
+	if len(token) != 2*impersonationTokenBytes {
+		return nil, ErrImpersonationInvalid
+	}
+
+	key := UserEphemeralKey(adminUserID, impersonationTmpExtension, token)
+	impersonationJson, err := Ephemeral().Get(key)
+	if err != nil {
+		return nil, ErrImpersonationInvalid
+	}
+
+	var impersonation Impersonation
+	err = json.Unmarshal([]byte(impersonationJson), &impersonation)
+	if err != nil {
+		return nil, err
+	}
+	if impersonation.AdminUserID != adminUserID {
+		return nil, ErrImpersonationInvalid
+	}
+	if time.Now().After(impersonation.ExpiresAt) {
+		_ = Ephemeral().Delete(key)
+		return nil, ErrImpersonationExpired
+	}
+
+	admin, err := GetUserByID(impersonation.AdminUserID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := GetUserByID(impersonation.TargetUserID)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkImpersonation(admin, target); err != nil {
+		_ = Ephemeral().Delete(key)
+		return nil, err
+	}
+	return &impersonation, nil
+}
+
+// EndImpersonation invalidates the token before it expires.
+func EndImpersonation(adminUserID uint64, token string) error {
+	impersonationJson, err := Ephemeral().Take(UserEphemeralKey(adminUserID, impersonationTmpExtension, token))
+	if err == ErrEphemeralNotFound {
+		return ErrImpersonationInvalid
+	} else if err != nil {
+		return err
+	}
+
+	var impersonation Impersonation
+	err = json.Unmarshal([]byte(impersonationJson), &impersonation)
+	if err != nil {
+		return err
+	}
+	return recordImpersonationEvent(&impersonation, AUDIT_IMPERSONATION_END, "", "", nil)
+}
+
+// RecordImpersonatedRequest records a request made with the impersonation
+// session, e.g. its method and path in detail.
+func RecordImpersonatedRequest(impersonation *Impersonation, ip, userAgent string, detail map[string]interface{}) error {
+	return recordImpersonationEvent(impersonation, AUDIT_IMPERSONATION_REQUEST, ip, userAgent, detail)
+}
+
+// recordImpersonationEvent records the event with the ID of the session.
+func recordImpersonationEvent(impersonation *Impersonation, eventType, ip, userAgent string, detail map[string]interface{}) error {
+	if detail == nil {
+		detail = map[string]interface{}{}
+	}
+	detail["impersonation_id"] = impersonation.ID
+	detailJson, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	return RecordAuditEvent(&AuditEvent{
+		EventType:     eventType,
+		ActorUserID:   impersonation.AdminUserID,
+		SubjectUserID: impersonation.TargetUserID,
+		IP:            ip,
+		UserAgent:     userAgent,
+		Detail:        detailJson,
+	})
+}
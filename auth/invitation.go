@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Invitation status
+const (
+	INVITATION_PENDING uint8 = iota
+	INVITATION_ACCEPTED
+	INVITATION_DECLINED
+	INVITATION_REVOKED
+)
+
+const (
+	invitationTokenBytes = 32
+)
+
+var (
+	DefaultInvitationLifetime = 7 * 24 * time.Hour
+
+	ErrInvitationNotPermitted      = errors.New("auth: inviter may not grant the roles in the affiliation")
+	ErrInvitationManageNotAllowed  = errors.New("auth: actor may not manage the invitations of the affiliation")
+	ErrInvitationRoleNotAllowed    = errors.New("auth: invitation may only grant affiliation roles")
+	ErrInvitationEmailEmpty        = errors.New("auth: invitation email is empty")
+	ErrInvitationNotPending        = errors.New("auth: invitation is no longer pending")
+	ErrInvitationExpired           = errors.New("auth: invitation expired")
+	ErrInvitationEmailMismatch     = errors.New("auth: invitation was sent to another email")
+	ErrInvitationEmailUnverified   = errors.New("auth: email of the invitee is not verified")
+	ErrInvitationAlreadyAffiliated = errors.New("auth: user already belongs to an affiliation")
+	ErrAffiliationRolesHeld        = errors.New("auth: user holds affiliation roles, leave the affiliation first")
+)
+
+type AffiliationInvitation struct {
+	id            uint64
+	AffiliationID uint64    `json:"affiliation"`
+	Email         string    `json:"email"`
+	Role          Role      `json:"role"` // AFFILIATION_* roles only
+	InviterUserID uint64    `json:"inviter"`
+	Status        uint8     `json:"status"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+
+	tokenHash string // hex of SHA-256
+	token     string // only to be sent to the invitee, set by Invite and Resend
+}
+
+func (invitation *AffiliationInvitation) ID() uint64 {
+	return invitation.id
+}
+
+// Token is to be sent to the invitee, e.g. in a link by email. Only its hash
+// is saved: it is empty for invitations loaded from the store.
+func (invitation *AffiliationInvitation) Token() string {
+	return invitation.token
+}
+
+// Invite creates an invitation to join the affiliation with the affiliation roles.
+// The inviter must be permitted to grant the roles to a member of the affiliation,
+// e.g. an AFFILIATION_ACCOUNT_ADMIN of it holding them. See CanGrant.
+// The caller is responsible for sending Token() to the email.
+func (affiliation *Affiliation) Invite(inviter *User, email string, role Role) (*AffiliationInvitation, error) {
+	if !AFFILIATION_ROLES.Includes(role) {
+		return nil, ErrInvitationRoleNotAllowed
+	}
+	if err := checkInviter(inviter, affiliation.id, RoleDelta{Grant: role}); err != nil {
+		return nil, err
+	}
+	if email == "" {
+		return nil, ErrInvitationEmailEmpty
+	}
+
+	token, err := randomToken(invitationTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invitation := &AffiliationInvitation{
+		AffiliationID: affiliation.id,
+		Email:         email,
+		Role:          role,
+		InviterUserID: inviter.id,
+		Status:        INVITATION_PENDING,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(DefaultInvitationLifetime),
+		tokenHash:     hashToken(token),
+		token:         token,
+	}
+	err = store.NewInvitation(invitation)
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// Invitations lists all invitations of the affiliation, latest first.
+func (affiliation *Affiliation) Invitations() ([]*AffiliationInvitation, error) {
+	return store.ListInvitationsByAffiliationID(affiliation.id)
+}
+
+// GetAffiliationInvitationByToken is called when the invitee follows the link.
+func GetAffiliationInvitationByToken(token string) (*AffiliationInvitation, error) {
+	return store.GetInvitationByTokenHash(hashToken(token))
+}
+
+// checkInvitationActor checks that actor may manage the members of the
+// affiliation, i.e. is an AFFILIATION_ACCOUNT_ADMIN of it. See CanManage.
+func checkInvitationActor(actor *User, affiliationID uint64) error {
+	canManage, err := CanManage(actor, &User{AffiliationID: affiliationID})
+	if err != nil {
+		return err
+	}
+	if !canManage {
+		return ErrInvitationManageNotAllowed
+	}
+	return nil
+}
+
+// checkInviter checks that inviter may apply roleDelta to a member of the affiliation.
+func checkInviter(inviter *User, affiliationID uint64, roleDelta RoleDelta) error {
+	canGrant, err := CanGrant(inviter, &User{AffiliationID: affiliationID}, roleDelta)
+	if err != nil {
+		return err
+	}
+	if !canGrant {
+		return ErrInvitationNotPermitted
+	}
+	return nil
+}
+
+func (invitation *AffiliationInvitation) checkPending() error {
+	if invitation.Status != INVITATION_PENDING {
+		return ErrInvitationNotPending
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		return ErrInvitationExpired
+	}
+	return nil
+}
+
+// Accept sets AffiliationID of the invitee, replaces their AFFILIATION_* roles
+// by the invited ones and marks the invitation accepted atomically. The roles
+// are changed on behalf of the inviter, who must still be permitted to.
+// The user must not belong to another affiliation, and must have verified
+// the email the invitation was sent to.
+func (invitation *AffiliationInvitation) Accept(user *User) error {
+	if err := invitation.checkPending(); err != nil {
+		return err
+	}
+	if !strings.EqualFold(invitation.Email, user.Email) {
+		return ErrInvitationEmailMismatch
+	}
+	verified, err := user.EmailVerified()
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return ErrInvitationEmailUnverified
+	}
+	if user.AffiliationID != 0 && user.AffiliationID != invitation.AffiliationID {
+		return ErrInvitationAlreadyAffiliated
+	}
+
+	inviter, err := GetUserByID(invitation.InviterUserID)
+	if err != nil {
+		return err
+	}
+	roleDelta := RoleDelta{
+		Grant:  invitation.Role,
+		Revoke: user.Role & AFFILIATION_ROLES &^ invitation.Role,
+	}
+	if err = checkInviter(inviter, invitation.AffiliationID, roleDelta); err != nil {
+		return err
+	}
+	if user.AffiliationID != invitation.AffiliationID {
+		if err = user.revokeTemporaryGrants(0, AFFILIATION_ROLES, "affiliation changed"); err != nil {
+			return err
+		}
+	}
+
+	err = store.AcceptInvitation(invitation, user.id, roleDelta)
+	if err != nil {
+		return err
+	}
+	stored, err := GetUserByID(user.id)
+	if err != nil {
+		return err
+	}
+	user.AffiliationID = stored.AffiliationID
+	user.Role = stored.Role
+	invitation.Status = INVITATION_ACCEPTED
+
+	if roleDelta.Grant == ROLELESS && roleDelta.Revoke == ROLELESS {
+		_, err = user.MFAEnrollmentState()
+		return err
+	}
+	_, err = user.recordRoleDelta(inviter.id, roleDelta, fmt.Sprintf("accepted invitation %d", invitation.id))
+	return err
+}
+
+func (invitation *AffiliationInvitation) Decline() error {
+	if err := invitation.checkPending(); err != nil {
+		return err
+	}
+	err := store.UpdateInvitationStatus(invitation.id, INVITATION_DECLINED)
+	if err == nil {
+		invitation.Status = INVITATION_DECLINED
+	}
+	return err
+}
+
+// Revoke is called by an account admin of the affiliation on behalf of actor.
+func (invitation *AffiliationInvitation) Revoke(actor *User) error {
+	if err := checkInvitationActor(actor, invitation.AffiliationID); err != nil {
+		return err
+	}
+	if invitation.Status != INVITATION_PENDING {
+		return ErrInvitationNotPending
+	}
+	err := store.UpdateInvitationStatus(invitation.id, INVITATION_REVOKED)
+	if err == nil {
+		invitation.Status = INVITATION_REVOKED
+	}
+	return err
+}
+
+// Resend renews the token and the expiry of a pending (or expired) invitation,
+// on behalf of actor, an account admin of the affiliation.
+// The previous token is no longer valid.
+// The caller is responsible for sending the new Token() to the email.
+func (invitation *AffiliationInvitation) Resend(actor *User) error {
+	if err := checkInvitationActor(actor, invitation.AffiliationID); err != nil {
+		return err
+	}
+	if invitation.Status != INVITATION_PENDING {
+		return ErrInvitationNotPending
+	}
+
+	token, err := randomToken(invitationTokenBytes)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(DefaultInvitationLifetime)
+
+	tokenHash := hashToken(token)
+	err = store.RenewInvitation(invitation.id, tokenHash, expiresAt)
+	if err != nil {
+		return err
+	}
+	invitation.tokenHash = tokenHash
+	invitation.token = token
+	invitation.ExpiresAt = expiresAt
+	return nil
+}
+
+// LeaveAffiliation strips all AFFILIATION_* roles of the user, recorded in the
+// role grant history, and detaches the user from the affiliation. Temporary
+// grants of AFFILIATION_* roles are revoked.
+func (user *User) LeaveAffiliation() error {
+	if err := user.revokeTemporaryGrants(user.id, AFFILIATION_ROLES, "left the affiliation"); err != nil {
+		return err
+	}
+	if held := user.Role & AFFILIATION_ROLES; held != ROLELESS {
+		if _, err := user.applyRoleDelta(user.id, RoleDelta{Revoke: held}, "left the affiliation"); err != nil {
+			return err
+		}
+	}
+	user.AffiliationID = 0
+	return user.Update()
+}
@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Failed logins and MFA challenges are counted per user and per IP.
+// Each failure delays the next attempt exponentially, and MaxFailures
+// consecutive failures lock the user (or IP) out temporarily. A counter
+// starts over once its last failure is older than FailureWindow.
+//
+// A success resets the counter of the user, and forgives the IP the failures
+// of that user from it: failures against other users are kept, so that an
+// attacker with one valid account may not guess others from the same IP.
+
+type LockoutPolicy struct {
+	MaxFailures     uint          // consecutive failures before a lockout
+	BaseBackoff     time.Duration // delay after the first failure, doubled for each one after
+	MaxBackoff      time.Duration
+	LockoutDuration time.Duration
+	FailureWindow   time.Duration // failures older than it are forgotten
+}
+
+// LockoutEvent is emitted when a user or an IP is locked out
+type LockoutEvent struct {
+	UserID      uint64    `json:"user_id"` // 0 if the IP is locked out
+	IP          string    `json:"ip"`      // empty if the user is locked out
+	Failures    uint      `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	UnlockToken string    `json:"unlock_token"` // for the emailed unlock link. Empty if the IP is locked out.
+}
+
+type LockoutHandler func(event LockoutEvent)
+
+const (
+	lockoutSubjectUser = "user"
+	lockoutSubjectIP   = "ip"
+	lockoutSubjectPair = "pair" // failures of a user from an IP, never locked out
+
+	unlockTmpExtension = "unlock"
+	unlockTokenBytes   = 16 // hex-encoded into 32 chars
+)
+
+var (
+	DefaultUnlockTokenLifetime = 24 * time.Hour
+
+	lockoutPolicyMutex sync.RWMutex  = sync.RWMutex{}
+	lockoutPolicy      LockoutPolicy = LockoutPolicy{
+		MaxFailures:     10,
+		BaseBackoff:     time.Second,
+		MaxBackoff:      5 * time.Minute,
+		LockoutDuration: time.Hour,
+		FailureWindow:   24 * time.Hour,
+	}
+
+	lockoutHandlerMutex sync.RWMutex     = sync.RWMutex{}
+	lockoutHandlers     []LockoutHandler = []LockoutHandler{}
+
+	ErrLockedOut        = errors.New("auth: too many failures, temporarily locked out")
+	ErrBackoff          = errors.New("auth: too many failures, try again later")
+	ErrUnlockTokenBad   = errors.New("auth: unlock token is invalid")
+	ErrLockoutPolicyBad = errors.New("auth: lockout policy requires MaxFailures, BaseBackoff, LockoutDuration, FailureWindow and MaxBackoff no shorter than BaseBackoff")
+)
+
+// SetLockoutPolicy replaces the lockout policy. The default allows 10 failures
+// within a day, with a backoff from a second to 5 minutes, before a lockout
+// of an hour.
+func SetLockoutPolicy(policy LockoutPolicy) error {
+	if policy.MaxFailures == 0 || policy.BaseBackoff <= 0 || policy.MaxBackoff < policy.BaseBackoff || policy.LockoutDuration <= 0 || policy.FailureWindow <= 0 {
+		return ErrLockoutPolicyBad
+	}
+
+	lockoutPolicyMutex.Lock()
+	defer lockoutPolicyMutex.Unlock()
+
+	lockoutPolicy = policy
+	return nil
+}
+
+// GetLockoutPolicy returns the lockout policy in effect.
+func GetLockoutPolicy() LockoutPolicy {
+	lockoutPolicyMutex.RLock()
+	defer lockoutPolicyMutex.RUnlock()
+
+	return lockoutPolicy
+}
+
+// RegLockoutHandler registers a handler to be called on every lockout,
+// e.g. to notify the user by email with the unlock link.
+func RegLockoutHandler(handler LockoutHandler) {
+	lockoutHandlerMutex.Lock()
+	defer lockoutHandlerMutex.Unlock()
+
+	lockoutHandlers = append(lockoutHandlers, handler)
+}
+
+func emitLockoutEvent(event LockoutEvent) {
+	lockoutHandlerMutex.RLock()
+	defer lockoutHandlerMutex.RUnlock()
+
+	for _, handler := range lockoutHandlers {
+		handler(event)
+	}
+}
+
+// CheckLockout returns ErrLockedOut or ErrBackoff if the user or the IP
+// may not attempt to authenticate now. Either userID or ip may be empty.
+func CheckLockout(userID uint64, ip string) error {
+	if userID != 0 {
+		if err := checkLockout(lockoutSubjectUser, strconv.FormatUint(userID, 10)); err != nil {
+			return err
+		}
+	}
+	if ip != "" {
+		if err := checkLockout(lockoutSubjectIP, ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkLockout(subjectType, subject string) error {
+	failures, lastFailure, lockedUntil, err := store.GetLockoutState(subjectType, subject)
+	if err != nil {
+		return err
+	}
+	if failures == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if lockedUntil.Valid {
+		if now.Before(lockedUntil.Time) {
+			return ErrLockedOut
+		}
+		// Lockout is over, start over
+		return store.ClearLockout(subjectType, subject)
+	}
+	if now.Sub(lastFailure) > GetLockoutPolicy().FailureWindow {
+		return store.ClearLockout(subjectType, subject)
+	}
+
+	if now.Before(lastFailure.Add(lockoutBackoff(failures))) {
+		return ErrBackoff
+	}
+	return nil
+}
+
+// lockoutBackoff is BaseBackoff * 2^(failures-1), capped by MaxBackoff
+func lockoutBackoff(failures uint) time.Duration {
+	policy := GetLockoutPolicy()
+	backoff := policy.BaseBackoff
+	for i := uint(1); i < failures; i++ {
+		backoff *= 2
+		if backoff >= policy.MaxBackoff {
+			return policy.MaxBackoff
+		}
+	}
+	return backoff
+}
+
+// RecordAuthFailure counts a failed login or MFA challenge.
+// Either userID or ip may be empty.
+func RecordAuthFailure(userID uint64, ip string) error {
+	if userID != 0 {
+		if err := recordAuthFailure(lockoutSubjectUser, strconv.FormatUint(userID, 10), userID, ""); err != nil {
+			return err
+		}
+	}
+	if ip != "" {
+		if err := recordAuthFailure(lockoutSubjectIP, ip, 0, ip); err != nil {
+			return err
+		}
+	}
+	if userID != 0 && ip != "" {
+		policy := GetLockoutPolicy()
+		if _, err := store.IncrLockoutFailures(lockoutSubjectPair, lockoutPair(userID, ip), time.Now(), policy.FailureWindow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lockoutPair(userID uint64, ip string) string {
+	return strconv.FormatUint(userID, 10) + "@" + ip
+}
+
+func recordAuthFailure(subjectType, subject string, userID uint64, ip string) error {
+	policy := GetLockoutPolicy()
+	now := time.Now()
+	failures, err := store.IncrLockoutFailures(subjectType, subject, now, policy.FailureWindow)
+	if err != nil {
+		return err
+	}
+	if failures < policy.MaxFailures {
+		return nil
+	}
+
+	lockedUntil := now.Add(policy.LockoutDuration)
+	err = store.SetLockedUntil(subjectType, subject, lockedUntil)
+	if err != nil {
+		return err
+	}
+
+	event := LockoutEvent{
+		UserID:      userID,
+		IP:          ip,
+		Failures:    failures,
+		LockedUntil: lockedUntil,
+	}
+	if userID != 0 {
+		event.UnlockToken, err = newUnlockToken(userID)
+		if err != nil {
+			return err
+		}
+	}
+	emitLockoutEvent(event)
+
+	return audit(AUDIT_LOCKOUT, 0, userID, ip, map[string]interface{}{"failures": failures, "locked_until": lockedUntil})
+}
+
+// RecordAuthSuccess resets the failure counter of the user, and takes the
+// failures of the user from the IP off the counter of the IP. Its other
+// failures are kept until its lockout ends, FailureWindow passes, or UnlockIP.
+func RecordAuthSuccess(userID uint64, ip string) error {
+	if userID == 0 {
+		return nil
+	}
+	if err := store.ClearLockout(lockoutSubjectUser, strconv.FormatUint(userID, 10)); err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+
+	pair := lockoutPair(userID, ip)
+	failures, lastFailure, _, err := store.GetLockoutState(lockoutSubjectPair, pair)
+	if err != nil || failures == 0 {
+		return err
+	}
+	if err = store.ClearLockout(lockoutSubjectPair, pair); err != nil {
+		return err
+	}
+	if time.Since(lastFailure) > GetLockoutPolicy().FailureWindow {
+		return nil // forgotten by the IP already
+	}
+	return store.DecrLockoutFailures(lockoutSubjectIP, ip, failures)
+}
+
+// guardAuth checks the lockout before calling authenticate,
+// and records its result.
+func guardAuth(userID uint64, ip string, authenticate func() error) error {
+	if err := CheckLockout(userID, ip); err != nil {
+		return err
+	}
+
+	authErr := authenticate()
+	if authErr != nil {
+		if err := RecordAuthFailure(userID, ip); err != nil {
+			return err
+		}
+		return authErr
+	}
+	return RecordAuthSuccess(userID, ip)
+}
+
+/************ Unlock ************/
+
+// UnlockUser is for admins to unlock a user before the lockout ends.
+// Failures of the user from any IP are still counted against the IP.
+func UnlockUser(userID uint64) error {
+	return store.ClearLockout(lockoutSubjectUser, strconv.FormatUint(userID, 10))
+}
+
+// UnlockIP is for admins to unlock an IP before the lockout ends.
+func UnlockIP(ip string) error {
+	return store.ClearLockout(lockoutSubjectIP, ip)
+}
+
+func newUnlockToken(userID uint64) (string, error) {
+	token, err := randomToken(unlockTokenBytes)
+	if err != nil {
+		return "", err
+	}
+
+	err = Ephemeral().Add(UserEphemeralKey(userID, unlockTmpExtension, token), "", DefaultUnlockTokenLifetime)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// UnlockUserByToken is called when the user follows the emailed unlock link.
+func UnlockUserByToken(userID uint64, token string) error {
+	if len(token) != 2*unlockTokenBytes {
+		return ErrUnlockTokenBad
+	}
+	if _, err := Ephemeral().Take(UserEphemeralKey(userID, unlockTmpExtension, token)); err != nil {
+		return ErrUnlockTokenBad
+	}
+
+	return UnlockUser(userID)
+}
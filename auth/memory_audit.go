@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"time"
+)
+
+/************ Audit ************/
+
+func (s *MemoryStore) NewAuditEvent(event *AuditEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	event.id = uint64(len(s.auditEvents)) + 1
+	stored := *event
+	stored.Detail = append([]byte(nil), event.Detail...)
+	s.auditEvents = append(s.auditEvents, stored)
+	return nil
+}
+
+func (s *MemoryStore) QueryAuditEvents(filter AuditFilter) ([]*AuditEvent, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var events []*AuditEvent = []*AuditEvent{}
+	var skipped uint
+	for i := len(s.auditEvents) - 1; i >= 0 && uint(len(events)) < filter.Limit; i-- {
+		event := s.auditEvents[i]
+		if !auditEventMatches(&event, &filter) {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+		event.Detail = append([]byte(nil), event.Detail...)
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+func auditEventMatches(event *AuditEvent, filter *AuditFilter) bool {
+	if (filter.ActorUserID != 0 && event.ActorUserID != filter.ActorUserID) ||
+		(filter.SubjectUserID != 0 && event.SubjectUserID != filter.SubjectUserID) ||
+		(filter.AffiliationID != 0 && event.AffiliationID != filter.AffiliationID) ||
+		(!filter.Since.IsZero() && event.CreatedAt.Before(filter.Since)) ||
+		(!filter.Until.IsZero() && !event.CreatedAt.Before(filter.Until)) {
+		return false
+	}
+	if len(filter.EventTypes) == 0 {
+		return true
+	}
+	for _, eventType := range filter.EventTypes {
+		if event.EventType == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) ScrubAuditIPs(before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range s.auditEvents {
+		if s.auditEvents[i].CreatedAt.Before(before) {
+			s.auditEvents[i].IP, s.auditEvents[i].UserAgent = "", ""
+		}
+	}
+	return nil
+}
+
+// scrubAudit clears the IP and user agent of the events by or about the
+// user, and the keys of their detail registered as PII.
+func (s *MemoryStore) scrubAudit(userID uint64) error {
+	piiKeys := getAuditPIIDetailKeys()
+	for i := range s.auditEvents {
+		event := &s.auditEvents[i]
+		if event.ActorUserID != userID && event.SubjectUserID != userID {
+			continue
+		}
+		event.IP, event.UserAgent = "", ""
+		detail, found, err := scrubAuditDetail(event.Detail, piiKeys)
+		if err != nil {
+			return err
+		}
+		if found {
+			event.Detail = detail
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"database/sql"
+	"sort"
+)
+
+type memoryCustomRoleKey struct {
+	customRoleID uint64
+	subjectID    uint64 // user or affiliation
+}
+
+/************ Custom Role ************/
+
+func (s *MemoryStore) NewCustomRole(customRole *CustomRole) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.customRoleNameExists(customRole.Name, 0) {
+		return ErrStoreDuplicate
+	}
+	s.lastCustomRoleID++
+	customRole.id = s.lastCustomRoleID
+	s.customRoles[customRole.id] = copyCustomRole(customRole)
+	return nil
+}
+
+func (s *MemoryStore) GetCustomRoleByID(customRoleID uint64) (*CustomRole, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	customRole, ok := s.customRoles[customRoleID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	customRole = copyCustomRole(&customRole)
+	return &customRole, nil
+}
+
+func (s *MemoryStore) GetCustomRoleByName(name string) (*CustomRole, error) {
+	customRoles := s.listCustomRoles(func(customRole *CustomRole) bool {
+		return customRole.Name == name
+	})
+	if len(customRoles) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return customRoles[0], nil
+}
+
+func (s *MemoryStore) ListCustomRoles() ([]*CustomRole, error) {
+	return s.listCustomRoles(func(*CustomRole) bool { return true }), nil
+}
+
+func (s *MemoryStore) ListCustomRolesByUser(userID, affiliationID uint64) ([]*CustomRole, error) {
+	return s.listCustomRoles(func(customRole *CustomRole) bool {
+		return s.customRoleUsers[memoryCustomRoleKey{customRole.id, userID}] ||
+			(affiliationID != 0 && s.customRoleAffiliations[memoryCustomRoleKey{customRole.id, affiliationID}])
+	}), nil
+}
+
+func (s *MemoryStore) ListCustomRolesByAffiliation(affiliationID uint64) ([]*CustomRole, error) {
+	return s.listCustomRoles(func(customRole *CustomRole) bool {
+		return s.customRoleAffiliations[memoryCustomRoleKey{customRole.id, affiliationID}]
+	}), nil
+}
+
+// listCustomRoles lists matching custom roles in order of name
+func (s *MemoryStore) listCustomRoles(match func(customRole *CustomRole) bool) []*CustomRole {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var customRoles []*CustomRole = []*CustomRole{}
+	for _, customRole := range s.customRoles {
+		customRole := copyCustomRole(&customRole)
+		if match(&customRole) {
+			customRoles = append(customRoles, &customRole)
+		}
+	}
+	sort.Slice(customRoles, func(i, j int) bool { return customRoles[i].Name < customRoles[j].Name })
+	return customRoles
+}
+
+func (s *MemoryStore) UpdateCustomRole(customRole *CustomRole) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.customRoles[customRole.id]; !ok {
+		return nil
+	}
+	if s.customRoleNameExists(customRole.Name, customRole.id) {
+		return ErrStoreDuplicate
+	}
+	s.customRoles[customRole.id] = copyCustomRole(customRole)
+	return nil
+}
+
+func (s *MemoryStore) DeleteCustomRole(customRoleID uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.customRoles, customRoleID)
+	for _, assignments := range []map[memoryCustomRoleKey]bool{s.customRoleUsers, s.customRoleAffiliations} {
+		for key := range assignments {
+			if key.customRoleID == customRoleID {
+				delete(assignments, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) AssignCustomRoleToUser(customRoleID, userID uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.customRoles[customRoleID]; !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := s.users[userID]; !ok {
+		return sql.ErrNoRows
+	}
+	s.customRoleUsers[memoryCustomRoleKey{customRoleID, userID}] = true
+	return nil
+}
+
+func (s *MemoryStore) UnassignCustomRoleFromUser(customRoleID, userID uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.customRoleUsers, memoryCustomRoleKey{customRoleID, userID})
+	return nil
+}
+
+func (s *MemoryStore) AssignCustomRoleToAffiliation(customRoleID, affiliationID uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.customRoles[customRoleID]; !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := s.affiliations[affiliationID]; !ok {
+		return sql.ErrNoRows
+	}
+	s.customRoleAffiliations[memoryCustomRoleKey{customRoleID, affiliationID}] = true
+	return nil
+}
+
+func (s *MemoryStore) UnassignCustomRoleFromAffiliation(customRoleID, affiliationID uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.customRoleAffiliations, memoryCustomRoleKey{customRoleID, affiliationID})
+	return nil
+}
+
+func (s *MemoryStore) customRoleNameExists(name string, exceptID uint64) bool {
+	for id, customRole := range s.customRoles {
+		if customRole.Name == name && id != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+// copyCustomRole copies Permissions, for the caller not to share them with the store
+func copyCustomRole(customRole *CustomRole) CustomRole {
+	copied := *customRole
+	copied.Permissions = append([]Permission(nil), customRole.Permissions...)
+	return copied
+}
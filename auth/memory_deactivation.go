@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+/************ Deactivation ************/
+
+func (s *MemoryStore) GetDeactivation(userID uint64) (*Deactivation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	deactivation, ok := s.deactivations[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &deactivation, nil
+}
+
+func (s *MemoryStore) NewDeactivation(deactivation *Deactivation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.users[deactivation.UserID]; !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := s.deactivations[deactivation.UserID]; ok {
+		return ErrStoreDuplicate
+	}
+	stored := *deactivation
+	stored.ErasedAt, stored.Pseudonym = nil, ""
+	s.deactivations[deactivation.UserID] = stored
+	return nil
+}
+
+func (s *MemoryStore) UpdateErasureAt(userID uint64, erasureAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if deactivation, ok := s.deactivations[userID]; ok && deactivation.ErasedAt == nil {
+		deactivation.ErasureAt = erasureAt
+		s.deactivations[userID] = deactivation
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteDeactivation(userID uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if deactivation, ok := s.deactivations[userID]; ok && deactivation.ErasedAt == nil {
+		delete(s.deactivations, userID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListUserIDsDueForErasure(now time.Time) ([]uint64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var userIDs []uint64
+	for userID, deactivation := range s.deactivations {
+		if deactivation.ErasedAt == nil && !deactivation.ErasureAt.After(now) {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+	return userIDs, nil
+}
+
+func (s *MemoryStore) AnonymizeUser(userID uint64, pseudonym string, erasedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	email := user.Email
+	user.Email = pseudonym + anonymizedEmailDomain
+	user.PublicKey = ""
+	user.Role = ROLELESS
+	user.AffiliationID = 0
+	s.users[userID] = user
+	if _, ok := s.userInfos[userID]; ok {
+		s.userInfos[userID] = UserInfo{}
+	}
+
+	for key := range s.mfas {
+		if key.userID == userID {
+			delete(s.mfas, key)
+		}
+	}
+	delete(s.mfaPreferences, userID)
+	for keyID, key := range s.userKeys {
+		if key.UserID == userID {
+			delete(s.userKeys, keyID)
+		}
+	}
+	for identityID, identity := range s.externalIdentities {
+		if identity.UserID == userID {
+			delete(s.externalIdentities, identityID)
+		}
+	}
+	delete(s.verifiedEmails, userID)
+	for key := range s.customRoleUsers {
+		if key.subjectID == userID {
+			delete(s.customRoleUsers, key)
+		}
+	}
+	for invitationID, invitation := range s.invitations {
+		if invitation.Email == email {
+			delete(s.invitations, invitationID)
+		}
+	}
+	for i, entry := range s.mfaRecoveryLog {
+		if s.mfaRecoveries[entry.RecoveryID].UserID == userID {
+			s.mfaRecoveryLog[i].Note = ""
+		}
+	}
+	if deactivation, ok := s.deactivations[userID]; ok {
+		deactivation.ErasedAt = &erasedAt
+		deactivation.Pseudonym = pseudonym
+		deactivation.Reason = ""
+		s.deactivations[userID] = deactivation
+	}
+
+	return s.scrubAudit(userID)
+}
@@ -0,0 +1,46 @@
+package auth
+
+import "time"
+
+type memoryVerifiedEmail struct {
+	email      string
+	verifiedAt time.Time
+}
+
+/************ Email Verification ************/
+
+func (s *MemoryStore) GetVerifiedEmail(userID uint64) (email string, verifiedAt time.Time, err error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	verified := s.verifiedEmails[userID]
+	return verified.email, verified.verifiedAt, nil
+}
+
+func (s *MemoryStore) SetVerifiedEmail(userID uint64, email string, verifiedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return nil
+	}
+	s.verifiedEmails[userID] = memoryVerifiedEmail{email, verifiedAt}
+	return nil
+}
+
+func (s *MemoryStore) ChangeVerifiedEmail(userID uint64, email string, verifiedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil
+	}
+	if user.Email != email && s.emailExists(email) {
+		return ErrStoreDuplicate
+	}
+	user.Email = email
+	s.users[userID] = user
+	s.verifiedEmails[userID] = memoryVerifiedEmail{email, verifiedAt}
+	return nil
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"sort"
+	"time"
+)
+
+/************ External Identity ************/
+
+func (s *MemoryStore) NewExternalIdentity(identity *ExternalIdentity) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.externalIdentity(identity.Provider, identity.Subject) != nil {
+		return ErrStoreDuplicate
+	}
+	s.lastExternalIdentityID++
+	identity.id = s.lastExternalIdentityID
+	stored := *identity
+	stored.LastUsedAt = nil
+	s.externalIdentities[identity.id] = stored
+	return nil
+}
+
+func (s *MemoryStore) GetExternalIdentity(provider, subject string) (*ExternalIdentity, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	identity := s.externalIdentity(provider, subject)
+	if identity == nil {
+		return nil, ErrExternalIdentityNotFound
+	}
+	return identity, nil
+}
+
+func (s *MemoryStore) ListExternalIdentities(userID uint64) ([]*ExternalIdentity, error) {
+	return s.listExternalIdentities(func(identity *ExternalIdentity) bool {
+		return identity.UserID == userID
+	}), nil
+}
+
+func (s *MemoryStore) ListProviderIdentities(provider string) ([]*ExternalIdentity, error) {
+	return s.listExternalIdentities(func(identity *ExternalIdentity) bool {
+		return identity.Provider == provider
+	}), nil
+}
+
+// listExternalIdentities lists matching identities, oldest first
+func (s *MemoryStore) listExternalIdentities(match func(identity *ExternalIdentity) bool) []*ExternalIdentity {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var identities []*ExternalIdentity = []*ExternalIdentity{}
+	for _, identity := range s.externalIdentities {
+		identity := identity
+		if match(&identity) {
+			identities = append(identities, &identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].id < identities[j].id })
+	return identities
+}
+
+func (s *MemoryStore) TouchExternalIdentity(identityID uint64, lastUsedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if identity, ok := s.externalIdentities[identityID]; ok {
+		identity.LastUsedAt = &lastUsedAt
+		s.externalIdentities[identityID] = identity
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteExternalIdentity(identityID uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.externalIdentities, identityID)
+	return nil
+}
+
+// externalIdentity returns a copy of the identity, or nil if none
+func (s *MemoryStore) externalIdentity(provider, subject string) *ExternalIdentity {
+	for _, identity := range s.externalIdentities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+/************ Affiliation Invitation ************/
+
+func (s *MemoryStore) NewInvitation(invitation *AffiliationInvitation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, existing := range s.invitations {
+		if existing.tokenHash == invitation.tokenHash {
+			return ErrStoreDuplicate
+		}
+	}
+	s.lastInvitationID++
+	invitation.id = s.lastInvitationID
+	stored := *invitation
+	stored.token = ""
+	s.invitations[invitation.id] = stored
+	return nil
+}
+
+func (s *MemoryStore) GetInvitationByTokenHash(tokenHash string) (*AffiliationInvitation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, invitation := range s.invitations {
+		if invitation.tokenHash == tokenHash {
+			return &invitation, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) ListInvitationsByAffiliationID(affiliationID uint64) ([]*AffiliationInvitation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var invitations []*AffiliationInvitation = []*AffiliationInvitation{}
+	for _, invitation := range s.invitations {
+		if invitation.AffiliationID == affiliationID {
+			invitation := invitation
+			invitations = append(invitations, &invitation)
+		}
+	}
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].id > invitations[j].id })
+	return invitations, nil
+}
+
+func (s *MemoryStore) UpdateInvitationStatus(invitationID uint64, status uint8) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if invitation, ok := s.invitations[invitationID]; ok {
+		invitation.Status = status
+		s.invitations[invitationID] = invitation
+	}
+	return nil
+}
+
+func (s *MemoryStore) RenewInvitation(invitationID uint64, tokenHash string, expiresAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if invitation, ok := s.invitations[invitationID]; ok {
+		invitation.tokenHash = tokenHash
+		invitation.ExpiresAt = expiresAt
+		s.invitations[invitationID] = invitation
+	}
+	return nil
+}
+
+func (s *MemoryStore) AcceptInvitation(invitation *AffiliationInvitation, userID uint64, roleDelta RoleDelta) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, ok := s.invitations[invitation.id]
+	if !ok || stored.Status != INVITATION_PENDING {
+		return ErrInvitationNotPending
+	}
+	user, ok := s.users[userID]
+	if !ok || (user.AffiliationID != 0 && user.AffiliationID != stored.AffiliationID) {
+		return ErrInvitationAlreadyAffiliated
+	}
+
+	stored.Status = INVITATION_ACCEPTED
+	s.invitations[stored.id] = stored
+	user.AffiliationID = stored.AffiliationID
+	user.Role = roleDelta.Apply(user.Role)
+	s.users[userID] = user
+	return nil
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+type memoryLockoutKey struct {
+	subjectType string
+	subject     string
+}
+
+type memoryLockout struct {
+	failures    uint
+	lastFailure time.Time
+	lockedUntil sql.NullTime
+}
+
+/************ Lockout ************/
+
+func (s *MemoryStore) IncrLockoutFailures(subjectType, subject string, now time.Time, window time.Duration) (uint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := memoryLockoutKey{subjectType, subject}
+	lockout, ok := s.lockouts[key]
+	if !ok || lockout.lastFailure.Before(now.Add(-window)) {
+		lockout.failures = 1
+	} else {
+		lockout.failures++
+	}
+	lockout.lastFailure = now
+	s.lockouts[key] = lockout
+	return lockout.failures, nil
+}
+
+func (s *MemoryStore) GetLockoutState(subjectType, subject string) (failures uint, lastFailure time.Time, lockedUntil sql.NullTime, err error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	lockout := s.lockouts[memoryLockoutKey{subjectType, subject}]
+	return lockout.failures, lockout.lastFailure, lockout.lockedUntil, nil
+}
+
+func (s *MemoryStore) DecrLockoutFailures(subjectType, subject string, failures uint) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := memoryLockoutKey{subjectType, subject}
+	if lockout, ok := s.lockouts[key]; ok {
+		if lockout.failures > failures {
+			lockout.failures -= failures
+		} else {
+			lockout.failures = 0
+		}
+		s.lockouts[key] = lockout
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetLockedUntil(subjectType, subject string, lockedUntil time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := memoryLockoutKey{subjectType, subject}
+	if lockout, ok := s.lockouts[key]; ok {
+		lockout.lockedUntil = sql.NullTime{Time: lockedUntil, Valid: true}
+		s.lockouts[key] = lockout
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClearLockout(subjectType, subject string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.lockouts, memoryLockoutKey{subjectType, subject})
+	return nil
+}
@@ -0,0 +1,32 @@
+package auth
+
+import "time"
+
+type memoryMFAPolicyKey struct {
+	userID     uint64
+	policyName string
+}
+
+/************ MFA Policy State ************/
+
+func (s *MemoryStore) MFAPolicySince(userID uint64, policyName string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := memoryMFAPolicyKey{userID, policyName}
+	since, ok := s.mfaPolicySince[key]
+	if !ok {
+		// First time the policy applies
+		since = time.Now()
+		s.mfaPolicySince[key] = since
+	}
+	return since, nil
+}
+
+func (s *MemoryStore) ClearMFAPolicySince(userID uint64, policyName string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.mfaPolicySince, memoryMFAPolicyKey{userID, policyName})
+	return nil
+}
@@ -0,0 +1,18 @@
+package auth
+
+/************ MFA Preference ************/
+
+func (s *MemoryStore) SetPreferredMFA(userID uint64, extentionType string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.mfaPreferences[userID] = extentionType
+	return nil
+}
+
+func (s *MemoryStore) GetPreferredMFA(userID uint64) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.mfaPreferences[userID], nil
+}
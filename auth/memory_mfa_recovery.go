@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+/************ MFA Recovery ************/
+
+func (s *MemoryStore) NewMFARecovery(recovery *MFARecovery) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, existing := range s.mfaRecoveries {
+		if existing.cancelTokenHash == recovery.cancelTokenHash {
+			return ErrStoreDuplicate
+		}
+	}
+	s.lastMFARecoveryID++
+	recovery.id = s.lastMFARecoveryID
+	stored := *recovery
+	stored.DecidedAt, stored.DeciderUserID = nil, 0
+	s.mfaRecoveries[recovery.id] = stored
+	return nil
+}
+
+func (s *MemoryStore) GetMFARecoveryByID(recoveryID uint64) (*MFARecovery, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	recovery, ok := s.mfaRecoveries[recoveryID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &recovery, nil
+}
+
+func (s *MemoryStore) GetMFARecoveryByCancelTokenHash(cancelTokenHash string) (*MFARecovery, error) {
+	recoveries := s.listMFARecoveries(func(recovery *MFARecovery) bool {
+		return recovery.cancelTokenHash == cancelTokenHash
+	})
+	if len(recoveries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return recoveries[0], nil
+}
+
+func (s *MemoryStore) ListMFARecoveriesByUserID(userID uint64) ([]*MFARecovery, error) {
+	recoveries := s.listMFARecoveries(func(recovery *MFARecovery) bool {
+		return recovery.UserID == userID
+	})
+	// newest first
+	sort.Slice(recoveries, func(i, j int) bool { return recoveries[i].id > recoveries[j].id })
+	return recoveries, nil
+}
+
+func (s *MemoryStore) ListMFARecoveriesByStatus(status uint8) ([]*MFARecovery, error) {
+	return s.listMFARecoveries(func(recovery *MFARecovery) bool {
+		return recovery.Status == status
+	}), nil
+}
+
+// listMFARecoveries lists matching recoveries, oldest first
+func (s *MemoryStore) listMFARecoveries(match func(recovery *MFARecovery) bool) []*MFARecovery {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var recoveries []*MFARecovery = []*MFARecovery{}
+	for _, recovery := range s.mfaRecoveries {
+		recovery := recovery
+		if match(&recovery) {
+			recoveries = append(recoveries, &recovery)
+		}
+	}
+	sort.Slice(recoveries, func(i, j int) bool { return recoveries[i].id < recoveries[j].id })
+	return recoveries
+}
+
+func (s *MemoryStore) DecideMFARecovery(recoveryID uint64, status uint8, deciderUserID uint64, decidedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	recovery, ok := s.mfaRecoveries[recoveryID]
+	if !ok || recovery.Status != MFA_RECOVERY_PENDING {
+		return ErrMFARecoveryNotPending
+	}
+	recovery.Status = status
+	recovery.DeciderUserID = deciderUserID
+	recovery.DecidedAt = &decidedAt
+	s.mfaRecoveries[recoveryID] = recovery
+	return nil
+}
+
+func (s *MemoryStore) NewMFARecoveryLog(entry *MFARecoveryLogEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.mfaRecoveries[entry.RecoveryID]; !ok {
+		return sql.ErrNoRows
+	}
+	s.mfaRecoveryLog = append(s.mfaRecoveryLog, *entry)
+	return nil
+}
+
+func (s *MemoryStore) ListMFARecoveryLog(recoveryID uint64) ([]*MFARecoveryLogEntry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var entries []*MFARecoveryLogEntry = []*MFARecoveryLogEntry{}
+	for _, entry := range s.mfaRecoveryLog {
+		if entry.RecoveryID == recoveryID {
+			entry := entry
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, nil
+}
+
+// deleteMFARecoveries deletes the recoveries of the user and their log
+func (s *MemoryStore) deleteMFARecoveries(userID uint64) {
+	var kept []MFARecoveryLogEntry
+	for _, entry := range s.mfaRecoveryLog {
+		if s.mfaRecoveries[entry.RecoveryID].UserID != userID {
+			kept = append(kept, entry)
+		}
+	}
+	s.mfaRecoveryLog = kept
+	for recoveryID, recovery := range s.mfaRecoveries {
+		if recovery.UserID == userID {
+			delete(s.mfaRecoveries, recoveryID)
+		}
+	}
+}
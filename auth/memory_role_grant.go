@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+/************ Role Grant ************/
+
+func (s *MemoryStore) NewRoleGrant(grant *RoleGrant) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastRoleGrantID++
+	grant.id = s.lastRoleGrantID
+	s.roleGrants[grant.id] = *grant
+	return nil
+}
+
+func (s *MemoryStore) GetRoleGrantByID(grantID uint64) (*RoleGrant, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	grant, ok := s.roleGrants[grantID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &grant, nil
+}
+
+func (s *MemoryStore) ActiveTemporaryRole(userID uint64, now time.Time) (Role, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var role Role
+	for _, grant := range s.roleGrants {
+		if grant.UserID == userID && grant.Status == ROLE_GRANT_ACTIVE && grant.ExpiresAt != nil &&
+			!grant.StartsAt.After(now) && grant.ExpiresAt.After(now) {
+			role = role.AddRole(grant.Granted)
+		}
+	}
+	return role, nil
+}
+
+func (s *MemoryStore) ListRoleGrantsByUserID(userID uint64) ([]*RoleGrant, error) {
+	return s.listRoleGrants(func(grant *RoleGrant) bool {
+		return grant.UserID == userID
+	}), nil
+}
+
+func (s *MemoryStore) ListRoleGrantsByRole(role Role) ([]*RoleGrant, error) {
+	return s.listRoleGrants(func(grant *RoleGrant) bool {
+		return grant.Granted&role != ROLELESS || grant.Revoked&role != ROLELESS
+	}), nil
+}
+
+// listRoleGrants lists matching grants, newest first
+func (s *MemoryStore) listRoleGrants(match func(grant *RoleGrant) bool) []*RoleGrant {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var grants []*RoleGrant = []*RoleGrant{}
+	for _, grant := range s.roleGrants {
+		grant := grant
+		if match(&grant) {
+			grants = append(grants, &grant)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].id > grants[j].id })
+	return grants
+}
+
+func (s *MemoryStore) UpdateRoleGrantStatus(grantID uint64, status uint8) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if grant, ok := s.roleGrants[grantID]; ok {
+		grant.Status = status
+		s.roleGrants[grantID] = grant
+	}
+	return nil
+}
+
+func (s *MemoryStore) ExpireRoleGrants(now time.Time) ([]uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	affected := map[uint64]bool{}
+	var userIDs []uint64
+	for grantID, grant := range s.roleGrants {
+		if grant.Status == ROLE_GRANT_ACTIVE && grant.ExpiresAt != nil && !grant.ExpiresAt.After(now) {
+			grant.Status = ROLE_GRANT_EXPIRED
+			s.roleGrants[grantID] = grant
+			if !affected[grant.UserID] {
+				affected[grant.UserID] = true
+				userIDs = append(userIDs, grant.UserID)
+			}
+		}
+	}
+	return userIDs, nil
+}
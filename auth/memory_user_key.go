@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"sort"
+	"time"
+)
+
+/************ User Key ************/
+
+func (s *MemoryStore) NewUserKey(key *UserKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.userKeyExists(key.UserID, key.PublicKey) {
+		return ErrStoreDuplicate
+	}
+	s.lastUserKeyID++
+	key.id = s.lastUserKeyID
+	stored := *key
+	stored.LastUsedAt, stored.RevokedAt = nil, nil
+	s.userKeys[key.id] = stored
+	return nil
+}
+
+func (s *MemoryStore) ListUserKeys(userID uint64, all bool) ([]*UserKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var keys []*UserKey = []*UserKey{}
+	for _, key := range s.userKeys {
+		if key.UserID == userID && (all || key.RevokedAt == nil) {
+			key := key
+			keys = append(keys, &key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].id < keys[j].id })
+	return keys, nil
+}
+
+func (s *MemoryStore) UserKeyExists(userID uint64, publicKey string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.userKeyExists(userID, publicKey), nil
+}
+
+func (s *MemoryStore) TouchUserKey(keyID uint64, lastUsedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if key, ok := s.userKeys[keyID]; ok {
+		key.LastUsedAt = &lastUsedAt
+		s.userKeys[keyID] = key
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateUserKeyLabel(keyID uint64, label string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if key, ok := s.userKeys[keyID]; ok {
+		key.Label = label
+		s.userKeys[keyID] = key
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserKey(userID, keyID uint64, revokedAt time.Time, primaryKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, ok := s.userKeys[keyID]
+	if !ok || key.UserID != userID || key.RevokedAt != nil {
+		return ErrUserKeyNotFound
+	}
+	key.RevokedAt = &revokedAt
+	s.userKeys[keyID] = key
+
+	if user, ok := s.users[userID]; ok {
+		user.PublicKey = primaryKey
+		s.users[userID] = user
+	}
+	return nil
+}
+
+func (s *MemoryStore) userKeyExists(userID uint64, publicKey string) bool {
+	for _, key := range s.userKeys {
+		if key.UserID == userID && key.PublicKey == publicKey {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,10 @@
 package auth
 
-import "errors"
+import (
+	"errors"
+	"sort"
+	"sync"
+)
 
 /**************** Interface ****************/
 type MultiFactorAuthentication interface {
@@ -21,22 +25,79 @@ type MultiFactorAuthentication interface {
 /**************** Helper Func ****************/
 
 func EnabledMFA(userID uint64) ([]string, error) {
-	return checkEnabledMFA(userID)
+	return store.ListEnabledMFA(userID)
 }
 
 /**************** Aggregator ****************/
+
+// MFAInstanceInfo is the display metadata of a registered MFA instance
+type MFAInstanceInfo struct {
+	Type        string `json:"type"`
+	DisplayName string `json:"display_name"`
+	IconURL     string `json:"icon_url"`
+	Priority    int    `json:"priority"` // higher priority is listed first
+}
+
 var (
-	mfaInstanceRegistry = map[string]MultiFactorAuthentication{} // Note: this registry is not thread-safe and is not to be updated by MFA implementation's init() func
+	mfaRegistryMutex        sync.RWMutex                         = sync.RWMutex{}
+	mfaInstanceRegistry     map[string]MultiFactorAuthentication = map[string]MultiFactorAuthentication{}
+	mfaInstanceInfoRegistry map[string]MFAInstanceInfo           = map[string]MFAInstanceInfo{}
 
 	ErrMFAInstanceUnknown = errors.New("auth: Unknown MFA instance")
+	ErrMFANotRegistered   = errors.New("auth: MFA is not registered by user")
 )
 
+// RegMFAInstance registers the MFA instance with default display metadata.
 func RegMFAInstance(MFAType string, instance MultiFactorAuthentication) {
+	RegMFAInstanceWithInfo(MFAType, instance, MFAInstanceInfo{
+		DisplayName: MFAType,
+	})
+}
+
+// RegMFAInstanceWithInfo registers the MFA instance with display metadata.
+// info.Type is always overwritten by MFAType.
+func RegMFAInstanceWithInfo(MFAType string, instance MultiFactorAuthentication, info MFAInstanceInfo) {
+	mfaRegistryMutex.Lock()
+	defer mfaRegistryMutex.Unlock()
+
+	info.Type = MFAType
 	mfaInstanceRegistry[MFAType] = instance
+	mfaInstanceInfoRegistry[MFAType] = info
+}
+
+func mfaInstance(MFAType string) (MultiFactorAuthentication, bool) {
+	mfaRegistryMutex.RLock()
+	defer mfaRegistryMutex.RUnlock()
+
+	instance, ok := mfaInstanceRegistry[MFAType]
+	return instance, ok
+}
+
+// mfaInstances returns a snapshot of the registry, so the lock
+// is not held while calling into the instances.
+func mfaInstances() map[string]MultiFactorAuthentication {
+	mfaRegistryMutex.RLock()
+	defer mfaRegistryMutex.RUnlock()
+
+	instances := map[string]MultiFactorAuthentication{}
+	for MFAType, instance := range mfaInstanceRegistry {
+		instances[MFAType] = instance
+	}
+	return instances
+}
+
+func MFAInfo(MFAType string) (MFAInstanceInfo, error) {
+	mfaRegistryMutex.RLock()
+	defer mfaRegistryMutex.RUnlock()
+
+	if info, ok := mfaInstanceInfoRegistry[MFAType]; ok {
+		return info, nil
+	}
+	return MFAInstanceInfo{}, ErrMFAInstanceUnknown
 }
 
 func AnyMFARegistered(userID uint64) bool {
-	for _, instance := range mfaInstanceRegistry {
+	for _, instance := range mfaInstances() {
 		if instance.Registered(userID) {
 			return true
 		}
@@ -45,43 +106,162 @@ func AnyMFARegistered(userID uint64) bool {
 }
 
 func MFARegistered(MFAType string, userID uint64) bool {
-	if instance, ok := mfaInstanceRegistry[MFAType]; ok {
+	if instance, ok := mfaInstance(MFAType); ok {
 		return instance.Registered(userID)
 	}
 	return false
 }
 
 func MFAInitSignUp(MFAType string, userID uint64, username string) (map[string]interface{}, error) {
-	if instance, ok := mfaInstanceRegistry[MFAType]; ok {
+	if instance, ok := mfaInstance(MFAType); ok {
 		return instance.InitSignUp(userID, username)
 	}
 	return nil, ErrMFAInstanceUnknown
 }
 
 func MFACompleteSignUp(MFAType string, userID uint64, mfaConf map[string]string) error {
-	if instance, ok := mfaInstanceRegistry[MFAType]; ok {
-		return instance.CompleteSignUp(userID, mfaConf)
+	if instance, ok := mfaInstance(MFAType); ok {
+		if err := instance.CompleteSignUp(userID, mfaConf); err != nil {
+			return err
+		}
+		return audit(AUDIT_MFA_ENROLL, userID, userID, "", map[string]interface{}{"mfa_type": MFAType})
 	}
 	return ErrMFAInstanceUnknown
 }
 
 func MFANewChallenge(MFAType string, userID uint64) (map[string]interface{}, error) {
-	if instance, ok := mfaInstanceRegistry[MFAType]; ok {
+	if instance, ok := mfaInstance(MFAType); ok {
 		return instance.NewChallenge(userID)
 	}
 	return nil, ErrMFAInstanceUnknown
 }
 
+// MFASubmitChallenge is protected against brute-force per user. See CheckLockout().
 func MFASubmitChallenge(MFAType string, userID uint64, challengeResponse map[string]string) error {
-	if instance, ok := mfaInstanceRegistry[MFAType]; ok {
-		return instance.SubmitChallenge(userID, challengeResponse)
+	return MFASubmitChallengeFromIP(MFAType, userID, "", challengeResponse)
+}
+
+// MFASubmitChallengeFromIP is protected against brute-force per user and per IP. See CheckLockout().
+func MFASubmitChallengeFromIP(MFAType string, userID uint64, ip string, challengeResponse map[string]string) error {
+	if instance, ok := mfaInstance(MFAType); ok {
+		err := guardAuth(userID, ip, func() error {
+			return instance.SubmitChallenge(userID, challengeResponse)
+		})
+		if err != nil {
+			if auditErr := audit(AUDIT_MFA_FAILED, userID, userID, ip, map[string]interface{}{"mfa_type": MFAType, "error": err.Error()}); auditErr != nil {
+				return auditErr
+			}
+			return err
+		}
+		return audit(AUDIT_MFA_CHALLENGE, userID, userID, ip, map[string]interface{}{"mfa_type": MFAType})
 	}
 	return ErrMFAInstanceUnknown
 }
 
 func MFARemove(MFAType string, userID uint64) error {
-	if instance, ok := mfaInstanceRegistry[MFAType]; ok {
-		return instance.Remove(userID)
+	return mfaRemove(MFAType, userID, userID)
+}
+
+// mfaRemove removes the MFA of the user on behalf of the actor, and
+// re-evaluates the MFA policies on the user.
+func mfaRemove(MFAType string, userID, actorUserID uint64) error {
+	if instance, ok := mfaInstance(MFAType); ok {
+		if err := instance.Remove(userID); err != nil {
+			return err
+		}
+		if err := audit(AUDIT_MFA_REMOVE, actorUserID, userID, "", map[string]interface{}{"mfa_type": MFAType}); err != nil {
+			return err
+		}
+
+		// Re-evaluate the MFA policies, so the grace period of a policy
+		// no longer satisfied is tracked from now on
+		user, err := GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+		_, err = user.MFAEnrollmentState()
+		return err
 	}
 	return ErrMFAInstanceUnknown
 }
+
+/**************** Method Selection ****************/
+
+// SetPreferredMFA sets the default MFA method of the user.
+// The MFA must be registered by the user.
+func SetPreferredMFA(userID uint64, MFAType string) error {
+	if _, ok := mfaInstance(MFAType); !ok {
+		return ErrMFAInstanceUnknown
+	}
+	if !MFARegistered(MFAType, userID) {
+		return ErrMFANotRegistered
+	}
+	return store.SetPreferredMFA(userID, MFAType)
+}
+
+// PreferredMFA returns the default MFA method set by the user, or
+// an empty string if not set.
+func PreferredMFA(userID uint64) (string, error) {
+	return store.GetPreferredMFA(userID)
+}
+
+// AvailableMFA lists all registered MFA instances the user has enabled.
+// The preferred one comes first, then the others by Priority.
+func AvailableMFA(userID uint64) ([]MFAInstanceInfo, error) {
+	enabled, err := EnabledMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+	preferred, err := PreferredMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	available := []MFAInstanceInfo{}
+	for _, MFAType := range enabled {
+		info, err := MFAInfo(MFAType)
+		if err != nil {
+			continue // not registered in this build
+		}
+		available = append(available, info)
+	}
+
+	sort.SliceStable(available, func(i, j int) bool {
+		if (available[i].Type == preferred) != (available[j].Type == preferred) {
+			return available[i].Type == preferred
+		}
+		if available[i].Priority != available[j].Priority {
+			return available[i].Priority > available[j].Priority
+		}
+		return available[i].Type < available[j].Type
+	})
+	return available, nil
+}
+
+// MFALoginOptions is the result of MFABeginLogin
+type MFALoginOptions struct {
+	Methods   []MFAInstanceInfo      `json:"methods"`   // Methods[0] is the default
+	Challenge map[string]interface{} `json:"challenge"` // challenge for the default method
+}
+
+// MFABeginLogin lists the MFA methods available to the user and creates the
+// challenge for the default one. Challenges for the other methods may be created
+// later with MFANewChallenge.
+// If the user has no MFA, Methods is empty and Challenge is nil.
+func MFABeginLogin(userID uint64) (*MFALoginOptions, error) {
+	methods, err := AvailableMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &MFALoginOptions{
+		Methods: methods,
+	}
+	if len(methods) > 0 {
+		options.Challenge, err = MFANewChallenge(methods[0].Type, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
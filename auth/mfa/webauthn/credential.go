@@ -0,0 +1,269 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+	duo "github.com/duo-labs/webauthn/webauthn"
+)
+
+var (
+	ErrCredentialNotFound  = errors.New("webauthn: credential not found")
+	ErrCredentialCloned    = errors.New("webauthn: sign count regression, credential flagged as possibly cloned")
+	ErrCredentialFlagged   = errors.New("webauthn: credential is flagged as possibly cloned")
+	ErrCredentialUnflagged = errors.New("webauthn: credential is not flagged")
+	ErrUnflagNotPermitted  = errors.New("webauthn: actor may not unflag credentials of the user")
+)
+
+const (
+	AUDIT_WEBAUTHN_UNFLAG = "webauthn_unflag"
+	AUDIT_WEBAUTHN_REMOVE = "webauthn_remove"
+)
+
+// CredentialMeta holds the user-facing information of a credential
+// which is not part of duo.Credential.
+type CredentialMeta struct {
+	Nickname   string    `json:"nickname"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// CredentialSummary is what a user sees when listing their credentials.
+// No secret (or public key) is included.
+type CredentialSummary struct {
+	ID         string    `json:"id"` // base64.RawURLEncoding of the credential ID
+	Nickname   string    `json:"nickname"`
+	AAGUID     string    `json:"aaguid"` // hex representation
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	SignCount  uint32    `json:"sign_count"`
+	Flagged    bool      `json:"flagged"` // possibly cloned
+}
+
+func credentialKey(credentialID []byte) string {
+	return base64.RawURLEncoding.EncodeToString(credentialID)
+}
+
+// credentialIndex returns the index of the credential in u.AuthnCredentials
+// or -1 if not found
+func (u *User) credentialIndex(credentialID []byte) int {
+	for idx, cred := range u.AuthnCredentials {
+		if bytes.Equal(cred.ID, credentialID) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (u *User) credentialMeta(credentialID []byte) *CredentialMeta {
+	if u.CredentialMetas == nil {
+		u.CredentialMetas = map[string]*CredentialMeta{}
+	}
+	key := credentialKey(credentialID)
+	if _, ok := u.CredentialMetas[key]; !ok {
+		u.CredentialMetas[key] = &CredentialMeta{}
+	}
+	return u.CredentialMetas[key]
+}
+
+// addCredential appends a newly registered credential along with its metadata
+func (u *User) addCredential(credential duo.Credential, nickname string) {
+	u.AuthnCredentials = append(u.AuthnCredentials, credential)
+
+	meta := u.credentialMeta(credential.ID)
+	meta.Nickname = nickname
+	meta.CreatedAt = time.Now()
+}
+
+// Credentials lists all credentials registered by the user
+func (u *User) Credentials() []CredentialSummary {
+	summaries := []CredentialSummary{}
+	for _, cred := range u.AuthnCredentials {
+		summary := CredentialSummary{
+			ID:        credentialKey(cred.ID),
+			AAGUID:    hex.EncodeToString(cred.Authenticator.AAGUID),
+			SignCount: cred.Authenticator.SignCount,
+			Flagged:   cred.Authenticator.CloneWarning,
+		}
+		if meta, ok := u.CredentialMetas[summary.ID]; ok {
+			summary.Nickname = meta.Nickname
+			summary.CreatedAt = meta.CreatedAt
+			summary.LastUsedAt = meta.LastUsedAt
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// RenameCredential sets the nickname of a credential. credentialID is in
+// base64.RawURLEncoding as returned by Credentials()
+func (u *User) RenameCredential(credentialID, nickname string) error {
+	rawID, err := base64.RawURLEncoding.DecodeString(credentialID)
+	if err != nil {
+		return ErrCredentialNotFound
+	}
+	if u.credentialIndex(rawID) < 0 {
+		return ErrCredentialNotFound
+	}
+
+	u.credentialMeta(rawID).Nickname = nickname
+	return u.UpdateDatabase()
+}
+
+// RemoveCredential removes a single credential. credentialID is in
+// base64.RawURLEncoding as returned by Credentials()
+// When the last credential is removed, WebAuthn is unregistered for the user
+// with auth.MFARemove(). Every removal is recorded in the audit trail.
+func (u *User) RemoveCredential(credentialID string) error {
+	rawID, err := base64.RawURLEncoding.DecodeString(credentialID)
+	if err != nil {
+		return ErrCredentialNotFound
+	}
+	idx := u.credentialIndex(rawID)
+	if idx < 0 {
+		return ErrCredentialNotFound
+	}
+
+	u.AuthnCredentials = append(u.AuthnCredentials[:idx], u.AuthnCredentials[idx+1:]...)
+	delete(u.CredentialMetas, credentialKey(rawID))
+
+	if len(u.AuthnCredentials) == 0 {
+		err = auth.MFARemove("webauthn", u.ID)
+	} else {
+		err = u.UpdateDatabase()
+	}
+	if err != nil {
+		return err
+	}
+
+	detail, err := json.Marshal(map[string]interface{}{"credential_id": credentialID})
+	if err != nil {
+		return err
+	}
+	return auth.RecordAuditEvent(&auth.AuditEvent{
+		EventType:     AUDIT_WEBAUTHN_REMOVE,
+		ActorUserID:   u.ID,
+		SubjectUserID: u.ID,
+		Detail:        detail,
+	})
+}
+
+// useCredential verifies the sign count of the credential at idx
+// and updates its counter and last-used time.
+// A sign count regression flags the credential as possibly cloned and
+// ErrCredentialCloned is returned. The flag is persisted until the credential
+// is removed, or cleared by an admin with (*WebAuthn).UnflagCredential().
+func (u *User) useCredential(idx int, signCount uint32) error {
+	cred := &u.AuthnCredentials[idx]
+	if cred.Authenticator.CloneWarning {
+		return ErrCredentialFlagged
+	}
+
+	if signCount <= cred.Authenticator.SignCount && (signCount != 0 || cred.Authenticator.SignCount != 0) {
+		cred.Authenticator.CloneWarning = true
+		if err := u.UpdateDatabase(); err != nil {
+			return err
+		}
+		return ErrCredentialCloned
+	}
+
+	cred.Authenticator.SignCount = signCount
+	u.credentialMeta(cred.ID).LastUsedAt = time.Now()
+	return u.UpdateDatabase()
+}
+
+// unflagCredential clears the possibly-cloned flag of a credential and
+// resets its sign count, so that the next sign-in sets it again.
+func (u *User) unflagCredential(credentialID string) error {
+	rawID, err := base64.RawURLEncoding.DecodeString(credentialID)
+	if err != nil {
+		return ErrCredentialNotFound
+	}
+	idx := u.credentialIndex(rawID)
+	if idx < 0 {
+		return ErrCredentialNotFound
+	}
+	cred := &u.AuthnCredentials[idx]
+	if !cred.Authenticator.CloneWarning {
+		return ErrCredentialUnflagged
+	}
+
+	cred.Authenticator.CloneWarning = false
+	cred.Authenticator.SignCount = 0
+	return u.UpdateDatabase()
+}
+
+/**************** Per-credential management on WebAuthn ****************/
+
+func (*WebAuthn) ListCredentials(userID uint64) ([]CredentialSummary, error) {
+	user, err := LoadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return user.Credentials(), nil
+}
+
+func (*WebAuthn) RenameCredential(userID uint64, credentialID, nickname string) error {
+	user, err := LoadUser(userID)
+	if err != nil {
+		return err
+	}
+	return user.RenameCredential(credentialID, nickname)
+}
+
+func (*WebAuthn) RemoveCredential(userID uint64, credentialID string) error {
+	user, err := LoadUser(userID)
+	if err != nil {
+		return err
+	}
+	return user.RemoveCredential(credentialID)
+}
+
+// UnflagCredential lets an admin clear the possibly-cloned flag of a credential
+// of the user, once the authenticator is known to be genuine, e.g. restored
+// from a backup with an older counter. Otherwise, the user should remove the
+// credential and register the authenticator again.
+//
+// The actor must manage the user (see auth.CanManage), and may not unflag
+// their own credentials. The change is recorded in the audit trail with the
+// reason, e.g. a support ticket.
+func (*WebAuthn) UnflagCredential(actor *auth.User, userID uint64, credentialID, reason string) error {
+	if actor.ID() == userID {
+		return ErrUnflagNotPermitted
+	}
+	target, err := auth.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	ok, err := auth.CanManage(actor, target)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUnflagNotPermitted
+	}
+
+	user, err := LoadUser(userID)
+	if err != nil {
+		return err
+	}
+	if err = user.unflagCredential(credentialID); err != nil {
+		return err
+	}
+
+	detail, err := json.Marshal(map[string]interface{}{"credential_id": credentialID, "reason": reason})
+	if err != nil {
+		return err
+	}
+	return auth.RecordAuditEvent(&auth.AuditEvent{
+		EventType:     AUDIT_WEBAUTHN_UNFLAG,
+		ActorUserID:   actor.ID(),
+		SubjectUserID: userID,
+		Detail:        detail,
+	})
+}
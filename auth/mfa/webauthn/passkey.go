@@ -0,0 +1,212 @@
+package webauthn
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+	"github.com/duo-labs/webauthn/protocol"
+	duo "github.com/duo-labs/webauthn/webauthn"
+)
+
+// Passkey (usernameless) login uses WebAuthn as a primary login method
+// instead of a second factor. The user is identified by the userHandle
+// returned by a discoverable (resident) credential.
+
+var (
+	ErrUserHandleMissing   = errors.New("webauthn: assertion has no user handle, credential may not be discoverable")
+	ErrUserHandleInvalid   = errors.New("webauthn: user handle is invalid")
+	ErrResidentKeyRequired = errors.New("webauthn: client did not report a discoverable credential")
+)
+
+const (
+	passkeyTmpExtension = "webauthn_passkey" // sessions of usernameless login are stored under userID 0
+)
+
+// InitPasskeySignUp works like InitSignUp, but requires the authenticator
+// to create a discoverable (resident) credential with user verification.
+// Complete it with CompleteSignUp.
+//
+// The response posted to CompleteSignUp must carry the credProps extension
+// output from getClientExtensionResults(), as in
+// {"clientExtensionResults": {"credProps": {"rk": true}}, ...}.
+func (w *WebAuthn) InitPasskeySignUp(userID uint64, username string) (map[string]interface{}, error) {
+	requireResidentKey := true
+	authenticatorSelection := w.duoWebAuthn.Config.AuthenticatorSelection
+	authenticatorSelection.RequireResidentKey = &requireResidentKey
+	authenticatorSelection.UserVerification = protocol.VerificationRequired
+
+	return w.beginRegistration(userID, username,
+		duo.WithAuthenticatorSelection(authenticatorSelection),
+		duo.WithExtensions(protocol.AuthenticationExtensions{"credProps": true}),
+	)
+}
+
+// residentKeyCreated reports whether the client says the credential of the
+// JSON-encoded registration response is discoverable.
+func residentKeyCreated(response string) bool {
+	var extensions struct {
+		ClientExtensionResults struct {
+			CredProps struct {
+				ResidentKey bool `json:"rk"`
+			} `json:"credProps"`
+		} `json:"clientExtensionResults"`
+	}
+	if json.Unmarshal([]byte(response), &extensions) != nil {
+		return false
+	}
+	return extensions.ClientExtensionResults.CredProps.ResidentKey
+}
+
+// BeginPasskeyLogin creates a challenge not bound to any user.
+// The client should call navigator.credentials.get() with an empty allowCredentials list.
+func (w *WebAuthn) BeginPasskeyLogin() (map[string]interface{}, error) {
+	challenge, err := protocol.CreateChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	requestOptions := protocol.PublicKeyCredentialRequestOptions{
+		Challenge:        challenge,
+		Timeout:          w.duoWebAuthn.Config.Timeout,
+		RelyingPartyID:   w.duoWebAuthn.Config.RPID,
+		UserVerification: protocol.VerificationRequired,
+	}
+
+	sessionData := duo.SessionData{
+		Challenge:        base64.RawURLEncoding.EncodeToString(challenge),
+		UserVerification: requestOptions.UserVerification,
+	}
+
+	// save sessionData to the ephemeral store
+	sessionKey, err := w.saveSession(0, passkeyTmpExtension, &sessionData)
+	if err != nil {
+		return nil, err
+	}
+
+	// make response
+	return map[string]interface{}{
+		"options":    protocol.CredentialAssertion{Response: requestOptions}, // Actual WebAuthn options
+		"sessionKey": sessionKey,                                             // Include this key for server to know which session to use
+	}, nil
+}
+
+// FinishPasskeyLogin verifies the assertion and resolves the user from its userHandle.
+// On success, the User of the result is the same as returned by auth.GetUserByEmail()
+// and the caller should proceed exactly as after a successful (*auth.User).VerifyLogin(),
+// which it mirrors: deactivated users may not sign in, attempts are subject to
+// auth.CheckLockout() and recorded in the audit trail.
+func (w *WebAuthn) FinishPasskeyLogin(loginResponse map[string]string, ip string) (*auth.LoginResult, error) {
+	// the user is unknown until the assertion is parsed
+	if err := auth.CheckLockout(0, ip); err != nil {
+		return nil, err
+	}
+
+	// load session
+	sessionKey, ok := loginResponse["sessionKey"]
+	if !ok {
+		return nil, errors.New("webauthn: incomplete post form")
+	}
+	var sessionData duo.SessionData
+	err := takeSession(0, passkeyTmpExtension, sessionKey, &sessionData)
+	if err != nil {
+		return nil, err
+	}
+
+	// load response
+	response, ok := loginResponse["response"]
+	if !ok {
+		return nil, errors.New("webauthn: incomplete post form")
+	}
+
+	parsedResponse, err := parseAssertionResponse(response)
+	if err != nil {
+		return nil, passkeyLoginFailed(0, ip, err)
+	}
+
+	// resolve user from userHandle, which is the WebAuthnID() of the user
+	userHandle := parsedResponse.Response.UserHandle
+	if len(userHandle) == 0 {
+		return nil, passkeyLoginFailed(0, ip, ErrUserHandleMissing)
+	}
+	userID, n := binary.Uvarint(userHandle)
+	if n <= 0 || userID == 0 {
+		return nil, passkeyLoginFailed(0, ip, ErrUserHandleInvalid)
+	}
+
+	if err = auth.CheckUserActive(userID); err != nil {
+		return nil, err
+	}
+	if err = auth.CheckLockout(userID, ip); err != nil {
+		return nil, err
+	}
+	user, err := LoadUser(userID)
+	if err != nil {
+		return nil, passkeyLoginFailed(userID, ip, err)
+	}
+	if !w.Registered(userID) {
+		return nil, passkeyLoginFailed(userID, ip, errors.New("webauthn: user not registered"))
+	}
+
+	// session is not bound to any user
+	sessionData.UserID = user.WebAuthnID()
+	err = w.validateAssertion(user, sessionData, parsedResponse)
+	if err != nil {
+		return nil, passkeyLoginFailed(userID, ip, err)
+	}
+	if err = auth.RecordAuthSuccess(userID, ip); err != nil {
+		return nil, err
+	}
+
+	err = auth.RecordAuditEvent(&auth.AuditEvent{
+		EventType:     auth.AUDIT_LOGIN,
+		ActorUserID:   userID,
+		SubjectUserID: userID,
+		IP:            ip,
+		Detail:        json.RawMessage(`{"method":"passkey"}`),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	authUser, err := auth.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	state, err := authUser.MFAEnrollmentState()
+	if err != nil {
+		return nil, err
+	}
+	return &auth.LoginResult{
+		User:                  authUser,
+		MFAEnrollmentRequired: state.Enforced(),
+	}, nil
+}
+
+// passkeyLoginFailed counts the failure against the IP, and the user if
+// known, and records it in the audit trail. It returns err unless that fails.
+func passkeyLoginFailed(userID uint64, ip string, err error) error {
+	if lockoutErr := auth.RecordAuthFailure(userID, ip); lockoutErr != nil {
+		return lockoutErr
+	}
+	if userID == 0 {
+		return err
+	}
+	detail, jsonErr := json.Marshal(map[string]interface{}{"method": "passkey", "error": err.Error()})
+	if jsonErr != nil {
+		return jsonErr
+	}
+	auditErr := auth.RecordAuditEvent(&auth.AuditEvent{
+		EventType:     auth.AUDIT_LOGIN_FAILED,
+		ActorUserID:   userID,
+		SubjectUserID: userID,
+		IP:            ip,
+		Detail:        detail,
+	})
+	if auditErr != nil {
+		return auditErr
+	}
+	return err
+}
@@ -0,0 +1,280 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/duo-labs/webauthn/metadata"
+	"github.com/duo-labs/webauthn/protocol"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrAuthenticatorPolicy = errors.New("webauthn: authenticator policy violation")
+	ErrMetadataUntrusted   = errors.New("webauthn: metadata blob is not signed by the root certificate")
+
+	// id-fido-gen-ce-aaguid, the AAGUID extension of attestation certificates
+	oidAAGUIDExtension = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+	// as listed by the FIDO Metadata Service
+	metadataSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+)
+
+// AuthenticatorPolicy decides which authenticators may be registered,
+// identified by the AAGUID reported at registration.
+//
+//   - If AllowedAAGUIDs is not empty, only listed AAGUIDs are accepted, and
+//     only from an attestation certificate chaining to a root certificate of
+//     the AAGUID in the metadata, which must be loaded. See checkAttestation.
+//   - Any AAGUID in DeniedAAGUIDs is rejected.
+//   - If metadata is loaded, the AAGUID must be found in the metadata and
+//     its latest status must not be an undesired one (e.g. REVOKED).
+//
+// Note: with AttestationPreference "none", browsers may report an all-zero AAGUID.
+type AuthenticatorPolicy struct {
+	AllowedAAGUIDs map[string]bool // normalized AAGUID -> true
+	DeniedAAGUIDs  map[string]bool // normalized AAGUID -> true
+
+	metadataEntries map[string]metadata.MetadataTOCPayloadEntry // normalized AAGUID -> entry
+}
+
+// normalizeAAGUID converts both "cb69481e-8ff7-4039-93ec-0a2729a154a8"
+// and "CB69481E8FF7403993EC0A2729A154A8" into "cb69481e8ff7403993ec0a2729a154a8"
+func normalizeAAGUID(aaguid string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(aaguid), "-", ""))
+}
+
+// parseAAGUIDList parses a comma-separated AAGUID list
+func parseAAGUIDList(list string) map[string]bool {
+	aaguids := map[string]bool{}
+	for _, aaguid := range strings.Split(list, ",") {
+		aaguid = normalizeAAGUID(aaguid)
+		if aaguid != "" {
+			aaguids[aaguid] = true
+		}
+	}
+	return aaguids
+}
+
+// LoadMetadata loads a FIDO metadata blob, the JWT as downloaded from the FIDO
+// Metadata Service, from a local file. Its signature is verified against the
+// root certificate of the FIDO Metadata Service in rootPath (PEM or DER) before
+// any entry is trusted.
+func (p *AuthenticatorPolicy) LoadMetadata(path, rootPath string) error {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	root, err := readCertificate(rootPath)
+	if err != nil {
+		return err
+	}
+
+	payload, err := verifyMetadataBlob(strings.TrimSpace(string(blob)), root, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var toc metadata.MetadataTOCPayload
+	err = json.Unmarshal(payload, &toc)
+	if err != nil {
+		return fmt.Errorf("webauthn: metadata blob payload: %s", err.Error())
+	}
+
+	p.metadataEntries = map[string]metadata.MetadataTOCPayloadEntry{}
+	for _, entry := range toc.Entries {
+		if entry.AaGUID != "" {
+			p.metadataEntries[normalizeAAGUID(entry.AaGUID)] = entry
+		}
+	}
+	return nil
+}
+
+// readCertificate reads a certificate in PEM or DER
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	return x509.ParseCertificate(data)
+}
+
+// parseCertificates parses the DER certificates of an x5c
+func parseCertificates(x5c []interface{}, decode func(interface{}) ([]byte, bool)) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for _, c := range x5c {
+		der, ok := decode(c)
+		if !ok {
+			return nil, errors.New("x5c holds no certificate")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("x5c is empty")
+	}
+	return certs, nil
+}
+
+// verifyChain verifies certs[0] against the roots, with the rest of certs
+// as intermediates.
+func verifyChain(certs []*x509.Certificate, roots *x509.CertPool, now time.Time) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// verifyMetadataBlob verifies the signature of the blob with the certificate
+// chain in its x5c header, or else with the root certificate itself, and
+// returns the payload.
+func verifyMetadataBlob(blob string, root *x509.Certificate, now time.Time) ([]byte, error) {
+	parser := &jwt.Parser{ValidMethods: metadataSigningMethods, SkipClaimsValidation: true}
+	_, err := parser.Parse(blob, func(token *jwt.Token) (interface{}, error) {
+		x5c, ok := token.Header["x5c"].([]interface{})
+		if !ok {
+			return root.PublicKey, nil
+		}
+		certs, err := parseCertificates(x5c, func(c interface{}) ([]byte, bool) {
+			encoded, ok := c.(string)
+			if !ok {
+				return nil, false
+			}
+			der, err := base64.StdEncoding.DecodeString(encoded)
+			return der, err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+		if err = verifyChain(certs, roots, now); err != nil {
+			return nil, err
+		}
+		return certs[0].PublicKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMetadataUntrusted, err.Error())
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.Split(blob, ".")[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: metadata blob payload: %s", err.Error())
+	}
+	return payload, nil
+}
+
+// Check returns nil if the authenticator is allowed by the policy, or an error
+// wrapping ErrAuthenticatorPolicy with the reason.
+func (p *AuthenticatorPolicy) Check(aaguid []byte) error {
+	if p == nil {
+		return nil
+	}
+	id := hex.EncodeToString(aaguid)
+
+	if p.DeniedAAGUIDs[id] {
+		return fmt.Errorf("%w: authenticator %s is denied", ErrAuthenticatorPolicy, id)
+	}
+	if len(p.AllowedAAGUIDs) > 0 && !p.AllowedAAGUIDs[id] {
+		return fmt.Errorf("%w: authenticator %s is not in the allow list", ErrAuthenticatorPolicy, id)
+	}
+
+	if p.metadataEntries != nil {
+		entry, ok := p.metadataEntries[id]
+		if !ok {
+			return fmt.Errorf("%w: authenticator %s is not found in metadata", ErrAuthenticatorPolicy, id)
+		}
+		// The latest status report reflects the current status
+		if len(entry.StatusReports) > 0 {
+			status := entry.StatusReports[len(entry.StatusReports)-1].Status
+			if metadata.IsUndesiredAuthenticatorStatus(metadata.AuthenticatorStatus(status)) {
+				return fmt.Errorf("%w: authenticator %s has status %s", ErrAuthenticatorPolicy, id, status)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkAttestation refuses, if AllowedAAGUIDs is not empty, a credential whose
+// AAGUID is not vouched for by its attestation: without attestation, or with
+// self attestation, the AAGUID is whatever the client claims. The attestation
+// certificate must chain to an attestation root certificate of the AAGUID in
+// the metadata, and its AAGUID extension, if any, must match.
+// The statement itself is verified by (*protocol.ParsedCredentialCreationData).Verify().
+func (p *AuthenticatorPolicy) checkAttestation(attestationObject protocol.AttestationObject) error {
+	if p == nil || len(p.AllowedAAGUIDs) == 0 {
+		return nil
+	}
+	if p.metadataEntries == nil {
+		return fmt.Errorf("%w: allow list requires metadata", ErrAuthenticatorPolicy)
+	}
+	aaguid := attestationObject.AuthData.AttData.AAGUID
+	id := hex.EncodeToString(aaguid)
+
+	x5c, ok := attestationObject.AttStatement["x5c"].([]interface{})
+	if !ok {
+		return fmt.Errorf("%w: allow list requires an attestation certificate", ErrAuthenticatorPolicy)
+	}
+	certs, err := parseCertificates(x5c, func(c interface{}) ([]byte, bool) {
+		der, ok := c.([]byte)
+		return der, ok
+	})
+	if err != nil {
+		return fmt.Errorf("%w: attestation certificate: %s", ErrAuthenticatorPolicy, err.Error())
+	}
+
+	entry, ok := p.metadataEntries[id]
+	if !ok {
+		return fmt.Errorf("%w: authenticator %s is not found in metadata", ErrAuthenticatorPolicy, id)
+	}
+	roots := x509.NewCertPool()
+	for _, encoded := range entry.MetadataStatement.AttestationRootCertificates {
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		root, err := x509.ParseCertificate(der)
+		if err != nil {
+			continue
+		}
+		roots.AddCert(root)
+	}
+	if err = verifyChain(certs, roots, time.Now()); err != nil {
+		return fmt.Errorf("%w: attestation of authenticator %s: %s", ErrAuthenticatorPolicy, id, err.Error())
+	}
+
+	for _, extension := range certs[0].Extensions {
+		if !extension.Id.Equal(oidAAGUIDExtension) {
+			continue
+		}
+		var certAAGUID []byte
+		rest, err := asn1.Unmarshal(extension.Value, &certAAGUID)
+		if err != nil || len(rest) > 0 || extension.Critical || !bytes.Equal(certAAGUID, aaguid) {
+			return fmt.Errorf("%w: attestation certificate is not for authenticator %s", ErrAuthenticatorPolicy, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package webauthn
+
+import (
+	"encoding/json"
+	"time"
+
+	harpocrates "github.com/TunnelWork/Harpocrates"
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+	duo "github.com/duo-labs/webauthn/webauthn"
+)
+
+// Ceremony sessions are kept in the ephemeral store of auth for the WebAuthn
+// timeout, and are taken atomically: each session completes at most once.
+
+const (
+	sessionNamespace = "webauthn"
+)
+
+// registrationSession keeps what the registration options required of the
+// authenticator, which duo.SessionData does not.
+type registrationSession struct {
+	duo.SessionData
+	RequireResidentKey bool `json:"requireResidentKey,omitempty"`
+}
+
+func (w *WebAuthn) saveSession(userID uint64, namespace string, sessionData interface{}) (string, error) {
+	sessionDataJson, err := json.Marshal(sessionData)
+	if err != nil {
+		return "", err
+	}
+	sessionKey, err := harpocrates.GetRandomHex(16)
+	if err != nil {
+		return "", err
+	}
+
+	ttl := time.Duration(w.duoWebAuthn.Config.Timeout) * time.Millisecond
+	err = auth.Ephemeral().Add(auth.UserEphemeralKey(userID, namespace, sessionKey), string(sessionDataJson), ttl)
+	if err != nil {
+		return "", err
+	}
+	return sessionKey, nil
+}
+
+func takeSession(userID uint64, namespace, sessionKey string, sessionData interface{}) error {
+	sessionDataJson, err := auth.Ephemeral().Take(auth.UserEphemeralKey(userID, namespace, sessionKey))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(sessionDataJson), sessionData)
+}
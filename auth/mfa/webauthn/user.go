@@ -14,8 +14,9 @@ type User struct {
 	ID       uint64
 	UserName string
 	// DisplayName  string // Use UserName instead
-	IconURL          string           // Baked in by WebAuthn struct
-	AuthnCredentials []duo.Credential // Load from DB (if any)
+	IconURL          string                     // Baked in by WebAuthn struct
+	AuthnCredentials []duo.Credential           // Load from DB (if any)
+	CredentialMetas  map[string]*CredentialMeta // Nickname, timestamps, etc. Keyed by base64.RawURLEncoding of credential ID
 	// SessionMap       map[string]duo.SessionData // Load from DB (if any)
 }
 
@@ -25,6 +26,7 @@ func CreateNewUser(id uint64, name, iconURL string) (*User, error) {
 		UserName:         name,
 		IconURL:          iconURL,
 		AuthnCredentials: []duo.Credential{},
+		CredentialMetas:  map[string]*CredentialMeta{},
 		// SessionMap:       map[string]duo.SessionData{},
 	}
 	userJson, err := json.Marshal(user)
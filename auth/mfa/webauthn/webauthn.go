@@ -6,8 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 
-	harpocrates "github.com/TunnelWork/Harpocrates"
 	"github.com/TunnelWork/Ulysses.Lib/auth"
 	"github.com/duo-labs/webauthn/protocol"
 	duo "github.com/duo-labs/webauthn/webauthn"
@@ -19,14 +19,26 @@ var (
 		"RPID":          "localhost",
 		"RPOriginURL":   "http://localhost:8081",
 		"RPIconURL":     "http://localhost:8081/favicon.ico",
+
+		// Optional: authenticator policy
+		"AttestationPreference":   "direct",    // none, indirect, direct
+		"UserVerification":        "preferred", // required, preferred, discouraged
+		"AuthenticatorAttachment": "",          // platform, cross-platform. Empty for any.
+		"Timeout":                 "60000",     // in milliseconds
+		"AllowedAAGUIDs":          "",          // comma-separated. Empty for any. Requires "direct" AttestationPreference and metadata.
+		"DeniedAAGUIDs":           "",          // comma-separated
+		"MetadataFile":            "",          // path to a local FIDO metadata blob
+		"MetadataRootFile":        "",          // path to the root certificate of the FIDO Metadata Service, required with MetadataFile
 	}
 )
 
 type WebAuthn struct {
 	duoWebAuthn *duo.WebAuthn
 	config      duo.Config
+	policy      *AuthenticatorPolicy
 }
 
+// NewWebAuthn returns nil if the conf is invalid.
 func NewWebAuthn(conf map[string]string) *WebAuthn {
 	RPDisplayName, ok := conf["RPDisplayName"]
 	if !ok {
@@ -50,6 +62,49 @@ func NewWebAuthn(conf map[string]string) *WebAuthn {
 		RPOrigin:      RPOriginURL,
 		RPIcon:        RPIconURL,
 	}
+
+	// Authenticator selection and attestation
+	switch attestationPreference := protocol.ConveyancePreference(conf["AttestationPreference"]); attestationPreference {
+	case "", protocol.PreferNoAttestation, protocol.PreferIndirectAttestation, protocol.PreferDirectAttestation:
+		config.AttestationPreference = attestationPreference
+	default:
+		return nil
+	}
+	switch userVerification := protocol.UserVerificationRequirement(conf["UserVerification"]); userVerification {
+	case "", protocol.VerificationRequired, protocol.VerificationPreferred, protocol.VerificationDiscouraged:
+		config.AuthenticatorSelection.UserVerification = userVerification
+	default:
+		return nil
+	}
+	switch authenticatorAttachment := protocol.AuthenticatorAttachment(conf["AuthenticatorAttachment"]); authenticatorAttachment {
+	case "", protocol.Platform, protocol.CrossPlatform:
+		config.AuthenticatorSelection.AuthenticatorAttachment = authenticatorAttachment
+	default:
+		return nil
+	}
+	if timeout, ok := conf["Timeout"]; ok && timeout != "" {
+		timeoutMs, err := strconv.Atoi(timeout)
+		if err != nil || timeoutMs <= 0 {
+			return nil
+		}
+		config.Timeout = timeoutMs
+	}
+
+	policy := &AuthenticatorPolicy{
+		AllowedAAGUIDs: parseAAGUIDList(conf["AllowedAAGUIDs"]),
+		DeniedAAGUIDs:  parseAAGUIDList(conf["DeniedAAGUIDs"]),
+	}
+	if metadataFile, ok := conf["MetadataFile"]; ok && metadataFile != "" {
+		if policy.LoadMetadata(metadataFile, conf["MetadataRootFile"]) != nil {
+			return nil
+		}
+	}
+	// Without attestation verified against the metadata, the AAGUID is
+	// whatever the client claims
+	if len(policy.AllowedAAGUIDs) > 0 && (config.AttestationPreference != protocol.PreferDirectAttestation || policy.metadataEntries == nil) {
+		return nil
+	}
+
 	duoWebAuthn, err := duo.New(&config)
 	if err != nil {
 		return nil
@@ -57,6 +112,7 @@ func NewWebAuthn(conf map[string]string) *WebAuthn {
 	return &WebAuthn{
 		duoWebAuthn: duoWebAuthn,
 		config:      config,
+		policy:      policy,
 	}
 }
 
@@ -69,6 +125,13 @@ func (*WebAuthn) Registered(userID uint64) bool {
 }
 
 func (w *WebAuthn) InitSignUp(userID uint64, username string) (map[string]interface{}, error) {
+	return w.beginRegistration(userID, username)
+}
+
+// beginRegistration creates the registration options and saves the session.
+// extraOptions are applied after the default options, which include the
+// configured authenticator selection.
+func (w *WebAuthn) beginRegistration(userID uint64, username string, extraOptions ...duo.RegistrationOption) (map[string]interface{}, error) {
 	var user *User
 	user, err := LoadUser(userID)
 	if err != nil {
@@ -86,7 +149,7 @@ func (w *WebAuthn) InitSignUp(userID uint64, username string) (map[string]interf
 
 	options, sessionData, err := w.duoWebAuthn.BeginRegistration(
 		user,
-		registerOptions,
+		append([]duo.RegistrationOption{registerOptions, duo.WithAuthenticatorSelection(w.duoWebAuthn.Config.AuthenticatorSelection)}, extraOptions...)...,
 	)
 	if err != nil {
 		return nil, err
@@ -95,16 +158,15 @@ func (w *WebAuthn) InitSignUp(userID uint64, username string) (map[string]interf
 	// jsonOptions, _ := json.Marshal(options)
 	// fmt.Printf("%s\n", jsonOptions)
 
-	// save sessionData to tmp
-	sessionDataJson, err := json.Marshal(sessionData)
-	if err != nil {
-		return nil, err
+	// save sessionData to the ephemeral store, with the requirements of the
+	// authenticator selection to be enforced by CompleteSignUp
+	authenticatorSelection := options.Response.AuthenticatorSelection
+	session := registrationSession{
+		SessionData:        *sessionData,
+		RequireResidentKey: authenticatorSelection.RequireResidentKey != nil && *authenticatorSelection.RequireResidentKey,
 	}
-	sessionKey, err := harpocrates.GetRandomHex(16)
-	if err != nil {
-		return nil, err
-	}
-	err = auth.InsertTmpEntry(userID, "webauthn", sessionKey, string(sessionDataJson))
+	session.UserVerification = authenticatorSelection.UserVerification
+	sessionKey, err := w.saveSession(userID, sessionNamespace, &session)
 	if err != nil {
 		return nil, err
 	}
@@ -127,17 +189,11 @@ func (w *WebAuthn) CompleteSignUp(userID uint64, mfaConf map[string]string) erro
 	if !ok {
 		return errors.New("webauthn: incomplete post form")
 	}
-	sessionDataJson, err := auth.ReadTmpEntry(userID, "webauthn", sessionKey)
+	var sessionData registrationSession
+	err = takeSession(userID, sessionNamespace, sessionKey, &sessionData)
 	if err != nil {
 		return err
 	}
-	sessionData := duo.SessionData{}
-	err = json.Unmarshal([]byte(sessionDataJson), &sessionData)
-	if err != nil {
-		return err
-	}
-
-	_ = auth.DeleteTmpEntry(userID, "webauthn", sessionKey)
 
 	// verify userID
 	if string(sessionData.UserID) != string(user.WebAuthnID()) {
@@ -181,7 +237,12 @@ func (w *WebAuthn) CompleteSignUp(userID uint64, mfaConf map[string]string) erro
 
 	pcc.Response = *parsedAttestationResponse
 
-	shouldVerifyUser := w.duoWebAuthn.Config.AuthenticatorSelection.UserVerification == protocol.VerificationRequired
+	if sessionData.RequireResidentKey && !residentKeyCreated(response) {
+		return ErrResidentKeyRequired
+	}
+
+	shouldVerifyUser := w.duoWebAuthn.Config.AuthenticatorSelection.UserVerification == protocol.VerificationRequired ||
+		sessionData.UserVerification == protocol.VerificationRequired
 	invalidErr := (&pcc).Verify(sessionData.Challenge, shouldVerifyUser, w.duoWebAuthn.Config.RPID, w.duoWebAuthn.Config.RPOrigin)
 	// clientDataOrigin, _ := url.Parse(pcc.Response.CollectedClientData.Origin)
 
@@ -194,7 +255,17 @@ func (w *WebAuthn) CompleteSignUp(userID uint64, mfaConf map[string]string) erro
 		return fmt.Errorf("webauthn: MakeNewCredential: %s", err.Error())
 	}
 
-	user.AuthnCredentials = append(user.AuthnCredentials, *credential)
+	// enforce authenticator policy
+	err = w.policy.checkAttestation(pcc.Response.AttestationObject)
+	if err != nil {
+		return err
+	}
+	err = w.policy.Check(credential.Authenticator.AAGUID)
+	if err != nil {
+		return err
+	}
+
+	user.addCredential(*credential, mfaConf["nickname"]) // nickname is optional
 	err = user.UpdateDatabase()
 	if err != nil {
 		return err
@@ -213,16 +284,8 @@ func (w *WebAuthn) NewChallenge(userID uint64) (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	// save sessionData to tmp
-	sessionDataJson, err := json.Marshal(sessionData)
-	if err != nil {
-		return nil, err
-	}
-	sessionKey, err := harpocrates.GetRandomHex(16)
-	if err != nil {
-		return nil, err
-	}
-	err = auth.InsertTmpEntry(userID, "webauthn", sessionKey, string(sessionDataJson))
+	// save sessionData to the ephemeral store
+	sessionKey, err := w.saveSession(userID, sessionNamespace, sessionData)
 	if err != nil {
 		return nil, err
 	}
@@ -245,17 +308,11 @@ func (w *WebAuthn) SubmitChallenge(userID uint64, challengeResponse map[string]s
 	if !ok {
 		return errors.New("webauthn: incomplete post form")
 	}
-	sessionDataJson, err := auth.ReadTmpEntry(userID, "webauthn", sessionKey)
+	var sessionData duo.SessionData
+	err = takeSession(userID, sessionNamespace, sessionKey, &sessionData)
 	if err != nil {
 		return err
 	}
-	sessionData := duo.SessionData{}
-	err = json.Unmarshal([]byte(sessionDataJson), &sessionData)
-	if err != nil {
-		return err
-	}
-
-	_ = auth.DeleteTmpEntry(userID, "webauthn", sessionKey)
 
 	if string(sessionData.UserID) != string(user.WebAuthnID()) {
 		return errors.New("webauthn: session data userID mismatch")
@@ -267,22 +324,32 @@ func (w *WebAuthn) SubmitChallenge(userID uint64, challengeResponse map[string]s
 		return errors.New("webauthn: incomplete post form")
 	}
 
-	// verify response
-	var car protocol.CredentialAssertionResponse
-	err = json.Unmarshal([]byte(response), &car)
+	parsedResponse, err := parseAssertionResponse(response)
 	if err != nil {
 		return err
 	}
+
+	return w.validateAssertion(user, sessionData, parsedResponse)
+}
+
+// parseAssertionResponse parses the JSON-encoded protocol.CredentialAssertionResponse
+// submitted by the client
+func parseAssertionResponse(response string) (*protocol.ParsedCredentialAssertionData, error) {
+	var car protocol.CredentialAssertionResponse
+	err := json.Unmarshal([]byte(response), &car)
+	if err != nil {
+		return nil, err
+	}
 	if car.ID == "" {
-		return errors.New("webauthn: parse error for Login - missing ID")
+		return nil, errors.New("webauthn: parse error for Login - missing ID")
 	}
 
 	_, err = base64.RawURLEncoding.DecodeString(car.ID)
 	if err != nil {
-		return errors.New("webauthn: parse error for Login - ID not base64.RawURLEncoded")
+		return nil, errors.New("webauthn: parse error for Login - ID not base64.RawURLEncoded")
 	}
 	if car.Type != "public-key" {
-		return errors.New("webauthn: parse error for Login - Credential.Type not public-key")
+		return nil, errors.New("webauthn: parse error for Login - Credential.Type not public-key")
 	}
 
 	var par protocol.ParsedCredentialAssertionData
@@ -294,16 +361,20 @@ func (w *WebAuthn) SubmitChallenge(userID uint64, challengeResponse map[string]s
 
 	err = json.Unmarshal(car.AssertionResponse.ClientDataJSON, &par.Response.CollectedClientData)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	err = par.Response.AuthenticatorData.Unmarshal(car.AssertionResponse.AuthenticatorData)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	parsedResponse := &par
+	return &par, nil
+}
 
+// validateAssertion validates a parsed assertion against the user credentials and the stored session
+// data, then updates the credential used.
+func (w *WebAuthn) validateAssertion(user *User, sessionData duo.SessionData, parsedResponse *protocol.ParsedCredentialAssertionData) error {
 	// From: duo.WebAuthn.FinishLogin()
 
 	// Step 1
@@ -370,17 +441,8 @@ func (w *WebAuthn) SubmitChallenge(userID uint64, challengeResponse map[string]s
 		return validError
 	}
 
-	// Handle step 17
-	loginCredential.Authenticator.UpdateCounter(parsedResponse.Response.AuthenticatorData.Counter)
-
-	// Save updated credential to database
-	user.AuthnCredentials[credidx] = loginCredential
-	err = user.UpdateDatabase()
-	if err != nil {
-		return err
-	}
-
-	return nil
+	// Handle step 17, and save updated credential to database
+	return user.useCredential(credidx, parsedResponse.Response.AuthenticatorData.Counter)
 }
 
 func (*WebAuthn) Remove(userID uint64) error {
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// An MFAPolicy requires users matching it to register MFA.
+// A user matches a policy when their effective role includes all bits of the policy's Role,
+// AND (if AffiliationID is set) they belong to that affiliation.
+//
+// Examples:
+//   - MFAPolicy{Name: "admin", Role: GLOBAL_ADMIN}
+//   - MFAPolicy{Name: "acme", AffiliationID: 42, AcceptedMFATypes: []string{"webauthn"}}
+//   - MFAPolicy{Name: "billing-admin", Role: AFFILIATION_BILLING_ADMIN, GracePeriod: 7 * 24 * time.Hour}
+type MFAPolicy struct {
+	Name             string        // unique
+	Role             Role          // ROLELESS matches any role
+	AffiliationID    uint64        // 0 matches any affiliation
+	AcceptedMFATypes []string      // user must register at least one of them. Empty for any MFA type.
+	GracePeriod      time.Duration // since the policy starts to apply to the user
+}
+
+// MFARequirement is an unsatisfied MFAPolicy for a user.
+type MFARequirement struct {
+	Policy           string    `json:"policy"`
+	AcceptedMFATypes []string  `json:"accepted_mfa_types"` // empty for any
+	Since            time.Time `json:"since"`
+	Deadline         time.Time `json:"deadline"`
+}
+
+// MFAEnrollmentState tells the API whether the user needs to enroll in MFA.
+type MFAEnrollmentState struct {
+	Requirements []MFARequirement `json:"requirements"`
+}
+
+var (
+	mfaPolicyMutex    sync.RWMutex = sync.RWMutex{}
+	mfaPolicyRegistry []MFAPolicy  = []MFAPolicy{}
+
+	ErrMFAPolicyNameEmpty    = errors.New("auth: MFA policy name is empty")
+	ErrMFAPolicyNameRepeated = errors.New("auth: MFA policy name is repeated")
+	ErrMFAPolicyMatchesAll   = errors.New("auth: MFA policy must specify a role or an affiliation")
+	ErrMFAEnrollmentRequired = errors.New("auth: MFA enrollment is required and the grace period is over")
+)
+
+func RegMFAPolicy(policy MFAPolicy) error {
+	if policy.Name == "" {
+		return ErrMFAPolicyNameEmpty
+	}
+	if policy.Role == ROLELESS && policy.AffiliationID == 0 {
+		return ErrMFAPolicyMatchesAll
+	}
+
+	mfaPolicyMutex.Lock()
+	defer mfaPolicyMutex.Unlock()
+
+	for _, p := range mfaPolicyRegistry {
+		if p.Name == policy.Name {
+			return ErrMFAPolicyNameRepeated
+		}
+	}
+	mfaPolicyRegistry = append(mfaPolicyRegistry, policy)
+	return nil
+}
+
+func (policy *MFAPolicy) appliesTo(user *User, role Role) bool {
+	if !role.Includes(policy.Role) {
+		return false
+	}
+	if policy.AffiliationID != 0 && policy.AffiliationID != user.AffiliationID {
+		return false
+	}
+	return true
+}
+
+func (policy *MFAPolicy) satisfiedBy(user *User) bool {
+	if len(policy.AcceptedMFATypes) == 0 {
+		return AnyMFARegistered(user.id)
+	}
+	for _, mfaType := range policy.AcceptedMFATypes {
+		if MFARegistered(mfaType, user.id) {
+			return true
+		}
+	}
+	return false
+}
+
+// MFAEnrollmentState evaluates all registered MFA policies against the user.
+// It should be called at login. It is also called by Update(), so the grace
+// period starts when a role change makes a policy apply.
+func (user *User) MFAEnrollmentState() (*MFAEnrollmentState, error) {
+	role, err := user.EffectiveRole()
+	if err != nil {
+		return nil, err
+	}
+
+	mfaPolicyMutex.RLock()
+	defer mfaPolicyMutex.RUnlock()
+
+	state := &MFAEnrollmentState{
+		Requirements: []MFARequirement{},
+	}
+	for _, policy := range mfaPolicyRegistry {
+		if !policy.appliesTo(user, role) {
+			// Forget the grace period, if any
+			err := store.ClearMFAPolicySince(user.id, policy.Name)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Start the grace period when first applied, even if it is satisfied
+		// already. Removing the MFA later won't grant a new grace period.
+		since, err := store.MFAPolicySince(user.id, policy.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !policy.satisfiedBy(user) {
+			state.Requirements = append(state.Requirements, MFARequirement{
+				Policy:           policy.Name,
+				AcceptedMFATypes: policy.AcceptedMFATypes,
+				Since:            since,
+				Deadline:         since.Add(policy.GracePeriod),
+			})
+		}
+	}
+	return state, nil
+}
+
+// EnrollmentRequired is true if any policy is not satisfied.
+// The API should prompt the user to enroll.
+func (state *MFAEnrollmentState) EnrollmentRequired() bool {
+	return len(state.Requirements) > 0
+}
+
+// Enforced is true if the grace period of any unsatisfied policy is over.
+// The API should refuse anything but MFA enrollment.
+func (state *MFAEnrollmentState) Enforced() bool {
+	now := time.Now()
+	for _, requirement := range state.Requirements {
+		if !now.Before(requirement.Deadline) {
+			return true
+		}
+	}
+	return false
+}
+
+// newLoginResult evaluates the MFA policies at sign-in.
+func (user *User) newLoginResult() (*LoginResult, error) {
+	state, err := user.MFAEnrollmentState()
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{
+		User:                  user,
+		MFAEnrollmentRequired: state.Enforced(),
+	}, nil
+}
+
+// CheckMFAEnrollment returns ErrMFAEnrollmentRequired if any MFA policy is
+// enforced on the user, e.g. to guard what a session may do.
+func (user *User) CheckMFAEnrollment() error {
+	state, err := user.MFAEnrollmentState()
+	if err != nil {
+		return err
+	}
+	if state.Enforced() {
+		return ErrMFAEnrollmentRequired
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MFA recovery is for users who lost every registered MFA:
+// 	1. BeginMFARecovery issues a token to be sent to the user's Email.
+// 	2. RequestMFARecovery with the token opens a recovery request and starts
+// 	the waiting period. Handlers are notified, so the user can be warned on
+// 	all channels and cancel with the CancelToken if it wasn't them.
+// 	3. After the waiting period, an admin approves (all MFA are removed)
+// 	or denies the request.
+// Every step is recorded in the audit log of the request.
+
+// MFARecovery status
+const (
+	MFA_RECOVERY_PENDING uint8 = iota
+	MFA_RECOVERY_CANCELLED
+	MFA_RECOVERY_APPROVED
+	MFA_RECOVERY_DENIED
+)
+
+// MFARecovery audit log actions
+const (
+	MFA_RECOVERY_ACTION_REQUEST = "request"
+	MFA_RECOVERY_ACTION_CANCEL  = "cancel"
+	MFA_RECOVERY_ACTION_APPROVE = "approve"
+	MFA_RECOVERY_ACTION_DENY    = "deny"
+	MFA_RECOVERY_ACTION_REMOVE  = "remove_mfa" // one per MFA removed on approval
+)
+
+const (
+	mfaRecoveryTmpExtension = "mfa_recovery"
+	mfaRecoveryTokenBytes   = 16 // hex-encoded into 32 chars
+)
+
+var (
+	DefaultMFARecoveryWaitingPeriod = 72 * time.Hour
+	DefaultMFARecoveryTokenLifetime = 24 * time.Hour
+
+	mfaRecoveryHandlerMutex sync.RWMutex         = sync.RWMutex{}
+	mfaRecoveryHandlers     []MFARecoveryHandler = []MFARecoveryHandler{}
+
+	ErrMFARecoveryTokenBad      = errors.New("auth: MFA recovery token is invalid")
+	ErrMFARecoveryPending       = errors.New("auth: an MFA recovery request is pending already")
+	ErrMFARecoveryNotPending    = errors.New("auth: MFA recovery request is no longer pending")
+	ErrMFARecoveryWaiting       = errors.New("auth: MFA recovery waiting period is not over")
+	ErrMFARecoveryNotAdmin      = errors.New("auth: only admins may decide MFA recovery requests")
+	ErrMFARecoveryNoMFA         = errors.New("auth: user has no MFA to recover")
+	ErrMFARecoveryEmailMismatch = errors.New("auth: MFA recovery token was issued for another email")
+	ErrMFARecoveryNotOwner      = errors.New("auth: MFA recovery request belongs to another user")
+	ErrMFARecoverySelfDecision  = errors.New("auth: admins may not decide their own MFA recovery request")
+)
+
+type MFARecovery struct {
+	id            uint64
+	UserID        uint64     `json:"user_id"`
+	Status        uint8      `json:"status"`
+	RequestedAt   time.Time  `json:"requested_at"`
+	EligibleAt    time.Time  `json:"eligible_at"` // end of the waiting period
+	DecidedAt     *time.Time `json:"decided_at"`
+	DeciderUserID uint64     `json:"decider"` // the user (cancel) or the admin
+
+	cancelTokenHash string // hex of SHA-256
+}
+
+type MFARecoveryLogEntry struct {
+	RecoveryID  uint64    `json:"recovery_id"`
+	ActorUserID uint64    `json:"actor"`
+	Action      string    `json:"action"`
+	Note        string    `json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// MFARecoveryEvent is emitted on every step of a recovery request
+type MFARecoveryEvent struct {
+	Recovery    *MFARecovery `json:"recovery"`
+	Action      string       `json:"action"`
+	CancelToken string       `json:"cancel_token"` // only set on MFA_RECOVERY_ACTION_REQUEST
+}
+
+type MFARecoveryHandler func(event MFARecoveryEvent)
+
+// RegMFARecoveryHandler registers a handler to be called on every step,
+// e.g. to notify the user by email, SMS, push, etc.
+func RegMFARecoveryHandler(handler MFARecoveryHandler) {
+	mfaRecoveryHandlerMutex.Lock()
+	defer mfaRecoveryHandlerMutex.Unlock()
+
+	mfaRecoveryHandlers = append(mfaRecoveryHandlers, handler)
+}
+
+func emitMFARecoveryEvent(event MFARecoveryEvent) {
+	mfaRecoveryHandlerMutex.RLock()
+	defer mfaRecoveryHandlerMutex.RUnlock()
+
+	for _, handler := range mfaRecoveryHandlers {
+		handler(event)
+	}
+}
+
+func (recovery *MFARecovery) ID() uint64 {
+	return recovery.id
+}
+
+func (recovery *MFARecovery) log(actorUserID uint64, action, note string) error {
+	return store.NewMFARecoveryLog(&MFARecoveryLogEntry{
+		RecoveryID:  recovery.id,
+		ActorUserID: actorUserID,
+		Action:      action,
+		Note:        note,
+		CreatedAt:   time.Now(),
+	})
+}
+
+// AuditLog lists every step of the recovery request, oldest first.
+func (recovery *MFARecovery) AuditLog() ([]*MFARecoveryLogEntry, error) {
+	return store.ListMFARecoveryLog(recovery.id)
+}
+
+func GetMFARecoveryByID(recoveryID uint64) (*MFARecovery, error) {
+	return store.GetMFARecoveryByID(recoveryID)
+}
+
+// ListMFARecoveries lists recovery requests by status, e.g. MFA_RECOVERY_PENDING
+// for the admin queue.
+func ListMFARecoveries(status uint8) ([]*MFARecovery, error) {
+	return store.ListMFARecoveriesByStatus(status)
+}
+
+// MFARecoveries lists all recovery requests of the user, latest first.
+func (user *User) MFARecoveries() ([]*MFARecovery, error) {
+	return store.ListMFARecoveriesByUserID(user.id)
+}
+
+// BeginMFARecovery issues a token proving the ownership of Email.
+// The caller is responsible for sending it to the Email.
+func (user *User) BeginMFARecovery() (string, error) {
+	if !AnyMFARegistered(user.id) {
+		return "", ErrMFARecoveryNoMFA
+	}
+
+	token, err := randomToken(mfaRecoveryTokenBytes)
+	if err != nil {
+		return "", err
+	}
+	err = Ephemeral().Add(UserEphemeralKey(user.id, mfaRecoveryTmpExtension, token), user.Email, DefaultMFARecoveryTokenLifetime)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RequestMFARecovery opens a recovery request with the token sent to the Email.
+// The token is single-use. The waiting period is DefaultMFARecoveryWaitingPeriod.
+func (user *User) RequestMFARecovery(token string) (*MFARecovery, error) {
+	if len(token) != 2*mfaRecoveryTokenBytes {
+		return nil, ErrMFARecoveryTokenBad
+	}
+	email, err := Ephemeral().Take(UserEphemeralKey(user.id, mfaRecoveryTmpExtension, token))
+	if err != nil {
+		return nil, ErrMFARecoveryTokenBad
+	}
+	if !strings.EqualFold(email, user.Email) {
+		return nil, ErrMFARecoveryEmailMismatch
+	}
+
+	recoveries, err := store.ListMFARecoveriesByUserID(user.id)
+	if err != nil {
+		return nil, err
+	}
+	for _, recovery := range recoveries {
+		if recovery.Status == MFA_RECOVERY_PENDING {
+			return nil, ErrMFARecoveryPending
+		}
+	}
+
+	cancelToken, err := randomToken(mfaRecoveryTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	recovery := &MFARecovery{
+		UserID:          user.id,
+		Status:          MFA_RECOVERY_PENDING,
+		RequestedAt:     now,
+		EligibleAt:      now.Add(DefaultMFARecoveryWaitingPeriod),
+		cancelTokenHash: hashToken(cancelToken),
+	}
+	err = store.NewMFARecovery(recovery)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recovery.log(user.id, MFA_RECOVERY_ACTION_REQUEST, "email verified: "+user.Email)
+	if err != nil {
+		return nil, err
+	}
+	emitMFARecoveryEvent(MFARecoveryEvent{
+		Recovery:    recovery,
+		Action:      MFA_RECOVERY_ACTION_REQUEST,
+		CancelToken: cancelToken,
+	})
+	return recovery, nil
+}
+
+// CancelMFARecoveryByToken is called when the user follows the cancel link
+// sent with the notification.
+func CancelMFARecoveryByToken(cancelToken string) error {
+	if len(cancelToken) != 2*mfaRecoveryTokenBytes {
+		return ErrMFARecoveryTokenBad
+	}
+	cancelTokenHash := hashToken(cancelToken)
+	recovery, err := store.GetMFARecoveryByCancelTokenHash(cancelTokenHash)
+	if err != nil || subtle.ConstantTimeCompare([]byte(recovery.cancelTokenHash), []byte(cancelTokenHash)) != 1 {
+		return ErrMFARecoveryTokenBad
+	}
+	return recovery.decide(recovery.UserID, MFA_RECOVERY_CANCELLED, MFA_RECOVERY_ACTION_CANCEL, "")
+}
+
+// Cancel is called by the signed-in user who owns the request.
+func (recovery *MFARecovery) Cancel(user *User) error {
+	if user.id != recovery.UserID {
+		return ErrMFARecoveryNotOwner
+	}
+	return recovery.decide(user.id, MFA_RECOVERY_CANCELLED, MFA_RECOVERY_ACTION_CANCEL, "")
+}
+
+// Approve removes all registered MFA of the user. It is only possible after
+// the waiting period. The admin must hold GLOBAL_ADMIN, and may not be the
+// user of the request.
+func (recovery *MFARecovery) Approve(admin *User, note string) error {
+	if err := recovery.checkAdmin(admin); err != nil {
+		return err
+	}
+	if time.Now().Before(recovery.EligibleAt) {
+		return ErrMFARecoveryWaiting
+	}
+
+	err := recovery.decide(admin.id, MFA_RECOVERY_APPROVED, MFA_RECOVERY_ACTION_APPROVE, note)
+	if err != nil {
+		return err
+	}
+
+	for MFAType, instance := range mfaInstances() {
+		if !instance.Registered(recovery.UserID) {
+			continue
+		}
+		if err = mfaRemove(MFAType, recovery.UserID, admin.id); err != nil {
+			return err
+		}
+		if err = recovery.log(admin.id, MFA_RECOVERY_ACTION_REMOVE, MFAType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deny closes the request without removing any MFA. The admin must hold
+// GLOBAL_ADMIN, and may not be the user of the request.
+func (recovery *MFARecovery) Deny(admin *User, note string) error {
+	if err := recovery.checkAdmin(admin); err != nil {
+		return err
+	}
+	return recovery.decide(admin.id, MFA_RECOVERY_DENIED, MFA_RECOVERY_ACTION_DENY, note)
+}
+
+// checkAdmin requires a second party: the point of the decision is that
+// someone other than the user vouches for them.
+func (recovery *MFARecovery) checkAdmin(admin *User) error {
+	if admin.id == recovery.UserID {
+		return ErrMFARecoverySelfDecision
+	}
+	role, err := admin.EffectiveRole()
+	if err != nil {
+		return err
+	}
+	if !role.Includes(GLOBAL_ADMIN) {
+		return ErrMFARecoveryNotAdmin
+	}
+	return nil
+}
+
+func (recovery *MFARecovery) decide(actorUserID uint64, status uint8, action, note string) error {
+	if recovery.Status != MFA_RECOVERY_PENDING {
+		return ErrMFARecoveryNotPending
+	}
+
+	now := time.Now()
+	err := store.DecideMFARecovery(recovery.id, status, actorUserID, now)
+	if err != nil {
+		return err
+	}
+	recovery.Status = status
+	recovery.DeciderUserID = actorUserID
+	recovery.DecidedAt = &now
+
+	err = recovery.log(actorUserID, action, note)
+	if err != nil {
+		return err
+	}
+	emitMFARecoveryEvent(MFARecoveryEvent{
+		Recovery: recovery,
+		Action:   action,
+	})
+	return nil
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const (
+	auditTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_audit (
+        id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+        eventType VARCHAR(32) NOT NULL,
+        actorUserID BIGINT UNSIGNED NOT NULL DEFAULT 0,
+        subjectUserID BIGINT UNSIGNED NOT NULL DEFAULT 0,
+        affiliationID BIGINT UNSIGNED NOT NULL DEFAULT 0,
+        ip VARCHAR(64) NOT NULL DEFAULT '',
+        userAgent VARCHAR(255) NOT NULL DEFAULT '',
+        detail TEXT NOT NULL,
+        createdAt DATETIME NOT NULL,
+        PRIMARY KEY (id),
+        INDEX (subjectUserID, createdAt),
+        INDEX (actorUserID, createdAt),
+        INDEX (affiliationID, createdAt),
+        INDEX (createdAt)
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+)
+
+/************ Audit Database ************/
+
+func (s *sqlStore) NewAuditEvent(event *AuditEvent) error {
+	stmtInsertAuditEvent, err := s.statement(`INSERT INTO dbprefix_auth_audit
+    (eventType, actorUserID, subjectUserID, affiliationID, ip, userAgent, detail, createdAt)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertAuditEvent.Close()
+
+	result, err := stmtInsertAuditEvent.Exec(event.EventType, event.ActorUserID, event.SubjectUserID, event.AffiliationID, event.IP, event.UserAgent, string(event.Detail), event.CreatedAt)
+	if err != nil {
+		return err
+	}
+	eventID, err := result.LastInsertId()
+	event.id = uint64(eventID)
+	return err
+}
+
+func (s *sqlStore) QueryAuditEvents(filter AuditFilter) ([]*AuditEvent, error) {
+	var conditions []string = []string{"1 = 1"}
+	var args []interface{}
+	if filter.ActorUserID != 0 {
+		conditions = append(conditions, "actorUserID = ?")
+		args = append(args, filter.ActorUserID)
+	}
+	if filter.SubjectUserID != 0 {
+		conditions = append(conditions, "subjectUserID = ?")
+		args = append(args, filter.SubjectUserID)
+	}
+	if filter.AffiliationID != 0 {
+		conditions = append(conditions, "affiliationID = ?")
+		args = append(args, filter.AffiliationID)
+	}
+	if len(filter.EventTypes) > 0 {
+		conditions = append(conditions, "eventType IN (?"+strings.Repeat(", ?", len(filter.EventTypes)-1)+")")
+		for _, eventType := range filter.EventTypes {
+			args = append(args, eventType)
+		}
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "createdAt >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "createdAt < ?")
+		args = append(args, filter.Until)
+	}
+	args = append(args, filter.Limit, filter.Offset)
+
+	stmtQueryAuditEvents, err := s.statement(`SELECT id, eventType, actorUserID, subjectUserID, affiliationID, ip, userAgent, detail, createdAt
+    FROM dbprefix_auth_audit WHERE ` + strings.Join(conditions, " AND ") + ` ORDER BY id DESC LIMIT ? OFFSET ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtQueryAuditEvents.Close()
+
+	rows, err := stmtQueryAuditEvents.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent = []*AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var detail string
+		err = rows.Scan(&event.id, &event.EventType, &event.ActorUserID, &event.SubjectUserID, &event.AffiliationID, &event.IP, &event.UserAgent, &detail, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		event.Detail = []byte(detail)
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// scrubAuditTx clears the IP and user agent of the events by or about the
+// user, and the keys of their detail registered as PII.
+func (s *sqlStore) scrubAuditTx(tx *sql.Tx, userID uint64) error {
+	stmtScrubAuditIP, err := s.txStatement(tx, `UPDATE dbprefix_auth_audit SET ip = '', userAgent = '' WHERE actorUserID = ? OR subjectUserID = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtScrubAuditIP.Close()
+	if _, err = stmtScrubAuditIP.Exec(userID, userID); err != nil {
+		return err
+	}
+
+	stmtListAuditDetail, err := s.txStatement(tx, `SELECT id, detail FROM dbprefix_auth_audit WHERE actorUserID = ? OR subjectUserID = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtListAuditDetail.Close()
+
+	rows, err := stmtListAuditDetail.Query(userID, userID)
+	if err != nil {
+		return err
+	}
+	piiKeys := getAuditPIIDetailKeys()
+	scrubbed := map[uint64]string{}
+	for rows.Next() {
+		var eventID uint64
+		var detailJson string
+		if err = rows.Scan(&eventID, &detailJson); err != nil {
+			rows.Close()
+			return err
+		}
+		scrubbedJson, found, err := scrubAuditDetail([]byte(detailJson), piiKeys)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if found {
+			scrubbed[eventID] = string(scrubbedJson)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// rows must be closed before the connection of tx is used again
+	stmtUpdateAuditDetail, err := s.txStatement(tx, `UPDATE dbprefix_auth_audit SET detail = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdateAuditDetail.Close()
+	for eventID, detailJson := range scrubbed {
+		if _, err = stmtUpdateAuditDetail.Exec(detailJson, eventID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) ScrubAuditIPs(before time.Time) error {
+	stmtScrubAuditIPs, err := s.statement(`UPDATE dbprefix_auth_audit SET ip = '', userAgent = '' WHERE createdAt < ? AND (ip <> '' OR userAgent <> '');`)
+	if err != nil {
+		return err
+	}
+	defer stmtScrubAuditIPs.Close()
+
+	_, err = stmtScrubAuditIPs.Exec(before)
+	return err
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+const (
+	customRoleTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_custom_role (
+        id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+        name VARCHAR(64) NOT NULL,
+        description VARCHAR(255) NOT NULL,
+        permissions TEXT NOT NULL,
+        PRIMARY KEY (id),
+        UNIQUE KEY (name)
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+
+	customRoleUserTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_custom_role_user (
+        roleID BIGINT UNSIGNED NOT NULL,
+        userID BIGINT UNSIGNED NOT NULL,
+        PRIMARY KEY (roleID, userID),
+        INDEX (userID),
+        CONSTRAINT FOREIGN KEY (roleID) REFERENCES dbprefix_auth_custom_role(id) ON DELETE CASCADE,
+        CONSTRAINT FOREIGN KEY (userID) REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+
+	customRoleAffiliationTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_custom_role_affiliation (
+        roleID BIGINT UNSIGNED NOT NULL,
+        affiliationID BIGINT UNSIGNED NOT NULL,
+        PRIMARY KEY (roleID, affiliationID),
+        INDEX (affiliationID),
+        CONSTRAINT FOREIGN KEY (roleID) REFERENCES dbprefix_auth_custom_role(id) ON DELETE CASCADE,
+        CONSTRAINT FOREIGN KEY (affiliationID) REFERENCES dbprefix_auth_affiliation(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+)
+
+/************ Custom Role Database ************/
+
+func scanCustomRoles(rows *sql.Rows) ([]*CustomRole, error) {
+	var customRoles []*CustomRole = []*CustomRole{}
+	for rows.Next() {
+		var customRole CustomRole
+		var permissions string
+		err := rows.Scan(&customRole.id, &customRole.Name, &customRole.Description, &permissions)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(permissions), &customRole.Permissions)
+		if err != nil {
+			return nil, err
+		}
+		customRoles = append(customRoles, &customRole)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return customRoles, nil
+}
+
+func (s *sqlStore) queryCustomRoles(query string, args ...interface{}) ([]*CustomRole, error) {
+	stmtQueryCustomRoles, err := s.statement(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtQueryCustomRoles.Close()
+
+	rows, err := stmtQueryCustomRoles.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanCustomRoles(rows)
+}
+
+func (s *sqlStore) NewCustomRole(customRole *CustomRole) error {
+	permissions, err := json.Marshal(customRole.Permissions)
+	if err != nil {
+		return err
+	}
+
+	stmtInsertCustomRole, err := s.statement(`INSERT INTO dbprefix_auth_custom_role (name, description, permissions) VALUES (?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertCustomRole.Close()
+
+	result, err := stmtInsertCustomRole.Exec(customRole.Name, customRole.Description, string(permissions))
+	if err != nil {
+		return err
+	}
+	customRoleID, err := result.LastInsertId()
+	customRole.id = uint64(customRoleID)
+	return err
+}
+
+func (s *sqlStore) GetCustomRoleByID(customRoleID uint64) (*CustomRole, error) {
+	customRoles, err := s.queryCustomRoles(`SELECT id, name, description, permissions FROM dbprefix_auth_custom_role WHERE id = ?;`, customRoleID)
+	if err != nil {
+		return nil, err
+	}
+	if len(customRoles) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return customRoles[0], nil
+}
+
+func (s *sqlStore) GetCustomRoleByName(name string) (*CustomRole, error) {
+	customRoles, err := s.queryCustomRoles(`SELECT id, name, description, permissions FROM dbprefix_auth_custom_role WHERE name = ?;`, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(customRoles) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return customRoles[0], nil
+}
+
+func (s *sqlStore) ListCustomRoles() ([]*CustomRole, error) {
+	return s.queryCustomRoles(`SELECT id, name, description, permissions FROM dbprefix_auth_custom_role ORDER BY name;`)
+}
+
+// ListCustomRolesByUser lists custom roles assigned to the user directly or through the affiliation
+func (s *sqlStore) ListCustomRolesByUser(userID, affiliationID uint64) ([]*CustomRole, error) {
+	return s.queryCustomRoles(`SELECT id, name, description, permissions FROM dbprefix_auth_custom_role
+    WHERE id IN (SELECT roleID FROM dbprefix_auth_custom_role_user WHERE userID = ?)
+    OR id IN (SELECT roleID FROM dbprefix_auth_custom_role_affiliation WHERE affiliationID = ? AND affiliationID <> 0)
+    ORDER BY name;`, userID, affiliationID)
+}
+
+func (s *sqlStore) ListCustomRolesByAffiliation(affiliationID uint64) ([]*CustomRole, error) {
+	return s.queryCustomRoles(`SELECT id, name, description, permissions FROM dbprefix_auth_custom_role
+    WHERE id IN (SELECT roleID FROM dbprefix_auth_custom_role_affiliation WHERE affiliationID = ?)
+    ORDER BY name;`, affiliationID)
+}
+
+func (s *sqlStore) UpdateCustomRole(customRole *CustomRole) error {
+	permissions, err := json.Marshal(customRole.Permissions)
+	if err != nil {
+		return err
+	}
+
+	stmtUpdateCustomRole, err := s.statement(`UPDATE dbprefix_auth_custom_role SET name = ?, description = ?, permissions = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdateCustomRole.Close()
+
+	_, err = stmtUpdateCustomRole.Exec(customRole.Name, customRole.Description, string(permissions), customRole.id)
+	return err
+}
+
+// DeleteCustomRole also removes all assignments by cascading
+func (s *sqlStore) DeleteCustomRole(customRoleID uint64) error {
+	stmtDeleteCustomRole, err := s.statement(`DELETE FROM dbprefix_auth_custom_role WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtDeleteCustomRole.Close()
+
+	_, err = stmtDeleteCustomRole.Exec(customRoleID)
+	return err
+}
+
+func (s *sqlStore) execCustomRoleAssignment(query string, customRoleID, subjectID uint64) error {
+	stmtAssignment, err := s.statement(query)
+	if err != nil {
+		return err
+	}
+	defer stmtAssignment.Close()
+
+	_, err = stmtAssignment.Exec(customRoleID, subjectID)
+	return err
+}
+
+func (s *sqlStore) AssignCustomRoleToUser(customRoleID, userID uint64) error {
+	return s.execCustomRoleAssignment(s.dialect(
+		`INSERT IGNORE INTO dbprefix_auth_custom_role_user (roleID, userID) VALUES (?, ?);`,
+		`INSERT OR IGNORE INTO dbprefix_auth_custom_role_user (roleID, userID) VALUES (?, ?);`,
+	), customRoleID, userID)
+}
+
+func (s *sqlStore) UnassignCustomRoleFromUser(customRoleID, userID uint64) error {
+	return s.execCustomRoleAssignment(`DELETE FROM dbprefix_auth_custom_role_user WHERE roleID = ? AND userID = ?;`, customRoleID, userID)
+}
+
+func (s *sqlStore) AssignCustomRoleToAffiliation(customRoleID, affiliationID uint64) error {
+	return s.execCustomRoleAssignment(s.dialect(
+		`INSERT IGNORE INTO dbprefix_auth_custom_role_affiliation (roleID, affiliationID) VALUES (?, ?);`,
+		`INSERT OR IGNORE INTO dbprefix_auth_custom_role_affiliation (roleID, affiliationID) VALUES (?, ?);`,
+	), customRoleID, affiliationID)
+}
+
+func (s *sqlStore) UnassignCustomRoleFromAffiliation(customRoleID, affiliationID uint64) error {
+	return s.execCustomRoleAssignment(`DELETE FROM dbprefix_auth_custom_role_affiliation WHERE roleID = ? AND affiliationID = ?;`, customRoleID, affiliationID)
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	deactivationTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_user_deactivation (
+        userID BIGINT UNSIGNED NOT NULL,
+        reason VARCHAR(255) NOT NULL,
+        deactivatedAt DATETIME NOT NULL,
+        erasureAt DATETIME NOT NULL,
+        erasedAt DATETIME NULL DEFAULT NULL,
+        pseudonym VARCHAR(32) NOT NULL DEFAULT '',
+        PRIMARY KEY (userID),
+        INDEX (erasedAt, erasureAt),
+        CONSTRAINT FOREIGN KEY (userID) REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+)
+
+/************ Deactivation Database ************/
+
+func (s *sqlStore) GetDeactivation(userID uint64) (*Deactivation, error) {
+	stmtGetDeactivation, err := s.statement(`SELECT userID, reason, deactivatedAt, erasureAt, erasedAt, pseudonym FROM dbprefix_auth_user_deactivation WHERE userID = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetDeactivation.Close()
+
+	var deactivation Deactivation
+	var erasedAt sql.NullTime
+	err = stmtGetDeactivation.QueryRow(userID).Scan(&deactivation.UserID, &deactivation.Reason, &deactivation.DeactivatedAt, &deactivation.ErasureAt, &erasedAt, &deactivation.Pseudonym)
+	if err != nil {
+		return nil, err
+	}
+	if erasedAt.Valid {
+		deactivation.ErasedAt = &erasedAt.Time
+	}
+	return &deactivation, nil
+}
+
+func (s *sqlStore) NewDeactivation(deactivation *Deactivation) error {
+	stmtInsertDeactivation, err := s.statement(`INSERT INTO dbprefix_auth_user_deactivation (userID, reason, deactivatedAt, erasureAt) VALUES (?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertDeactivation.Close()
+
+	_, err = stmtInsertDeactivation.Exec(deactivation.UserID, deactivation.Reason, deactivation.DeactivatedAt, deactivation.ErasureAt)
+	return err
+}
+
+func (s *sqlStore) UpdateErasureAt(userID uint64, erasureAt time.Time) error {
+	stmtUpdateErasureAt, err := s.statement(`UPDATE dbprefix_auth_user_deactivation SET erasureAt = ? WHERE userID = ? AND erasedAt IS NULL;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdateErasureAt.Close()
+
+	_, err = stmtUpdateErasureAt.Exec(erasureAt, userID)
+	return err
+}
+
+func (s *sqlStore) DeleteDeactivation(userID uint64) error {
+	stmtDeleteDeactivation, err := s.statement(`DELETE FROM dbprefix_auth_user_deactivation WHERE userID = ? AND erasedAt IS NULL;`)
+	if err != nil {
+		return err
+	}
+	defer stmtDeleteDeactivation.Close()
+
+	_, err = stmtDeleteDeactivation.Exec(userID)
+	return err
+}
+
+func (s *sqlStore) ListUserIDsDueForErasure(now time.Time) ([]uint64, error) {
+	stmtListDue, err := s.statement(`SELECT userID FROM dbprefix_auth_user_deactivation WHERE erasedAt IS NULL AND erasureAt <= ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListDue.Close()
+
+	rows, err := stmtListDue.Query(now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []uint64
+	for rows.Next() {
+		var userID uint64
+		err = rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
+
+// AnonymizeUser scrubs PII of the user atomically. The user row is kept with
+// the pseudonym in place of the email, so financial records keyed by the
+// user ID remain valid. Invitations to the email are deleted, and notes of
+// MFA recoveries and the audit trail are scrubbed.
+func (s *sqlStore) AnonymizeUser(userID uint64, pseudonym string, erasedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmtGetEmail, err := s.txStatement(tx, `SELECT email FROM dbprefix_auth_user WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtGetEmail.Close()
+	var email string
+	if err = stmtGetEmail.QueryRow(userID).Scan(&email); err != nil {
+		return err
+	}
+
+	queries := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE dbprefix_auth_user SET email = ?, publickey = '', role = 0, affiliation = 0 WHERE id = ?;`, []interface{}{pseudonym + anonymizedEmailDomain, userID}},
+		{`UPDATE dbprefix_auth_user_info SET first_name = '', last_name = '', street_address = '', suite = '', city = '', state = '', country_iso = '', zip_code = '' WHERE id = ?;`, []interface{}{userID}},
+		{`DELETE FROM dbprefix_auth_mfa WHERE userID = ?;`, []interface{}{userID}},
+		{`DELETE FROM dbprefix_auth_mfa_preference WHERE userID = ?;`, []interface{}{userID}},
+		{`DELETE FROM dbprefix_auth_user_key WHERE userID = ?;`, []interface{}{userID}},
+		{`DELETE FROM dbprefix_auth_external_identity WHERE userID = ?;`, []interface{}{userID}},
+		{`DELETE FROM dbprefix_auth_email_verified WHERE userID = ?;`, []interface{}{userID}},
+		{`DELETE FROM dbprefix_auth_custom_role_user WHERE userID = ?;`, []interface{}{userID}},
+		{`DELETE FROM dbprefix_auth_affiliation_invitation WHERE email = ?;`, []interface{}{email}},
+		{`UPDATE dbprefix_auth_mfa_recovery_log SET note = '' WHERE recoveryID IN (SELECT id FROM dbprefix_auth_mfa_recovery WHERE userID = ?);`, []interface{}{userID}},
+		{`UPDATE dbprefix_auth_user_deactivation SET erasedAt = ?, pseudonym = ?, reason = '' WHERE userID = ?;`, []interface{}{erasedAt, pseudonym, userID}},
+	}
+	for _, q := range queries {
+		stmt, err := s.txStatement(tx, q.query)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(q.args...)
+		stmt.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err = s.scrubAuditTx(tx, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
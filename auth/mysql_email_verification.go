@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	emailVerifiedTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_email_verified (
+        userID BIGINT UNSIGNED NOT NULL,
+        email VARCHAR(128) NOT NULL,
+        verifiedAt DATETIME NOT NULL,
+        PRIMARY KEY (userID),
+        CONSTRAINT FOREIGN KEY (userID) REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+)
+
+/************ Email Verification Database ************/
+
+// GetVerifiedEmail returns the last email address verified by the user, if any
+func (s *sqlStore) GetVerifiedEmail(userID uint64) (email string, verifiedAt time.Time, err error) {
+	stmtGetVerifiedEmail, err := s.statement(`SELECT email, verifiedAt FROM dbprefix_auth_email_verified WHERE userID = ?;`)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer stmtGetVerifiedEmail.Close()
+
+	err = stmtGetVerifiedEmail.QueryRow(userID).Scan(&email, &verifiedAt)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, nil
+	}
+	return email, verifiedAt, err
+}
+
+func (s *sqlStore) setVerifiedEmailQuery() string {
+	return s.dialect(`INSERT INTO dbprefix_auth_email_verified (userID, email, verifiedAt) VALUES (?, ?, ?)
+    ON DUPLICATE KEY UPDATE email = VALUES(email), verifiedAt = VALUES(verifiedAt);`, `INSERT INTO dbprefix_auth_email_verified (userID, email, verifiedAt) VALUES (?, ?, ?)
+    ON CONFLICT (userID) DO UPDATE SET email = excluded.email, verifiedAt = excluded.verifiedAt;`)
+}
+
+func (s *sqlStore) SetVerifiedEmail(userID uint64, email string, verifiedAt time.Time) error {
+	stmtSetVerifiedEmail, err := s.statement(s.setVerifiedEmailQuery())
+	if err != nil {
+		return err
+	}
+	defer stmtSetVerifiedEmail.Close()
+
+	_, err = stmtSetVerifiedEmail.Exec(userID, email, verifiedAt)
+	return err
+}
+
+// ChangeVerifiedEmail changes the email of the user and marks it verified atomically
+func (s *sqlStore) ChangeVerifiedEmail(userID uint64, email string, verifiedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmtUpdateEmail, err := s.txStatement(tx, `UPDATE dbprefix_auth_user SET email = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdateEmail.Close()
+
+	_, err = stmtUpdateEmail.Exec(email, userID)
+	if err != nil {
+		return err
+	}
+
+	stmtSetVerifiedEmail, err := s.txStatement(tx, s.setVerifiedEmailQuery())
+	if err != nil {
+		return err
+	}
+	defer stmtSetVerifiedEmail.Close()
+
+	_, err = stmtSetVerifiedEmail.Exec(userID, email, verifiedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
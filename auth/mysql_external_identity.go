@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	externalIdentityTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_external_identity (
+        id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+        userID BIGINT UNSIGNED NOT NULL,
+        provider VARCHAR(64) NOT NULL,
+        subject VARCHAR(255) NOT NULL,
+        email VARCHAR(128) NOT NULL,
+        linkedAt DATETIME NOT NULL,
+        lastUsedAt DATETIME NULL DEFAULT NULL,
+        PRIMARY KEY (id),
+        UNIQUE KEY (provider, subject),
+        INDEX (userID),
+        CONSTRAINT FOREIGN KEY (userID) REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`
+)
+
+/************ External Identity Database ************/
+
+func scanExternalIdentity(scanner interface{ Scan(...interface{}) error }) (*ExternalIdentity, error) {
+	var identity ExternalIdentity
+	var lastUsedAt sql.NullTime
+	err := scanner.Scan(&identity.id, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.LinkedAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		identity.LastUsedAt = &lastUsedAt.Time
+	}
+	return &identity, nil
+}
+
+func (s *sqlStore) NewExternalIdentity(identity *ExternalIdentity) error {
+	stmtInsertExternalIdentity, err := s.statement(`INSERT INTO dbprefix_auth_external_identity (userID, provider, subject, email, linkedAt) VALUES (?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertExternalIdentity.Close()
+
+	result, err := stmtInsertExternalIdentity.Exec(identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.LinkedAt)
+	if err != nil {
+		return err
+	}
+	identityID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	identity.id = uint64(identityID)
+	return nil
+}
+
+func (s *sqlStore) GetExternalIdentity(provider, subject string) (*ExternalIdentity, error) {
+	stmtGetExternalIdentity, err := s.statement(`SELECT id, userID, provider, subject, email, linkedAt, lastUsedAt FROM dbprefix_auth_external_identity WHERE provider = ? AND subject = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetExternalIdentity.Close()
+
+	identity, err := scanExternalIdentity(stmtGetExternalIdentity.QueryRow(provider, subject))
+	if err == sql.ErrNoRows {
+		return nil, ErrExternalIdentityNotFound
+	}
+	return identity, err
+}
+
+func (s *sqlStore) ListExternalIdentities(userID uint64) ([]*ExternalIdentity, error) {
+	stmtListExternalIdentity, err := s.statement(`SELECT id, userID, provider, subject, email, linkedAt, lastUsedAt FROM dbprefix_auth_external_identity WHERE userID = ? ORDER BY id ASC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListExternalIdentity.Close()
+
+	rows, err := stmtListExternalIdentity.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*ExternalIdentity = []*ExternalIdentity{}
+	for rows.Next() {
+		identity, err := scanExternalIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (s *sqlStore) ListProviderIdentities(provider string) ([]*ExternalIdentity, error) {
+	stmtListProviderIdentity, err := s.statement(`SELECT id, userID, provider, subject, email, linkedAt, lastUsedAt FROM dbprefix_auth_external_identity WHERE provider = ? ORDER BY id ASC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListProviderIdentity.Close()
+
+	rows, err := stmtListProviderIdentity.Query(provider)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*ExternalIdentity = []*ExternalIdentity{}
+	for rows.Next() {
+		identity, err := scanExternalIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (s *sqlStore) TouchExternalIdentity(identityID uint64, lastUsedAt time.Time) error {
+	stmtTouchExternalIdentity, err := s.statement(`UPDATE dbprefix_auth_external_identity SET lastUsedAt = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtTouchExternalIdentity.Close()
+
+	_, err = stmtTouchExternalIdentity.Exec(lastUsedAt, identityID)
+	return err
+}
+
+func (s *sqlStore) DeleteExternalIdentity(identityID uint64) error {
+	stmtDeleteExternalIdentity, err := s.statement(`DELETE FROM dbprefix_auth_external_identity WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtDeleteExternalIdentity.Close()
+
+	_, err = stmtDeleteExternalIdentity.Exec(identityID)
+	return err
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	lockoutTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_lockout (
+        subjectType VARCHAR(8) NOT NULL,
+        subject VARCHAR(128) NOT NULL,
+        failures INT UNSIGNED NOT NULL DEFAULT 0,
+        lastFailure DATETIME NOT NULL,
+        lockedUntil DATETIME NULL DEFAULT NULL,
+        PRIMARY KEY (subjectType, subject)
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+)
+
+/************ Lockout Database ************/
+
+// IncrLockoutFailures atomically increments the failure counter and returns
+// the new value. A counter whose last failure is older than window starts
+// over.
+func (s *sqlStore) IncrLockoutFailures(subjectType, subject string, now time.Time, window time.Duration) (uint, error) {
+	stmtIncrFailures, err := s.statement(s.dialect(`INSERT INTO dbprefix_auth_lockout (subjectType, subject, failures, lastFailure) VALUES (?, ?, 1, ?)
+    ON DUPLICATE KEY UPDATE failures = CASE WHEN lastFailure < ? THEN 1 ELSE failures + 1 END, lastFailure = VALUES(lastFailure);`, `INSERT INTO dbprefix_auth_lockout (subjectType, subject, failures, lastFailure) VALUES (?, ?, 1, ?)
+    ON CONFLICT (subjectType, subject) DO UPDATE SET failures = CASE WHEN lastFailure < ? THEN 1 ELSE failures + 1 END, lastFailure = excluded.lastFailure;`))
+	if err != nil {
+		return 0, err
+	}
+	defer stmtIncrFailures.Close()
+
+	_, err = stmtIncrFailures.Exec(subjectType, subject, now, now.Add(-window))
+	if err != nil {
+		return 0, err
+	}
+
+	failures, _, _, err := s.GetLockoutState(subjectType, subject)
+	return failures, err
+}
+
+func (s *sqlStore) GetLockoutState(subjectType, subject string) (failures uint, lastFailure time.Time, lockedUntil sql.NullTime, err error) {
+	stmtGetLockoutState, err := s.statement(`SELECT failures, lastFailure, lockedUntil FROM dbprefix_auth_lockout WHERE subjectType = ? AND subject = ?;`)
+	if err != nil {
+		return 0, time.Time{}, sql.NullTime{}, err
+	}
+	defer stmtGetLockoutState.Close()
+
+	err = stmtGetLockoutState.QueryRow(subjectType, subject).Scan(&failures, &lastFailure, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, sql.NullTime{}, nil
+	}
+	return failures, lastFailure, lockedUntil, err
+}
+
+// DecrLockoutFailures takes failures off the counter, down to 0 at most
+func (s *sqlStore) DecrLockoutFailures(subjectType, subject string, failures uint) error {
+	stmtDecrFailures, err := s.statement(`UPDATE dbprefix_auth_lockout SET failures = CASE WHEN failures > ? THEN failures - ? ELSE 0 END WHERE subjectType = ? AND subject = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtDecrFailures.Close()
+
+	_, err = stmtDecrFailures.Exec(failures, failures, subjectType, subject)
+	return err
+}
+
+func (s *sqlStore) SetLockedUntil(subjectType, subject string, lockedUntil time.Time) error {
+	stmtSetLockedUntil, err := s.statement(`UPDATE dbprefix_auth_lockout SET lockedUntil = ? WHERE subjectType = ? AND subject = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtSetLockedUntil.Close()
+
+	_, err = stmtSetLockedUntil.Exec(lockedUntil, subjectType, subject)
+	return err
+}
+
+func (s *sqlStore) ClearLockout(subjectType, subject string) error {
+	stmtClearLockout, err := s.statement(`DELETE FROM dbprefix_auth_lockout WHERE subjectType = ? AND subject = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtClearLockout.Close()
+
+	_, err = stmtClearLockout.Exec(subjectType, subject)
+	return err
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	mfaPolicyStateTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_mfa_policy_state (
+        userID BIGINT UNSIGNED NOT NULL,
+        policyName VARCHAR(64) NOT NULL,
+        since DATETIME NOT NULL,
+        PRIMARY KEY (userID, policyName),
+        CONSTRAINT FOREIGN KEY (userID) REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+)
+
+/************ MFA Policy State Database ************/
+
+// MFAPolicySince returns when the policy started to apply to the user.
+// If the policy was not recorded for the user, it is recorded as of now.
+func (s *sqlStore) MFAPolicySince(userID uint64, policyName string) (time.Time, error) {
+	stmtGetSince, err := s.statement(`SELECT since FROM dbprefix_auth_mfa_policy_state WHERE userID = ? AND policyName = ?;`)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer stmtGetSince.Close()
+
+	var since time.Time
+	err = stmtGetSince.QueryRow(userID, policyName).Scan(&since)
+	if err == nil {
+		return since, nil
+	} else if err != sql.ErrNoRows {
+		return time.Time{}, err
+	}
+
+	// First time the policy applies
+	since = time.Now()
+	stmtInsertSince, err := s.statement(`INSERT INTO dbprefix_auth_mfa_policy_state (userID, policyName, since) VALUES (?, ?, ?);`)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer stmtInsertSince.Close()
+
+	_, err = stmtInsertSince.Exec(userID, policyName, since)
+	return since, err
+}
+
+// ClearMFAPolicySince is called when the policy no longer applies to the user.
+func (s *sqlStore) ClearMFAPolicySince(userID uint64, policyName string) error {
+	stmtClearSince, err := s.statement(`DELETE FROM dbprefix_auth_mfa_policy_state WHERE userID = ? AND policyName = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtClearSince.Close()
+
+	_, err = stmtClearSince.Exec(userID, policyName)
+	return err
+}
@@ -0,0 +1,42 @@
+package auth
+
+import "database/sql"
+
+const (
+	mfaPreferenceTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_mfa_preference (
+        userID BIGINT UNSIGNED NOT NULL,
+        extentionType VARCHAR(32) NOT NULL,
+        PRIMARY KEY (userID),
+        CONSTRAINT FOREIGN KEY (userID) REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+)
+
+/************ MFA Preference Database ************/
+
+func (s *sqlStore) SetPreferredMFA(userID uint64, extentionType string) error {
+	stmtSetPreferredMFA, err := s.statement(s.dialect(`INSERT INTO dbprefix_auth_mfa_preference (userID, extentionType) VALUES (?, ?)
+    ON DUPLICATE KEY UPDATE extentionType = VALUES(extentionType);`, `INSERT INTO dbprefix_auth_mfa_preference (userID, extentionType) VALUES (?, ?)
+    ON CONFLICT (userID) DO UPDATE SET extentionType = excluded.extentionType;`))
+	if err != nil {
+		return err
+	}
+	defer stmtSetPreferredMFA.Close()
+
+	_, err = stmtSetPreferredMFA.Exec(userID, extentionType)
+	return err
+}
+
+func (s *sqlStore) GetPreferredMFA(userID uint64) (string, error) {
+	stmtGetPreferredMFA, err := s.statement(`SELECT extentionType FROM dbprefix_auth_mfa_preference WHERE userID = ?;`)
+	if err != nil {
+		return "", err
+	}
+	defer stmtGetPreferredMFA.Close()
+
+	var extentionType string
+	err = stmtGetPreferredMFA.QueryRow(userID).Scan(&extentionType)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return extentionType, err
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	mfaRecoveryTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_mfa_recovery (
+        id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+        userID BIGINT UNSIGNED NOT NULL,
+        status TINYINT UNSIGNED NOT NULL DEFAULT 0,
+        cancelToken VARCHAR(64) NOT NULL,
+        requestedAt DATETIME NOT NULL,
+        eligibleAt DATETIME NOT NULL,
+        decidedAt DATETIME NULL DEFAULT NULL,
+        deciderUserID BIGINT UNSIGNED NOT NULL DEFAULT 0,
+        PRIMARY KEY (id),
+        UNIQUE KEY (cancelToken),
+        INDEX (userID),
+        INDEX (status),
+        CONSTRAINT FOREIGN KEY (userID) REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+
+	mfaRecoveryLogTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_mfa_recovery_log (
+        id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+        recoveryID BIGINT UNSIGNED NOT NULL,
+        actorUserID BIGINT UNSIGNED NOT NULL,
+        action VARCHAR(16) NOT NULL,
+        note VARCHAR(255) NOT NULL,
+        createdAt DATETIME NOT NULL,
+        PRIMARY KEY (id),
+        INDEX (recoveryID),
+        CONSTRAINT FOREIGN KEY (recoveryID) REFERENCES dbprefix_auth_mfa_recovery(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+)
+
+/************ MFA Recovery Database ************/
+
+const mfaRecoveryColumns = `id, userID, status, cancelToken, requestedAt, eligibleAt, decidedAt, deciderUserID`
+
+func scanMFARecoveries(rows *sql.Rows) ([]*MFARecovery, error) {
+	var recoveries []*MFARecovery = []*MFARecovery{}
+	for rows.Next() {
+		var recovery MFARecovery
+		var decidedAt sql.NullTime
+		err := rows.Scan(&recovery.id, &recovery.UserID, &recovery.Status, &recovery.cancelTokenHash, &recovery.RequestedAt, &recovery.EligibleAt, &decidedAt, &recovery.DeciderUserID)
+		if err != nil {
+			return nil, err
+		}
+		if decidedAt.Valid {
+			recovery.DecidedAt = &decidedAt.Time
+		}
+		recoveries = append(recoveries, &recovery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return recoveries, nil
+}
+
+func (s *sqlStore) queryMFARecoveries(query string, args ...interface{}) ([]*MFARecovery, error) {
+	stmtQueryRecoveries, err := s.statement(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtQueryRecoveries.Close()
+
+	rows, err := stmtQueryRecoveries.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMFARecoveries(rows)
+}
+
+func (s *sqlStore) NewMFARecovery(recovery *MFARecovery) error {
+	stmtInsertRecovery, err := s.statement(`INSERT INTO dbprefix_auth_mfa_recovery (userID, status, cancelToken, requestedAt, eligibleAt) VALUES (?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertRecovery.Close()
+
+	result, err := stmtInsertRecovery.Exec(recovery.UserID, recovery.Status, recovery.cancelTokenHash, recovery.RequestedAt, recovery.EligibleAt)
+	if err != nil {
+		return err
+	}
+	recoveryID, err := result.LastInsertId()
+	recovery.id = uint64(recoveryID)
+	return err
+}
+
+func (s *sqlStore) GetMFARecoveryByID(recoveryID uint64) (*MFARecovery, error) {
+	recoveries, err := s.queryMFARecoveries(`SELECT `+mfaRecoveryColumns+` FROM dbprefix_auth_mfa_recovery WHERE id = ?;`, recoveryID)
+	if err != nil {
+		return nil, err
+	}
+	if len(recoveries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return recoveries[0], nil
+}
+
+func (s *sqlStore) GetMFARecoveryByCancelTokenHash(cancelTokenHash string) (*MFARecovery, error) {
+	recoveries, err := s.queryMFARecoveries(`SELECT `+mfaRecoveryColumns+` FROM dbprefix_auth_mfa_recovery WHERE cancelToken = ?;`, cancelTokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(recoveries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return recoveries[0], nil
+}
+
+func (s *sqlStore) ListMFARecoveriesByUserID(userID uint64) ([]*MFARecovery, error) {
+	return s.queryMFARecoveries(`SELECT `+mfaRecoveryColumns+` FROM dbprefix_auth_mfa_recovery WHERE userID = ? ORDER BY id DESC;`, userID)
+}
+
+func (s *sqlStore) ListMFARecoveriesByStatus(status uint8) ([]*MFARecovery, error) {
+	return s.queryMFARecoveries(`SELECT `+mfaRecoveryColumns+` FROM dbprefix_auth_mfa_recovery WHERE status = ? ORDER BY id;`, status)
+}
+
+// DecideMFARecovery moves a pending recovery to status. It fails with
+// ErrMFARecoveryNotPending if the recovery was decided concurrently.
+func (s *sqlStore) DecideMFARecovery(recoveryID uint64, status uint8, deciderUserID uint64, decidedAt time.Time) error {
+	stmtDecideRecovery, err := s.statement(`UPDATE dbprefix_auth_mfa_recovery SET status = ?, deciderUserID = ?, decidedAt = ? WHERE id = ? AND status = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtDecideRecovery.Close()
+
+	result, err := stmtDecideRecovery.Exec(status, deciderUserID, decidedAt, recoveryID, MFA_RECOVERY_PENDING)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrMFARecoveryNotPending
+	}
+	return nil
+}
+
+func (s *sqlStore) NewMFARecoveryLog(entry *MFARecoveryLogEntry) error {
+	stmtInsertLog, err := s.statement(`INSERT INTO dbprefix_auth_mfa_recovery_log (recoveryID, actorUserID, action, note, createdAt) VALUES (?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertLog.Close()
+
+	_, err = stmtInsertLog.Exec(entry.RecoveryID, entry.ActorUserID, entry.Action, entry.Note, entry.CreatedAt)
+	return err
+}
+
+func (s *sqlStore) ListMFARecoveryLog(recoveryID uint64) ([]*MFARecoveryLogEntry, error) {
+	stmtListLog, err := s.statement(`SELECT recoveryID, actorUserID, action, note, createdAt FROM dbprefix_auth_mfa_recovery_log WHERE recoveryID = ? ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListLog.Close()
+
+	rows, err := stmtListLog.Query(recoveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*MFARecoveryLogEntry = []*MFARecoveryLogEntry{}
+	for rows.Next() {
+		var entry MFARecoveryLogEntry
+		err = rows.Scan(&entry.RecoveryID, &entry.ActorUserID, &entry.Action, &entry.Note, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	roleGrantTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_role_grant (
+        id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+        userID BIGINT UNSIGNED NOT NULL,
+        granted INT UNSIGNED NOT NULL DEFAULT 0,
+        revoked INT UNSIGNED NOT NULL DEFAULT 0,
+        granterUserID BIGINT UNSIGNED NOT NULL,
+        reason VARCHAR(255) NOT NULL,
+        createdAt DATETIME NOT NULL,
+        startsAt DATETIME NOT NULL,
+        expiresAt DATETIME NULL DEFAULT NULL,
+        status TINYINT UNSIGNED NOT NULL DEFAULT 0,
+        PRIMARY KEY (id),
+        INDEX (userID),
+        INDEX (status, expiresAt),
+        CONSTRAINT FOREIGN KEY (userID) REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+)
+
+/************ Role Grant Database ************/
+
+func scanRoleGrants(rows *sql.Rows) ([]*RoleGrant, error) {
+	var grants []*RoleGrant = []*RoleGrant{}
+	for rows.Next() {
+		var grant RoleGrant
+		var expiresAt sql.NullTime
+		err := rows.Scan(&grant.id, &grant.UserID, &grant.Granted, &grant.Revoked, &grant.GranterUserID, &grant.Reason, &grant.CreatedAt, &grant.StartsAt, &expiresAt, &grant.Status)
+		if err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			grant.ExpiresAt = &expiresAt.Time
+		}
+		grants = append(grants, &grant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+func (s *sqlStore) NewRoleGrant(grant *RoleGrant) error {
+	stmtInsertRoleGrant, err := s.statement(`INSERT INTO dbprefix_auth_role_grant
+    (userID, granted, revoked, granterUserID, reason, createdAt, startsAt, expiresAt, status)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertRoleGrant.Close()
+
+	var expiresAt sql.NullTime
+	if grant.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *grant.ExpiresAt, Valid: true}
+	}
+	result, err := stmtInsertRoleGrant.Exec(grant.UserID, grant.Granted, grant.Revoked, grant.GranterUserID, grant.Reason, grant.CreatedAt, grant.StartsAt, expiresAt, grant.Status)
+	if err != nil {
+		return err
+	}
+	grantID, err := result.LastInsertId()
+	grant.id = uint64(grantID)
+	return err
+}
+
+func (s *sqlStore) GetRoleGrantByID(grantID uint64) (*RoleGrant, error) {
+	stmtGetRoleGrant, err := s.statement(`SELECT id, userID, granted, revoked, granterUserID, reason, createdAt, startsAt, expiresAt, status
+    FROM dbprefix_auth_role_grant WHERE id = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetRoleGrant.Close()
+
+	rows, err := stmtGetRoleGrant.Query(grantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants, err := scanRoleGrants(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(grants) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return grants[0], nil
+}
+
+// ActiveTemporaryRole combines all temporary grants in effect for the user
+func (s *sqlStore) ActiveTemporaryRole(userID uint64, now time.Time) (Role, error) {
+	stmtActiveGrants, err := s.statement(`SELECT granted FROM dbprefix_auth_role_grant
+    WHERE userID = ? AND status = ? AND expiresAt IS NOT NULL AND startsAt <= ? AND expiresAt > ?;`)
+	if err != nil {
+		return ROLELESS, err
+	}
+	defer stmtActiveGrants.Close()
+
+	rows, err := stmtActiveGrants.Query(userID, ROLE_GRANT_ACTIVE, now, now)
+	if err != nil {
+		return ROLELESS, err
+	}
+	defer rows.Close()
+
+	var role Role
+	for rows.Next() {
+		var granted Role
+		err = rows.Scan(&granted)
+		if err != nil {
+			return ROLELESS, err
+		}
+		role = role.AddRole(granted)
+	}
+	if err = rows.Err(); err != nil {
+		return ROLELESS, err
+	}
+
+	return role, nil
+}
+
+func (s *sqlStore) ListRoleGrantsByUserID(userID uint64) ([]*RoleGrant, error) {
+	stmtListRoleGrants, err := s.statement(`SELECT id, userID, granted, revoked, granterUserID, reason, createdAt, startsAt, expiresAt, status
+    FROM dbprefix_auth_role_grant WHERE userID = ? ORDER BY id DESC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListRoleGrants.Close()
+
+	rows, err := stmtListRoleGrants.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRoleGrants(rows)
+}
+
+// ListRoleGrantsByRole lists grants (or revocations) touching any bit of role
+func (s *sqlStore) ListRoleGrantsByRole(role Role) ([]*RoleGrant, error) {
+	stmtListRoleGrants, err := s.statement(`SELECT id, userID, granted, revoked, granterUserID, reason, createdAt, startsAt, expiresAt, status
+    FROM dbprefix_auth_role_grant WHERE (granted & ?) <> 0 OR (revoked & ?) <> 0 ORDER BY id DESC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListRoleGrants.Close()
+
+	rows, err := stmtListRoleGrants.Query(role, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRoleGrants(rows)
+}
+
+func (s *sqlStore) UpdateRoleGrantStatus(grantID uint64, status uint8) error {
+	stmtUpdateStatus, err := s.statement(`UPDATE dbprefix_auth_role_grant SET status = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdateStatus.Close()
+
+	_, err = stmtUpdateStatus.Exec(status, grantID)
+	return err
+}
+
+// ExpireRoleGrants marks all lapsed temporary grants as expired
+// and returns the affected user IDs
+func (s *sqlStore) ExpireRoleGrants(now time.Time) ([]uint64, error) {
+	stmtListLapsed, err := s.statement(`SELECT DISTINCT userID FROM dbprefix_auth_role_grant
+    WHERE status = ? AND expiresAt IS NOT NULL AND expiresAt <= ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListLapsed.Close()
+
+	rows, err := stmtListLapsed.Query(ROLE_GRANT_ACTIVE, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []uint64
+	for rows.Next() {
+		var userID uint64
+		err = rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stmtExpire, err := s.statement(`UPDATE dbprefix_auth_role_grant SET status = ?
+    WHERE status = ? AND expiresAt IS NOT NULL AND expiresAt <= ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtExpire.Close()
+
+	_, err = stmtExpire.Exec(ROLE_GRANT_EXPIRED, ROLE_GRANT_ACTIVE, now)
+	return userIDs, err
+}
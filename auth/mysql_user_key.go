@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	userKeyTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_user_key (
+        id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+        userID BIGINT UNSIGNED NOT NULL,
+        publicKey VARCHAR(64) NOT NULL,
+        label VARCHAR(64) NOT NULL,
+        createdAt DATETIME NOT NULL,
+        lastUsedAt DATETIME NULL DEFAULT NULL,
+        revokedAt DATETIME NULL DEFAULT NULL,
+        PRIMARY KEY (id),
+        UNIQUE KEY (userID, publicKey),
+        CONSTRAINT FOREIGN KEY (userID) REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+)
+
+/************ User Key Database ************/
+
+func scanUserKeys(rows *sql.Rows) ([]*UserKey, error) {
+	var keys []*UserKey = []*UserKey{}
+	for rows.Next() {
+		var key UserKey
+		var lastUsedAt, revokedAt sql.NullTime
+		err := rows.Scan(&key.id, &key.UserID, &key.PublicKey, &key.Label, &key.CreatedAt, &lastUsedAt, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+		if lastUsedAt.Valid {
+			key.LastUsedAt = &lastUsedAt.Time
+		}
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+		keys = append(keys, &key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s *sqlStore) NewUserKey(key *UserKey) error {
+	stmtInsertUserKey, err := s.statement(`INSERT INTO dbprefix_auth_user_key (userID, publicKey, label, createdAt) VALUES (?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertUserKey.Close()
+
+	result, err := stmtInsertUserKey.Exec(key.UserID, key.PublicKey, key.Label, key.CreatedAt)
+	if err != nil {
+		return err
+	}
+	keyID, err := result.LastInsertId()
+	key.id = uint64(keyID)
+	return err
+}
+
+// ListUserKeys lists keys of the user, oldest first. Revoked keys are included if all is true.
+func (s *sqlStore) ListUserKeys(userID uint64, all bool) ([]*UserKey, error) {
+	stmtListUserKeys, err := s.statement(`SELECT id, userID, publicKey, label, createdAt, lastUsedAt, revokedAt
+    FROM dbprefix_auth_user_key WHERE userID = ? AND (? OR revokedAt IS NULL) ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListUserKeys.Close()
+
+	rows, err := stmtListUserKeys.Query(userID, all)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanUserKeys(rows)
+}
+
+func (s *sqlStore) UserKeyExists(userID uint64, publicKey string) (bool, error) {
+	stmtUserKeyExists, err := s.statement(`SELECT COUNT(*) FROM dbprefix_auth_user_key WHERE userID = ? AND publicKey = ?;`)
+	if err != nil {
+		return false, err
+	}
+	defer stmtUserKeyExists.Close()
+
+	var count int
+	err = stmtUserKeyExists.QueryRow(userID, publicKey).Scan(&count)
+	return count > 0, err
+}
+
+func (s *sqlStore) TouchUserKey(keyID uint64, lastUsedAt time.Time) error {
+	stmtTouchUserKey, err := s.statement(`UPDATE dbprefix_auth_user_key SET lastUsedAt = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtTouchUserKey.Close()
+
+	_, err = stmtTouchUserKey.Exec(lastUsedAt, keyID)
+	return err
+}
+
+func (s *sqlStore) UpdateUserKeyLabel(keyID uint64, label string) error {
+	stmtUpdateUserKeyLabel, err := s.statement(`UPDATE dbprefix_auth_user_key SET label = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdateUserKeyLabel.Close()
+
+	_, err = stmtUpdateUserKeyLabel.Exec(label, keyID)
+	return err
+}
+
+// RevokeUserKey revokes the key and replaces the primary key of the user
+// (dbprefix_auth_user.publickey) with primaryKey atomically
+func (s *sqlStore) RevokeUserKey(userID, keyID uint64, revokedAt time.Time, primaryKey string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmtRevokeUserKey, err := s.txStatement(tx, `UPDATE dbprefix_auth_user_key SET revokedAt = ? WHERE id = ? AND userID = ? AND revokedAt IS NULL;`)
+	if err != nil {
+		return err
+	}
+	defer stmtRevokeUserKey.Close()
+
+	result, err := stmtRevokeUserKey.Exec(revokedAt, keyID, userID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrUserKeyNotFound
+	}
+
+	stmtUpdatePrimaryKey, err := s.txStatement(tx, `UPDATE dbprefix_auth_user SET publickey = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdatePrimaryKey.Close()
+
+	_, err = stmtUpdatePrimaryKey.Exec(primaryKey, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
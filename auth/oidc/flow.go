@@ -0,0 +1,185 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// A flow is saved in the ephemeral store of auth under its state from Begin
+// until Complete, which takes it: each flow completes at most once.
+
+const (
+	flowNamespace = "oidc"
+)
+
+type flow struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	LinkUserID   uint64 `json:"link_user_id"`
+}
+
+func flowKey(state string) string {
+	return fmt.Sprintf("%s/%s", flowNamespace, state)
+}
+
+// randomToken returns n random bytes in base64url, as used for state,
+// nonce and the PKCE code verifier.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Begin starts a sign-in. The caller redirects the user to authURL, and
+// passes the state and code the provider redirects back with to Complete.
+func (p *Provider) Begin() (authURL, state string, err error) {
+	return p.begin(0)
+}
+
+// BeginLink starts linking an identity of the provider to the signed-in user.
+func (p *Provider) BeginLink(userID uint64) (authURL, state string, err error) {
+	return p.begin(userID)
+}
+
+func (p *Provider) begin(linkUserID uint64) (authURL, state string, err error) {
+	state, err = randomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	codeVerifier, err := randomToken(32) // 43 characters, the minimum of RFC 7636
+	if err != nil {
+		return "", "", err
+	}
+
+	flowJson, err := json.Marshal(flow{
+		Provider:     p.config.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		LinkUserID:   linkUserID,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	err = auth.Ephemeral().Add(flowKey(state), string(flowJson), DefaultFlowLifetime)
+	if err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.metadata.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// Complete exchanges the code for tokens and returns the identity asserted by
+// the validated ID token. A state is accepted only once, and only by the
+// provider which began the flow.
+func (p *Provider) Complete(state, code string) (*Identity, error) {
+	flowJson, err := auth.Ephemeral().Take(flowKey(state))
+	if err == auth.ErrEphemeralNotFound {
+		return nil, ErrFlowNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var f flow
+	if err = json.Unmarshal([]byte(flowJson), &f); err != nil {
+		return nil, err
+	}
+	if f.Provider != p.config.Name {
+		return nil, ErrFlowNotFound
+	}
+
+	rawIDToken, err := p.exchange(code, f.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := p.verifyIDToken(rawIDToken, f.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	identity.LinkUserID = f.LinkUserID
+	return identity, nil
+}
+
+// exchange redeems the code at the token endpoint and returns the ID token.
+func (p *Provider) exchange(code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	basicAuth := false
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	} else if methods := p.metadata.TokenEndpointAuthMethodsSupported; len(methods) == 0 || contains(methods, "client_secret_basic") {
+		basicAuth = true // the default of the spec
+	} else {
+		form.Set("client_id", p.config.ClientID)
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basicAuth {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&tokenResponse)
+	if resp.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		if tokenResponse.Error != "" {
+			return "", fmt.Errorf("%w: %s %s", ErrTokenExchange, tokenResponse.Error, tokenResponse.ErrorDescription)
+		}
+		return "", fmt.Errorf("%w: %s", ErrTokenExchange, resp.Status)
+	}
+	if err != nil {
+		return "", err
+	}
+	if tokenResponse.IDToken == "" {
+		return "", ErrNoIDToken
+	}
+	return tokenResponse.IDToken, nil
+}
@@ -0,0 +1,232 @@
+package oidc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrIDTokenMalformed = errors.New("oidc: ID token is malformed")
+	ErrIDTokenAlg       = errors.New("oidc: ID token is signed with an unaccepted algorithm")
+	ErrIDTokenSignature = errors.New("oidc: ID token signature is invalid")
+	ErrIDTokenClaims    = errors.New("oidc: ID token is not for this client, or expired")
+	ErrIDTokenNonce     = errors.New("oidc: ID token nonce mismatch")
+)
+
+// Signing algorithms accepted for ID tokens. Symmetric algorithms (HS256 etc.)
+// and "none" are never accepted.
+var signingAlgs = map[string]struct {
+	hash crypto.Hash
+	pss  bool
+}{
+	"RS256": {crypto.SHA256, false},
+	"RS384": {crypto.SHA384, false},
+	"RS512": {crypto.SHA512, false},
+	"PS256": {crypto.SHA256, true},
+	"PS384": {crypto.SHA384, true},
+	"PS512": {crypto.SHA512, true},
+	"ES256": {crypto.SHA256, false},
+	"ES384": {crypto.SHA384, false},
+	"ES512": {crypto.SHA512, false},
+	"EdDSA": {0, false},
+}
+
+// Identity is an identity asserted by a validated ID token.
+type Identity struct {
+	Provider      string                 `json:"provider"`
+	Subject       string                 `json:"subject"`
+	Email         string                 `json:"email"`
+	EmailVerified bool                   `json:"email_verified"`
+	Name          string                 `json:"name"`
+	Claims        map[string]interface{} `json:"claims"` // all claims of the ID token
+
+	// LinkUserID is the user who started the flow with BeginLink.
+	// 0 if started with Begin.
+	LinkUserID uint64 `json:"link_user_id"`
+}
+
+type idTokenClaims struct {
+	Issuer        string      `json:"iss"`
+	Subject       string      `json:"sub"`
+	Audience      audience    `json:"aud"`
+	AuthorizedBy  string      `json:"azp"`
+	Expiry        json.Number `json:"exp"`
+	IssuedAt      json.Number `json:"iat"`
+	Nonce         string      `json:"nonce"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"` // some providers send "true"
+	Name          string      `json:"name"`
+}
+
+// audience is a string or an array of strings
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*aud = multiple
+	return nil
+}
+
+// verifyIDToken checks the signature against the JWKS of the provider, and
+// the claims against the config and the nonce of the flow.
+func (p *Provider) verifyIDToken(rawIDToken, nonce string) (*Identity, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrIDTokenMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrIDTokenMalformed
+	}
+	if !p.acceptsAlg(header.Alg) {
+		return nil, ErrIDTokenAlg
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrIDTokenMalformed
+	}
+
+	keys, err := p.keys.candidates(header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if verifySignature(header.Alg, key.key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrIDTokenSignature
+	}
+
+	var claims idTokenClaims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrIDTokenMalformed
+	}
+	if err = p.checkClaims(&claims, time.Now()); err != nil {
+		return nil, err
+	}
+	if claims.Nonce != nonce {
+		return nil, ErrIDTokenNonce
+	}
+
+	allClaims := map[string]interface{}{}
+	if err = decodeSegment(parts[1], &allClaims); err != nil {
+		return nil, ErrIDTokenMalformed
+	}
+	return &Identity{
+		Provider:      p.config.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified == true || claims.EmailVerified == "true",
+		Name:          claims.Name,
+		Claims:        allClaims,
+	}, nil
+}
+
+// acceptsAlg accepts the algorithms advertised by the provider, or RS256 if
+// none is advertised, as long as this package supports them.
+func (p *Provider) acceptsAlg(alg string) bool {
+	if _, ok := signingAlgs[alg]; !ok {
+		return false
+	}
+	if len(p.metadata.IDTokenSigningAlgValuesSupported) == 0 {
+		return alg == "RS256"
+	}
+	return contains(p.metadata.IDTokenSigningAlgValuesSupported, alg)
+}
+
+func (p *Provider) checkClaims(claims *idTokenClaims, now time.Time) error {
+	if claims.Issuer != p.config.Issuer || claims.Subject == "" {
+		return ErrIDTokenClaims
+	}
+	if !contains(claims.Audience, p.config.ClientID) {
+		return ErrIDTokenClaims
+	}
+	if (len(claims.Audience) > 1 || claims.AuthorizedBy != "") && claims.AuthorizedBy != p.config.ClientID {
+		return ErrIDTokenClaims
+	}
+
+	exp, err := claims.Expiry.Float64()
+	if err != nil || now.Add(-ClockSkew).After(time.Unix(int64(exp), 0)) {
+		return ErrIDTokenClaims
+	}
+	iat, err := claims.IssuedAt.Float64()
+	if err != nil || now.Add(ClockSkew).Before(time.Unix(int64(iat), 0)) {
+		return ErrIDTokenClaims
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) bool {
+	params := signingAlgs[alg]
+	if alg == "EdDSA" {
+		edKey, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(edKey, signed, signature)
+	}
+
+	hasher := params.hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if params.pss {
+			return rsa.VerifyPSS(key, params.hash, digest, signature, nil) == nil
+		}
+		if strings.HasPrefix(alg, "RS") {
+			return rsa.VerifyPKCS1v15(key, params.hash, digest, signature) == nil
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return false
+		}
+		// r || s, each padded to the size of the curve
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// JWKSMinRefreshInterval limits how often the JWKS is fetched again for
+	// an unknown kid, so that forged tokens can't make us hammer the provider.
+	JWKSMinRefreshInterval = time.Minute
+
+	ErrKeyNotFound = errors.New("oidc: no key of the provider verifies the token")
+)
+
+// jsonWebKey is a public key in the JWK format. Private members are ignored.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type publicKey struct {
+	kid string
+	alg string // empty if not restricted
+	key crypto.PublicKey
+}
+
+// keySet caches the JWKS of a provider. It is fetched on first use, and again
+// when a token is signed by an unknown kid, i.e. after a key rotation.
+type keySet struct {
+	client    *http.Client
+	uri       string
+	mutex     sync.Mutex
+	keys      []publicKey
+	fetchedAt time.Time
+}
+
+func newKeySet(client *http.Client, uri string) *keySet {
+	return &keySet{
+		client: client,
+		uri:    uri,
+	}
+}
+
+// candidates returns the keys which may have signed a token of the kid and alg.
+func (ks *keySet) candidates(kid, alg string) ([]publicKey, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	keys := matchKeys(ks.keys, kid, alg)
+	if len(keys) > 0 || time.Since(ks.fetchedAt) < JWKSMinRefreshInterval {
+		return keys, nil
+	}
+	if err := ks.refresh(); err != nil {
+		return nil, err
+	}
+	return matchKeys(ks.keys, kid, alg), nil
+}
+
+func (ks *keySet) refresh() error {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ks.client, ks.uri, &jwks); err != nil {
+		return err
+	}
+
+	keys := []publicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil { // unsupported keys are skipped, not fatal
+			continue
+		}
+		keys = append(keys, publicKey{kid: jwk.Kid, alg: jwk.Alg, key: key})
+	}
+	ks.keys = keys
+	ks.fetchedAt = time.Now()
+	return nil
+}
+
+func matchKeys(keys []publicKey, kid, alg string) []publicKey {
+	matched := []publicKey{}
+	for _, key := range keys {
+		if kid != "" && key.kid != kid {
+			continue
+		}
+		if key.alg != "" && key.alg != alg {
+			continue
+		}
+		matched = append(matched, key)
+	}
+	return matched
+}
+
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("oidc: RSA exponent is too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("oidc: unsupported curve")
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("oidc: EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.New("oidc: unsupported curve")
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("oidc: bad Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("oidc: unsupported key type")
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("oidc: empty key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
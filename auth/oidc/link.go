@@ -0,0 +1,83 @@
+package oidc
+
+import (
+	"database/sql"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// SignIn signs in the user linked to the identity from Complete. If no user is
+// linked and the provider has LinkByEmail, the identity is linked to the user
+// of the same email first, as long as both sides verified it. As after
+// (*auth.User).VerifyExternalLogin(), whose result is returned, MFA is up to
+// the caller.
+func SignIn(identity *Identity, ip string) (*auth.LoginResult, error) {
+	if identity.LinkUserID != 0 {
+		return nil, ErrFlowMismatch
+	}
+
+	user, err := auth.GetUserByExternalIdentity(identity.Provider, identity.Subject)
+	if err == auth.ErrExternalIdentityNotFound {
+		user, err = linkByEmail(identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user.VerifyExternalLogin(identity.Provider, identity.Subject, ip)
+}
+
+func linkByEmail(identity *Identity) (*auth.User, error) {
+	p, err := GetProvider(identity.Provider)
+	if err != nil {
+		return nil, err
+	}
+	if !p.config.LinkByEmail || identity.Email == "" {
+		return nil, ErrNotLinked
+	}
+	if !identity.EmailVerified {
+		return nil, ErrEmailUnverified
+	}
+
+	user, err := auth.GetUserByEmail(identity.Email)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotLinked
+	} else if err != nil {
+		return nil, err
+	}
+	verified, err := user.EmailVerified()
+	if err != nil {
+		return nil, err
+	}
+	if !verified {
+		return nil, ErrEmailUnverified
+	}
+
+	// The provider may not speak for accounts guarded by more than an email
+	role, err := user.EffectiveRole()
+	if err != nil {
+		return nil, err
+	}
+	enabledMFA, err := auth.EnabledMFA(user.ID())
+	if err != nil {
+		return nil, err
+	}
+	if role&auth.GLOBAL_ROLES != 0 || len(enabledMFA) > 0 {
+		return nil, ErrLinkRefused
+	}
+
+	_, err = user.LinkExternalIdentity(identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Link links the identity from Complete to the user, who must be the one who
+// started the flow with BeginLink.
+func Link(user *auth.User, identity *Identity) (*auth.ExternalIdentity, error) {
+	if identity.LinkUserID == 0 || identity.LinkUserID != user.ID() {
+		return nil, ErrFlowMismatch
+	}
+	return user.LinkExternalIdentity(identity.Provider, identity.Subject, identity.Email)
+}
@@ -0,0 +1,179 @@
+// Package oidc lets users sign in with an OpenID Connect provider, e.g. Google
+// or a company's own identity provider, instead of an Ed25519 key.
+//
+// Ulysses acts as the relying party: a Provider is configured per issuer with
+// discovery, and runs the authorization code flow with PKCE. The ID token is
+// validated against the JWKS of the provider. The identity is then linked to
+// an auth.User, either explicitly by a signed-in user (BeginLink), or by a
+// verified email if the provider is configured to (Config.LinkByEmail).
+//
+// Nothing here is bound to a specific provider: a local mock server serving
+// discovery, JWKS and the token endpoint works like any other issuer.
+package oidc
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrProviderNotFound = errors.New("oidc: provider not found")
+	ErrProviderExists   = errors.New("oidc: provider of the name is registered already")
+	ErrConfigIncomplete = errors.New("oidc: config requires Name, Issuer, ClientID and RedirectURL")
+	ErrDiscovery        = errors.New("oidc: provider metadata is invalid")
+	ErrIssuerMismatch   = errors.New("oidc: provider metadata is for another issuer")
+	ErrFlowNotFound     = errors.New("oidc: flow does not exist, expired or was completed already")
+	ErrTokenExchange    = errors.New("oidc: token endpoint rejected the code")
+	ErrNoIDToken        = errors.New("oidc: token response has no id_token")
+	ErrNotLinked        = errors.New("oidc: identity is not linked to any user")
+	ErrFlowMismatch     = errors.New("oidc: identity was obtained for another purpose or user")
+	ErrEmailUnverified  = errors.New("oidc: email is not verified on both sides, may not link by email")
+	ErrLinkRefused      = errors.New("oidc: user has MFA or a global role, may not link by email")
+)
+
+var (
+	// DefaultFlowLifetime is how long a user may take at the provider
+	// between Begin and Complete.
+	DefaultFlowLifetime = 10 * time.Minute
+	// ClockSkew is tolerated when checking exp and iat of ID tokens.
+	ClockSkew = time.Minute
+
+	DefaultScopes = []string{"openid", "email", "profile"}
+)
+
+// Config of a Provider. Name identifies the provider in this system, e.g. in
+// auth.ExternalIdentity, and must not change once identities are linked.
+type Config struct {
+	Name         string
+	DisplayName  string // shown on the sign-in button. Name if empty.
+	Issuer       string // exactly as in the iss claim, e.g. "https://accounts.google.com"
+	ClientID     string
+	ClientSecret string // empty for public clients
+	RedirectURL  string
+	Scopes       []string // DefaultScopes if empty. "openid" is always included.
+
+	// LinkByEmail links an unknown identity to the user of the same email on
+	// sign-in, if both the provider and this system verified the email.
+	// Enable it only if the provider is trusted to verify emails. Users with
+	// MFA or any GLOBAL_* role are never linked by email: they link their
+	// identities themselves with BeginLink.
+	LinkByEmail bool
+
+	HTTPClient *http.Client // http.Client with a 10s timeout if nil
+}
+
+// Provider is an OpenID Connect provider discovered from its Config.
+type Provider struct {
+	config   Config
+	metadata *Metadata
+	client   *http.Client
+	keys     *keySet
+}
+
+// NewProvider discovers the provider and returns it. Register it with
+// RegisterProvider to look it up by name later.
+func NewProvider(conf Config) (*Provider, error) {
+	if conf.Name == "" || conf.Issuer == "" || conf.ClientID == "" || conf.RedirectURL == "" {
+		return nil, ErrConfigIncomplete
+	}
+	if conf.DisplayName == "" {
+		conf.DisplayName = conf.Name
+	}
+	conf.Scopes = withOpenIDScope(conf.Scopes)
+
+	client := conf.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	metadata, err := discover(client, conf.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		config:   conf,
+		metadata: metadata,
+		client:   client,
+		keys:     newKeySet(client, metadata.JWKSURI),
+	}, nil
+}
+
+func withOpenIDScope(scopes []string) []string {
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	for _, scope := range scopes {
+		if scope == "openid" {
+			return scopes
+		}
+	}
+	return append([]string{"openid"}, scopes...)
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+func (p *Provider) DisplayName() string {
+	return p.config.DisplayName
+}
+
+func (p *Provider) Metadata() Metadata {
+	return *p.metadata
+}
+
+/************ Provider Registry ************/
+
+var (
+	providerRegistry      map[string]*Provider = map[string]*Provider{}
+	providerRegistryMutex sync.RWMutex
+)
+
+func RegisterProvider(p *Provider) error {
+	providerRegistryMutex.Lock()
+	defer providerRegistryMutex.Unlock()
+
+	if _, ok := providerRegistry[p.config.Name]; ok {
+		return ErrProviderExists
+	}
+	providerRegistry[p.config.Name] = p
+	return nil
+}
+
+// UnregisterProvider stops new flows with the provider. Linked identities
+// are kept, and work again once the provider is registered again.
+func UnregisterProvider(name string) {
+	providerRegistryMutex.Lock()
+	defer providerRegistryMutex.Unlock()
+
+	delete(providerRegistry, name)
+}
+
+func GetProvider(name string) (*Provider, error) {
+	providerRegistryMutex.RLock()
+	defer providerRegistryMutex.RUnlock()
+
+	if p, ok := providerRegistry[name]; ok {
+		return p, nil
+	}
+	return nil, ErrProviderNotFound
+}
+
+// ListProviders returns all registered providers sorted by name,
+// e.g. to render sign-in buttons.
+func ListProviders() []*Provider {
+	providerRegistryMutex.RLock()
+	defer providerRegistryMutex.RUnlock()
+
+	providers := make([]*Provider, 0, len(providerRegistry))
+	for _, p := range providerRegistry {
+		providers = append(providers, p)
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return strings.Compare(providers[i].config.Name, providers[j].config.Name) < 0
+	})
+	return providers
+}
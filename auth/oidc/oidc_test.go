@@ -0,0 +1,361 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+const (
+	testClientID    = "ulysses-test"
+	testRedirectURL = "https://ulysses.example/oidc/callback"
+)
+
+// mockProvider is a local OpenID provider serving discovery, JWKS and the
+// token endpoint. The token endpoint returns idToken for any code.
+type mockProvider struct {
+	server *httptest.Server
+
+	mutex      sync.Mutex
+	key        *rsa.PrivateKey
+	kid        string
+	idToken    string
+	jwksServed int
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	m := &mockProvider{kid: "key-1"}
+	m.key = newRSAKey(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, Metadata{
+			Issuer:                           m.server.URL,
+			AuthorizationEndpoint:            m.server.URL + "/authorize",
+			TokenEndpoint:                    m.server.URL + "/token",
+			JWKSURI:                          m.server.URL + "/jwks",
+			IDTokenSigningAlgValuesSupported: []string{"RS256"},
+			CodeChallengeMethodsSupported:    []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.jwksServed++
+		writeJSON(w, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": m.kid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_request"})
+			return
+		}
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		writeJSON(w, map[string]string{"id_token": m.idToken, "token_type": "Bearer"})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// rotate replaces the signing key, as a provider does from time to time.
+func (m *mockProvider) rotate(t *testing.T, kid string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.key = newRSAKey(t)
+	m.kid = kid
+}
+
+func (m *mockProvider) jwksFetches() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.jwksServed
+}
+
+func (m *mockProvider) setIDToken(idToken string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.idToken = idToken
+}
+
+// claims returns valid claims for the nonce, to be altered by each test.
+func (m *mockProvider) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            m.server.URL,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "user@example.com",
+		"email_verified": true,
+	}
+}
+
+// sign returns an RS256 token signed with the current key of the provider.
+func (m *mockProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return signRS256(t, m.key, m.kid, claims)
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := encodeSegment(t, map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func setupProvider(t *testing.T, m *mockProvider) *Provider {
+	if err := auth.Setup(auth.NewMemoryStore()); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProvider(Config{
+		Name:        "mock",
+		Issuer:      m.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: testRedirectURL,
+		HTTPClient:  m.server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// begin starts a flow and returns its state and the nonce sent to the provider.
+func begin(t *testing.T, p *Provider) (state, nonce string) {
+	authURL, state, err := p.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("state") != state || query.Get("code_challenge_method") != "S256" || query.Get("client_id") != testClientID {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+	return state, query.Get("nonce")
+}
+
+func TestComplete(t *testing.T) {
+	m := newMockProvider(t)
+	p := setupProvider(t, m)
+
+	state, nonce := begin(t, p)
+	m.setIDToken(m.sign(t, m.claims(nonce)))
+	identity, err := p.Complete(state, "code")
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if identity.Provider != "mock" || identity.Subject != "user-1" || identity.Email != "user@example.com" || !identity.EmailVerified {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	// each state completes once
+	if _, err = p.Complete(state, "code"); err != ErrFlowNotFound {
+		t.Errorf("second Complete: got %v, want %v", err, ErrFlowNotFound)
+	}
+}
+
+func TestCompleteRejectsClaims(t *testing.T) {
+	m := newMockProvider(t)
+	p := setupProvider(t, m)
+
+	tests := []struct {
+		name   string
+		modify func(claims map[string]interface{})
+		want   error
+	}{
+		{"issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example" }, ErrIDTokenClaims},
+		{"missing subject", func(c map[string]interface{}) { delete(c, "sub") }, ErrIDTokenClaims},
+		{"audience", func(c map[string]interface{}) { c["aud"] = "another-client" }, ErrIDTokenClaims},
+		{"audience list without azp", func(c map[string]interface{}) { c["aud"] = []string{testClientID, "another-client"} }, ErrIDTokenClaims},
+		{"azp of another client", func(c map[string]interface{}) {
+			c["aud"] = []string{testClientID, "another-client"}
+			c["azp"] = "another-client"
+		}, ErrIDTokenClaims},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-ClockSkew - time.Minute).Unix() }, ErrIDTokenClaims},
+		{"missing exp", func(c map[string]interface{}) { delete(c, "exp") }, ErrIDTokenClaims},
+		{"issued in the future", func(c map[string]interface{}) { c["iat"] = time.Now().Add(ClockSkew + time.Minute).Unix() }, ErrIDTokenClaims},
+		{"nonce", func(c map[string]interface{}) { c["nonce"] = "replayed" }, ErrIDTokenNonce},
+		{"missing nonce", func(c map[string]interface{}) { delete(c, "nonce") }, ErrIDTokenNonce},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, nonce := begin(t, p)
+			claims := m.claims(nonce)
+			test.modify(claims)
+			m.setIDToken(m.sign(t, claims))
+			if _, err := p.Complete(state, "code"); err != test.want {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	t.Run("azp of this client", func(t *testing.T) {
+		state, nonce := begin(t, p)
+		claims := m.claims(nonce)
+		claims["aud"] = []string{testClientID, "another-client"}
+		claims["azp"] = testClientID
+		m.setIDToken(m.sign(t, claims))
+		if _, err := p.Complete(state, "code"); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+	})
+}
+
+func TestCompleteRejectsAlgConfusion(t *testing.T) {
+	m := newMockProvider(t)
+	p := setupProvider(t, m)
+
+	header := func(alg string) string {
+		return encodeSegment(t, map[string]string{"alg": alg, "kid": m.kid, "typ": "JWT"})
+	}
+
+	t.Run("none", func(t *testing.T) {
+		state, nonce := begin(t, p)
+		m.setIDToken(header("none") + "." + encodeSegment(t, m.claims(nonce)) + ".")
+		if _, err := p.Complete(state, "code"); err != ErrIDTokenAlg {
+			t.Errorf("got %v, want %v", err, ErrIDTokenAlg)
+		}
+	})
+
+	t.Run("HS256 keyed with the public key", func(t *testing.T) {
+		state, nonce := begin(t, p)
+		signed := header("HS256") + "." + encodeSegment(t, m.claims(nonce))
+		mac := hmac.New(sha256.New, m.key.N.Bytes())
+		mac.Write([]byte(signed))
+		m.setIDToken(signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+		if _, err := p.Complete(state, "code"); err != ErrIDTokenAlg {
+			t.Errorf("got %v, want %v", err, ErrIDTokenAlg)
+		}
+	})
+
+	t.Run("algorithm not advertised", func(t *testing.T) {
+		state, nonce := begin(t, p)
+		m.setIDToken(header("PS256") + "." + encodeSegment(t, m.claims(nonce)) + ".c2lnbmF0dXJl")
+		if _, err := p.Complete(state, "code"); err != ErrIDTokenAlg {
+			t.Errorf("got %v, want %v", err, ErrIDTokenAlg)
+		}
+	})
+
+	t.Run("signed by another key", func(t *testing.T) {
+		state, nonce := begin(t, p)
+		m.setIDToken(signRS256(t, newRSAKey(t), m.kid, m.claims(nonce)))
+		if _, err := p.Complete(state, "code"); err != ErrIDTokenSignature {
+			t.Errorf("got %v, want %v", err, ErrIDTokenSignature)
+		}
+	})
+
+	t.Run("tampered claims", func(t *testing.T) {
+		state, nonce := begin(t, p)
+		token := m.sign(t, m.claims(nonce))
+		claims := m.claims(nonce)
+		claims["sub"] = "admin"
+		parts := strings.Split(token, ".")
+		m.setIDToken(parts[0] + "." + encodeSegment(t, claims) + "." + parts[2])
+		if _, err := p.Complete(state, "code"); err != ErrIDTokenSignature {
+			t.Errorf("got %v, want %v", err, ErrIDTokenSignature)
+		}
+	})
+}
+
+func TestUnknownKidRefreshesJWKS(t *testing.T) {
+	defaultInterval := JWKSMinRefreshInterval
+	defer func() { JWKSMinRefreshInterval = defaultInterval }()
+	JWKSMinRefreshInterval = time.Hour
+
+	m := newMockProvider(t)
+	p := setupProvider(t, m)
+
+	state, nonce := begin(t, p)
+	m.setIDToken(m.sign(t, m.claims(nonce)))
+	if _, err := p.Complete(state, "code"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if fetches := m.jwksFetches(); fetches != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", fetches)
+	}
+
+	// Within JWKSMinRefreshInterval, an unknown kid does not refetch the JWKS
+	m.rotate(t, "key-2")
+	state, nonce = begin(t, p)
+	m.setIDToken(m.sign(t, m.claims(nonce)))
+	if _, err := p.Complete(state, "code"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v, want %v", err, ErrKeyNotFound)
+	}
+	if fetches := m.jwksFetches(); fetches != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", fetches)
+	}
+
+	// After it, the rotated key is fetched
+	JWKSMinRefreshInterval = 0
+	state, nonce = begin(t, p)
+	m.setIDToken(m.sign(t, m.claims(nonce)))
+	if _, err := p.Complete(state, "code"); err != nil {
+		t.Errorf("Complete after rotation: %v", err)
+	}
+	if fetches := m.jwksFetches(); fetches != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", fetches)
+	}
+
+	// The old key is gone with the rotation
+	state, nonce = begin(t, p)
+	m.setIDToken(signRS256(t, newRSAKey(t), "key-1", m.claims(nonce)))
+	if _, err := p.Complete(state, "code"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v, want %v", err, ErrKeyNotFound)
+	}
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Permissions are named capabilities declared by packages at init, e.g.
+//
+// 	func init() {
+// 		auth.RegPermission("support.ticket.reply", "Reply to support tickets")
+// 		auth.RegBuiltinRolePermissions(auth.GLOBAL_ADMIN, "support.ticket.reply")
+// 	}
+//
+// A user holds a permission if any of their built-in roles (the Role bits of
+// their effective role) or any of their custom roles includes it.
+
+type Permission string
+
+type PermissionInfo struct {
+	Name        Permission `json:"name"`
+	Description string     `json:"description"`
+}
+
+var (
+	permissionMutex          sync.RWMutex                 = sync.RWMutex{}
+	permissionRegistry       map[Permission]string        = map[Permission]string{}
+	builtinRolePermissionMap map[Role]map[Permission]bool = map[Role]map[Permission]bool{}
+
+	ErrPermissionNameEmpty    = errors.New("auth: permission name is empty")
+	ErrPermissionRepeated     = errors.New("auth: permission is registered already")
+	ErrPermissionUnknown      = errors.New("auth: permission is not registered")
+	ErrBuiltinRoleNotSingular = errors.New("auth: built-in role must be a single role")
+)
+
+// RegPermission declares a permission. It should be called at init.
+func RegPermission(name Permission, description string) error {
+	if name == "" {
+		return ErrPermissionNameEmpty
+	}
+
+	permissionMutex.Lock()
+	defer permissionMutex.Unlock()
+
+	if _, ok := permissionRegistry[name]; ok {
+		return ErrPermissionRepeated
+	}
+	permissionRegistry[name] = description
+	return nil
+}
+
+// RegBuiltinRolePermissions grants registered permissions to everyone
+// holding the built-in role.
+func RegBuiltinRolePermissions(role Role, permissions ...Permission) error {
+	if role == ROLELESS || role&(role-1) != 0 {
+		return ErrBuiltinRoleNotSingular
+	}
+
+	permissionMutex.Lock()
+	defer permissionMutex.Unlock()
+
+	for _, permission := range permissions {
+		if _, ok := permissionRegistry[permission]; !ok {
+			return ErrPermissionUnknown
+		}
+	}
+
+	if builtinRolePermissionMap[role] == nil {
+		builtinRolePermissionMap[role] = map[Permission]bool{}
+	}
+	for _, permission := range permissions {
+		builtinRolePermissionMap[role][permission] = true
+	}
+	return nil
+}
+
+// Permissions lists all registered permissions, sorted by name.
+func Permissions() []PermissionInfo {
+	permissionMutex.RLock()
+	defer permissionMutex.RUnlock()
+
+	var infos []PermissionInfo = []PermissionInfo{}
+	for name, description := range permissionRegistry {
+		infos = append(infos, PermissionInfo{
+			Name:        name,
+			Description: description,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+func checkPermissionsRegistered(permissions []Permission) error {
+	permissionMutex.RLock()
+	defer permissionMutex.RUnlock()
+
+	for _, permission := range permissions {
+		if _, ok := permissionRegistry[permission]; !ok {
+			return ErrPermissionUnknown
+		}
+	}
+	return nil
+}
+
+// builtinPermissions collects the permissions of every built-in role included in role
+func builtinPermissions(role Role, permissions map[Permission]bool) {
+	permissionMutex.RLock()
+	defer permissionMutex.RUnlock()
+
+	for builtinRole, builtinPermissions := range builtinRolePermissionMap {
+		if !role.Includes(builtinRole) {
+			continue
+		}
+		for permission := range builtinPermissions {
+			permissions[permission] = true
+		}
+	}
+}
+
+// Permissions lists all permissions held by the user through built-in
+// roles (including temporary grants) and custom roles, sorted by name.
+func (user *User) Permissions() ([]Permission, error) {
+	permissionSet, err := user.permissionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []Permission = []Permission{}
+	for permission := range permissionSet {
+		permissions = append(permissions, permission)
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i] < permissions[j]
+	})
+	return permissions, nil
+}
+
+// HasPermission checks if the user holds all the permissions.
+func (user *User) HasPermission(permissions ...Permission) (bool, error) {
+	permissionSet, err := user.permissionSet()
+	if err != nil {
+		return false, err
+	}
+	for _, permission := range permissions {
+		if !permissionSet[permission] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (user *User) permissionSet() (map[Permission]bool, error) {
+	role, err := user.EffectiveRole()
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions map[Permission]bool = map[Permission]bool{}
+	builtinPermissions(role, permissions)
+
+	customRoles, err := user.CustomRoles()
+	if err != nil {
+		return nil, err
+	}
+	for _, customRole := range customRoles {
+		for _, permission := range customRole.Permissions {
+			permissions[permission] = true
+		}
+	}
+	return permissions, nil
+}
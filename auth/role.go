@@ -38,6 +38,13 @@ const (
 	AFFILIATION_BILLING_ADMIN // BILLING_ADMIN may deposit funds into Affiliation-owned wallet and view/manage associated products
 )
 
+// Masks of role groups
+const (
+	GLOBAL_ROLES      Role = GLOBAL_EVALUATION_USER | GLOBAL_PRODUCTION_USER | GLOBAL_INTERNAL_USER | GLOBAL_ADMIN
+	EXEMPT_ROLES      Role = EXEMPT_MARKETING_CONTACT | EXEMPT_BILLING_CONTACT | EXEMPT_SUPPORT_CONTACT
+	AFFILIATION_ROLES Role = AFFILIATION_ACCOUNT_USER | AFFILIATION_ACCOUNT_ADMIN | AFFILIATION_PRODUCT_USER | AFFILIATION_PRODUCT_ADMIN | AFFILIATION_BILLING_USER | AFFILIATION_BILLING_ADMIN
+)
+
 // Roles() merge input roles into one single role.
 // repeated entry will be ignored.
 func Roles(roles ...Role) Role {
@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+// Every role change is recorded as a RoleGrant with the granter and the reason.
+// 	- Permanent changes (via UpdateRoles or GrantRole without expiry) are written
+// 	to User.Role immediately. Their grants are kept for the history only.
+// 	- Temporary grants (GrantRole with expiry) never touch User.Role. They are
+// 	combined into EffectiveRole() while in effect.
+
+// RoleGrant status
+const (
+	ROLE_GRANT_ACTIVE uint8 = iota
+	ROLE_GRANT_EXPIRED
+	ROLE_GRANT_REVOKED
+)
+
+var (
+	ErrRoleGrantReasonEmpty  = errors.New("auth: role grant reason is empty")
+	ErrRoleGrantEmpty        = errors.New("auth: role grant grants nothing")
+	ErrRoleGrantExpiryBad    = errors.New("auth: role grant expires before it starts")
+	ErrRoleGrantNotTemporary = errors.New("auth: only temporary role grants may be revoked")
+	ErrRoleGrantNotActive    = errors.New("auth: role grant is no longer active")
+)
+
+var (
+	RoleGrantJanitorInterval = time.Minute
+
+	stopRoleGrantJanitor func()
+)
+
+type RoleGrant struct {
+	id            uint64
+	UserID        uint64     `json:"user_id"`
+	Granted       Role       `json:"granted"`
+	Revoked       Role       `json:"revoked"`
+	GranterUserID uint64     `json:"granter"`
+	Reason        string     `json:"reason"`
+	CreatedAt     time.Time  `json:"created_at"`
+	StartsAt      time.Time  `json:"starts_at"`
+	ExpiresAt     *time.Time `json:"expires_at"` // nil for permanent changes
+	Status        uint8      `json:"status"`
+}
+
+func (grant *RoleGrant) ID() uint64 {
+	return grant.id
+}
+
+func (grant *RoleGrant) Temporary() bool {
+	return grant.ExpiresAt != nil
+}
+
+// InEffect is true if a temporary grant currently adds to the effective role.
+func (grant *RoleGrant) InEffect() bool {
+	if !grant.Temporary() || grant.Status != ROLE_GRANT_ACTIVE {
+		return false
+	}
+	now := time.Now()
+	return !now.Before(grant.StartsAt) && now.Before(*grant.ExpiresAt)
+}
+
+func GetRoleGrantByID(grantID uint64) (*RoleGrant, error) {
+	return store.GetRoleGrantByID(grantID)
+}
+
+// GrantRole grants role to the user on behalf of actor, subject to CanGrant.
+// If expiresAt is nil, the grant is permanent and starts immediately.
+// Otherwise it is in effect between startsAt and expiresAt.
+func (user *User) GrantRole(actor *User, role Role, reason string, startsAt time.Time, expiresAt *time.Time) (*RoleGrant, error) {
+	if expiresAt == nil {
+		return user.changeRoles(actor, RoleDelta{Grant: role}, reason)
+	}
+
+	if role == ROLELESS {
+		return nil, ErrRoleGrantEmpty
+	}
+	if reason == "" {
+		return nil, ErrRoleGrantReasonEmpty
+	}
+	if !expiresAt.After(startsAt) {
+		return nil, ErrRoleGrantExpiryBad
+	}
+	if err := checkRoleDelta(actor, user, RoleDelta{Grant: role}); err != nil {
+		return nil, err
+	}
+
+	grant := &RoleGrant{
+		UserID:        user.id,
+		Granted:       role,
+		GranterUserID: actor.id,
+		Reason:        reason,
+		CreatedAt:     time.Now(),
+		StartsAt:      startsAt,
+		ExpiresAt:     expiresAt,
+		Status:        ROLE_GRANT_ACTIVE,
+	}
+	err := store.NewRoleGrant(grant)
+	if err != nil {
+		return nil, err
+	}
+	err = audit(AUDIT_ROLE_GRANT, actor.id, user.id, "", map[string]interface{}{
+		"grant_id":   grant.id,
+		"granted":    grant.Granted,
+		"starts_at":  grant.StartsAt,
+		"expires_at": grant.ExpiresAt,
+		"reason":     reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A grant starting now may bring the user under an MFA policy
+	_, err = user.MFAEnrollmentState()
+	return grant, err
+}
+
+// changeRoles applies a permanent role change and records it.
+func (user *User) changeRoles(actor *User, roleDelta RoleDelta, reason string) (*RoleGrant, error) {
+	if roleDelta.Grant == ROLELESS && roleDelta.Revoke == ROLELESS {
+		return nil, ErrRoleGrantEmpty
+	}
+	if reason == "" {
+		return nil, ErrRoleGrantReasonEmpty
+	}
+	if err := checkRoleDelta(actor, user, roleDelta); err != nil {
+		return nil, err
+	}
+	return user.applyRoleDelta(actor.id, roleDelta, reason)
+}
+
+// applyRoleDelta saves and records a permanent role change without checking
+// the actor. actorUserID is 0 for changes made by the system. The delta is
+// applied to the saved role, not to user.Role which may hold unsaved changes.
+func (user *User) applyRoleDelta(actorUserID uint64, roleDelta RoleDelta, reason string) (*RoleGrant, error) {
+	stored, err := store.GetUserByID(user.id)
+	if err != nil {
+		return nil, err
+	}
+	newRole := roleDelta.Apply(stored.Role)
+	err = store.UpdateUserRole(user.id, newRole)
+	if err != nil {
+		return nil, err
+	}
+	user.Role = newRole
+
+	return user.recordRoleDelta(actorUserID, roleDelta, reason)
+}
+
+// recordRoleDelta records a permanent role change already saved, in the role
+// grant history and the audit trail, and re-evaluates the MFA policies.
+func (user *User) recordRoleDelta(actorUserID uint64, roleDelta RoleDelta, reason string) (*RoleGrant, error) {
+	now := time.Now()
+	grant := &RoleGrant{
+		UserID:        user.id,
+		Granted:       roleDelta.Grant,
+		Revoked:       roleDelta.Revoke,
+		GranterUserID: actorUserID,
+		Reason:        reason,
+		CreatedAt:     now,
+		StartsAt:      now,
+		Status:        ROLE_GRANT_ACTIVE,
+	}
+	err := store.NewRoleGrant(grant)
+	if err != nil {
+		return nil, err
+	}
+	err = audit(AUDIT_ROLE_CHANGE, actorUserID, user.id, "", map[string]interface{}{
+		"grant_id": grant.id,
+		"granted":  grant.Granted,
+		"revoked":  grant.Revoked,
+		"reason":   reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = user.MFAEnrollmentState()
+	return grant, err
+}
+
+// Revoke ends a temporary grant early.
+func (grant *RoleGrant) Revoke(actor *User) error {
+	if !grant.Temporary() {
+		return ErrRoleGrantNotTemporary
+	}
+	if grant.Status != ROLE_GRANT_ACTIVE {
+		return ErrRoleGrantNotActive
+	}
+
+	user, err := GetUserByID(grant.UserID)
+	if err != nil {
+		return err
+	}
+	if err := checkRoleDelta(actor, user, RoleDelta{Revoke: grant.Granted}); err != nil {
+		return err
+	}
+
+	err = store.UpdateRoleGrantStatus(grant.id, ROLE_GRANT_REVOKED)
+	if err != nil {
+		return err
+	}
+	grant.Status = ROLE_GRANT_REVOKED
+	err = audit(AUDIT_ROLE_GRANT_REVOKE, actor.id, user.id, "", map[string]interface{}{"grant_id": grant.id})
+	if err != nil {
+		return err
+	}
+
+	_, err = user.MFAEnrollmentState()
+	return err
+}
+
+// revokeTemporaryGrants revokes the active temporary grants of the user
+// granting any of the roles, e.g. AFFILIATION_ROLES when the user leaves the
+// affiliation. actorUserID is 0 for revocations made by the system.
+func (user *User) revokeTemporaryGrants(actorUserID uint64, roles Role, reason string) error {
+	grants, err := store.ListRoleGrantsByUserID(user.id)
+	if err != nil {
+		return err
+	}
+	for _, grant := range grants {
+		if !grant.Temporary() || grant.Status != ROLE_GRANT_ACTIVE || grant.Granted&roles == ROLELESS {
+			continue
+		}
+		err = store.UpdateRoleGrantStatus(grant.id, ROLE_GRANT_REVOKED)
+		if err != nil {
+			return err
+		}
+		err = audit(AUDIT_ROLE_GRANT_REVOKE, actorUserID, user.id, "", map[string]interface{}{"grant_id": grant.id, "reason": reason})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EffectiveRole combines the permanent role and all temporary grants in effect.
+// Access checks should use it instead of Role.
+func (user *User) EffectiveRole() (Role, error) {
+	temporaryRole, err := store.ActiveTemporaryRole(user.id, time.Now())
+	if err != nil {
+		return ROLELESS, err
+	}
+	return user.Role.AddRole(temporaryRole), nil
+}
+
+// RoleGrantHistory lists all grants and permanent changes of the user, latest first.
+func (user *User) RoleGrantHistory() ([]*RoleGrant, error) {
+	return store.ListRoleGrantsByUserID(user.id)
+}
+
+// RoleGrantHistory lists all grants and permanent changes touching any of
+// the roles, latest first. e.g. RoleGrantHistory(GLOBAL_ADMIN)
+func RoleGrantHistory(role Role) ([]*RoleGrant, error) {
+	return store.ListRoleGrantsByRole(role)
+}
+
+// ExpireRoleGrants marks lapsed temporary grants as expired and re-evaluates
+// MFA policies for the affected users. The janitor started by Setup calls it
+// every RoleGrantJanitorInterval.
+func ExpireRoleGrants() []error {
+	var errs []error
+
+	userIDs, err := store.ExpireRoleGrants(time.Now())
+	if err != nil {
+		return append(errs, err)
+	}
+
+	for _, userID := range userIDs {
+		user, err := GetUserByID(userID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err = user.MFAEnrollmentState(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// StartRoleGrantJanitor calls ExpireRoleGrants every interval until stop is called.
+// Errors are ignored: the grants are expired at the next interval.
+func StartRoleGrantJanitor(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				_ = ExpireRoleGrants()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
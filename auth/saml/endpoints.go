@@ -0,0 +1,238 @@
+package saml
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/TunnelWork/Ulysses.Lib/api"
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// Endpoints in the Auth category of package api, relative to Config.BaseURL:
+//
+//	GET  sp/:affiliation_id/metadata
+//	GET  sp/:affiliation_id/login  ?RelayState= redirects to the IdP
+//	POST sp/:affiliation_id/acs    calls onSignIn
+//	GET  config        ?affiliation_id= (userGroup)
+//	POST config/update (userGroup) IdPConfig, optionally with "metadata" of the IdP
+//	POST config/delete (userGroup)
+//
+// The Access Control Funcs of userGroup must set api.ContextKeyUserID. The
+// config endpoints are further limited to GLOBAL_ADMIN and the
+// AFFILIATION_ACCOUNT_ADMIN of the affiliation.
+
+var onSignIn func(c *gin.Context, result *LoginResult)
+
+// RegisterEndpoints registers the endpoints with package api. After a
+// successful login, onSignIn is to issue the session of the user, e.g. a
+// token, and respond. If result.MFAEnrollmentRequired, the session must allow
+// nothing but MFA enrollment.
+func RegisterEndpoints(userGroup string, signInHandler func(c *gin.Context, result *LoginResult)) error {
+	onSignIn = signInHandler
+
+	routes := []struct {
+		method    string
+		path      string
+		userGroup string // empty for public endpoints
+		handler   *gin.HandlerFunc
+	}{
+		{http.MethodGet, "saml/sp/:affiliation_id/metadata", "", &metadataHandler},
+		{http.MethodGet, "saml/sp/:affiliation_id/login", "", &loginHandler},
+		{http.MethodPost, "saml/sp/:affiliation_id/acs", "", &acsHandler},
+		{http.MethodGet, "saml/config", userGroup, &getConfigHandler},
+		{http.MethodPost, "saml/config/update", userGroup, &updateConfigHandler},
+		{http.MethodPost, "saml/config/delete", userGroup, &deleteConfigHandler},
+	}
+
+	var err error
+	for _, route := range routes {
+		switch {
+		case route.method == http.MethodGet && route.userGroup == "":
+			err = api.CGET(api.Auth, route.path, route.handler)
+		case route.method == http.MethodGet:
+			err = api.AuthedCGET(api.Auth, route.path, route.userGroup, route.handler)
+		case route.userGroup == "":
+			err = api.CPOST(api.Auth, route.path, route.handler)
+		default:
+			err = api.AuthedCPOST(api.Auth, route.path, route.userGroup, route.handler)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/************ SP Endpoints ************/
+
+func affiliationIDParam(c *gin.Context) (uint64, bool) {
+	affiliationID, err := strconv.ParseUint(c.Param("affiliation_id"), 10, 64)
+	if err != nil || affiliationID == 0 {
+		c.JSON(http.StatusNotFound, api.MessageResponse(api.ERROR, "SAML_IDP_NOT_FOUND"))
+		return 0, false
+	}
+	return affiliationID, true
+}
+
+var metadataHandler gin.HandlerFunc = func(c *gin.Context) {
+	affiliationID, ok := affiliationIDParam(c)
+	if !ok {
+		return
+	}
+	metadata, err := SPMetadata(affiliationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.MessageResponse(api.ERROR, "INTERNAL_ERROR"))
+		return
+	}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+var loginHandler gin.HandlerFunc = func(c *gin.Context) {
+	affiliationID, ok := affiliationIDParam(c)
+	if !ok {
+		return
+	}
+	redirectURL, err := BeginLogin(affiliationID, c.Query("RelayState"))
+	if err == ErrIdPNotConfigured || err == ErrIdPDisabled {
+		c.JSON(http.StatusNotFound, api.MessageResponse(api.ERROR, "SAML_IDP_NOT_FOUND"))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, api.MessageResponse(api.ERROR, "INTERNAL_ERROR"))
+		return
+	}
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+var acsHandler gin.HandlerFunc = func(c *gin.Context) {
+	affiliationID, ok := affiliationIDParam(c)
+	if !ok {
+		return
+	}
+	result, err := CompleteLogin(affiliationID, c.PostForm("SAMLResponse"), c.PostForm("RelayState"), c.ClientIP())
+	switch err {
+	case nil:
+		onSignIn(c, result)
+	case ErrIdPNotConfigured, ErrIdPDisabled:
+		c.JSON(http.StatusNotFound, api.MessageResponse(api.ERROR, "SAML_IDP_NOT_FOUND"))
+	case ErrNotProvisioned, ErrUserOutsideAffiliation, ErrUserNotManaged:
+		c.JSON(http.StatusForbidden, api.MessageResponse(api.ERROR, "SAML_USER_NOT_ALLOWED"))
+	case auth.ErrUserDeactivated:
+		c.JSON(http.StatusForbidden, api.MessageResponse(api.ERROR, "USER_DEACTIVATED"))
+	case ErrResponseStatus:
+		c.JSON(http.StatusUnauthorized, api.MessageResponse(api.ERROR, "SAML_IDP_REJECTED"))
+	case ErrXMLMalformed, ErrResponseMalformed, ErrAssertionEncrypted, ErrAssertionInvalid,
+		ErrSignatureMissing, ErrSignatureAlgorithm, ErrSignatureReference, ErrDigestMismatch, ErrSignatureInvalid,
+		ErrRequestNotFound, ErrIdPInitiatedForbidden, ErrAssertionReplayed, ErrEmailMissing:
+		c.JSON(http.StatusUnauthorized, api.MessageResponse(api.ERROR, "SAML_RESPONSE_INVALID"))
+	default:
+		c.JSON(http.StatusInternalServerError, api.MessageResponse(api.ERROR, "INTERNAL_ERROR"))
+	}
+}
+
+/************ Config Endpoints ************/
+
+type updateConfigForm struct {
+	IdPConfig
+	// Metadata of the IdP. If set, it overrides EntityID, SSOURL and
+	// Certificates.
+	Metadata string `json:"metadata"`
+}
+
+func actorOf(c *gin.Context) (*auth.User, bool) {
+	actor, err := auth.GetUserByID(c.GetUint64(api.ContextKeyUserID))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, api.MessageResponse(api.ERROR, "AUTH_FAILED"))
+		return nil, false
+	}
+	return actor, true
+}
+
+func configErrorResponse(c *gin.Context, err error) {
+	switch err {
+	case ErrConfigNotAllowed:
+		c.JSON(http.StatusForbidden, api.MessageResponse(api.ERROR, "PERMISSION_DENIED"))
+	case ErrIdPNotConfigured, sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, api.MessageResponse(api.ERROR, "SAML_IDP_NOT_FOUND"))
+	case ErrIdPConfigBad, ErrCertificateBad, ErrRoleMappingBad, ErrMetadataMalformed, ErrXMLMalformed:
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "SAML_CONFIG_INVALID"))
+	default:
+		c.JSON(http.StatusInternalServerError, api.MessageResponse(api.ERROR, "INTERNAL_ERROR"))
+	}
+}
+
+var getConfigHandler gin.HandlerFunc = func(c *gin.Context) {
+	affiliationID, err := strconv.ParseUint(c.Query("affiliation_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "BAD_REQUEST"))
+		return
+	}
+	actor, ok := actorOf(c)
+	if !ok {
+		return
+	}
+
+	var conf *IdPConfig
+	err = checkConfigActor(actor, affiliationID)
+	if err == nil {
+		conf, err = GetIdPConfig(affiliationID)
+	}
+	if err != nil {
+		configErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, api.PayloadResponse(api.SUCCESS, gin.H{
+		"config":       conf,
+		"sp_entity_id": EntityID(affiliationID),
+		"acs_url":      ACSURL(affiliationID),
+		"login_url":    LoginURL(affiliationID),
+	}))
+}
+
+var updateConfigHandler gin.HandlerFunc = func(c *gin.Context) {
+	var form updateConfigForm
+	if c.ShouldBindJSON(&form) != nil {
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "BAD_REQUEST"))
+		return
+	}
+	actor, ok := actorOf(c)
+	if !ok {
+		return
+	}
+
+	conf := &form.IdPConfig
+	if form.Metadata != "" {
+		parsed, err := ParseIdPMetadata([]byte(form.Metadata))
+		if err != nil {
+			configErrorResponse(c, err)
+			return
+		}
+		conf.EntityID, conf.SSOURL, conf.Certificates = parsed.EntityID, parsed.SSOURL, parsed.Certificates
+	}
+	if err := SetIdPConfig(actor, conf); err != nil {
+		configErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, api.MessageResponse(api.SUCCESS, "SAML_CONFIG_UPDATED"))
+}
+
+var deleteConfigHandler gin.HandlerFunc = func(c *gin.Context) {
+	var form struct {
+		AffiliationID uint64 `json:"affiliation_id" binding:"required"`
+	}
+	if c.ShouldBindJSON(&form) != nil {
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "BAD_REQUEST"))
+		return
+	}
+	actor, ok := actorOf(c)
+	if !ok {
+		return
+	}
+
+	if err := DeleteIdPConfig(actor, form.AffiliationID); err != nil {
+		configErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, api.MessageResponse(api.SUCCESS, "SAML_CONFIG_DELETED"))
+}
@@ -0,0 +1,185 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+var (
+	ErrIdPNotConfigured  = errors.New("saml: affiliation has no IdP configured")
+	ErrIdPDisabled       = errors.New("saml: IdP of the affiliation is disabled")
+	ErrIdPConfigBad      = errors.New("saml: IdP config requires an entity ID, an HTTPS SSO URL and a certificate")
+	ErrCertificateBad    = errors.New("saml: IdP certificate is not a PEM-encoded X.509 certificate")
+	ErrRoleMappingBad    = errors.New("saml: only AFFILIATION_* roles may be mapped")
+	ErrConfigNotAllowed  = errors.New("saml: actor may not configure the IdP of the affiliation")
+	ErrMetadataMalformed = errors.New("saml: IdP metadata has no IDPSSODescriptor with an HTTP-Redirect SSO service")
+)
+
+// IdPConfig is the SAML IdP of an affiliation.
+type IdPConfig struct {
+	AffiliationID uint64   `json:"affiliation_id"`
+	EntityID      string   `json:"entity_id"`
+	SSOURL        string   `json:"sso_url"`      // HTTP-Redirect binding
+	Certificates  []string `json:"certificates"` // PEM. Any of them may sign.
+
+	// EmailAttribute names the attribute holding the email.
+	// If empty, the NameID is the email.
+	EmailAttribute string `json:"email_attribute"`
+	// RoleAttribute names the attribute mapped to roles. If empty, the roles
+	// of users are managed in Ulysses and not synced.
+	RoleAttribute string `json:"role_attribute"`
+	// RoleMapping maps values of RoleAttribute to AFFILIATION_* roles.
+	RoleMapping map[string]auth.Role `json:"role_mapping"`
+	// DefaultRole is held by every user of the IdP, e.g. AFFILIATION_ACCOUNT_USER.
+	// Provisioned users get it, and RoleAttribute adds to it.
+	DefaultRole auth.Role `json:"default_role"`
+
+	JITProvisioning   bool      `json:"jit_provisioning"`
+	AllowIdPInitiated bool      `json:"allow_idp_initiated"`
+	Enabled           bool      `json:"enabled"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	// UpdatedBy is the admin on whose behalf the IdP signs users in.
+	UpdatedBy uint64 `json:"updated_by"`
+}
+
+func (conf *IdPConfig) validate() error {
+	ssoURL, err := url.Parse(conf.SSOURL)
+	if conf.EntityID == "" || err != nil || ssoURL.Scheme != "https" || len(conf.Certificates) == 0 {
+		return ErrIdPConfigBad
+	}
+	if _, err = conf.certificates(); err != nil {
+		return err
+	}
+	if !auth.AFFILIATION_ROLES.Includes(conf.DefaultRole) {
+		return ErrRoleMappingBad
+	}
+	for _, role := range conf.RoleMapping {
+		if !auth.AFFILIATION_ROLES.Includes(role) {
+			return ErrRoleMappingBad
+		}
+	}
+	return nil
+}
+
+func (conf *IdPConfig) certificates() ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for _, certPEM := range conf.Certificates {
+		block, _ := pem.Decode([]byte(certPEM))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, ErrCertificateBad
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, ErrCertificateBad
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// replacedBy tells if the update names another IdP, or trusts another
+// certificate.
+func (conf *IdPConfig) replacedBy(update *IdPConfig) bool {
+	if conf.EntityID != update.EntityID {
+		return true
+	}
+	trusted := map[string]bool{}
+	for _, certPEM := range conf.Certificates {
+		trusted[strings.TrimSpace(certPEM)] = true
+	}
+	for _, certPEM := range update.Certificates {
+		if !trusted[strings.TrimSpace(certPEM)] {
+			return true
+		}
+	}
+	return false
+}
+
+// mappedRoles returns DefaultRole with the roles mapped from the values.
+func (conf *IdPConfig) mappedRoles(values []string) auth.Role {
+	role := conf.DefaultRole
+	for _, value := range values {
+		role = role.AddRole(conf.RoleMapping[value])
+	}
+	return role & auth.AFFILIATION_ROLES
+}
+
+// checkConfigActor allows GLOBAL_ADMIN, and AFFILIATION_ACCOUNT_ADMIN of the
+// affiliation itself. Admins of a parent affiliation may not: the IdP decides
+// who belongs to the affiliation.
+func checkConfigActor(actor *auth.User, affiliationID uint64) error {
+	role, err := actor.EffectiveRole()
+	if err != nil {
+		return err
+	}
+	if role.Includes(auth.GLOBAL_ADMIN) {
+		return nil
+	}
+	if role.Includes(auth.AFFILIATION_ACCOUNT_ADMIN) && actor.AffiliationID == affiliationID && affiliationID != 0 {
+		return nil
+	}
+	return ErrConfigNotAllowed
+}
+
+// GetIdPConfig returns the IdP of the affiliation, or ErrIdPNotConfigured.
+func GetIdPConfig(affiliationID uint64) (*IdPConfig, error) {
+	return store.getIdPConfig(affiliationID)
+}
+
+// SetIdPConfig saves the IdP of the affiliation on behalf of the actor.
+func SetIdPConfig(actor *auth.User, conf *IdPConfig) error {
+	if err := checkConfigActor(actor, conf.AffiliationID); err != nil {
+		return err
+	}
+	if _, err := auth.GetAffiliationByID(conf.AffiliationID); err != nil {
+		return err
+	}
+	if err := conf.validate(); err != nil {
+		return err
+	}
+
+	// NameIDs are only as good as the IdP asserting them: links made with
+	// another IdP are not trusted from the new one
+	existing, err := store.getIdPConfig(conf.AffiliationID)
+	if err == nil && existing.replacedBy(conf) {
+		err = auth.UnlinkProviderIdentities(actor.ID(), providerName(conf.AffiliationID))
+	} else if err == ErrIdPNotConfigured {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	conf.UpdatedAt = time.Now()
+	conf.UpdatedBy = actor.ID()
+	if err := store.saveIdPConfig(conf); err != nil {
+		return err
+	}
+	return audit(AUDIT_SAML_CONFIG_UPDATE, actor.ID(), 0, conf.AffiliationID, "", map[string]interface{}{
+		"entity_id":           conf.EntityID,
+		"jit_provisioning":    conf.JITProvisioning,
+		"allow_idp_initiated": conf.AllowIdPInitiated,
+		"enabled":             conf.Enabled,
+	})
+}
+
+// DeleteIdPConfig removes the IdP of the affiliation, along with the
+// identities linked with it.
+func DeleteIdPConfig(actor *auth.User, affiliationID uint64) error {
+	if err := checkConfigActor(actor, affiliationID); err != nil {
+		return err
+	}
+	if err := auth.UnlinkProviderIdentities(actor.ID(), providerName(affiliationID)); err != nil {
+		return err
+	}
+	if err := store.deleteIdPConfig(affiliationID); err != nil {
+		return err
+	}
+	return audit(AUDIT_SAML_CONFIG_DELETE, actor.ID(), 0, affiliationID, "", map[string]interface{}{})
+}
@@ -0,0 +1,205 @@
+package saml
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+var (
+	ErrRequestNotFound        = errors.New("saml: response is not to a pending request, or the request expired")
+	ErrIdPInitiatedForbidden  = errors.New("saml: IdP-initiated login is not allowed for the affiliation")
+	ErrAssertionReplayed      = errors.New("saml: assertion was used already")
+	ErrEmailMissing           = errors.New("saml: assertion carries no email")
+	ErrNotProvisioned         = errors.New("saml: user does not exist and just-in-time provisioning is off")
+	ErrUserOutsideAffiliation = errors.New("saml: user does not belong to the affiliation of the IdP")
+	ErrUserNotManaged         = errors.New("saml: the admin who configured the IdP may not manage the user")
+)
+
+// LoginResult of a completed login
+type LoginResult struct {
+	User         *auth.User
+	RelayState   string // from BeginLogin, or as posted by the IdP if IdP-initiated
+	IdPInitiated bool
+	Provisioned  bool // the user was created just now
+	SessionIndex string
+	// MFAEnrollmentRequired is set if an MFA policy is enforced on the user:
+	// the session must allow nothing but MFA enrollment.
+	MFAEnrollmentRequired bool
+}
+
+// CompleteLogin verifies the SAMLResponse posted to the ACS of the
+// affiliation, and signs the user in, provisioning them if configured.
+//
+// For an IdP-initiated login, RelayState comes from the IdP unverified: the
+// caller must not redirect to it blindly.
+func CompleteLogin(affiliationID uint64, samlResponse, relayState, ip string) (*LoginResult, error) {
+	conf, err := store.getIdPConfig(affiliationID)
+	if err != nil {
+		return nil, err
+	}
+	if !conf.Enabled {
+		return nil, ErrIdPDisabled
+	}
+
+	now := time.Now()
+	assertion, err := parseResponse(conf, samlResponse, now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LoginResult{
+		RelayState:   relayState,
+		SessionIndex: assertion.SessionIndex,
+	}
+	if assertion.InResponseTo != "" {
+		// The request is taken, so that a response to it is accepted once
+		pendingJson, err := auth.Ephemeral().Take(requestKey(assertion.InResponseTo))
+		if err == auth.ErrEphemeralNotFound {
+			return nil, ErrRequestNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		var pending pendingRequest
+		if err = json.Unmarshal([]byte(pendingJson), &pending); err != nil {
+			return nil, err
+		}
+		if pending.AffiliationID != affiliationID {
+			return nil, ErrRequestNotFound
+		}
+		result.RelayState = pending.RelayState
+	} else if !conf.AllowIdPInitiated {
+		return nil, ErrIdPInitiatedForbidden
+	} else {
+		result.IdPInitiated = true
+	}
+
+	if err = acceptOnce(affiliationID, assertion); err != nil {
+		return nil, err
+	}
+
+	loginResult, provisioned, err := signIn(conf, assertion, ip)
+	if err != nil {
+		return nil, err
+	}
+	result.User = loginResult.User
+	result.Provisioned = provisioned
+	result.MFAEnrollmentRequired = loginResult.MFAEnrollmentRequired
+	return result, nil
+}
+
+// acceptOnce accepts each assertion once while it is valid.
+func acceptOnce(affiliationID uint64, assertion *assertion) error {
+	assertionHash := sha256.Sum256([]byte(assertion.ID))
+	replayTTL := time.Until(assertion.NotOnOrAfter.Add(ClockSkew))
+	err := auth.Ephemeral().Add(fmt.Sprintf("saml/assertion/%d/%s", affiliationID, hex.EncodeToString(assertionHash[:])), "", replayTTL)
+	if err == auth.ErrEphemeralExists {
+		return ErrAssertionReplayed
+	}
+	return err
+}
+
+// signIn resolves the user of the assertion: the one linked to the NameID,
+// or else the one of the email in the affiliation, which gets linked, or
+// else a new one if JITProvisioning is on.
+func signIn(conf *IdPConfig, assertion *assertion, ip string) (*auth.LoginResult, bool, error) {
+	provider := providerName(conf.AffiliationID)
+	email := assertion.NameID
+	if conf.EmailAttribute != "" {
+		email = ""
+		if values := assertion.Attributes[conf.EmailAttribute]; len(values) > 0 {
+			email = values[0]
+		}
+	}
+	email = strings.TrimSpace(email)
+	roles := conf.mappedRoles(assertion.Attributes[conf.RoleAttribute])
+
+	provisioned := false
+	user, err := auth.GetUserByExternalIdentity(provider, assertion.NameID)
+	if err == auth.ErrExternalIdentityNotFound {
+		if email == "" || !strings.Contains(email, "@") {
+			return nil, false, ErrEmailMissing
+		}
+		user, err = auth.GetUserByEmail(email)
+		if err == sql.ErrNoRows {
+			if !conf.JITProvisioning {
+				return nil, false, ErrNotProvisioned
+			}
+			user, err = provision(conf, email, roles, ip)
+			provisioned = true
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if err = checkManaged(conf, user); err != nil {
+			return nil, false, err
+		}
+		if _, err = user.LinkExternalIdentity(provider, assertion.NameID, email); err != nil {
+			return nil, false, err
+		}
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	if err = checkManaged(conf, user); err != nil {
+		return nil, false, err
+	}
+	if conf.RoleAttribute != "" && !provisioned {
+		if err = user.SyncExternalRoles(provider, roles); err != nil {
+			return nil, false, err
+		}
+	}
+	result, err := user.VerifyExternalLogin(provider, assertion.NameID, ip)
+	if err != nil {
+		return nil, false, err
+	}
+	return result, provisioned, nil
+}
+
+// checkManaged refuses users the IdP may not speak for. It speaks for the
+// members of its affiliation only, on behalf of the admin who configured it:
+// see auth.CanManage.
+func checkManaged(conf *IdPConfig, user *auth.User) error {
+	if user.AffiliationID != conf.AffiliationID {
+		return ErrUserOutsideAffiliation
+	}
+	configActor, err := auth.GetUserByID(conf.UpdatedBy)
+	if err == sql.ErrNoRows {
+		return ErrUserNotManaged
+	} else if err != nil {
+		return err
+	}
+	canManage, err := auth.CanManage(configActor, user)
+	if err != nil {
+		return err
+	}
+	if !canManage {
+		return ErrUserNotManaged
+	}
+	return nil
+}
+
+func provision(conf *IdPConfig, email string, roles auth.Role, ip string) (*auth.User, error) {
+	user := &auth.User{
+		Email:         email,
+		Role:          roles,
+		AffiliationID: conf.AffiliationID,
+	}
+	if err := user.Create(); err != nil {
+		return nil, err
+	}
+	user, err := auth.GetUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	return user, audit(AUDIT_SAML_PROVISION, 0, user.ID(), conf.AffiliationID, ip, map[string]interface{}{
+		"role": roles,
+	})
+}
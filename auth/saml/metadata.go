@@ -0,0 +1,84 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// ParseIdPMetadata reads the entity ID, the HTTP-Redirect SSO service and the
+// signing certificates from the metadata of an IdP, either an EntityDescriptor
+// or an EntitiesDescriptor with a single IdP. The caller completes the
+// returned IdPConfig with the affiliation and the attribute mapping.
+//
+// Signatures on the metadata are not checked: it is to be obtained from the
+// IdP administrator directly, or downloaded over HTTPS.
+func ParseIdPMetadata(data []byte) (*IdPConfig, error) {
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	entities := []*xmlNode{root}
+	if root.is(nsMetadata, "EntitiesDescriptor") {
+		entities = root.childrenNamed(nsMetadata, "EntityDescriptor")
+	}
+	var entity, descriptor *xmlNode
+	for _, candidate := range entities {
+		if !candidate.is(nsMetadata, "EntityDescriptor") {
+			continue
+		}
+		if idpDescriptor := candidate.child(nsMetadata, "IDPSSODescriptor"); idpDescriptor != nil {
+			if entity != nil {
+				return nil, ErrMetadataMalformed
+			}
+			entity, descriptor = candidate, idpDescriptor
+		}
+	}
+	if entity == nil || entity.attr("entityID") == "" {
+		return nil, ErrMetadataMalformed
+	}
+
+	conf := &IdPConfig{
+		EntityID:    entity.attr("entityID"),
+		RoleMapping: map[string]auth.Role{},
+	}
+	for _, service := range descriptor.childrenNamed(nsMetadata, "SingleSignOnService") {
+		if service.attr("Binding") == bindingHTTPRedirect {
+			conf.SSOURL = service.attr("Location")
+			break
+		}
+	}
+	if conf.SSOURL == "" {
+		return nil, ErrMetadataMalformed
+	}
+
+	for _, keyDescriptor := range descriptor.childrenNamed(nsMetadata, "KeyDescriptor") {
+		if use := keyDescriptor.attr("use"); use != "" && use != "signing" {
+			continue
+		}
+		keyInfo := keyDescriptor.child(nsDSig, "KeyInfo")
+		if keyInfo == nil {
+			continue
+		}
+		for _, x509Data := range keyInfo.childrenNamed(nsDSig, "X509Data") {
+			for _, certNode := range x509Data.childrenNamed(nsDSig, "X509Certificate") {
+				der, err := decodeBase64Text(certNode.text())
+				if err != nil {
+					return nil, ErrCertificateBad
+				}
+				if _, err = x509.ParseCertificate(der); err != nil {
+					return nil, ErrCertificateBad
+				}
+				certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+				conf.Certificates = append(conf.Certificates, strings.TrimSpace(string(certPEM)))
+			}
+		}
+	}
+	if len(conf.Certificates) == 0 {
+		return nil, ErrMetadataMalformed
+	}
+	return conf, nil
+}
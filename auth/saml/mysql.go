@@ -0,0 +1,74 @@
+package saml
+
+import (
+	"database/sql"
+	"strings"
+)
+
+/************ Table Definitions ************/
+
+const (
+	idpConfigTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_saml_idp (
+        affiliation_id BIGINT UNSIGNED NOT NULL,
+        entity_id VARCHAR(255) NOT NULL,
+        sso_url VARCHAR(1024) NOT NULL,
+        certificates TEXT NOT NULL,
+        email_attribute VARCHAR(255) NOT NULL,
+        role_attribute VARCHAR(255) NOT NULL,
+        role_mapping TEXT NOT NULL,
+        default_role INT UNSIGNED NOT NULL DEFAULT 0,
+        jit_provisioning BOOLEAN NOT NULL DEFAULT FALSE,
+        allow_idp_initiated BOOLEAN NOT NULL DEFAULT FALSE,
+        enabled BOOLEAN NOT NULL DEFAULT FALSE,
+        updated_at DATETIME NOT NULL,
+        updated_by BIGINT UNSIGNED NOT NULL DEFAULT 0,
+        PRIMARY KEY (affiliation_id),
+        CONSTRAINT FOREIGN KEY (affiliation_id) REFERENCES dbprefix_auth_affiliation(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+	sqliteIdPConfigTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_saml_idp (
+        affiliation_id INTEGER PRIMARY KEY REFERENCES dbprefix_auth_affiliation(id) ON DELETE CASCADE,
+        entity_id VARCHAR(255) NOT NULL,
+        sso_url VARCHAR(1024) NOT NULL,
+        certificates TEXT NOT NULL,
+        email_attribute VARCHAR(255) NOT NULL,
+        role_attribute VARCHAR(255) NOT NULL,
+        role_mapping TEXT NOT NULL,
+        default_role INTEGER NOT NULL DEFAULT 0,
+        jit_provisioning BOOLEAN NOT NULL DEFAULT FALSE,
+        allow_idp_initiated BOOLEAN NOT NULL DEFAULT FALSE,
+        enabled BOOLEAN NOT NULL DEFAULT FALSE,
+        updated_at DATETIME NOT NULL,
+        updated_by INTEGER NOT NULL DEFAULT 0
+    );`
+)
+
+// sqlStore keeps the IdP configs in the database of an auth.SQLStore.
+type sqlStore struct {
+	db        *sql.DB
+	tblPrefix string
+	sqlite    bool
+}
+
+/************ Helper Functions ************/
+func (s *sqlStore) statement(query string) (*sql.Stmt, error) {
+	prefixUpdatedQuery := strings.ReplaceAll(query, "dbprefix_", s.tblPrefix)
+
+	return s.db.Prepare(prefixUpdatedQuery)
+}
+
+/************ Table Creations ************/
+func (s *sqlStore) init() error {
+	// dbprefix_saml_idp relys on dbprefix_auth_affiliation
+	tblCreation := idpConfigTblCreation
+	if s.sqlite {
+		tblCreation = sqliteIdPConfigTblCreation
+	}
+	stmt, err := s.statement(tblCreation)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec()
+	return err
+}
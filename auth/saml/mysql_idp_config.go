@@ -0,0 +1,69 @@
+package saml
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+/************ IdP Config Database ************/
+
+func (s *sqlStore) getIdPConfig(affiliationID uint64) (*IdPConfig, error) {
+	stmtGetIdPConfig, err := s.statement(`SELECT affiliation_id, entity_id, sso_url, certificates, email_attribute, role_attribute, role_mapping, default_role, jit_provisioning, allow_idp_initiated, enabled, updated_at, updated_by FROM dbprefix_saml_idp WHERE affiliation_id = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetIdPConfig.Close()
+
+	var conf IdPConfig
+	var certificatesJson, roleMappingJson string
+	err = stmtGetIdPConfig.QueryRow(affiliationID).Scan(&conf.AffiliationID, &conf.EntityID, &conf.SSOURL, &certificatesJson, &conf.EmailAttribute, &conf.RoleAttribute, &roleMappingJson, &conf.DefaultRole, &conf.JITProvisioning, &conf.AllowIdPInitiated, &conf.Enabled, &conf.UpdatedAt, &conf.UpdatedBy)
+	if err == sql.ErrNoRows {
+		return nil, ErrIdPNotConfigured
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(certificatesJson), &conf.Certificates); err != nil {
+		return nil, err
+	}
+	conf.RoleMapping = map[string]auth.Role{}
+	if err = json.Unmarshal([]byte(roleMappingJson), &conf.RoleMapping); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+func (s *sqlStore) saveIdPConfig(conf *IdPConfig) error {
+	certificatesJson, err := json.Marshal(conf.Certificates)
+	if err != nil {
+		return err
+	}
+	if conf.RoleMapping == nil {
+		conf.RoleMapping = map[string]auth.Role{}
+	}
+	roleMappingJson, err := json.Marshal(conf.RoleMapping)
+	if err != nil {
+		return err
+	}
+
+	stmtSaveIdPConfig, err := s.statement(`REPLACE INTO dbprefix_saml_idp (affiliation_id, entity_id, sso_url, certificates, email_attribute, role_attribute, role_mapping, default_role, jit_provisioning, allow_idp_initiated, enabled, updated_at, updated_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtSaveIdPConfig.Close()
+
+	_, err = stmtSaveIdPConfig.Exec(conf.AffiliationID, conf.EntityID, conf.SSOURL, string(certificatesJson), conf.EmailAttribute, conf.RoleAttribute, string(roleMappingJson), conf.DefaultRole, conf.JITProvisioning, conf.AllowIdPInitiated, conf.Enabled, conf.UpdatedAt, conf.UpdatedBy)
+	return err
+}
+
+func (s *sqlStore) deleteIdPConfig(affiliationID uint64) error {
+	stmtDeleteIdPConfig, err := s.statement(`DELETE FROM dbprefix_saml_idp WHERE affiliation_id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtDeleteIdPConfig.Close()
+
+	_, err = stmtDeleteIdPConfig.Exec(affiliationID)
+	return err
+}
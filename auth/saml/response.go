@@ -0,0 +1,198 @@
+package saml
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrResponseMalformed  = errors.New("saml: response is malformed")
+	ErrResponseStatus     = errors.New("saml: IdP responded with a failure status")
+	ErrAssertionEncrypted = errors.New("saml: encrypted assertions are not supported")
+	ErrAssertionInvalid   = errors.New("saml: assertion is not issued by the IdP for us, or expired")
+)
+
+// assertion holds what we read from a verified assertion.
+type assertion struct {
+	ID           string
+	NameID       string
+	InResponseTo string
+	NotOnOrAfter time.Time
+	SessionIndex string
+	Attributes   map[string][]string
+}
+
+// parseResponse verifies the base64-encoded Response for the IdP of the
+// affiliation and reads its assertion. Either the Response or the Assertion
+// must be signed, and only what the signature covers is read: the
+// assertion is parsed again from the verified canonical bytes, so that
+// nothing outside of them, e.g. a wrapped copy, can be read instead.
+func parseResponse(conf *IdPConfig, samlResponse string, now time.Time) (*assertion, error) {
+	certs, err := conf.certificates()
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(samlResponse))
+	if err != nil {
+		return nil, ErrResponseMalformed
+	}
+	response, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+	if !response.is(nsProtocol, "Response") {
+		return nil, ErrResponseMalformed
+	}
+	if len(response.childrenNamed(nsAssertion, "EncryptedAssertion")) > 0 {
+		return nil, ErrAssertionEncrypted
+	}
+	acs := ACSURL(conf.AffiliationID)
+	if destination := response.attr("Destination"); destination != "" && destination != acs {
+		return nil, ErrAssertionInvalid
+	}
+	if !statusSucceeded(response) {
+		return nil, ErrResponseStatus
+	}
+
+	var assertionNode *xmlNode
+	if response.child(nsDSig, "Signature") != nil {
+		canonical, err := verifyEnveloped(response, response, certs)
+		if err != nil {
+			return nil, err
+		}
+		if response, err = parseXML(canonical); err != nil {
+			return nil, err
+		}
+		if !statusSucceeded(response) {
+			return nil, ErrResponseStatus
+		}
+		assertionNode = response.child(nsAssertion, "Assertion")
+		if assertionNode == nil {
+			return nil, ErrResponseMalformed
+		}
+	} else {
+		assertionNode = response.child(nsAssertion, "Assertion")
+		if assertionNode == nil {
+			return nil, ErrResponseMalformed
+		}
+		canonical, err := verifyEnveloped(response, assertionNode, certs)
+		if err != nil {
+			return nil, err
+		}
+		if assertionNode, err = parseXML(canonical); err != nil {
+			return nil, err
+		}
+	}
+
+	return readAssertion(conf, assertionNode, now)
+}
+
+func statusSucceeded(response *xmlNode) bool {
+	status := response.child(nsProtocol, "Status")
+	if status == nil {
+		return false
+	}
+	statusCode := status.child(nsProtocol, "StatusCode")
+	return statusCode != nil && statusCode.attr("Value") == statusSuccess
+}
+
+// readAssertion checks that the assertion is meant for the SP of the
+// affiliation and valid now, and reads it.
+func readAssertion(conf *IdPConfig, node *xmlNode, now time.Time) (*assertion, error) {
+	if !node.is(nsAssertion, "Assertion") || node.attr("Version") != "2.0" || node.attr("ID") == "" {
+		return nil, ErrResponseMalformed
+	}
+	issuer := node.child(nsAssertion, "Issuer")
+	if issuer == nil || strings.TrimSpace(issuer.text()) != conf.EntityID {
+		return nil, ErrAssertionInvalid
+	}
+
+	result := &assertion{
+		ID:         node.attr("ID"),
+		Attributes: map[string][]string{},
+	}
+
+	// Subject: the NameID, confirmed as a bearer for our ACS
+	subject := node.child(nsAssertion, "Subject")
+	if subject == nil {
+		return nil, ErrResponseMalformed
+	}
+	nameID := subject.child(nsAssertion, "NameID")
+	if nameID == nil || strings.TrimSpace(nameID.text()) == "" {
+		return nil, ErrResponseMalformed
+	}
+	result.NameID = strings.TrimSpace(nameID.text())
+
+	confirmed := false
+	for _, confirmation := range subject.childrenNamed(nsAssertion, "SubjectConfirmation") {
+		data := confirmation.child(nsAssertion, "SubjectConfirmationData")
+		if confirmation.attr("Method") != confirmationBearer || data == nil {
+			continue
+		}
+		if data.attr("Recipient") != ACSURL(conf.AffiliationID) {
+			continue
+		}
+		notOnOrAfter, err := time.Parse(time.RFC3339, data.attr("NotOnOrAfter"))
+		if err != nil || !now.Before(notOnOrAfter.Add(ClockSkew)) {
+			continue
+		}
+		if notBefore := data.attr("NotBefore"); notBefore != "" {
+			if t, err := time.Parse(time.RFC3339, notBefore); err != nil || now.Add(ClockSkew).Before(t) {
+				continue
+			}
+		}
+		confirmed = true
+		result.InResponseTo = data.attr("InResponseTo")
+		result.NotOnOrAfter = notOnOrAfter
+		break
+	}
+	if !confirmed {
+		return nil, ErrAssertionInvalid
+	}
+
+	// Conditions: validity period, and us in every AudienceRestriction
+	conditions := node.child(nsAssertion, "Conditions")
+	if conditions == nil {
+		return nil, ErrAssertionInvalid
+	}
+	if notBefore := conditions.attr("NotBefore"); notBefore != "" {
+		if t, err := time.Parse(time.RFC3339, notBefore); err != nil || now.Add(ClockSkew).Before(t) {
+			return nil, ErrAssertionInvalid
+		}
+	}
+	if notOnOrAfter := conditions.attr("NotOnOrAfter"); notOnOrAfter != "" {
+		if t, err := time.Parse(time.RFC3339, notOnOrAfter); err != nil || !now.Before(t.Add(ClockSkew)) {
+			return nil, ErrAssertionInvalid
+		}
+	}
+	restrictions := conditions.childrenNamed(nsAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return nil, ErrAssertionInvalid
+	}
+	for _, restriction := range restrictions {
+		audienceMatched := false
+		for _, audience := range restriction.childrenNamed(nsAssertion, "Audience") {
+			if strings.TrimSpace(audience.text()) == EntityID(conf.AffiliationID) {
+				audienceMatched = true
+			}
+		}
+		if !audienceMatched {
+			return nil, ErrAssertionInvalid
+		}
+	}
+
+	if authnStatement := node.child(nsAssertion, "AuthnStatement"); authnStatement != nil {
+		result.SessionIndex = authnStatement.attr("SessionIndex")
+	}
+	for _, statement := range node.childrenNamed(nsAssertion, "AttributeStatement") {
+		for _, attribute := range statement.childrenNamed(nsAssertion, "Attribute") {
+			name := attribute.attr("Name")
+			for _, value := range attribute.childrenNamed(nsAssertion, "AttributeValue") {
+				result.Attributes[name] = append(result.Attributes[name], strings.TrimSpace(value.text()))
+			}
+		}
+	}
+	return result, nil
+}
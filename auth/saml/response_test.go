@@ -0,0 +1,286 @@
+package saml
+
+import (
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+	"github.com/TunnelWork/Ulysses.Lib/auth/saml/samltest"
+)
+
+const testAffiliationID = 7
+
+func newTestIdP(t *testing.T) (*samltest.IdP, *IdPConfig) {
+	config = Config{BaseURL: "https://sp.example/auth/saml"}
+	idp, err := samltest.NewIdP("https://idp.example/metadata", "https://idp.example/sso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idp, &IdPConfig{
+		AffiliationID: testAffiliationID,
+		EntityID:      idp.EntityID,
+		SSOURL:        idp.SSOURL,
+		Certificates:  []string{idp.CertificatePEM},
+		Enabled:       true,
+	}
+}
+
+func testAssertion(nameID string) *samltest.Assertion {
+	return &samltest.Assertion{
+		SPEntityID: EntityID(testAffiliationID),
+		ACSURL:     ACSURL(testAffiliationID),
+		NameID:     nameID,
+	}
+}
+
+func issue(t *testing.T, idp *samltest.IdP, a *samltest.Assertion) string {
+	samlResponse, err := idp.Response(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return samlResponse
+}
+
+func decodeResponse(t *testing.T, samlResponse string) string {
+	data, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func encodeResponse(xml string) string {
+	return base64.StdEncoding.EncodeToString([]byte(xml))
+}
+
+var (
+	assertionPattern = regexp.MustCompile(`<saml:Assertion .*</saml:Assertion>`)
+	signaturePattern = regexp.MustCompile(`<ds:Signature .*?</ds:Signature>`)
+	idPattern        = regexp.MustCompile(` ID="[^"]*"`)
+)
+
+func TestParseResponse(t *testing.T) {
+	idp, conf := newTestIdP(t)
+
+	for _, signResponse := range []bool{false, true} {
+		a := testAssertion("alice@example.com")
+		a.InResponseTo = "_request"
+		a.Attributes = map[string][]string{"role": {"admin", "user"}}
+		a.SignResponse = signResponse
+
+		parsed, err := parseResponse(conf, issue(t, idp, a), time.Now())
+		if err != nil {
+			t.Fatalf("SignResponse %v: %v", signResponse, err)
+		}
+		if parsed.NameID != "alice@example.com" || parsed.InResponseTo != "_request" || parsed.SessionIndex != parsed.ID {
+			t.Errorf("SignResponse %v: unexpected assertion %+v", signResponse, parsed)
+		}
+		if roles := parsed.Attributes["role"]; len(roles) != 2 || roles[0] != "admin" || roles[1] != "user" {
+			t.Errorf("SignResponse %v: unexpected attributes %v", signResponse, parsed.Attributes)
+		}
+	}
+}
+
+func TestParseResponseRejectsWrapping(t *testing.T) {
+	idp, conf := newTestIdP(t)
+	signed := decodeResponse(t, issue(t, idp, testAssertion("mallory@example.com")))
+	original := assertionPattern.FindString(signed)
+	if original == "" {
+		t.Fatal("no assertion in the response")
+	}
+	signature := signaturePattern.FindString(original)
+
+	// an assertion for alice, as the attacker would write it
+	evil := strings.Replace(signaturePattern.ReplaceAllString(original, ""), "mallory@example.com", "alice@example.com", 1)
+	evilWithSignature := strings.Replace(evil, "</saml:Issuer>", "</saml:Issuer>"+signature, 1)
+	extensions := func(content string) string {
+		return `<samlp:Extensions>` + content + `</samlp:Extensions>`
+	}
+
+	cases := []struct {
+		name     string
+		response string
+		want     error
+	}{
+		{
+			name:     "second assertion",
+			response: strings.Replace(signed, original, original+evil, 1),
+			want:     ErrResponseMalformed,
+		},
+		{
+			name:     "signed assertion moved aside",
+			response: strings.Replace(signed, original, extensions(original)+evil, 1),
+			want:     ErrSignatureMissing,
+		},
+		{
+			name:     "duplicated ID",
+			response: strings.Replace(signed, original, extensions(original)+evilWithSignature, 1),
+			want:     ErrSignatureReference,
+		},
+		{
+			name:     "duplicated ID nested in the assertion",
+			response: strings.Replace(signed, original, strings.Replace(evilWithSignature, "</saml:Assertion>", original+"</saml:Assertion>", 1), 1),
+			want:     ErrSignatureReference,
+		},
+		{
+			name:     "copied signature referencing another ID",
+			response: strings.Replace(signed, original, idPattern.ReplaceAllString(evilWithSignature, ` ID="_evil"`), 1),
+			want:     ErrSignatureReference,
+		},
+		{
+			name:     "copied signature over another assertion",
+			response: strings.Replace(signed, original, evilWithSignature, 1),
+			want:     ErrDigestMismatch,
+		},
+	}
+	for _, c := range cases {
+		if c.response == signed {
+			t.Fatalf("%s: test response is not modified", c.name)
+		}
+		if _, err := parseResponse(conf, encodeResponse(c.response), time.Now()); err != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+func TestParseResponseRejectsWrappedResponse(t *testing.T) {
+	idp, conf := newTestIdP(t)
+	a := testAssertion("mallory@example.com")
+	a.SignResponse = true
+	signed := decodeResponse(t, issue(t, idp, a))
+	signature := signaturePattern.FindString(signed)
+
+	// an unsigned response for alice carrying the signed one
+	evil := strings.Replace(signaturePattern.ReplaceAllString(signed, ""), "mallory@example.com", "alice@example.com", 1)
+	wrapped := strings.Replace(evil, "</saml:Issuer>", "</saml:Issuer><samlp:Extensions>"+signed+"</samlp:Extensions>", 1)
+	if _, err := parseResponse(conf, encodeResponse(wrapped), time.Now()); err != ErrSignatureMissing {
+		t.Errorf("wrapped: got %v, want %v", err, ErrSignatureMissing)
+	}
+
+	// with the signature copied, the ID is duplicated
+	wrapped = strings.Replace(wrapped, "</saml:Issuer><samlp:Extensions>", "</saml:Issuer>"+signature+"<samlp:Extensions>", 1)
+	if _, err := parseResponse(conf, encodeResponse(wrapped), time.Now()); err != ErrSignatureReference {
+		t.Errorf("wrapped with signature: got %v, want %v", err, ErrSignatureReference)
+	}
+}
+
+func TestParseResponseRejectsTampering(t *testing.T) {
+	idp, conf := newTestIdP(t)
+
+	for _, signResponse := range []bool{false, true} {
+		a := testAssertion("mallory@example.com")
+		a.SignResponse = signResponse
+		tampered := strings.Replace(decodeResponse(t, issue(t, idp, a)), "mallory@example.com", "alice@example.com", 1)
+		if _, err := parseResponse(conf, encodeResponse(tampered), time.Now()); err != ErrDigestMismatch {
+			t.Errorf("SignResponse %v: got %v, want %v", signResponse, err, ErrDigestMismatch)
+		}
+	}
+
+}
+
+func TestParseResponseReadsSignedTextOnly(t *testing.T) {
+	idp, conf := newTestIdP(t)
+
+	// Comments are not signed: one splitting the NameID must not shorten it
+	signed := decodeResponse(t, issue(t, idp, testAssertion("alice@example.com.evil.example")))
+	commented := strings.Replace(signed, "alice@example.com.evil.example", "alice@example.com<!---->.evil.example", 1)
+	parsed, err := parseResponse(conf, encodeResponse(commented), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.NameID != "alice@example.com.evil.example" {
+		t.Errorf("got NameID %q", parsed.NameID)
+	}
+}
+
+func TestParseResponseRejectsOtherSigner(t *testing.T) {
+	_, conf := newTestIdP(t)
+	other, err := samltest.NewIdP(conf.EntityID, conf.SSOURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, signResponse := range []bool{false, true} {
+		a := testAssertion("alice@example.com")
+		a.SignResponse = signResponse
+		if _, err = parseResponse(conf, issue(t, other, a), time.Now()); err != ErrSignatureInvalid {
+			t.Errorf("SignResponse %v: got %v, want %v", signResponse, err, ErrSignatureInvalid)
+		}
+	}
+}
+
+func TestParseResponseRejectsConditions(t *testing.T) {
+	idp, conf := newTestIdP(t)
+
+	unsigned := decodeResponse(t, issue(t, idp, testAssertion("alice@example.com")))
+	unsigned = signaturePattern.ReplaceAllString(unsigned, "")
+	if _, err := parseResponse(conf, encodeResponse(unsigned), time.Now()); err != ErrSignatureMissing {
+		t.Errorf("unsigned: got %v, want %v", err, ErrSignatureMissing)
+	}
+
+	wrongAudience := testAssertion("alice@example.com")
+	wrongAudience.SPEntityID = EntityID(testAffiliationID + 1)
+
+	// the Destination of the unsigned Response is put right, so that only
+	// the Recipient of the assertion is wrong
+	wrongRecipient := decodeResponse(t, issue(t, idp, &samltest.Assertion{
+		SPEntityID: EntityID(testAffiliationID),
+		ACSURL:     ACSURL(testAffiliationID + 1),
+		NameID:     "alice@example.com",
+	}))
+	wrongRecipient = strings.Replace(wrongRecipient, `Destination="`+ACSURL(testAffiliationID+1)+`"`, `Destination="`+ACSURL(testAffiliationID)+`"`, 1)
+
+	wrongDestination := decodeResponse(t, issue(t, idp, testAssertion("alice@example.com")))
+	wrongDestination = strings.Replace(wrongDestination, `Destination="`+ACSURL(testAffiliationID)+`"`, `Destination="https://sp.example/other"`, 1)
+
+	expired := testAssertion("alice@example.com")
+	expired.Lifetime = -ClockSkew - time.Minute
+
+	cases := []struct {
+		name     string
+		response string
+		now      time.Time
+	}{
+		{"audience", issue(t, idp, wrongAudience), time.Now()},
+		{"recipient", encodeResponse(wrongRecipient), time.Now()},
+		{"destination", encodeResponse(wrongDestination), time.Now()},
+		{"expired", issue(t, idp, expired), time.Now()},
+		{"not yet valid", issue(t, idp, testAssertion("alice@example.com")), time.Now().Add(-ClockSkew - time.Minute)},
+	}
+	for _, c := range cases {
+		if _, err := parseResponse(conf, c.response, c.now); err != ErrAssertionInvalid {
+			t.Errorf("%s: got %v, want %v", c.name, err, ErrAssertionInvalid)
+		}
+	}
+
+	otherIssuer := *conf
+	otherIssuer.EntityID = "https://other-idp.example/metadata"
+	if _, err := parseResponse(&otherIssuer, issue(t, idp, testAssertion("alice@example.com")), time.Now()); err != ErrAssertionInvalid {
+		t.Errorf("issuer: got %v, want %v", err, ErrAssertionInvalid)
+	}
+}
+
+func TestAcceptOnce(t *testing.T) {
+	if err := auth.Setup(auth.NewMemoryStore()); err != nil {
+		t.Fatal(err)
+	}
+	idp, conf := newTestIdP(t)
+	parsed, err := parseResponse(conf, issue(t, idp, testAssertion("alice@example.com")), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = acceptOnce(testAffiliationID, parsed); err != nil {
+		t.Fatalf("first: %v", err)
+	}
+	if err = acceptOnce(testAffiliationID, parsed); err != ErrAssertionReplayed {
+		t.Errorf("replayed: got %v, want %v", err, ErrAssertionReplayed)
+	}
+	// affiliations are apart
+	if err = acceptOnce(testAffiliationID+1, parsed); err != nil {
+		t.Errorf("other affiliation: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+// Package saml lets the employees of an enterprise Affiliation sign in through
+// the corporate SAML 2.0 IdP of the affiliation, with Ulysses as the SP.
+//
+// Each affiliation configures its IdP (IdPConfig): the IdP metadata and
+// signing certificates, and how attributes map to the email and to the
+// AFFILIATION_* roles. Both SP-initiated (BeginLogin) and, if allowed,
+// IdP-initiated login are supported, with the HTTP-Redirect binding for
+// requests and the HTTP-POST binding for responses. Unknown users may be
+// provisioned into the affiliation just in time.
+//
+// Signed assertions or signed responses are required. Encrypted assertions
+// are not supported: the IdP must send them in the clear over HTTPS.
+//
+// A signed-in user's SAML NameID is linked to them as an auth.ExternalIdentity
+// of the provider "saml:<affiliation ID>".
+package saml
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// Audit event types
+const (
+	AUDIT_SAML_CONFIG_UPDATE = "saml_config_update"
+	AUDIT_SAML_CONFIG_DELETE = "saml_config_delete"
+	AUDIT_SAML_PROVISION     = "saml_provision"
+)
+
+var (
+	// RequestLifetime is how long a user may take at the IdP for an
+	// SP-initiated login.
+	RequestLifetime = 10 * time.Minute
+	// ClockSkew is tolerated between the IdP and us.
+	ClockSkew = 2 * time.Minute
+
+	ErrConfigIncomplete = errors.New("saml: config requires BaseURL")
+	ErrStoreUnsupported = errors.New("saml: store of package auth is not supported")
+)
+
+// Config of the SP.
+type Config struct {
+	// BaseURL is the URL the endpoints are served under, i.e. the path prefix
+	// passed to api.FinalizeGinEngine followed by "auth/saml", e.g.
+	// "https://example.com/api/auth/saml".
+	BaseURL string
+}
+
+var config Config
+
+// Setup() of saml package requires:
+// - Previous Setup() of auth package, with authStore
+//
+// The IdP configs are kept in the database of authStore if it is an
+// auth.SQLStore, or in memory with an auth.MemoryStore.
+func Setup(authStore auth.Store, conf Config) error {
+	if conf.BaseURL == "" {
+		return ErrConfigIncomplete
+	}
+	conf.BaseURL = strings.TrimSuffix(conf.BaseURL, "/")
+
+	s, err := newIdPConfigStore(authStore)
+	if err != nil {
+		return err
+	}
+	if err = s.init(); err != nil {
+		return err
+	}
+	config = conf
+	store = s
+	return nil
+}
+
+// EntityID of the SP for the affiliation. Each affiliation is a separate SP,
+// so that IdPs of different affiliations can't sign in each other's users.
+func EntityID(affiliationID uint64) string {
+	return fmt.Sprintf("%s/sp/%d/metadata", config.BaseURL, affiliationID)
+}
+
+// ACSURL is the Assertion Consumer Service of the SP for the affiliation.
+func ACSURL(affiliationID uint64) string {
+	return fmt.Sprintf("%s/sp/%d/acs", config.BaseURL, affiliationID)
+}
+
+// LoginURL starts an SP-initiated login, e.g. a link on the sign-in page.
+func LoginURL(affiliationID uint64) string {
+	return fmt.Sprintf("%s/sp/%d/login", config.BaseURL, affiliationID)
+}
+
+func providerName(affiliationID uint64) string {
+	return fmt.Sprintf("saml:%d", affiliationID)
+}
+
+// audit records an event in the audit trail of package auth.
+func audit(eventType string, actorUserID, subjectUserID, affiliationID uint64, ip string, detail map[string]interface{}) error {
+	detailJson, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	return auth.RecordAuditEvent(&auth.AuditEvent{
+		EventType:     eventType,
+		ActorUserID:   actorUserID,
+		SubjectUserID: subjectUserID,
+		AffiliationID: affiliationID,
+		IP:            ip,
+		Detail:        detailJson,
+	})
+}
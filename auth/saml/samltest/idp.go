@@ -0,0 +1,305 @@
+// Package samltest is a minimal SAML 2.0 IdP for testing package saml locally,
+// without a corporate IdP: it issues signed responses for any user it is told
+// to, so it must never be configured for an affiliation in production.
+//
+//	idp, _ := samltest.NewIdP("https://idp.test/metadata", "https://idp.test/sso")
+//	conf, _ := saml.ParseIdPMetadata(idp.Metadata())
+//	// conf.AffiliationID = ..., saml.SetIdPConfig(admin, conf)
+//	redirectURL, _ := saml.BeginLogin(affiliationID, "/")
+//	request, _ := samltest.ParseAuthnRequest(redirectURL)
+//	samlResponse, _ := idp.Response(&samltest.Assertion{
+//		SPEntityID:   saml.EntityID(affiliationID),
+//		ACSURL:       request.ACSURL,
+//		InResponseTo: request.ID, // empty for IdP-initiated login
+//		NameID:       "alice@example.com",
+//	})
+//	result, err := saml.CompleteLogin(affiliationID, samlResponse, "", "127.0.0.1")
+package samltest
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math/big"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	nsDSig      = "http://www.w3.org/2000/09/xmldsig#"
+
+	algExcC14N    = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped  = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algSHA256     = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algRSASHA256  = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	timeFormat    = "2006-01-02T15:04:05Z"
+	bindingRedir  = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+)
+
+var (
+	ErrAuthnRequestMissing = errors.New("samltest: URL carries no SAMLRequest")
+)
+
+// IdP signs with a self-signed RSA certificate generated by NewIdP.
+type IdP struct {
+	EntityID       string
+	SSOURL         string
+	Certificate    *x509.Certificate
+	CertificatePEM string
+
+	key *rsa.PrivateKey
+}
+
+func NewIdP(entityID, ssoURL string) (*IdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: entityID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &IdP{
+		EntityID:       entityID,
+		SSOURL:         ssoURL,
+		Certificate:    cert,
+		CertificatePEM: strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))),
+		key:            key,
+	}, nil
+}
+
+// Metadata of the IdP, as accepted by saml.ParseIdPMetadata
+func (idp *IdP) Metadata() []byte {
+	return []byte(`<md:EntityDescriptor xmlns:md="` + nsMetadata + `" xmlns:ds="` + nsDSig + `" entityID="` + escapeAttr(idp.EntityID) + `">` +
+		`<md:IDPSSODescriptor protocolSupportEnumeration="` + nsProtocol + `">` +
+		`<md:KeyDescriptor use="signing"><ds:KeyInfo><ds:X509Data><ds:X509Certificate>` +
+		base64.StdEncoding.EncodeToString(idp.Certificate.Raw) +
+		`</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>` +
+		`<md:SingleSignOnService Binding="` + bindingRedir + `" Location="` + escapeAttr(idp.SSOURL) + `"></md:SingleSignOnService>` +
+		`</md:IDPSSODescriptor></md:EntityDescriptor>`)
+}
+
+/************ AuthnRequest ************/
+
+// AuthnRequest as received by the IdP
+type AuthnRequest struct {
+	ID     string `xml:"ID,attr"`
+	ACSURL string `xml:"AssertionConsumerServiceURL,attr"`
+	Issuer string `xml:"Issuer"`
+}
+
+// ParseAuthnRequest reads the AuthnRequest from a URL returned by
+// saml.BeginLogin, i.e. the HTTP-Redirect binding.
+func ParseAuthnRequest(redirectURL string) (*AuthnRequest, error) {
+	parsed, err := url.Parse(redirectURL)
+	if err != nil {
+		return nil, err
+	}
+	encoded := parsed.Query().Get("SAMLRequest")
+	if encoded == "" {
+		return nil, ErrAuthnRequestMissing
+	}
+	deflated, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		return nil, err
+	}
+	var request AuthnRequest
+	if err = xml.Unmarshal(inflated, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+/************ Response ************/
+
+// Assertion to issue
+type Assertion struct {
+	SPEntityID   string
+	ACSURL       string
+	InResponseTo string // empty for IdP-initiated login
+	NameID       string
+	Attributes   map[string][]string
+	// Lifetime of the assertion, 5 minutes if zero. Negative for an expired one.
+	Lifetime time.Duration
+	// SignResponse signs the Response instead of the Assertion.
+	SignResponse bool
+}
+
+// Response returns the signed Response, base64-encoded as the SAMLResponse
+// of the HTTP-POST binding.
+//
+// The XML is written in its exclusive canonical form already, so that the
+// digest is taken over it as is.
+func (idp *IdP) Response(a *Assertion) (string, error) {
+	lifetime := a.Lifetime
+	if lifetime == 0 {
+		lifetime = 5 * time.Minute
+	}
+	now := time.Now().UTC()
+	issueInstant := now.Format(timeFormat)
+	notOnOrAfter := now.Add(lifetime).Format(timeFormat)
+
+	responseID, err := newID()
+	if err != nil {
+		return "", err
+	}
+	assertionID, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	// Exclusive canonicalization declares a namespace on the outermost
+	// elements using it, i.e. saml on both the Issuer and the Assertion.
+	samlNS := ` xmlns:saml="` + nsAssertion + `"`
+	var subjectConfirmationData strings.Builder
+	subjectConfirmationData.WriteString(`<saml:SubjectConfirmationData`)
+	if a.InResponseTo != "" {
+		subjectConfirmationData.WriteString(` InResponseTo="` + escapeAttr(a.InResponseTo) + `"`)
+	}
+	subjectConfirmationData.WriteString(` NotOnOrAfter="` + notOnOrAfter + `" Recipient="` + escapeAttr(a.ACSURL) + `"></saml:SubjectConfirmationData>`)
+
+	assertionHead := `<saml:Assertion` + samlNS + ` ID="` + assertionID + `" IssueInstant="` + issueInstant + `" Version="2.0">` +
+		`<saml:Issuer>` + escapeText(idp.EntityID) + `</saml:Issuer>`
+	assertionBody := `<saml:Subject>` +
+		`<saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified">` + escapeText(a.NameID) + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">` + subjectConfirmationData.String() + `</saml:SubjectConfirmation>` +
+		`</saml:Subject>` +
+		`<saml:Conditions NotBefore="` + issueInstant + `" NotOnOrAfter="` + notOnOrAfter + `">` +
+		`<saml:AudienceRestriction><saml:Audience>` + escapeText(a.SPEntityID) + `</saml:Audience></saml:AudienceRestriction>` +
+		`</saml:Conditions>` +
+		`<saml:AuthnStatement AuthnInstant="` + issueInstant + `" SessionIndex="` + assertionID + `">` +
+		`<saml:AuthnContext><saml:AuthnContextClassRef>urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport</saml:AuthnContextClassRef></saml:AuthnContext>` +
+		`</saml:AuthnStatement>` +
+		attributeStatement(a.Attributes) +
+		`</saml:Assertion>`
+
+	assertion := assertionHead + assertionBody
+	if !a.SignResponse {
+		signature, err := idp.sign(assertionID, assertion)
+		if err != nil {
+			return "", err
+		}
+		assertion = assertionHead + signature + assertionBody
+	}
+
+	responseHead := `<samlp:Response xmlns:samlp="` + nsProtocol + `" Destination="` + escapeAttr(a.ACSURL) + `" ID="` + responseID + `"`
+	if a.InResponseTo != "" {
+		responseHead += ` InResponseTo="` + escapeAttr(a.InResponseTo) + `"`
+	}
+	responseHead += ` IssueInstant="` + issueInstant + `" Version="2.0">` +
+		`<saml:Issuer` + samlNS + `>` + escapeText(idp.EntityID) + `</saml:Issuer>`
+	responseBody := `<samlp:Status><samlp:StatusCode Value="` + statusSuccess + `"></samlp:StatusCode></samlp:Status>` +
+		assertion +
+		`</samlp:Response>`
+
+	response := responseHead + responseBody
+	if a.SignResponse {
+		signature, err := idp.sign(responseID, response)
+		if err != nil {
+			return "", err
+		}
+		response = responseHead + signature + responseBody
+	}
+	return base64.StdEncoding.EncodeToString([]byte(response)), nil
+}
+
+// sign returns the enveloped Signature over the element, which is given in
+// canonical form without the Signature.
+func (idp *IdP) sign(id, canonical string) (string, error) {
+	digest := sha256.Sum256([]byte(canonical))
+	signedInfo := `<ds:SignedInfo xmlns:ds="` + nsDSig + `">` +
+		`<ds:CanonicalizationMethod Algorithm="` + algExcC14N + `"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="` + algRSASHA256 + `"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#` + id + `">` +
+		`<ds:Transforms><ds:Transform Algorithm="` + algEnveloped + `"></ds:Transform><ds:Transform Algorithm="` + algExcC14N + `"></ds:Transform></ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="` + algSHA256 + `"></ds:DigestMethod>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue>` +
+		`</ds:Reference></ds:SignedInfo>`
+
+	signedInfoDigest := sha256.Sum256([]byte(signedInfo))
+	signatureValue, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, signedInfoDigest[:])
+	if err != nil {
+		return "", err
+	}
+	return `<ds:Signature xmlns:ds="` + nsDSig + `">` + signedInfo +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(signatureValue) + `</ds:SignatureValue>` +
+		`<ds:KeyInfo><ds:X509Data><ds:X509Certificate>` + base64.StdEncoding.EncodeToString(idp.Certificate.Raw) + `</ds:X509Certificate></ds:X509Data></ds:KeyInfo>` +
+		`</ds:Signature>`, nil
+}
+
+func attributeStatement(attributes map[string][]string) string {
+	if len(attributes) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(`<saml:AttributeStatement>`)
+	for _, name := range names {
+		sb.WriteString(`<saml:Attribute Name="` + escapeAttr(name) + `">`)
+		for _, value := range attributes[name] {
+			sb.WriteString(`<saml:AttributeValue>` + escapeText(value) + `</saml:AttributeValue>`)
+		}
+		sb.WriteString(`</saml:Attribute>`)
+	}
+	sb.WriteString(`</saml:AttributeStatement>`)
+	return sb.String()
+}
+
+func newID() (string, error) {
+	idBytes := make([]byte, 20)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	return "_" + hex.EncodeToString(idBytes), nil
+}
+
+// Escaping of the canonical form
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
@@ -0,0 +1,167 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+const (
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	bindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	bindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	nameIDFormatUnspecified = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	statusSuccess           = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	confirmationBearer      = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// pendingRequest is kept in the ephemeral store under requestKey until the
+// response to the AuthnRequest arrives.
+type pendingRequest struct {
+	AffiliationID uint64 `json:"affiliation_id"`
+	RelayState    string `json:"relay_state"`
+}
+
+func requestKey(requestID string) string {
+	return "saml/request/" + requestID
+}
+
+/************ SP Metadata ************/
+
+type spMetadata struct {
+	XMLName         xml.Name `xml:"md:EntityDescriptor"`
+	XMLNSMD         string   `xml:"xmlns:md,attr"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor struct {
+		AuthnRequestsSigned        bool   `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool   `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupportEnumeration string `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormat               string `xml:"md:NameIDFormat"`
+		AssertionConsumerService   struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+			Index    int    `xml:"index,attr"`
+		} `xml:"md:AssertionConsumerService"`
+	} `xml:"md:SPSSODescriptor"`
+}
+
+// SPMetadata returns the metadata of the SP for the affiliation, to be
+// imported into the IdP.
+func SPMetadata(affiliationID uint64) ([]byte, error) {
+	metadata := spMetadata{
+		XMLNSMD:  nsMetadata,
+		EntityID: EntityID(affiliationID),
+	}
+	metadata.SPSSODescriptor.WantAssertionsSigned = true
+	metadata.SPSSODescriptor.ProtocolSupportEnumeration = nsProtocol
+	metadata.SPSSODescriptor.NameIDFormat = nameIDFormatUnspecified
+	metadata.SPSSODescriptor.AssertionConsumerService.Binding = bindingHTTPPost
+	metadata.SPSSODescriptor.AssertionConsumerService.Location = ACSURL(affiliationID)
+
+	data, err := xml.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+/************ SP-initiated Login ************/
+
+type authnRequest struct {
+	XMLName                     xml.Name `xml:"samlp:AuthnRequest"`
+	XMLNSSAMLP                  string   `xml:"xmlns:samlp,attr"`
+	XMLNSSAML                   string   `xml:"xmlns:saml,attr"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	IssueInstant                string   `xml:"IssueInstant,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	Issuer                      string   `xml:"saml:Issuer"`
+	NameIDPolicy                struct {
+		AllowCreate bool `xml:"AllowCreate,attr"`
+	} `xml:"samlp:NameIDPolicy"`
+}
+
+// BeginLogin starts an SP-initiated login with the IdP of the affiliation.
+// The user is to be redirected to the returned URL. relayState is opaque to
+// the IdP and comes back in the LoginResult, e.g. the page to return to. It
+// is kept on our side, so it is neither limited in length nor forgeable.
+func BeginLogin(affiliationID uint64, relayState string) (redirectURL string, err error) {
+	conf, err := store.getIdPConfig(affiliationID)
+	if err != nil {
+		return "", err
+	}
+	if !conf.Enabled {
+		return "", ErrIdPDisabled
+	}
+
+	idBytes := make([]byte, 20)
+	if _, err = rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	// xs:ID must not start with a digit
+	requestID := "_" + hex.EncodeToString(idBytes)
+
+	request := authnRequest{
+		XMLNSSAMLP:                  nsProtocol,
+		XMLNSSAML:                   nsAssertion,
+		ID:                          requestID,
+		Version:                     "2.0",
+		IssueInstant:                time.Now().UTC().Format(time.RFC3339),
+		Destination:                 conf.SSOURL,
+		AssertionConsumerServiceURL: ACSURL(affiliationID),
+		ProtocolBinding:             bindingHTTPPost,
+		Issuer:                      EntityID(affiliationID),
+	}
+	request.NameIDPolicy.AllowCreate = true
+	requestXML, err := xml.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	// HTTP-Redirect binding: DEFLATE, then base64
+	var deflated bytes.Buffer
+	writer, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err = writer.Write(requestXML); err != nil {
+		return "", err
+	}
+	if err = writer.Close(); err != nil {
+		return "", err
+	}
+
+	pendingJson, err := json.Marshal(pendingRequest{
+		AffiliationID: affiliationID,
+		RelayState:    relayState,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err = auth.Ephemeral().Add(requestKey(requestID), string(pendingJson), RequestLifetime); err != nil {
+		return "", err
+	}
+
+	ssoURL, err := url.Parse(conf.SSOURL)
+	if err != nil {
+		return "", err
+	}
+	query := ssoURL.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	ssoURL.RawQuery = query.Encode()
+	return ssoURL.String(), nil
+}
@@ -0,0 +1,31 @@
+package saml
+
+import (
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// idpConfigStore keeps the IdP configs along with the store of package auth:
+// in its database with an auth.SQLStore, or in memory with an auth.MemoryStore.
+type idpConfigStore interface {
+	init() error
+	getIdPConfig(affiliationID uint64) (*IdPConfig, error) // ErrIdPNotConfigured if none
+	saveIdPConfig(conf *IdPConfig) error
+	deleteIdPConfig(affiliationID uint64) error
+}
+
+var (
+	_ idpConfigStore = (*sqlStore)(nil)
+	_ idpConfigStore = (*memoryStore)(nil)
+)
+
+var store idpConfigStore
+
+func newIdPConfigStore(authStore auth.Store) (idpConfigStore, error) {
+	switch s := authStore.(type) {
+	case auth.SQLStore:
+		return &sqlStore{db: s.DB(), tblPrefix: s.TablePrefix(), sqlite: s.SQLite()}, nil
+	case *auth.MemoryStore:
+		return newMemoryStore(), nil
+	}
+	return nil, ErrStoreUnsupported
+}
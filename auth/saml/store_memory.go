@@ -0,0 +1,64 @@
+package saml
+
+import (
+	"sync"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// memoryStore keeps the IdP configs with an auth.MemoryStore, for unit tests.
+type memoryStore struct {
+	mutex   sync.RWMutex
+	configs map[uint64]IdPConfig
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{configs: map[uint64]IdPConfig{}}
+}
+
+func (s *memoryStore) init() error {
+	return nil
+}
+
+func (s *memoryStore) getIdPConfig(affiliationID uint64) (*IdPConfig, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	conf, ok := s.configs[affiliationID]
+	if !ok {
+		return nil, ErrIdPNotConfigured
+	}
+	conf = copyIdPConfig(&conf)
+	return &conf, nil
+}
+
+func (s *memoryStore) saveIdPConfig(conf *IdPConfig) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if conf.RoleMapping == nil {
+		conf.RoleMapping = map[string]auth.Role{}
+	}
+	s.configs[conf.AffiliationID] = copyIdPConfig(conf)
+	return nil
+}
+
+func (s *memoryStore) deleteIdPConfig(affiliationID uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.configs, affiliationID)
+	return nil
+}
+
+// copyIdPConfig copies Certificates and RoleMapping, for the caller not to
+// share them with the store
+func copyIdPConfig(conf *IdPConfig) IdPConfig {
+	copied := *conf
+	copied.Certificates = append([]string{}, conf.Certificates...)
+	copied.RoleMapping = map[string]auth.Role{}
+	for value, role := range conf.RoleMapping {
+		copied.RoleMapping[value] = role
+	}
+	return copied
+}
@@ -0,0 +1,448 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// A minimal XML Signature verifier for SAML: enveloped signatures over an
+// element referenced by its ID, with Exclusive XML Canonicalization. This is
+// what SAML IdPs produce. Other canonicalization methods are refused.
+//
+// To defeat signature wrapping, the caller reads the assertion only from
+// the canonical bytes returned by verifyEnveloped, i.e. exactly what was
+// signed, never from the original document.
+
+const (
+	nsDSig = "http://www.w3.org/2000/09/xmldsig#"
+	nsXML  = "http://www.w3.org/XML/1998/namespace"
+
+	algExcC14N     = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped   = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algRSASHA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	algECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	algSHA256      = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algSHA512      = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+var (
+	ErrXMLMalformed       = errors.New("saml: XML is malformed or has a DOCTYPE")
+	ErrSignatureMissing   = errors.New("saml: element is not signed")
+	ErrSignatureAlgorithm = errors.New("saml: unsupported signature, digest or canonicalization algorithm")
+	ErrSignatureReference = errors.New("saml: signature does not reference the signed element")
+	ErrDigestMismatch     = errors.New("saml: digest mismatch, the element was modified")
+	ErrSignatureInvalid   = errors.New("saml: signature is not made by a certificate of the IdP")
+)
+
+var digestHashes = map[string]crypto.Hash{
+	algSHA256: crypto.SHA256,
+	algSHA512: crypto.SHA512,
+}
+
+var signatureHashes = map[string]crypto.Hash{
+	algRSASHA256:   crypto.SHA256,
+	algRSASHA512:   crypto.SHA512,
+	algECDSASHA256: crypto.SHA256,
+}
+
+/************ XML Tree ************/
+
+// xmlNode keeps the prefixes as written, which canonicalization needs:
+// name.Space and the Space of attrs hold prefixes, not namespace URIs.
+type xmlNode struct {
+	parent   *xmlNode
+	name     xml.Name
+	attrs    []xml.Attr
+	children []interface{} // *xmlNode or xml.CharData. Comments are dropped.
+}
+
+func parseXML(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var root, current *xmlNode
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrXMLMalformed
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{
+				parent: current,
+				name:   t.Name,
+				attrs:  append([]xml.Attr{}, t.Attr...),
+			}
+			if current == nil {
+				if root != nil {
+					return nil, ErrXMLMalformed
+				}
+				root = node
+			} else {
+				current.children = append(current.children, node)
+			}
+			current = node
+		case xml.EndElement:
+			if current == nil || current.name != t.Name {
+				return nil, ErrXMLMalformed
+			}
+			current = current.parent
+		case xml.CharData:
+			if current != nil {
+				current.children = append(current.children, t.Copy())
+			}
+		case xml.Directive: // DOCTYPE and entity declarations are never legitimate in SAML
+			return nil, ErrXMLMalformed
+		}
+	}
+	if root == nil || current != nil {
+		return nil, ErrXMLMalformed
+	}
+	return root, nil
+}
+
+// lookupNS resolves the prefix in the scope of the node. "" if unbound.
+func (n *xmlNode) lookupNS(prefix string) string {
+	if prefix == "xml" {
+		return nsXML
+	}
+	for node := n; node != nil; node = node.parent {
+		for _, attr := range node.attrs {
+			if (prefix == "" && attr.Name.Space == "" && attr.Name.Local == "xmlns") ||
+				(prefix != "" && attr.Name.Space == "xmlns" && attr.Name.Local == prefix) {
+				return attr.Value
+			}
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) is(namespace, local string) bool {
+	return n.name.Local == local && n.lookupNS(n.name.Space) == namespace
+}
+
+func (n *xmlNode) attr(local string) string {
+	for _, attr := range n.attrs {
+		if attr.Name.Space == "" && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) childrenNamed(namespace, local string) []*xmlNode {
+	matched := []*xmlNode{}
+	for _, child := range n.children {
+		if node, ok := child.(*xmlNode); ok && node.is(namespace, local) {
+			matched = append(matched, node)
+		}
+	}
+	return matched
+}
+
+// child returns the only child of the name, or nil if there is none or more.
+func (n *xmlNode) child(namespace, local string) *xmlNode {
+	matched := n.childrenNamed(namespace, local)
+	if len(matched) != 1 {
+		return nil
+	}
+	return matched[0]
+}
+
+func (n *xmlNode) text() string {
+	var sb strings.Builder
+	for _, child := range n.children {
+		if text, ok := child.(xml.CharData); ok {
+			sb.Write(text)
+		}
+	}
+	return sb.String()
+}
+
+// countID counts the elements in the tree with the ID
+func (n *xmlNode) countID(id string) int {
+	count := 0
+	if n.attr("ID") == id {
+		count++
+	}
+	for _, child := range n.children {
+		if node, ok := child.(*xmlNode); ok {
+			count += node.countID(id)
+		}
+	}
+	return count
+}
+
+/************ Exclusive XML Canonicalization ************/
+
+// canonicalize serializes the node with Exclusive XML Canonicalization 1.0
+// (without comments), leaving out the excluded child, e.g. the enveloped
+// signature. Prefixes in inclusivePrefixes ("#default" for the default
+// namespace) are treated as visibly utilized by every element.
+func canonicalize(n *xmlNode, exclude *xmlNode, inclusivePrefixes []string) []byte {
+	var buf bytes.Buffer
+	writeCanonical(&buf, n, map[string]string{}, exclude, inclusivePrefixes)
+	return buf.Bytes()
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+func isNamespaceDecl(attr xml.Attr) bool {
+	return attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns")
+}
+
+func writeCanonical(buf *bytes.Buffer, n *xmlNode, rendered map[string]string, exclude *xmlNode, inclusivePrefixes []string) {
+	utilized := map[string]bool{n.name.Space: true}
+	for _, attr := range n.attrs {
+		if !isNamespaceDecl(attr) && attr.Name.Space != "" && attr.Name.Space != "xml" {
+			utilized[attr.Name.Space] = true
+		}
+	}
+	for _, prefix := range inclusivePrefixes {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		if n.lookupNS(prefix) != "" {
+			utilized[prefix] = true
+		}
+	}
+
+	inScope := map[string]string{}
+	for prefix, uri := range rendered {
+		inScope[prefix] = uri
+	}
+	prefixes := []string{}
+	for prefix := range utilized {
+		uri := n.lookupNS(prefix)
+		previous, ok := rendered[prefix]
+		if prefix == "" && uri == "" {
+			// xmlns="" only undoes a default namespace rendered above
+			if ok && previous != "" {
+				prefixes = append(prefixes, prefix)
+				inScope[prefix] = ""
+			}
+			continue
+		}
+		if uri == "" || (ok && previous == uri) {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+		inScope[prefix] = uri
+	}
+	sort.Strings(prefixes)
+
+	attrs := []xml.Attr{}
+	for _, attr := range n.attrs {
+		if !isNamespaceDecl(attr) {
+			attrs = append(attrs, attr)
+		}
+	}
+	attrNS := func(attr xml.Attr) string {
+		if attr.Name.Space == "" {
+			return ""
+		}
+		return n.lookupNS(attr.Name.Space)
+	}
+	sort.SliceStable(attrs, func(i, j int) bool {
+		nsI, nsJ := attrNS(attrs[i]), attrNS(attrs[j])
+		if nsI != nsJ {
+			return nsI < nsJ
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+
+	buf.WriteString("<" + qualifiedName(n.name))
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			buf.WriteString(` xmlns="`)
+		} else {
+			buf.WriteString(` xmlns:` + prefix + `="`)
+		}
+		buf.WriteString(escapeCanonicalAttr(inScope[prefix]) + `"`)
+	}
+	for _, attr := range attrs {
+		buf.WriteString(" " + qualifiedName(attr.Name) + `="` + escapeCanonicalAttr(attr.Value) + `"`)
+	}
+	buf.WriteString(">")
+
+	for _, child := range n.children {
+		switch c := child.(type) {
+		case *xmlNode:
+			if c != exclude {
+				writeCanonical(buf, c, inScope, exclude, inclusivePrefixes)
+			}
+		case xml.CharData:
+			buf.WriteString(escapeCanonicalText(string(c)))
+		}
+	}
+	buf.WriteString("</" + qualifiedName(n.name) + ">")
+}
+
+var (
+	canonicalTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	canonicalAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeCanonicalText(s string) string {
+	return canonicalTextEscaper.Replace(s)
+}
+
+func escapeCanonicalAttr(s string) string {
+	return canonicalAttrEscaper.Replace(s)
+}
+
+/************ Signature Verification ************/
+
+// inclusivePrefixList reads the PrefixList of an InclusiveNamespaces child
+// of a canonicalization method or transform.
+func inclusivePrefixList(method *xmlNode) []string {
+	for _, child := range method.children {
+		if node, ok := child.(*xmlNode); ok && node.is(algExcC14N, "InclusiveNamespaces") {
+			return strings.Fields(node.attr("PrefixList"))
+		}
+	}
+	return nil
+}
+
+func decodeBase64Text(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
+
+// verifyEnveloped verifies the enveloped signature of the element, which must
+// be a direct child of it, against the certificates. It returns the canonical
+// form of the element without the signature: the bytes the digest covers.
+func verifyEnveloped(root, element *xmlNode, certs []*x509.Certificate) ([]byte, error) {
+	signature := element.child(nsDSig, "Signature")
+	if signature == nil {
+		return nil, ErrSignatureMissing
+	}
+	signedInfo := signature.child(nsDSig, "SignedInfo")
+	if signedInfo == nil {
+		return nil, ErrSignatureMissing
+	}
+
+	c14nMethod := signedInfo.child(nsDSig, "CanonicalizationMethod")
+	signatureMethod := signedInfo.child(nsDSig, "SignatureMethod")
+	reference := signedInfo.child(nsDSig, "Reference")
+	if c14nMethod == nil || signatureMethod == nil || reference == nil || len(signedInfo.childrenNamed(nsDSig, "Reference")) != 1 {
+		return nil, ErrSignatureReference
+	}
+	if c14nMethod.attr("Algorithm") != algExcC14N {
+		return nil, ErrSignatureAlgorithm
+	}
+	signatureAlgorithm := signatureMethod.attr("Algorithm")
+	signatureHash, ok := signatureHashes[signatureAlgorithm]
+	if !ok {
+		return nil, ErrSignatureAlgorithm
+	}
+
+	// The reference must be the element itself, and its ID unique: otherwise
+	// the signature may cover another element than the one read.
+	id := element.attr("ID")
+	if id == "" || reference.attr("URI") != "#"+id || root.countID(id) != 1 {
+		return nil, ErrSignatureReference
+	}
+
+	var referencePrefixes []string
+	excC14N := false
+	if transforms := reference.child(nsDSig, "Transforms"); transforms != nil {
+		for _, transform := range transforms.childrenNamed(nsDSig, "Transform") {
+			switch transform.attr("Algorithm") {
+			case algEnveloped:
+			case algExcC14N:
+				excC14N = true
+				referencePrefixes = inclusivePrefixList(transform)
+			default:
+				return nil, ErrSignatureAlgorithm
+			}
+		}
+	}
+	if !excC14N {
+		return nil, ErrSignatureAlgorithm
+	}
+
+	digestMethod := reference.child(nsDSig, "DigestMethod")
+	digestValue := reference.child(nsDSig, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return nil, ErrSignatureReference
+	}
+	digestHash, ok := digestHashes[digestMethod.attr("Algorithm")]
+	if !ok {
+		return nil, ErrSignatureAlgorithm
+	}
+	expectedDigest, err := decodeBase64Text(digestValue.text())
+	if err != nil {
+		return nil, ErrSignatureReference
+	}
+
+	canonical := canonicalize(element, signature, referencePrefixes)
+	hasher := digestHash.New()
+	hasher.Write(canonical)
+	if subtle.ConstantTimeCompare(hasher.Sum(nil), expectedDigest) != 1 {
+		return nil, ErrDigestMismatch
+	}
+
+	signatureValue := signature.child(nsDSig, "SignatureValue")
+	if signatureValue == nil {
+		return nil, ErrSignatureMissing
+	}
+	signatureBytes, err := decodeBase64Text(signatureValue.text())
+	if err != nil {
+		return nil, ErrSignatureInvalid
+	}
+	hasher = signatureHash.New()
+	hasher.Write(canonicalize(signedInfo, nil, inclusivePrefixList(c14nMethod)))
+	signedInfoDigest := hasher.Sum(nil)
+
+	// Only the configured certificates are trusted, never a KeyInfo
+	for _, cert := range certs {
+		if verifySignatureValue(signatureAlgorithm, cert.PublicKey, signatureHash, signedInfoDigest, signatureBytes) {
+			return canonical, nil
+		}
+	}
+	return nil, ErrSignatureInvalid
+}
+
+func verifySignatureValue(algorithm string, publicKey crypto.PublicKey, hash crypto.Hash, digest, signature []byte) bool {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if algorithm != algRSASHA256 && algorithm != algRSASHA512 {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		if algorithm != algECDSASHA256 {
+			return false
+		}
+		// r || s, each padded to the size of the curve
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
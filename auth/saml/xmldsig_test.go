@@ -0,0 +1,167 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The expected forms are written by hand after the Exclusive XML
+// Canonicalization spec, not taken from the canonicalizer or samltest.
+
+const c14nInput = `<?xml version="1.0" encoding="UTF-8"?>
+<root xmlns="urn:d" xmlns:a="urn:a" xmlns:unused="urn:u"><!-- dropped --><a:child c="3&#9;" a:x="1" b='&gt;&quot;'><inner xmlns="">t&amp;&lt;<![CDATA[x<y]]>&gt;&#xD;</inner><empty/></a:child></root>`
+
+func TestCanonicalize(t *testing.T) {
+	root, err := parseXML([]byte(c14nInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := root.childrenNamed("urn:a", "child")
+	if len(child) != 1 {
+		t.Fatal("no child")
+	}
+
+	cases := []struct {
+		name              string
+		node              *xmlNode
+		inclusivePrefixes []string
+		want              string
+	}{
+		{
+			name: "document",
+			node: root,
+			want: `<root xmlns="urn:d"><a:child xmlns:a="urn:a" b=">&quot;" c="3&#x9;" a:x="1"><inner xmlns="">t&amp;&lt;x&lt;y&gt;&#xD;</inner><empty></empty></a:child></root>`,
+		},
+		{
+			// as an enveloped subtree, the default namespace is declared
+			// where it is visibly utilized
+			name: "subtree",
+			node: child[0],
+			want: `<a:child xmlns:a="urn:a" b=">&quot;" c="3&#x9;" a:x="1"><inner>t&amp;&lt;x&lt;y&gt;&#xD;</inner><empty xmlns="urn:d"></empty></a:child>`,
+		},
+		{
+			name:              "inclusive prefixes",
+			node:              child[0],
+			inclusivePrefixes: []string{"unused", "#default"},
+			want:              `<a:child xmlns="urn:d" xmlns:a="urn:a" xmlns:unused="urn:u" b=">&quot;" c="3&#x9;" a:x="1"><inner xmlns="">t&amp;&lt;x&lt;y&gt;&#xD;</inner><empty></empty></a:child>`,
+		},
+	}
+	for _, c := range cases {
+		if got := string(canonicalize(c.node, nil, c.inclusivePrefixes)); got != c.want {
+			t.Errorf("%s:\n got %s\nwant %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCanonicalizeExcludesSignature(t *testing.T) {
+	root, err := parseXML([]byte(`<p:e xmlns:p="urn:p" xmlns:ds="urn:ds"> <ds:Signature><ds:X/></ds:Signature> <p:f/></p:e>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := root.childrenNamed("urn:ds", "Signature")[0]
+	want := `<p:e xmlns:p="urn:p">  <p:f></p:f></p:e>`
+	if got := string(canonicalize(root, signature, nil)); got != want {
+		t.Errorf("\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestParseXMLRejectsDoctype(t *testing.T) {
+	_, err := parseXML([]byte(`<!DOCTYPE r [<!ENTITY x "y">]><r>&x;</r>`))
+	if err != ErrXMLMalformed {
+		t.Errorf("got %v, want %v", err, ErrXMLMalformed)
+	}
+}
+
+// TestParseResponseNonCanonical verifies a response as an IdP may write it,
+// i.e. not in canonical form, signed over the canonical form written by hand.
+func TestParseResponseNonCanonical(t *testing.T) {
+	config = Config{BaseURL: "https://sp.example/auth/saml"}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &IdPConfig{
+		AffiliationID: testAffiliationID,
+		EntityID:      "https://idp.example/metadata",
+		Certificates:  []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))},
+	}
+
+	const (
+		nsSAML       = `urn:oasis:names:tc:SAML:2.0:assertion`
+		notOnOrAfter = `2030-01-01T00:00:00Z`
+	)
+	acs, audience := ACSURL(testAffiliationID), EntityID(testAffiliationID)
+
+	// The assertion, with the signature in between the parts
+	assertionHead := `<saml:Assertion Version="2.0" IssueInstant="2029-12-31T23:58:00Z" ID="_a1" xmlns:xs="http://www.w3.org/2001/XMLSchema">` + "\n" +
+		`  <saml:Issuer>https://idp.example/metadata</saml:Issuer>` + "\n  "
+	assertionTail := "\n" +
+		`  <saml:Subject><!-- the user --><saml:NameID>alice&#64;example.com</saml:NameID>` +
+		`<saml:SubjectConfirmation Method='urn:oasis:names:tc:SAML:2.0:cm:bearer'><saml:SubjectConfirmationData Recipient="` + acs + `" NotOnOrAfter="` + notOnOrAfter + `"/></saml:SubjectConfirmation></saml:Subject>` + "\n" +
+		`  <saml:Conditions NotOnOrAfter="` + notOnOrAfter + `"><saml:AudienceRestriction><saml:Audience>` + audience + `</saml:Audience></saml:AudienceRestriction></saml:Conditions>` + "\n" +
+		`</saml:Assertion>`
+	canonicalAssertion := `<saml:Assertion xmlns:saml="` + nsSAML + `" ID="_a1" IssueInstant="2029-12-31T23:58:00Z" Version="2.0">` + "\n" +
+		`  <saml:Issuer>https://idp.example/metadata</saml:Issuer>` + "\n  \n" +
+		`  <saml:Subject><saml:NameID>alice@example.com</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer"><saml:SubjectConfirmationData NotOnOrAfter="` + notOnOrAfter + `" Recipient="` + acs + `"></saml:SubjectConfirmationData></saml:SubjectConfirmation></saml:Subject>` + "\n" +
+		`  <saml:Conditions NotOnOrAfter="` + notOnOrAfter + `"><saml:AudienceRestriction><saml:Audience>` + audience + `</saml:Audience></saml:AudienceRestriction></saml:Conditions>` + "\n" +
+		`</saml:Assertion>`
+	digest := sha256.Sum256([]byte(canonicalAssertion))
+	digestValue := base64.StdEncoding.EncodeToString(digest[:])
+
+	signedInfo := `<ds:SignedInfo>` + "\n" +
+		`<ds:CanonicalizationMethod Algorithm="` + algExcC14N + `"/><ds:SignatureMethod Algorithm="` + algRSASHA256 + `"/>` +
+		`<ds:Reference URI="#_a1"><ds:Transforms><ds:Transform Algorithm="` + algEnveloped + `"/><ds:Transform Algorithm="` + algExcC14N + `"/></ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="` + algSHA256 + `"/><ds:DigestValue>` + digestValue + `</ds:DigestValue></ds:Reference></ds:SignedInfo>`
+	canonicalSignedInfo := `<ds:SignedInfo xmlns:ds="` + nsDSig + `">` + "\n" +
+		`<ds:CanonicalizationMethod Algorithm="` + algExcC14N + `"></ds:CanonicalizationMethod><ds:SignatureMethod Algorithm="` + algRSASHA256 + `"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#_a1"><ds:Transforms><ds:Transform Algorithm="` + algEnveloped + `"></ds:Transform><ds:Transform Algorithm="` + algExcC14N + `"></ds:Transform></ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="` + algSHA256 + `"></ds:DigestMethod><ds:DigestValue>` + digestValue + `</ds:DigestValue></ds:Reference></ds:SignedInfo>`
+	signedInfoDigest := sha256.Sum256([]byte(canonicalSignedInfo))
+	signatureValue, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, signedInfoDigest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := `<ds:Signature xmlns:ds="` + nsDSig + `">` + signedInfo +
+		"<ds:SignatureValue>\n" + base64.StdEncoding.EncodeToString(signatureValue) + "\n</ds:SignatureValue></ds:Signature>"
+	response := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="` + nsSAML + `" Version='2.0' ID="_r1" Destination="` + acs + `">` + "\n" +
+		`<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>` + "\n" +
+		assertionHead + signature + assertionTail + "\n" +
+		`</samlp:Response>`
+
+	now, _ := time.Parse(time.RFC3339, "2029-12-31T23:59:00Z")
+	parsed, err := parseResponse(conf, base64.StdEncoding.EncodeToString([]byte(response)), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.ID != "_a1" || parsed.NameID != "alice@example.com" || parsed.NotOnOrAfter.Format(time.RFC3339) != notOnOrAfter {
+		t.Errorf("unexpected assertion %+v", parsed)
+	}
+
+	tampered := strings.Replace(response, "alice&#64;example.com", "alice&#64;example.org", 1)
+	if _, err = parseResponse(conf, base64.StdEncoding.EncodeToString([]byte(tampered)), now); err != ErrDigestMismatch {
+		t.Errorf("tampered: got %v, want %v", err, ErrDigestMismatch)
+	}
+}
@@ -0,0 +1,590 @@
+package scim
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TunnelWork/Ulysses.Lib/api"
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// Endpoints in the Auth category of package api, relative to Config.BaseURL,
+// authenticated with an affiliation API token:
+//
+//	GET    ServiceProviderConfig
+//	GET    ResourceTypes
+//	GET    Users          ?filter= &startIndex= &count= &attributes= &excludedAttributes=
+//	POST   Users
+//	GET    Users/:id
+//	PUT    Users/:id
+//	PATCH  Users/:id
+//	DELETE Users/:id
+//	GET    Groups         as Users
+//	GET    Groups/:id
+//	PUT    Groups/:id     members only
+//	PATCH  Groups/:id     members only
+//
+// and for the administrators of the affiliation, relative to auth/scim:
+//
+//	POST token/create (userGroup)
+//	GET  token/list   (userGroup) ?affiliation_id=
+//	POST token/revoke (userGroup)
+//
+// The Access Control Funcs of userGroup must set api.ContextKeyUserID.
+
+const (
+	contextKeyAffiliationID = "scim_affiliation_id"
+	contextKeyCreator       = "scim_creator"
+	contextKeyTokenID       = "scim_token_id"
+)
+
+// RegisterEndpoints registers the endpoints with package api.
+func RegisterEndpoints(userGroup string) error {
+	routes := []struct {
+		method    string
+		path      string
+		userGroup string // empty for the endpoints authenticated with a token
+		handler   *gin.HandlerFunc
+	}{
+		{http.MethodGet, "scim/v2/ServiceProviderConfig", "", &serviceProviderConfigHandler},
+		{http.MethodGet, "scim/v2/ResourceTypes", "", &resourceTypesHandler},
+		{http.MethodGet, "scim/v2/Users", "", &listUsersHandler},
+		{http.MethodPost, "scim/v2/Users", "", &createUserHandler},
+		{http.MethodGet, "scim/v2/Users/:id", "", &getUserHandler},
+		{http.MethodPut, "scim/v2/Users/:id", "", &replaceUserHandler},
+		{http.MethodPatch, "scim/v2/Users/:id", "", &patchUserHandler},
+		{http.MethodDelete, "scim/v2/Users/:id", "", &deleteUserHandler},
+		{http.MethodGet, "scim/v2/Groups", "", &listGroupsHandler},
+		{http.MethodPost, "scim/v2/Groups", "", &groupNotMutableHandler},
+		{http.MethodGet, "scim/v2/Groups/:id", "", &getGroupHandler},
+		{http.MethodPut, "scim/v2/Groups/:id", "", &replaceGroupHandler},
+		{http.MethodPatch, "scim/v2/Groups/:id", "", &patchGroupHandler},
+		{http.MethodDelete, "scim/v2/Groups/:id", "", &groupNotMutableHandler},
+		{http.MethodPost, "scim/token/create", userGroup, &createTokenHandler},
+		{http.MethodGet, "scim/token/list", userGroup, &listTokensHandler},
+		{http.MethodPost, "scim/token/revoke", userGroup, &revokeTokenHandler},
+	}
+
+	var err error
+	for _, route := range routes {
+		switch {
+		case route.userGroup != "" && route.method == http.MethodGet:
+			err = api.AuthedCGET(api.Auth, route.path, route.userGroup, route.handler)
+		case route.userGroup != "":
+			err = api.AuthedCPOST(api.Auth, route.path, route.userGroup, route.handler)
+		case route.method == http.MethodGet:
+			err = api.CGET(api.Auth, route.path, &tokenAuthHandler, route.handler)
+		case route.method == http.MethodPost:
+			err = api.CPOST(api.Auth, route.path, &tokenAuthHandler, route.handler)
+		case route.method == http.MethodPut:
+			err = api.CPUT(api.Auth, route.path, &tokenAuthHandler, route.handler)
+		case route.method == http.MethodPatch:
+			err = api.CPATCH(api.Auth, route.path, &tokenAuthHandler, route.handler)
+		default:
+			err = api.CDELETE(api.Auth, route.path, &tokenAuthHandler, route.handler)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/************ Helpers ************/
+
+func scimResponse(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", "application/scim+json")
+	c.JSON(status, body)
+}
+
+func scimErrorResponse(c *gin.Context, err error) {
+	scimErr, ok := err.(*Error)
+	if !ok {
+		scimErr = &Error{http.StatusInternalServerError, "", "internal error"}
+	}
+	body := gin.H{
+		"schemas": []string{SchemaError},
+		"status":  strconv.Itoa(scimErr.Status),
+		"detail":  scimErr.Detail,
+	}
+	if scimErr.ScimType != "" {
+		body["scimType"] = scimErr.ScimType
+	}
+	c.Header("Content-Type", "application/scim+json")
+	c.AbortWithStatusJSON(scimErr.Status, body)
+}
+
+// bindResource decodes the request body into the resource.
+func bindResource(c *gin.Context, resource interface{}) bool {
+	body, err := c.GetRawData()
+	if err == nil {
+		err = json.Unmarshal(body, resource)
+	}
+	if err != nil {
+		scimErrorResponse(c, ErrInvalidSyntax)
+		return false
+	}
+	return true
+}
+
+var tokenAuthHandler gin.HandlerFunc = func(c *gin.Context) {
+	authorization := c.GetHeader("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		scimErrorResponse(c, ErrUnauthorized)
+		return
+	}
+	token, err := authenticate(strings.TrimSpace(authorization[7:]))
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	// The token is no good without its creator, on whose behalf it acts
+	creator, err := auth.GetUserByID(token.CreatedBy)
+	if err == sql.ErrNoRows {
+		scimErrorResponse(c, ErrUnauthorized)
+		return
+	} else if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	c.Set(contextKeyAffiliationID, token.AffiliationID)
+	c.Set(contextKeyCreator, creator)
+	c.Set(contextKeyTokenID, token.id)
+	c.Next()
+}
+
+// creatorOf returns the creator of the token of the request.
+func creatorOf(c *gin.Context) *auth.User {
+	return c.MustGet(contextKeyCreator).(*auth.User)
+}
+
+// listResponse filters, paginates and projects the resources
+// (RFC 7644 3.4.2).
+func listResponse(c *gin.Context, resources []interface{}) {
+	var filter filterExpr
+	if filterParam := c.Query("filter"); filterParam != "" {
+		var err error
+		if filter, err = parseFilter(filterParam); err != nil {
+			scimErrorResponse(c, err)
+			return
+		}
+	}
+
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(MaxResults)))
+	if err != nil || count > MaxResults {
+		count = MaxResults
+	}
+	if count < 0 {
+		count = 0
+	}
+
+	matched := []map[string]interface{}{}
+	for _, resource := range resources {
+		object, err := toObject(resource)
+		if err != nil {
+			scimErrorResponse(c, err)
+			return
+		}
+		if filter == nil || filter.match(object) {
+			matched = append(matched, object)
+		}
+	}
+
+	page := []map[string]interface{}{}
+	if startIndex <= len(matched) {
+		end := startIndex - 1 + count
+		if end > len(matched) {
+			end = len(matched)
+		}
+		page = matched[startIndex-1 : end]
+	}
+	for _, object := range page {
+		project(object, c.Query("attributes"), c.Query("excludedAttributes"))
+	}
+
+	scimResponse(c, http.StatusOK, gin.H{
+		"schemas":      []string{SchemaListResponse},
+		"totalResults": len(matched),
+		"startIndex":   startIndex,
+		"itemsPerPage": len(page),
+		"Resources":    page,
+	})
+}
+
+// project keeps the attributes, or drops the excluded ones. id and schemas
+// are always kept. Only top-level attributes are supported.
+func project(object map[string]interface{}, attributes, excludedAttributes string) {
+	split := func(list string) map[string]bool {
+		names := map[string]bool{}
+		for _, name := range strings.Split(list, ",") {
+			name = strings.ToLower(strings.SplitN(stripSchema(strings.TrimSpace(name)), ".", 2)[0])
+			if name != "" {
+				names[name] = true
+			}
+		}
+		return names
+	}
+	kept, excluded := split(attributes), split(excludedAttributes)
+	for key := range object {
+		name := strings.ToLower(key)
+		if name == "id" || name == "schemas" {
+			continue
+		}
+		if (len(kept) > 0 && !kept[name]) || excluded[name] {
+			delete(object, key)
+		}
+	}
+}
+
+/************ Discovery Endpoints ************/
+
+var serviceProviderConfigHandler gin.HandlerFunc = func(c *gin.Context) {
+	scimResponse(c, http.StatusOK, gin.H{
+		"schemas":        []string{SchemaServiceProviderConfig},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": MaxResults},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "Affiliation API Token",
+			"description": "Bearer token created by an administrator of the affiliation",
+			"primary":     true,
+		}},
+		"meta": gin.H{"resourceType": "ServiceProviderConfig", "location": config.BaseURL + "/ServiceProviderConfig"},
+	})
+}
+
+var resourceTypesHandler gin.HandlerFunc = func(c *gin.Context) {
+	resourceTypes := []gin.H{
+		{
+			"schemas":  []string{SchemaResourceType},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   SchemaUser,
+			"meta":     gin.H{"resourceType": "ResourceType", "location": config.BaseURL + "/ResourceTypes/User"},
+		},
+		{
+			"schemas":  []string{SchemaResourceType},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   SchemaGroup,
+			"meta":     gin.H{"resourceType": "ResourceType", "location": config.BaseURL + "/ResourceTypes/Group"},
+		},
+	}
+	scimResponse(c, http.StatusOK, gin.H{
+		"schemas":      []string{SchemaListResponse},
+		"totalResults": len(resourceTypes),
+		"startIndex":   1,
+		"itemsPerPage": len(resourceTypes),
+		"Resources":    resourceTypes,
+	})
+}
+
+/************ User Endpoints ************/
+
+var listUsersHandler gin.HandlerFunc = func(c *gin.Context) {
+	users, err := listUsers(c.GetUint64(contextKeyAffiliationID))
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID() < users[j].ID() })
+
+	resources := []interface{}{}
+	for _, user := range users {
+		resource, err := renderUser(user)
+		if err != nil {
+			scimErrorResponse(c, err)
+			return
+		}
+		resources = append(resources, resource)
+	}
+	listResponse(c, resources)
+}
+
+// userResponse responds with the current representation of the user.
+func userResponse(c *gin.Context, status int, user *auth.User) {
+	resource, err := renderUser(user)
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	c.Header("Location", resource.Meta.Location)
+	scimResponse(c, status, resource)
+}
+
+var getUserHandler gin.HandlerFunc = func(c *gin.Context) {
+	user, err := getUser(c.GetUint64(contextKeyAffiliationID), c.Param("id"))
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	userResponse(c, http.StatusOK, user)
+}
+
+var createUserHandler gin.HandlerFunc = func(c *gin.Context) {
+	var resource userResource
+	if !bindResource(c, &resource) {
+		return
+	}
+	user, err := createUser(creatorOf(c), c.GetUint64(contextKeyTokenID), c.GetUint64(contextKeyAffiliationID), &resource)
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	userResponse(c, http.StatusCreated, user)
+}
+
+var replaceUserHandler gin.HandlerFunc = func(c *gin.Context) {
+	user, err := getUser(c.GetUint64(contextKeyAffiliationID), c.Param("id"))
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	var resource userResource
+	if !bindResource(c, &resource) {
+		return
+	}
+	if err = replaceUser(creatorOf(c), c.GetUint64(contextKeyTokenID), user, &resource); err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	userResponse(c, http.StatusOK, user)
+}
+
+var patchUserHandler gin.HandlerFunc = func(c *gin.Context) {
+	user, err := getUser(c.GetUint64(contextKeyAffiliationID), c.Param("id"))
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	var request patchRequest
+	if !bindResource(c, &request) {
+		return
+	}
+	if err = patchUser(creatorOf(c), c.GetUint64(contextKeyTokenID), user, request.Operations); err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	userResponse(c, http.StatusOK, user)
+}
+
+var deleteUserHandler gin.HandlerFunc = func(c *gin.Context) {
+	user, err := getUser(c.GetUint64(contextKeyAffiliationID), c.Param("id"))
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	if err = deleteUser(creatorOf(c), c.GetUint64(contextKeyTokenID), user); err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+/************ Group Endpoints ************/
+
+var listGroupsHandler gin.HandlerFunc = func(c *gin.Context) {
+	users, err := listUsers(c.GetUint64(contextKeyAffiliationID))
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	resources := []interface{}{}
+	for i := range groups {
+		resources = append(resources, renderGroup(&groups[i], users))
+	}
+	listResponse(c, resources)
+}
+
+// groupOf returns the group of the request and the users of the affiliation.
+func groupOf(c *gin.Context) (*group, []*auth.User, bool) {
+	g, err := getGroup(c.Param("id"))
+	if err != nil {
+		scimErrorResponse(c, err)
+		return nil, nil, false
+	}
+	users, err := listUsers(c.GetUint64(contextKeyAffiliationID))
+	if err != nil {
+		scimErrorResponse(c, err)
+		return nil, nil, false
+	}
+	return g, users, true
+}
+
+// groupResponse responds with the current representation of the group.
+func groupResponse(c *gin.Context, g *group) {
+	users, err := listUsers(c.GetUint64(contextKeyAffiliationID))
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	resource := renderGroup(g, users)
+	object, err := toObject(resource)
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	project(object, c.Query("attributes"), c.Query("excludedAttributes"))
+	c.Header("Location", resource.Meta.Location)
+	scimResponse(c, http.StatusOK, object)
+}
+
+var getGroupHandler gin.HandlerFunc = func(c *gin.Context) {
+	g, err := getGroup(c.Param("id"))
+	if err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	groupResponse(c, g)
+}
+
+var replaceGroupHandler gin.HandlerFunc = func(c *gin.Context) {
+	g, users, ok := groupOf(c)
+	if !ok {
+		return
+	}
+	var resource groupResource
+	if !bindResource(c, &resource) {
+		return
+	}
+	if err := replaceMembers(creatorOf(c), g, users, resource.Members); err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	groupResponse(c, g)
+}
+
+var patchGroupHandler gin.HandlerFunc = func(c *gin.Context) {
+	g, users, ok := groupOf(c)
+	if !ok {
+		return
+	}
+	var request patchRequest
+	if !bindResource(c, &request) {
+		return
+	}
+	if err := patchGroup(creatorOf(c), g, users, request.Operations); err != nil {
+		scimErrorResponse(c, err)
+		return
+	}
+	groupResponse(c, g)
+}
+
+var groupNotMutableHandler gin.HandlerFunc = func(c *gin.Context) {
+	scimErrorResponse(c, ErrGroupNotMutable)
+}
+
+/************ Token Endpoints ************/
+
+func actorOf(c *gin.Context) (*auth.User, bool) {
+	actor, err := auth.GetUserByID(c.GetUint64(api.ContextKeyUserID))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, api.MessageResponse(api.ERROR, "AUTH_FAILED"))
+		return nil, false
+	}
+	return actor, true
+}
+
+func tokenErrorResponse(c *gin.Context, err error) {
+	switch err {
+	case ErrTokenNotAllowed:
+		c.JSON(http.StatusForbidden, api.MessageResponse(api.ERROR, "PERMISSION_DENIED"))
+	case ErrTokenBad:
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "SCIM_TOKEN_INVALID"))
+	case ErrTokenNotFound, sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, api.MessageResponse(api.ERROR, "SCIM_TOKEN_NOT_FOUND"))
+	default:
+		c.JSON(http.StatusInternalServerError, api.MessageResponse(api.ERROR, "INTERNAL_ERROR"))
+	}
+}
+
+// createTokenHandler refuses impersonated requests: the token would outlive
+// the impersonation session.
+var createTokenHandler gin.HandlerFunc = func(c *gin.Context) {
+	if api.Impersonated(c) {
+		c.JSON(http.StatusForbidden, api.MessageResponse(api.ERROR, "IMPERSONATION_FORBIDDEN"))
+		return
+	}
+	var form struct {
+		AffiliationID uint64 `json:"affiliation_id" binding:"required"`
+		Label         string `json:"label" binding:"required"`
+	}
+	if c.ShouldBindJSON(&form) != nil {
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "BAD_REQUEST"))
+		return
+	}
+	actor, ok := actorOf(c)
+	if !ok {
+		return
+	}
+
+	token, secret, err := CreateToken(actor, form.AffiliationID, form.Label)
+	if err != nil {
+		tokenErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, api.PayloadResponse(api.SUCCESS, gin.H{
+		"token_id": token.ID(),
+		"token":    token,
+		"secret":   secret,
+		"base_url": config.BaseURL,
+	}))
+}
+
+var listTokensHandler gin.HandlerFunc = func(c *gin.Context) {
+	affiliationID, err := strconv.ParseUint(c.Query("affiliation_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "BAD_REQUEST"))
+		return
+	}
+	actor, ok := actorOf(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := ListTokens(actor, affiliationID)
+	if err != nil {
+		tokenErrorResponse(c, err)
+		return
+	}
+	payload := []gin.H{}
+	for _, token := range tokens {
+		payload = append(payload, gin.H{"token_id": token.ID(), "token": token})
+	}
+	c.JSON(http.StatusOK, api.PayloadResponse(api.SUCCESS, payload))
+}
+
+var revokeTokenHandler gin.HandlerFunc = func(c *gin.Context) {
+	var form struct {
+		TokenID uint64 `json:"token_id" binding:"required"`
+	}
+	if c.ShouldBindJSON(&form) != nil {
+		c.JSON(http.StatusBadRequest, api.MessageResponse(api.ERROR, "BAD_REQUEST"))
+		return
+	}
+	actor, ok := actorOf(c)
+	if !ok {
+		return
+	}
+
+	if err := RevokeToken(actor, form.TokenID); err != nil {
+		tokenErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, api.MessageResponse(api.SUCCESS, "SCIM_TOKEN_REVOKED"))
+}
@@ -0,0 +1,465 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// Filters (RFC 7644 3.4.2.2) are evaluated on the JSON representation of a
+// resource, i.e. a map[string]interface{} as from json.Unmarshal, so that
+// Users and Groups share them. Attribute names are case-insensitive.
+
+// filterExpr matches a resource, or an element of a multi-valued attribute
+// within a value path.
+type filterExpr interface {
+	match(resource map[string]interface{}) bool
+}
+
+type logicalExpr struct {
+	and         bool
+	left, right filterExpr
+}
+
+func (e *logicalExpr) match(resource map[string]interface{}) bool {
+	if e.and {
+		return e.left.match(resource) && e.right.match(resource)
+	}
+	return e.left.match(resource) || e.right.match(resource)
+}
+
+type notExpr struct {
+	expr filterExpr
+}
+
+func (e *notExpr) match(resource map[string]interface{}) bool {
+	return !e.expr.match(resource)
+}
+
+// valuePathExpr matches if any element of the multi-valued attribute
+// matches the filter, e.g. emails[type eq "work" and value co "@example.com"]
+type valuePathExpr struct {
+	attr   string
+	filter filterExpr
+}
+
+func (e *valuePathExpr) match(resource map[string]interface{}) bool {
+	switch value := getAttr(resource, e.attr).(type) {
+	case []interface{}:
+		for _, element := range value {
+			if m, ok := element.(map[string]interface{}); ok && e.filter.match(m) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		return e.filter.match(value)
+	}
+	return false
+}
+
+type compareExpr struct {
+	path  []string // attribute, and optionally a sub-attribute
+	op    string   // lower case
+	value interface{}
+}
+
+func (e *compareExpr) match(resource map[string]interface{}) bool {
+	values := resolvePath(resource, e.path)
+	caseExact := isCaseExact(e.path[0])
+
+	switch e.op {
+	case "pr":
+		for _, value := range values {
+			if value != nil && value != "" {
+				return true
+			}
+		}
+		return false
+	case "ne":
+		return !(&compareExpr{e.path, "eq", e.value}).match(resource)
+	}
+	if e.value == nil && e.op == "eq" {
+		return len(values) == 0
+	}
+	for _, value := range values {
+		if compareValue(e.op, value, e.value, caseExact) {
+			return true
+		}
+	}
+	return false
+}
+
+// seed returns the element an eq filter describes, e.g. {"type": "work"}
+// for emails[type eq "work"], or nil if the filter is not made of eq
+// comparisons. PATCH adds such an element if none matches.
+func seed(expr filterExpr) map[string]interface{} {
+	switch e := expr.(type) {
+	case *compareExpr:
+		if e.op != "eq" || len(e.path) != 1 || e.value == nil {
+			return nil
+		}
+		return map[string]interface{}{e.path[0]: e.value}
+	case *logicalExpr:
+		if !e.and {
+			return nil
+		}
+		left, right := seed(e.left), seed(e.right)
+		if left == nil || right == nil {
+			return nil
+		}
+		for k, v := range right {
+			left[k] = v
+		}
+		return left
+	}
+	return nil
+}
+
+// compareValue compares an actual value with the one in the filter.
+// dateTime values compare as strings, which orders them correctly as all
+// are rendered in UTC.
+func compareValue(op string, actual, expected interface{}, caseExact bool) bool {
+	switch exp := expected.(type) {
+	case bool:
+		act, ok := actual.(bool)
+		return ok && op == "eq" && act == exp
+	case float64:
+		act, ok := actual.(float64)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return act == exp
+		case "gt":
+			return act > exp
+		case "ge":
+			return act >= exp
+		case "lt":
+			return act < exp
+		case "le":
+			return act <= exp
+		}
+	case string:
+		act, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		if !caseExact {
+			act, exp = strings.ToLower(act), strings.ToLower(exp)
+		}
+		switch op {
+		case "eq":
+			return act == exp
+		case "co":
+			return strings.Contains(act, exp)
+		case "sw":
+			return strings.HasPrefix(act, exp)
+		case "ew":
+			return strings.HasSuffix(act, exp)
+		case "gt":
+			return act > exp
+		case "ge":
+			return act >= exp
+		case "lt":
+			return act < exp
+		case "le":
+			return act <= exp
+		}
+	}
+	return false
+}
+
+// isCaseExact is true for the attributes defined with caseExact, whose
+// values are compared as is.
+func isCaseExact(attr string) bool {
+	switch strings.ToLower(attr) {
+	case "id", "externalid", "value":
+		return true
+	}
+	return false
+}
+
+/************ Attribute Access ************/
+
+// schemaPrefixes may qualify attribute names, e.g.
+// urn:ietf:params:scim:schemas:core:2.0:User:userName
+var schemaPrefixes = []string{SchemaUser + ":", SchemaGroup + ":"}
+
+func stripSchema(path string) string {
+	for _, prefix := range schemaPrefixes {
+		if len(path) > len(prefix) && strings.EqualFold(path[:len(prefix)], prefix) {
+			return path[len(prefix):]
+		}
+	}
+	return path
+}
+
+// findKey returns the key of the attribute in the object, matched
+// case-insensitively. The attribute itself if absent.
+func findKey(object map[string]interface{}, attr string) string {
+	if _, ok := object[attr]; ok {
+		return attr
+	}
+	for key := range object {
+		if strings.EqualFold(key, attr) {
+			return key
+		}
+	}
+	return attr
+}
+
+func getAttr(object map[string]interface{}, attr string) interface{} {
+	return object[findKey(object, attr)]
+}
+
+// resolvePath returns the values at the path. Values of multi-valued
+// attributes are flattened, and complex values without a sub-attribute
+// resolve to their "value" sub-attribute.
+func resolvePath(object map[string]interface{}, path []string) []interface{} {
+	values := []interface{}{}
+	var collect func(value interface{}, rest []string)
+	collect = func(value interface{}, rest []string) {
+		switch v := value.(type) {
+		case nil:
+		case []interface{}:
+			for _, element := range v {
+				collect(element, rest)
+			}
+		case map[string]interface{}:
+			if len(rest) == 0 {
+				if inner, ok := v["value"]; ok {
+					values = append(values, inner)
+				}
+				return
+			}
+			collect(getAttr(v, rest[0]), rest[1:])
+		default:
+			if len(rest) == 0 {
+				values = append(values, v)
+			}
+		}
+	}
+	collect(getAttr(object, path[0]), path[1:])
+	return values
+}
+
+/************ Parser ************/
+
+type filterToken struct {
+	quoted bool // a JSON string
+	text   string
+}
+
+func tokenizeFilter(filter string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	for i := 0; i < len(filter); {
+		c := filter[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			tokens = append(tokens, filterToken{text: string(c)})
+			i++
+		case c == '"':
+			// A JSON string ends at the first unescaped quote
+			j := i + 1
+			for ; j < len(filter) && filter[j] != '"'; j++ {
+				if filter[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(filter) {
+				return nil, ErrInvalidFilter
+			}
+			var s string
+			if err := json.Unmarshal([]byte(filter[i:j+1]), &s); err != nil {
+				return nil, ErrInvalidFilter
+			}
+			tokens = append(tokens, filterToken{quoted: true, text: s})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(filter) && !strings.ContainsRune(" \t()[]\"", rune(filter[j])); j++ {
+			}
+			tokens = append(tokens, filterToken{text: filter[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// parseFilter parses a filter. Precedence: not, and, or.
+func parseFilter(filter string) (filterExpr, error) {
+	tokens, err := tokenizeFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, ErrInvalidFilter
+	}
+	return expr, nil
+}
+
+func (p *filterParser) peek() (filterToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// accept consumes the next token if it is the unquoted keyword or symbol.
+func (p *filterParser) accept(keyword string) bool {
+	token, ok := p.peek()
+	if ok && !token.quoted && strings.EqualFold(token.text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	if p.accept("not") {
+		if !p.accept("(") {
+			return nil, ErrInvalidFilter
+		}
+		expr, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{expr}, nil
+	}
+	if p.accept("(") {
+		return p.parseGroup()
+	}
+	return p.parseAttrExp()
+}
+
+// parseGroup parses the rest of a parenthesized filter.
+func (p *filterParser) parseGroup() (filterExpr, error) {
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(")") {
+		return nil, ErrInvalidFilter
+	}
+	return expr, nil
+}
+
+func (p *filterParser) parseAttrExp() (filterExpr, error) {
+	token, ok := p.peek()
+	if !ok || token.quoted || !validAttrPath(token.text) {
+		return nil, ErrInvalidFilter
+	}
+	p.pos++
+	path := strings.Split(stripSchema(token.text), ".")
+	if len(path) > 2 {
+		return nil, ErrInvalidFilter
+	}
+
+	if p.accept("[") {
+		if len(path) != 1 {
+			return nil, ErrInvalidFilter
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept("]") {
+			return nil, ErrInvalidFilter
+		}
+		return &valuePathExpr{attr: path[0], filter: inner}, nil
+	}
+
+	token, ok = p.peek()
+	if !ok || token.quoted {
+		return nil, ErrInvalidFilter
+	}
+	p.pos++
+	op := strings.ToLower(token.text)
+	switch op {
+	case "pr":
+		return &compareExpr{path: path, op: op}, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, ErrInvalidFilter
+	}
+
+	token, ok = p.peek()
+	if !ok {
+		return nil, ErrInvalidFilter
+	}
+	p.pos++
+	var value interface{}
+	if token.quoted {
+		value = token.text
+	} else {
+		// false, null, true, or a number
+		if err := json.Unmarshal([]byte(strings.ToLower(token.text)), &value); err != nil {
+			return nil, ErrInvalidFilter
+		}
+		if _, isString := value.(string); isString {
+			return nil, ErrInvalidFilter
+		}
+	}
+	if value == nil && op != "eq" && op != "ne" {
+		return nil, ErrInvalidFilter
+	}
+	if _, isBool := value.(bool); isBool && op != "eq" && op != "ne" {
+		return nil, ErrInvalidFilter
+	}
+	return &compareExpr{path: path, op: op, value: value}, nil
+}
+
+func validAttrPath(path string) bool {
+	if path == "" {
+		return false
+	}
+	for _, r := range path {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(":._-$", r) {
+			return false
+		}
+	}
+	return true
+}
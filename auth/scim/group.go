@@ -0,0 +1,145 @@
+package scim
+
+import (
+	"strconv"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+/************ Group Resource ************/
+
+// group is one of the AFFILIATION_* roles
+type group struct {
+	id          string
+	displayName string
+	role        auth.Role
+}
+
+var groups = []group{
+	{"account_user", "Account User", auth.AFFILIATION_ACCOUNT_USER},
+	{"account_admin", "Account Admin", auth.AFFILIATION_ACCOUNT_ADMIN},
+	{"product_user", "Product User", auth.AFFILIATION_PRODUCT_USER},
+	{"product_admin", "Product Admin", auth.AFFILIATION_PRODUCT_ADMIN},
+	{"billing_user", "Billing User", auth.AFFILIATION_BILLING_USER},
+	{"billing_admin", "Billing Admin", auth.AFFILIATION_BILLING_ADMIN},
+}
+
+type groupResource struct {
+	Schemas     []string      `json:"schemas"`
+	ID          string        `json:"id"`
+	DisplayName string        `json:"displayName"`
+	Members     []memberRef   `json:"members"`
+	Meta        *resourceMeta `json:"meta,omitempty"`
+}
+
+// readOnlyGroupAttributes may not be patched: only members may change.
+var readOnlyGroupAttributes = map[string]bool{"id": true, "displayname": true, "meta": true, "schemas": true}
+
+func groupLocation(groupID string) string {
+	return config.BaseURL + "/Groups/" + groupID
+}
+
+func getGroup(id string) (*group, error) {
+	for i := range groups {
+		if groups[i].id == id {
+			return &groups[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// renderGroup returns the SCIM representation of the group with its
+// members among the users.
+func renderGroup(g *group, users []*auth.User) *groupResource {
+	resource := &groupResource{
+		Schemas:     []string{SchemaGroup},
+		ID:          g.id,
+		DisplayName: g.displayName,
+		Members:     []memberRef{},
+		Meta:        &resourceMeta{ResourceType: "Group", Location: groupLocation(g.id)},
+	}
+	for _, user := range users {
+		if user.Role.Includes(g.role) {
+			resource.Members = append(resource.Members, memberRef{
+				Value:   strconv.FormatUint(user.ID(), 10),
+				Display: user.Email,
+				Ref:     userLocation(user.ID()),
+			})
+		}
+	}
+	return resource
+}
+
+/************ Group Operations ************/
+
+// replaceMembers grants the role of the group to the members among the
+// users, and revokes it from the others, on behalf of the creator of the
+// token. Members must be users of the affiliation. Nothing changes unless
+// the creator may grant or revoke the role of every user concerned.
+func replaceMembers(creator *auth.User, g *group, users []*auth.User, members []memberRef) error {
+	wanted := map[string]bool{}
+	for _, member := range members {
+		wanted[member.Value] = true
+	}
+	for _, user := range users {
+		delete(wanted, strconv.FormatUint(user.ID(), 10))
+	}
+	if len(wanted) > 0 {
+		return ErrInvalidValue
+	}
+	for _, member := range members {
+		wanted[member.Value] = true
+	}
+
+	changed := []*auth.User{}
+	for _, user := range users {
+		roleDelta := auth.RoleDelta{Revoke: g.role}
+		if wanted[strconv.FormatUint(user.ID(), 10)] {
+			roleDelta = auth.RoleDelta{Grant: g.role}
+		}
+		if user.Role.Includes(g.role) == (roleDelta.Grant != 0) {
+			continue
+		}
+		if err := checkManaged(creator, user); err != nil {
+			return err
+		}
+		canGrant, err := auth.CanGrant(creator, user, roleDelta)
+		if err != nil {
+			return err
+		}
+		if !canGrant {
+			return ErrRoleNotGranted
+		}
+		changed = append(changed, user)
+	}
+
+	for _, user := range changed {
+		roles := user.Role & auth.AFFILIATION_ROLES
+		if wanted[strconv.FormatUint(user.ID(), 10)] {
+			roles = roles.AddRole(g.role)
+		} else {
+			roles = roles.RemoveRole(g.role)
+		}
+		if err := user.SyncExternalRoles(providerName(user.AffiliationID), roles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchGroup applies the PATCH operations to the members of the group.
+func patchGroup(creator *auth.User, g *group, users []*auth.User, operations []patchOperation) error {
+	object, err := toObject(renderGroup(g, users))
+	if err != nil {
+		return err
+	}
+	if err = applyPatch(object, operations, readOnlyGroupAttributes); err != nil {
+		return err
+	}
+
+	var patched groupResource
+	if err = fromObject(object, &patched); err != nil {
+		return err
+	}
+	return replaceMembers(creator, g, users, patched.Members)
+}
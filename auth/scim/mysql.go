@@ -0,0 +1,65 @@
+package scim
+
+import (
+	"database/sql"
+	"strings"
+)
+
+/************ Table Definitions ************/
+
+const (
+	tokenTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_scim_token (
+        id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+        affiliation_id BIGINT UNSIGNED NOT NULL,
+        token_hash VARCHAR(64) NOT NULL,
+        label VARCHAR(64) NOT NULL,
+        created_by BIGINT UNSIGNED NOT NULL,
+        created_at DATETIME NOT NULL,
+        last_used_at DATETIME NULL DEFAULT NULL,
+        revoked_at DATETIME NULL DEFAULT NULL,
+        PRIMARY KEY (id),
+        UNIQUE KEY (token_hash),
+        CONSTRAINT FOREIGN KEY (affiliation_id) REFERENCES dbprefix_auth_affiliation(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;`
+	sqliteTokenTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_scim_token (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        affiliation_id INTEGER NOT NULL REFERENCES dbprefix_auth_affiliation(id) ON DELETE CASCADE,
+        token_hash VARCHAR(64) NOT NULL UNIQUE,
+        label VARCHAR(64) NOT NULL,
+        created_by INTEGER NOT NULL,
+        created_at DATETIME NOT NULL,
+        last_used_at DATETIME NULL DEFAULT NULL,
+        revoked_at DATETIME NULL DEFAULT NULL
+    );`
+)
+
+// sqlStore keeps the tokens in the database of an auth.SQLStore.
+type sqlStore struct {
+	db        *sql.DB
+	tblPrefix string
+	sqlite    bool
+}
+
+/************ Helper Functions ************/
+func (s *sqlStore) statement(query string) (*sql.Stmt, error) {
+	prefixUpdatedQuery := strings.ReplaceAll(query, "dbprefix_", s.tblPrefix)
+
+	return s.db.Prepare(prefixUpdatedQuery)
+}
+
+/************ Table Creations ************/
+func (s *sqlStore) init() error {
+	// dbprefix_scim_token relys on dbprefix_auth_affiliation
+	tblCreation := tokenTblCreation
+	if s.sqlite {
+		tblCreation = sqliteTokenTblCreation
+	}
+	stmt, err := s.statement(tblCreation)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec()
+	return err
+}
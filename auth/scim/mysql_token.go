@@ -0,0 +1,117 @@
+package scim
+
+import (
+	"database/sql"
+	"time"
+)
+
+/************ Token Database ************/
+
+func scanToken(scanner interface{ Scan(...interface{}) error }) (*Token, error) {
+	var token Token
+	var lastUsedAt, revokedAt sql.NullTime
+	err := scanner.Scan(&token.id, &token.AffiliationID, &token.tokenHash, &token.Label, &token.CreatedBy, &token.CreatedAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return &token, nil
+}
+
+func (s *sqlStore) newToken(token *Token) error {
+	stmtInsertToken, err := s.statement(`INSERT INTO dbprefix_scim_token (affiliation_id, token_hash, label, created_by, created_at) VALUES (?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertToken.Close()
+
+	result, err := stmtInsertToken.Exec(token.AffiliationID, token.tokenHash, token.Label, token.CreatedBy, token.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	token.id = uint64(id)
+	return nil
+}
+
+func (s *sqlStore) getToken(tokenID uint64) (*Token, error) {
+	stmtGetToken, err := s.statement(`SELECT id, affiliation_id, token_hash, label, created_by, created_at, last_used_at, revoked_at FROM dbprefix_scim_token WHERE id = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetToken.Close()
+
+	token, err := scanToken(stmtGetToken.QueryRow(tokenID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenNotFound
+	}
+	return token, err
+}
+
+func (s *sqlStore) getTokenByHash(tokenHash string) (*Token, error) {
+	stmtGetToken, err := s.statement(`SELECT id, affiliation_id, token_hash, label, created_by, created_at, last_used_at, revoked_at FROM dbprefix_scim_token WHERE token_hash = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetToken.Close()
+
+	token, err := scanToken(stmtGetToken.QueryRow(tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenNotFound
+	}
+	return token, err
+}
+
+func (s *sqlStore) listTokens(affiliationID uint64) ([]*Token, error) {
+	stmtListTokens, err := s.statement(`SELECT id, affiliation_id, token_hash, label, created_by, created_at, last_used_at, revoked_at FROM dbprefix_scim_token WHERE affiliation_id = ? ORDER BY id ASC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListTokens.Close()
+
+	rows, err := stmtListTokens.Query(affiliationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqlStore) touchToken(tokenID uint64, lastUsedAt time.Time) error {
+	stmtTouchToken, err := s.statement(`UPDATE dbprefix_scim_token SET last_used_at = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtTouchToken.Close()
+
+	_, err = stmtTouchToken.Exec(lastUsedAt, tokenID)
+	return err
+}
+
+func (s *sqlStore) revokeToken(tokenID uint64, revokedAt time.Time) error {
+	stmtRevokeToken, err := s.statement(`UPDATE dbprefix_scim_token SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL;`)
+	if err != nil {
+		return err
+	}
+	defer stmtRevokeToken.Close()
+
+	_, err = stmtRevokeToken.Exec(revokedAt, tokenID)
+	return err
+}
@@ -0,0 +1,284 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// PATCH (RFC 7644 3.5.2) is applied to the JSON representation of the
+// resource, which is then saved like a PUT of the result.
+
+type patchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []patchOperation `json:"Operations"`
+}
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchPath is attr[.sub], or attr[filter][.sub]
+type patchPath struct {
+	attr   string
+	filter filterExpr
+	sub    string
+}
+
+func parsePatchPath(path string) (*patchPath, error) {
+	path = stripSchema(strings.TrimSpace(path))
+	p := &patchPath{}
+
+	if open := strings.IndexByte(path, '['); open >= 0 {
+		closing := strings.LastIndexByte(path, ']')
+		if closing < open {
+			return nil, ErrInvalidPath
+		}
+		filter, err := parseFilter(path[open+1 : closing])
+		if err != nil {
+			return nil, ErrInvalidPath
+		}
+		p.attr, p.filter = path[:open], filter
+		if rest := path[closing+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ".") {
+				return nil, ErrInvalidPath
+			}
+			p.sub = rest[1:]
+		}
+	} else if dot := strings.IndexByte(path, '.'); dot >= 0 {
+		p.attr, p.sub = path[:dot], path[dot+1:]
+	} else {
+		p.attr = path
+	}
+
+	if !validAttrPath(p.attr) || strings.ContainsAny(p.attr, ".:") || strings.ContainsAny(p.sub, ".:[]") {
+		return nil, ErrInvalidPath
+	}
+	return p, nil
+}
+
+// applyPatch applies the operations to the resource. Attributes in
+// readOnly (lower case) may not be modified.
+func applyPatch(resource map[string]interface{}, operations []patchOperation, readOnly map[string]bool) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		var value interface{}
+		if len(operation.Value) > 0 {
+			if err := json.Unmarshal(operation.Value, &value); err != nil {
+				return ErrInvalidSyntax
+			}
+		}
+
+		switch op {
+		case "add", "replace":
+			if operation.Path == "" {
+				// The value holds the attributes to add or replace. Some
+				// directories name them by paths, e.g. "name.givenName".
+				attributes, ok := value.(map[string]interface{})
+				if !ok {
+					return ErrInvalidValue
+				}
+				for attrPath, attrValue := range attributes {
+					path, err := parsePatchPath(attrPath)
+					if err != nil {
+						return err
+					}
+					if err = patchSet(resource, op, path, attrValue, readOnly); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			path, err := parsePatchPath(operation.Path)
+			if err != nil {
+				return err
+			}
+			if err = patchSet(resource, op, path, value, readOnly); err != nil {
+				return err
+			}
+		case "remove":
+			if operation.Path == "" {
+				return ErrNoTarget
+			}
+			path, err := parsePatchPath(operation.Path)
+			if err != nil {
+				return err
+			}
+			if err = patchRemove(resource, path, value, readOnly); err != nil {
+				return err
+			}
+		default:
+			return ErrInvalidSyntax
+		}
+	}
+	return nil
+}
+
+func patchSet(resource map[string]interface{}, op string, path *patchPath, value interface{}, readOnly map[string]bool) error {
+	if readOnly[strings.ToLower(path.attr)] {
+		return ErrMutability
+	}
+	key := findKey(resource, path.attr)
+	current, exists := resource[key]
+
+	if path.filter != nil {
+		elements, _ := current.([]interface{})
+		matched := false
+		for i, element := range elements {
+			m, ok := element.(map[string]interface{})
+			if !ok || !path.filter.match(m) {
+				continue
+			}
+			matched = true
+			elements[i] = setValue(m, op, path.sub, value)
+		}
+		if !matched {
+			// e.g. emails[type eq "work"].value adds the work email
+			m := seed(path.filter)
+			if m == nil {
+				return ErrNoTarget
+			}
+			elements = append(elements, setValue(m, "add", path.sub, value))
+		}
+		resource[key] = elements
+		return nil
+	}
+
+	if path.sub != "" {
+		switch c := current.(type) {
+		case []interface{}:
+			for i, element := range c {
+				if m, ok := element.(map[string]interface{}); ok {
+					c[i] = setValue(m, op, path.sub, value)
+				}
+			}
+		case map[string]interface{}:
+			resource[key] = setValue(c, op, path.sub, value)
+		default:
+			resource[key] = setValue(map[string]interface{}{}, op, path.sub, value)
+		}
+		return nil
+	}
+
+	// add appends to multi-valued attributes and merges complex ones;
+	// replace replaces values, but also merges complex ones.
+	if !exists {
+		resource[key] = value
+		return nil
+	}
+	switch c := current.(type) {
+	case []interface{}:
+		if op == "add" {
+			if values, ok := value.([]interface{}); ok {
+				resource[key] = append(c, values...)
+			} else {
+				resource[key] = append(c, value)
+			}
+			return nil
+		}
+	case map[string]interface{}:
+		if m, ok := value.(map[string]interface{}); ok {
+			for sub, subValue := range m {
+				c[findKey(c, sub)] = subValue
+			}
+			return nil
+		}
+	}
+	resource[key] = value
+	return nil
+}
+
+// setValue sets the sub-attribute of the complex value, or replaces or
+// merges the value itself if sub is empty.
+func setValue(m map[string]interface{}, op, sub string, value interface{}) map[string]interface{} {
+	if sub != "" {
+		m[findKey(m, sub)] = value
+		return m
+	}
+	replacement, ok := value.(map[string]interface{})
+	if !ok {
+		return m
+	}
+	if op == "replace" {
+		return replacement
+	}
+	for k, v := range replacement {
+		m[findKey(m, k)] = v
+	}
+	return m
+}
+
+// patchRemove removes the attribute, its sub-attribute, or the matching
+// values. Removing values of a multi-valued attribute by value is also
+// accepted, e.g. {"op": "remove", "path": "members", "value": [{"value": "42"}]}.
+func patchRemove(resource map[string]interface{}, path *patchPath, value interface{}, readOnly map[string]bool) error {
+	if readOnly[strings.ToLower(path.attr)] {
+		return ErrMutability
+	}
+	key := findKey(resource, path.attr)
+	current, exists := resource[key]
+	if !exists {
+		return nil
+	}
+
+	elements, multiValued := current.([]interface{})
+	if path.filter == nil && path.sub == "" && multiValued && value != nil {
+		removed, ok := value.([]interface{})
+		if !ok {
+			removed = []interface{}{value}
+		}
+		path.filter = &valueInExpr{values: removed}
+	}
+
+	if path.filter == nil {
+		if path.sub == "" {
+			delete(resource, key)
+			return nil
+		}
+		if m, ok := current.(map[string]interface{}); ok {
+			delete(m, findKey(m, path.sub))
+		}
+		for _, element := range elements {
+			if m, ok := element.(map[string]interface{}); ok {
+				delete(m, findKey(m, path.sub))
+			}
+		}
+		return nil
+	}
+
+	kept := []interface{}{}
+	for _, element := range elements {
+		m, ok := element.(map[string]interface{})
+		if !ok || !path.filter.match(m) {
+			kept = append(kept, element)
+			continue
+		}
+		if path.sub != "" {
+			delete(m, findKey(m, path.sub))
+			kept = append(kept, m)
+		}
+	}
+	resource[key] = kept
+	return nil
+}
+
+// valueInExpr matches the complex values whose "value" is among the ones
+// of the values.
+type valueInExpr struct {
+	values []interface{}
+}
+
+func (e *valueInExpr) match(element map[string]interface{}) bool {
+	actual := getAttr(element, "value")
+	for _, value := range e.values {
+		if m, ok := value.(map[string]interface{}); ok {
+			value = getAttr(m, "value")
+		}
+		// Values are ids, which are strings
+		if s, ok := value.(string); ok && s == actual {
+			return true
+		}
+	}
+	return false
+}
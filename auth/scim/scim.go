@@ -0,0 +1,145 @@
+// Package scim lets the directory of an Affiliation, e.g. Azure AD or Okta,
+// provision its users into Ulysses with SCIM 2.0 (RFC 7643, RFC 7644).
+//
+// The directory authenticates with an affiliation API token (Token) as a
+// bearer token, and sees the users of its affiliation only:
+//
+//   - A SCIM User is an auth.User with its UserInfo. userName is the email,
+//     externalId is kept as an auth.ExternalIdentity of the provider
+//     "scim:<affiliation ID>", and active=false deactivates the user.
+//     Deleting a user deactivates them and detaches them from the affiliation.
+//     The email may not change: users change it themselves, verifying it.
+//   - A SCIM Group is one of the AFFILIATION_* roles, e.g. "account_admin".
+//     Groups are fixed: their members are the users holding the role, and
+//     adding or removing members grants or revokes it.
+//
+// The directory acts on behalf of the creator of the token: it may change
+// only the users the creator may manage, and grant or revoke only the roles
+// the creator may. See auth.CanManage and auth.CanGrant.
+//
+// Filtering (all operators, and/or/not, value paths), pagination, and PATCH
+// are supported. Sorting, bulk operations and ETags are not.
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+// Audit event types
+const (
+	AUDIT_SCIM_TOKEN_CREATE    = "scim_token_create"
+	AUDIT_SCIM_TOKEN_REVOKE    = "scim_token_revoke"
+	AUDIT_SCIM_USER_CREATE     = "scim_user_create"
+	AUDIT_SCIM_USER_DEACTIVATE = "scim_user_deactivate"
+	AUDIT_SCIM_USER_REACTIVATE = "scim_user_reactivate"
+	AUDIT_SCIM_USER_DELETE     = "scim_user_delete"
+)
+
+var (
+	ErrConfigIncomplete = errors.New("scim: config requires BaseURL")
+	ErrStoreUnsupported = errors.New("scim: store of package auth is not supported")
+
+	// DefaultRole is granted to the users the directory creates, in addition
+	// to the roles of the groups they are added to.
+	DefaultRole auth.Role = auth.AFFILIATION_ACCOUNT_USER
+	// MaxResults is the most resources returned in one page.
+	MaxResults = 200
+)
+
+// Error is reported to the directory as a SCIM error response. Errors
+// which are not an *Error are reported as internal errors.
+type Error struct {
+	Status   int
+	ScimType string // empty if not applicable
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("scim: %d %s: %s", e.Status, e.ScimType, e.Detail)
+}
+
+var (
+	ErrUnauthorized    = &Error{http.StatusUnauthorized, "", "the bearer token is missing, invalid or revoked"}
+	ErrNotFound        = &Error{http.StatusNotFound, "", "resource not found"}
+	ErrUniqueness      = &Error{http.StatusConflict, "uniqueness", "userName or externalId is taken"}
+	ErrInvalidFilter   = &Error{http.StatusBadRequest, "invalidFilter", "the filter is malformed or not supported"}
+	ErrInvalidPath     = &Error{http.StatusBadRequest, "invalidPath", "the path is malformed or not supported"}
+	ErrNoTarget        = &Error{http.StatusBadRequest, "noTarget", "the path matches nothing"}
+	ErrInvalidValue    = &Error{http.StatusBadRequest, "invalidValue", "a required value is missing, or a value is invalid"}
+	ErrInvalidSyntax   = &Error{http.StatusBadRequest, "invalidSyntax", "the request is malformed"}
+	ErrMutability      = &Error{http.StatusBadRequest, "mutability", "the attribute is read-only"}
+	ErrNotImplemented  = &Error{http.StatusNotImplemented, "", "the operation is not supported"}
+	ErrGroupNotMutable = &Error{http.StatusNotImplemented, "", "groups are fixed to the roles of the affiliation"}
+	ErrEmailImmutable  = &Error{http.StatusBadRequest, "mutability", "userName and emails may not change: users change their email themselves, verifying it"}
+	ErrUserNotManaged  = &Error{http.StatusForbidden, "", "the creator of the token may not manage the user"}
+	ErrRoleNotGranted  = &Error{http.StatusForbidden, "", "the creator of the token may not grant or revoke the role"}
+)
+
+// Config of the SCIM service provider.
+type Config struct {
+	// BaseURL is the URL the SCIM endpoints are served under, i.e. the path
+	// prefix passed to api.FinalizeGinEngine followed by "auth/scim/v2", e.g.
+	// "https://example.com/api/auth/scim/v2". It is the one to enter in the
+	// directory.
+	BaseURL string
+}
+
+var config Config
+
+// Setup() of scim package requires:
+// - Previous Setup() of auth package, with authStore
+//
+// The tokens are kept in the database of authStore if it is an
+// auth.SQLStore, or in memory with an auth.MemoryStore.
+func Setup(authStore auth.Store, conf Config) error {
+	if conf.BaseURL == "" {
+		return ErrConfigIncomplete
+	}
+	conf.BaseURL = strings.TrimSuffix(conf.BaseURL, "/")
+
+	s, err := newTokenStore(authStore)
+	if err != nil {
+		return err
+	}
+	if err = s.init(); err != nil {
+		return err
+	}
+	config = conf
+	store = s
+	return nil
+}
+
+func providerName(affiliationID uint64) string {
+	return fmt.Sprintf("scim:%d", affiliationID)
+}
+
+// audit records an event in the audit trail of package auth.
+func audit(eventType string, actorUserID, subjectUserID, affiliationID uint64, detail map[string]interface{}) error {
+	detailJson, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	return auth.RecordAuditEvent(&auth.AuditEvent{
+		EventType:     eventType,
+		ActorUserID:   actorUserID,
+		SubjectUserID: subjectUserID,
+		AffiliationID: affiliationID,
+		Detail:        detailJson,
+	})
+}
@@ -0,0 +1,36 @@
+package scim
+
+import (
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// tokenStore keeps the tokens along with the store of package auth: in its
+// database with an auth.SQLStore, or in memory with an auth.MemoryStore.
+type tokenStore interface {
+	init() error
+	newToken(token *Token) error                       // sets the ID of token
+	getToken(tokenID uint64) (*Token, error)           // ErrTokenNotFound if none
+	getTokenByHash(tokenHash string) (*Token, error)   // ErrTokenNotFound if none
+	listTokens(affiliationID uint64) ([]*Token, error) // oldest first
+	touchToken(tokenID uint64, lastUsedAt time.Time) error
+	revokeToken(tokenID uint64, revokedAt time.Time) error // no-op if revoked already
+}
+
+var (
+	_ tokenStore = (*sqlStore)(nil)
+	_ tokenStore = (*memoryStore)(nil)
+)
+
+var store tokenStore
+
+func newTokenStore(authStore auth.Store) (tokenStore, error) {
+	switch s := authStore.(type) {
+	case auth.SQLStore:
+		return &sqlStore{db: s.DB(), tblPrefix: s.TablePrefix(), sqlite: s.SQLite()}, nil
+	case *auth.MemoryStore:
+		return newMemoryStore(), nil
+	}
+	return nil, ErrStoreUnsupported
+}
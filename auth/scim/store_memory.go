@@ -0,0 +1,101 @@
+package scim
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// memoryStore keeps the tokens with an auth.MemoryStore, for unit tests.
+type memoryStore struct {
+	mutex       sync.RWMutex
+	lastTokenID uint64
+	tokens      map[uint64]Token
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{tokens: map[uint64]Token{}}
+}
+
+func (s *memoryStore) init() error {
+	return nil
+}
+
+func (s *memoryStore) newToken(token *Token) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, existing := range s.tokens {
+		if existing.tokenHash == token.tokenHash {
+			return auth.ErrStoreDuplicate
+		}
+	}
+	s.lastTokenID++
+	token.id = s.lastTokenID
+	stored := *token
+	stored.LastUsedAt, stored.RevokedAt = nil, nil
+	s.tokens[token.id] = stored
+	return nil
+}
+
+func (s *memoryStore) getToken(tokenID uint64) (*Token, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	token, ok := s.tokens[tokenID]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	return &token, nil
+}
+
+func (s *memoryStore) getTokenByHash(tokenHash string) (*Token, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, token := range s.tokens {
+		if token.tokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, ErrTokenNotFound
+}
+
+func (s *memoryStore) listTokens(affiliationID uint64) ([]*Token, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	tokens := []*Token{}
+	for _, token := range s.tokens {
+		if token.AffiliationID == affiliationID {
+			token := token
+			tokens = append(tokens, &token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].id < tokens[j].id })
+	return tokens, nil
+}
+
+func (s *memoryStore) touchToken(tokenID uint64, lastUsedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if token, ok := s.tokens[tokenID]; ok {
+		token.LastUsedAt = &lastUsedAt
+		s.tokens[tokenID] = token
+	}
+	return nil
+}
+
+func (s *memoryStore) revokeToken(tokenID uint64, revokedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if token, ok := s.tokens[tokenID]; ok && token.RevokedAt == nil {
+		token.RevokedAt = &revokedAt
+		s.tokens[tokenID] = token
+	}
+	return nil
+}
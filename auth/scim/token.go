@@ -0,0 +1,150 @@
+package scim
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// A Token is an affiliation API token: the directory of the affiliation
+// authenticates with it to the SCIM endpoints.
+
+const tokenPrefix = "scim_"
+
+var (
+	ErrTokenNotFound   = errors.New("scim: token not found")
+	ErrTokenBad        = errors.New("scim: token requires a label of at most 64 characters")
+	ErrTokenNotAllowed = errors.New("scim: actor may not manage the tokens of the affiliation")
+)
+
+type Token struct {
+	id            uint64
+	AffiliationID uint64     `json:"affiliation_id"`
+	tokenHash     string     // hex of SHA-256
+	Label         string     `json:"label"`
+	CreatedBy     uint64     `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastUsedAt    *time.Time `json:"last_used_at"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+}
+
+func (token *Token) ID() uint64 {
+	return token.id
+}
+
+// Tokens are random, so a fast hash suffices.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkActor allows GLOBAL_ADMIN, and AFFILIATION_ACCOUNT_ADMIN of the
+// affiliation itself.
+func checkActor(actor *auth.User, affiliationID uint64) error {
+	role, err := actor.EffectiveRole()
+	if err != nil {
+		return err
+	}
+	if role.Includes(auth.GLOBAL_ADMIN) {
+		return nil
+	}
+	if role.Includes(auth.AFFILIATION_ACCOUNT_ADMIN) && actor.AffiliationID == affiliationID && affiliationID != 0 {
+		return nil
+	}
+	return ErrTokenNotAllowed
+}
+
+// CreateToken creates a token for the affiliation on behalf of the actor.
+// The secret is returned only here: it is to be entered in the directory.
+func CreateToken(actor *auth.User, affiliationID uint64, label string) (token *Token, secret string, err error) {
+	if err = checkActor(actor, affiliationID); err != nil {
+		return nil, "", err
+	}
+	if label == "" || len(label) > 64 {
+		return nil, "", ErrTokenBad
+	}
+	if _, err = auth.GetAffiliationByID(affiliationID); err != nil {
+		return nil, "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	secret = tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	token = &Token{
+		AffiliationID: affiliationID,
+		tokenHash:     hashToken(secret),
+		Label:         label,
+		CreatedBy:     actor.ID(),
+		CreatedAt:     time.Now(),
+	}
+	if err = store.newToken(token); err != nil {
+		return nil, "", err
+	}
+	err = audit(AUDIT_SCIM_TOKEN_CREATE, actor.ID(), 0, affiliationID, map[string]interface{}{"token_id": token.id, "label": label})
+	if err != nil {
+		return nil, "", err
+	}
+	return token, secret, nil
+}
+
+// ListTokens lists the tokens of the affiliation, revoked ones included.
+func ListTokens(actor *auth.User, affiliationID uint64) ([]*Token, error) {
+	if err := checkActor(actor, affiliationID); err != nil {
+		return nil, err
+	}
+	return store.listTokens(affiliationID)
+}
+
+// RevokeToken revokes the token on behalf of the actor. The directory
+// holding it is locked out immediately.
+func RevokeToken(actor *auth.User, tokenID uint64) error {
+	token, err := store.getToken(tokenID)
+	if err != nil {
+		return err
+	}
+	if err = checkActor(actor, token.AffiliationID); err != nil {
+		return err
+	}
+	if token.RevokedAt != nil {
+		return nil
+	}
+	if err = store.revokeToken(tokenID, time.Now()); err != nil {
+		return err
+	}
+	return audit(AUDIT_SCIM_TOKEN_REVOKE, actor.ID(), 0, token.AffiliationID, map[string]interface{}{"token_id": tokenID})
+}
+
+// authenticate returns the token of the secret unless it is revoked.
+func authenticate(secret string) (*Token, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil, ErrUnauthorized
+	}
+	token, err := store.getTokenByHash(hashToken(secret))
+	if err == ErrTokenNotFound {
+		return nil, ErrUnauthorized
+	} else if err != nil {
+		return nil, err
+	}
+	if token.RevokedAt != nil {
+		return nil, ErrUnauthorized
+	}
+
+	// Last use is kept at a minute's resolution, saving a write per request
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		if err = store.touchToken(token.id, now); err != nil {
+			return nil, err
+		}
+		token.LastUsedAt = &now
+	}
+	return token, nil
+}
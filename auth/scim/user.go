@@ -0,0 +1,452 @@
+package scim
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+/************ User Resource ************/
+
+type userResource struct {
+	Schemas     []string      `json:"schemas"`
+	ID          string        `json:"id,omitempty"`
+	ExternalID  string        `json:"externalId,omitempty"`
+	UserName    string        `json:"userName"`
+	Name        *userName     `json:"name,omitempty"`
+	DisplayName string        `json:"displayName,omitempty"`
+	Emails      []multiValue  `json:"emails,omitempty"`
+	Addresses   []address     `json:"addresses,omitempty"`
+	Active      *flexibleBool `json:"active,omitempty"`
+	Groups      []memberRef   `json:"groups,omitempty"`
+	Meta        *resourceMeta `json:"meta,omitempty"`
+}
+
+type userName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type multiValue struct {
+	Value   string        `json:"value"`
+	Type    string        `json:"type,omitempty"`
+	Primary *flexibleBool `json:"primary,omitempty"`
+}
+
+type address struct {
+	Type          string        `json:"type,omitempty"`
+	StreetAddress string        `json:"streetAddress,omitempty"`
+	Locality      string        `json:"locality,omitempty"`
+	Region        string        `json:"region,omitempty"`
+	PostalCode    string        `json:"postalCode,omitempty"`
+	Country       string        `json:"country,omitempty"`
+	Primary       *flexibleBool `json:"primary,omitempty"`
+}
+
+// memberRef is an element of User.groups and Group.members
+type memberRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type resourceMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+}
+
+// flexibleBool also accepts "True" and "False", as some directories send
+// booleans as strings.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*b = flexibleBool(v)
+	case string:
+		parsed, err := strconv.ParseBool(strings.ToLower(v))
+		if err != nil {
+			return ErrInvalidValue
+		}
+		*b = flexibleBool(parsed)
+	default:
+		return ErrInvalidValue
+	}
+	return nil
+}
+
+func newBool(b bool) *flexibleBool {
+	value := flexibleBool(b)
+	return &value
+}
+
+// readOnlyUserAttributes may not be patched
+var readOnlyUserAttributes = map[string]bool{"id": true, "groups": true, "meta": true, "schemas": true}
+
+// email is userName if it is an email, or else the primary email.
+func (resource *userResource) email() (string, error) {
+	email := strings.TrimSpace(resource.UserName)
+	if !strings.Contains(email, "@") {
+		email = ""
+		for _, e := range resource.Emails {
+			if email == "" || (e.Primary != nil && bool(*e.Primary)) {
+				email = strings.TrimSpace(e.Value)
+			}
+		}
+	}
+	if !strings.Contains(email, "@") {
+		return "", ErrInvalidValue
+	}
+	return email, nil
+}
+
+// info returns the UserInfo of the resource, keeping the fields which
+// have no SCIM counterpart from current.
+func (resource *userResource) info(current *auth.UserInfo) *auth.UserInfo {
+	info := &auth.UserInfo{}
+	if current != nil {
+		info.Suite = current.Suite
+	}
+	if resource.Name != nil {
+		info.FirstName = resource.Name.GivenName
+		info.LastName = resource.Name.FamilyName
+	}
+	for i, a := range resource.Addresses {
+		if i == 0 || (a.Primary != nil && bool(*a.Primary)) {
+			info.StreetAddress = a.StreetAddress
+			info.City = a.Locality
+			info.State = a.Region
+			info.ZipCode = a.PostalCode
+			info.CountryISO = a.Country
+		}
+	}
+	return info
+}
+
+func userLocation(userID uint64) string {
+	return config.BaseURL + "/Users/" + strconv.FormatUint(userID, 10)
+}
+
+// renderUser returns the SCIM representation of the user.
+func renderUser(user *auth.User) (*userResource, error) {
+	resource := &userResource{
+		Schemas:  []string{SchemaUser},
+		ID:       strconv.FormatUint(user.ID(), 10),
+		UserName: user.Email,
+		Emails:   []multiValue{{Value: user.Email, Type: "work", Primary: newBool(true)}},
+		Meta:     &resourceMeta{ResourceType: "User", Location: userLocation(user.ID())},
+	}
+
+	info, err := user.Info()
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if info != nil {
+		resource.Name = &userName{
+			Formatted:  strings.TrimSpace(info.FirstName + " " + info.LastName),
+			GivenName:  info.FirstName,
+			FamilyName: info.LastName,
+		}
+		resource.DisplayName = resource.Name.Formatted
+		if info.StreetAddress != "" || info.City != "" || info.State != "" || info.ZipCode != "" || info.CountryISO != "" {
+			resource.Addresses = []address{{
+				Type:          "work",
+				StreetAddress: info.StreetAddress,
+				Locality:      info.City,
+				Region:        info.State,
+				PostalCode:    info.ZipCode,
+				Country:       info.CountryISO,
+				Primary:       newBool(true),
+			}}
+		}
+	}
+
+	deactivation, err := user.Deactivation()
+	if err != nil {
+		return nil, err
+	}
+	resource.Active = newBool(deactivation == nil)
+
+	identities, err := user.ExternalIdentities()
+	if err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		if identity.Provider == providerName(user.AffiliationID) {
+			resource.ExternalID = identity.Subject
+		}
+	}
+
+	for _, g := range groups {
+		if user.Role.Includes(g.role) {
+			resource.Groups = append(resource.Groups, memberRef{Value: g.id, Display: g.displayName, Ref: groupLocation(g.id)})
+		}
+	}
+	return resource, nil
+}
+
+/************ User Operations ************/
+
+// getUser returns the user if it is in the affiliation and not erased.
+func getUser(affiliationID uint64, id string) (*auth.User, error) {
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	user, err := auth.GetUserByID(userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if user.AffiliationID != affiliationID {
+		return nil, ErrNotFound
+	}
+	if err = auth.CheckUserActive(user.ID()); err == auth.ErrUserErased {
+		return nil, ErrNotFound
+	}
+	return user, nil
+}
+
+// listUsers returns the users of the affiliation, except for erased ones.
+func listUsers(affiliationID uint64) ([]*auth.User, error) {
+	users, err := auth.GetUsersByAffiliationID(affiliationID)
+	if err != nil {
+		return nil, err
+	}
+	listed := []*auth.User{}
+	for _, user := range users {
+		if auth.CheckUserActive(user.ID()) == auth.ErrUserErased {
+			continue
+		}
+		listed = append(listed, user)
+	}
+	return listed, nil
+}
+
+// createUser creates the user in the affiliation with DefaultRole, on behalf
+// of the creator of the token.
+func createUser(creator *auth.User, tokenID, affiliationID uint64, resource *userResource) (*auth.User, error) {
+	email, err := resource.email()
+	if err != nil {
+		return nil, err
+	}
+	exist, err := (&auth.User{Email: email}).EmailExists()
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		return nil, ErrUniqueness
+	}
+	provider := providerName(affiliationID)
+	if resource.ExternalID != "" {
+		_, err = auth.GetUserByExternalIdentity(provider, resource.ExternalID)
+		if err == nil {
+			return nil, ErrUniqueness
+		} else if err != auth.ErrExternalIdentityNotFound {
+			return nil, err
+		}
+	}
+
+	user := &auth.User{
+		Email:         email,
+		Role:          DefaultRole & auth.AFFILIATION_ROLES,
+		AffiliationID: affiliationID,
+	}
+	if err = user.Create(); err != nil {
+		return nil, err
+	}
+	user, err = auth.GetUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if err = user.CreateInfo(resource.info(nil)); err != nil {
+		return nil, err
+	}
+	if resource.ExternalID != "" {
+		if _, err = user.LinkExternalIdentity(provider, resource.ExternalID, email); err != nil {
+			return nil, err
+		}
+	}
+	if resource.Active != nil && !bool(*resource.Active) {
+		if _, err = user.Deactivate(creator.ID(), "deactivated by the directory of the affiliation", auth.ErasureNever); err != nil {
+			return nil, err
+		}
+	}
+	return user, audit(AUDIT_SCIM_USER_CREATE, creator.ID(), user.ID(), affiliationID, map[string]interface{}{"token_id": tokenID, "external_id": resource.ExternalID})
+}
+
+// checkManaged refuses the users the creator of the token may not manage.
+// See auth.CanManage.
+func checkManaged(creator, user *auth.User) error {
+	canManage, err := auth.CanManage(creator, user)
+	if err != nil {
+		return err
+	}
+	if !canManage {
+		return ErrUserNotManaged
+	}
+	return nil
+}
+
+// replaceUser saves the resource to the user on behalf of the creator of the
+// token. Read-only attributes are ignored, and active is kept if absent. The
+// email may differ in case only. An inactive user is suspended: the erasure
+// is not scheduled until the directory deletes them.
+func replaceUser(creator *auth.User, tokenID uint64, user *auth.User, resource *userResource) error {
+	if err := checkManaged(creator, user); err != nil {
+		return err
+	}
+	email, err := resource.email()
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(email, user.Email) {
+		return ErrEmailImmutable
+	}
+
+	info, err := user.Info()
+	if err == sql.ErrNoRows {
+		err = user.CreateInfo(resource.info(nil))
+	} else if err == nil {
+		err = user.UpdateInfo(resource.info(info))
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = replaceExternalID(user, resource.ExternalID); err != nil {
+		return err
+	}
+
+	if resource.Active != nil {
+		deactivation, err := user.Deactivation()
+		if err != nil {
+			return err
+		}
+		if deactivation == nil && !bool(*resource.Active) {
+			if _, err = user.Deactivate(creator.ID(), "deactivated by the directory of the affiliation", auth.ErasureNever); err != nil {
+				return err
+			}
+			return audit(AUDIT_SCIM_USER_DEACTIVATE, creator.ID(), user.ID(), user.AffiliationID, map[string]interface{}{"token_id": tokenID})
+		} else if deactivation != nil && bool(*resource.Active) {
+			if err = user.Reactivate(creator.ID()); err != nil {
+				return err
+			}
+			return audit(AUDIT_SCIM_USER_REACTIVATE, creator.ID(), user.ID(), user.AffiliationID, map[string]interface{}{"token_id": tokenID})
+		}
+	}
+	return nil
+}
+
+func replaceExternalID(user *auth.User, externalID string) error {
+	provider := providerName(user.AffiliationID)
+	identities, err := user.ExternalIdentities()
+	if err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		if identity.Provider != provider {
+			continue
+		}
+		if identity.Subject == externalID {
+			return nil
+		}
+		if err = user.UnlinkExternalIdentity(provider, identity.Subject); err != nil {
+			return err
+		}
+	}
+	if externalID == "" {
+		return nil
+	}
+	_, err = user.LinkExternalIdentity(provider, externalID, user.Email)
+	if err == auth.ErrExternalIdentityLinked {
+		return ErrUniqueness
+	}
+	return err
+}
+
+// patchUser applies the PATCH operations to the user.
+func patchUser(creator *auth.User, tokenID uint64, user *auth.User, operations []patchOperation) error {
+	resource, err := renderUser(user)
+	if err != nil {
+		return err
+	}
+	object, err := toObject(resource)
+	if err != nil {
+		return err
+	}
+	if err = applyPatch(object, operations, readOnlyUserAttributes); err != nil {
+		return err
+	}
+
+	var patched userResource
+	if err = fromObject(object, &patched); err != nil {
+		return err
+	}
+	return replaceUser(creator, tokenID, user, &patched)
+}
+
+// deleteUser deactivates the user, and detaches them from the affiliation,
+// so that the directory no longer finds them. They are erased after the
+// auth.DefaultErasureDelay, including users suspended before.
+func deleteUser(creator *auth.User, tokenID uint64, user *auth.User) error {
+	if err := checkManaged(creator, user); err != nil {
+		return err
+	}
+	affiliationID := user.AffiliationID
+	deactivation, err := user.Deactivation()
+	if err != nil {
+		return err
+	}
+	if deactivation == nil {
+		deactivation, err = user.Deactivate(creator.ID(), "deleted by the directory of the affiliation", 0)
+	} else if !deactivation.ErasureScheduled() {
+		deactivation.ErasureAt = time.Now().Add(auth.DefaultErasureDelay)
+		err = user.RescheduleErasure(deactivation.ErasureAt)
+	}
+	if err != nil {
+		return err
+	}
+	if err = replaceExternalID(user, ""); err != nil {
+		return err
+	}
+	if err = user.LeaveAffiliation(); err != nil {
+		return err
+	}
+	return audit(AUDIT_SCIM_USER_DELETE, creator.ID(), user.ID(), affiliationID, map[string]interface{}{"token_id": tokenID, "erasure_at": deactivation.ErasureAt})
+}
+
+/************ JSON Helpers ************/
+
+// toObject returns the JSON representation of the resource as a map.
+func toObject(resource interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	object := map[string]interface{}{}
+	return object, json.Unmarshal(data, &object)
+}
+
+// fromObject decodes the map into the resource. Attribute names are
+// case-insensitive as in json.Unmarshal.
+func fromObject(object map[string]interface{}, resource interface{}) error {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, resource); err != nil {
+		return ErrInvalidValue
+	}
+	return nil
+}
@@ -1,16 +1,28 @@
 package auth
 
-import (
-	"database/sql"
-)
+// Setup() of auth package creates the schema of the store, e.g.
+//
+//	auth.Setup(auth.NewMySQLStore(db, "ulysses_"))
+//
+// Setup also starts the janitors of the EphemeralStore and of temporary role
+// grants.
+//
+// Setup used to take the *sql.DB and the table prefix of MySQL, i.e.
+// auth.Setup(db, "ulysses_") is now auth.Setup(auth.NewMySQLStore(db, "ulysses_")).
+func Setup(s Store) error {
+	if err := s.Init(); err != nil {
+		return err
+	}
 
-func Setup(dbConn *sql.DB, tblPrefixOverride string) {
-	db = dbConn
-	if db.Ping() != nil {
-		panic("Could not connect to database")
+	store = s
+	if stopEphemeralJanitor != nil {
+		stopEphemeralJanitor()
 	}
+	stopEphemeralJanitor = StartEphemeralJanitor(s.Ephemeral(), EphemeralJanitorInterval)
 
-	tblPrefix = tblPrefixOverride
-	// Create tables
-	initDatabaseTable()
+	if stopRoleGrantJanitor != nil {
+		stopRoleGrantJanitor()
+	}
+	stopRoleGrantJanitor = StartRoleGrantJanitor(RoleGrantJanitorInterval)
+	return nil
 }
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// A step-up token is the proof that the user passed an MFA challenge recently.
+// It is bound to the user and the login session, limited to a set of scopes,
+// and expires shortly.
+
+// Known step-up scopes for sensitive actions
+const (
+	STEPUP_WALLET      = "wallet"      // wallet deposits/withdrawals
+	STEPUP_API_KEY     = "api_key"     // API key creation
+	STEPUP_ROLE_CHANGE = "role_change" // role changes
+	STEPUP_USER_WIPE   = "user_wipe"   // User.Wipe()
+	STEPUP_USER_KEY    = "user_key"    // User.AddKeyWithStepUp()
+	STEPUP_ALL         = "*"           // any scope
+)
+
+const (
+	stepUpTmpExtension = "stepup"
+	stepUpTokenBytes   = 16 // hex-encoded into 32 chars
+)
+
+var (
+	DefaultStepUpLifetime = 5 * time.Minute
+
+	ErrStepUpInvalid      = errors.New("auth: step-up token is invalid")
+	ErrStepUpExpired      = errors.New("auth: step-up token expired")
+	ErrStepUpScopeMissing = errors.New("auth: step-up token does not cover the scope")
+)
+
+type StepUpToken struct {
+	Token     string    `json:"token"`
+	UserID    uint64    `json:"user_id"`
+	SessionID string    `json:"session_id"`
+	MFAType   string    `json:"mfa_type"`
+	Scopes    []string  `json:"scopes"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// MFASubmitStepUpChallenge works like MFASubmitChallenge. On success, it mints a
+// step-up token bound to the userID and sessionID, valid for DefaultStepUpLifetime.
+// If no scope is given, the token covers STEPUP_ALL.
+func MFASubmitStepUpChallenge(MFAType string, userID uint64, sessionID string, challengeResponse map[string]string, scopes ...string) (*StepUpToken, error) {
+	err := MFASubmitChallenge(MFAType, userID, challengeResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(scopes) == 0 {
+		scopes = []string{STEPUP_ALL}
+	}
+
+	tokenStr, err := randomToken(stepUpTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	token := &StepUpToken{
+		Token:     tokenStr,
+		UserID:    userID,
+		SessionID: sessionID,
+		MFAType:   MFAType,
+		Scopes:    scopes,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(DefaultStepUpLifetime),
+	}
+
+	tokenJson, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+	err = Ephemeral().Add(UserEphemeralKey(userID, stepUpTmpExtension, token.Token), string(tokenJson), DefaultStepUpLifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// VerifyStepUp checks that the token was minted for the user and session,
+// is not expired, was issued no earlier than maxAge ago (if maxAge > 0),
+// and covers the scope.
+func VerifyStepUp(userID uint64, sessionID, token, scope string, maxAge time.Duration) error {
+	if len(token) != 2*stepUpTokenBytes {
+		return ErrStepUpInvalid
+	}
+
+	tokenJson, err := Ephemeral().Get(UserEphemeralKey(userID, stepUpTmpExtension, token))
+	if err != nil {
+		return ErrStepUpInvalid
+	}
+
+	var stepUp StepUpToken
+	err = json.Unmarshal([]byte(tokenJson), &stepUp)
+	if err != nil {
+		return err
+	}
+
+	if stepUp.UserID != userID || stepUp.SessionID != sessionID {
+		return ErrStepUpInvalid
+	}
+
+	now := time.Now()
+	if now.After(stepUp.ExpiresAt) || (maxAge > 0 && now.After(stepUp.IssuedAt.Add(maxAge))) {
+		_ = Ephemeral().Delete(UserEphemeralKey(userID, stepUpTmpExtension, token))
+		return ErrStepUpExpired
+	}
+
+	for _, s := range stepUp.Scopes {
+		if s == STEPUP_ALL || s == scope {
+			return nil
+		}
+	}
+	return ErrStepUpScopeMissing
+}
+
+// RevokeStepUp invalidates a step-up token before it expires, e.g. at logout.
+func RevokeStepUp(userID uint64, token string) error {
+	return Ephemeral().Delete(UserEphemeralKey(userID, stepUpTmpExtension, token))
+}
@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Store persists all records of this package: users, affiliations and MFA
+// records, and those of the features embedded below. MySQLStore is the
+// default; SQLiteStore is for single-node installs, and MemoryStore is meant
+// for unit tests. All of them support every feature.
+//
+// Getters report a missing row with sql.ErrNoRows, unless documented otherwise.
+// Users and affiliations carry unexported IDs, so stores are implemented
+// inside this package.
+type Store interface {
+	// Init creates the schema if needed. It is called by Setup.
+	Init() error
+
+	// User
+	NewUser(user *User) error // sets the ID of user
+	GetUserByID(userID uint64) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+	GetUsersByAffiliationID(affiliationID uint64) ([]*User, error)
+	ListUserID() ([]uint64, error)
+	ListUserIDByAffiliationID(affiliationID uint64) ([]uint64, error)
+	EmailExists(email string) (bool, error)
+	UpdateUser(user *User) error // PublicKey and Role are not saved
+	UpdateUserRole(userID uint64, role Role) error
+	DeleteUser(userID uint64) error // user info goes with it
+
+	// UserInfo
+	NewUserInfo(userID uint64, info *UserInfo) error
+	GetUserInfo(userID uint64) (*UserInfo, error)
+	UpdateUserInfo(userID uint64, info *UserInfo) error
+
+	// Affiliation
+	NewAffiliation(affiliation *Affiliation) error // sets the ID of affiliation
+	GetAffiliationByID(affiliationID uint64) (*Affiliation, error)
+	UpdateAffiliation(affiliation *Affiliation) error // ParentID is not saved
+	AffiliationExists(affiliationID uint64) (bool, error)
+	ListChildAffiliations(affiliationID uint64) ([]*Affiliation, error)
+	ListDescendantAffiliations(affiliationID uint64) ([]*Affiliation, error) // nearest first
+	ListAncestorAffiliations(affiliationID uint64) ([]*Affiliation, error)   // direct parent first
+	// MoveAffiliation checks the new parent (ErrAffiliationParentNotFound,
+	// ErrAffiliationCycle, ErrAffiliationTooDeep) and moves the affiliation atomically, so that
+	// concurrent moves cannot create a cycle. It returns the old parent.
+	MoveAffiliation(affiliationID, newParentID uint64) (uint64, error)
+	// DeleteAffiliations deletes the affiliations and detaches their members
+	// (stripping AFFILIATION_* roles) atomically. If reparentTo is not nil,
+	// children of the deleted affiliations are moved to *reparentTo.
+	DeleteAffiliations(affiliationIDs []uint64, reparentTo *uint64) error
+
+	// MFA
+	InitMFA(userID uint64, extentionType, extentionData string) error
+	CheckoutMFA(userID uint64, extentionType string) (string, error)
+	MFAEnabled(userID uint64, extentionType string) (bool, error)
+	ConfirmMFA(userID uint64, extentionType string) error
+	UpdateMFA(userID uint64, extentionType, extentionData string) error
+	ClearMFA(userID uint64, extentionType string) error
+	ListEnabledMFA(userID uint64) ([]string, error)
+
+	Ephemeral() EphemeralStore
+
+	MFAPolicyStore
+	MFAPreferenceStore
+	LockoutStore
+	InvitationStore
+	RoleGrantStore
+	CustomRoleStore
+	UserKeyStore
+	ExternalIdentityStore
+	EmailVerificationStore
+	MFARecoveryStore
+	DeactivationStore
+	AuditStore
+}
+
+// SQLStore is implemented by MySQLStore and SQLiteStore. Packages keeping
+// their own tables next to those of this package (e.g. auth/saml) find the
+// database through it.
+type SQLStore interface {
+	Store
+	DB() *sql.DB
+	TablePrefix() string
+	SQLite() bool // false for MySQL
+}
+
+type MFAPolicyStore interface {
+	// MFAPolicySince returns when the policy started to apply to the user.
+	// If the policy was not recorded for the user, it is recorded as of now.
+	MFAPolicySince(userID uint64, policyName string) (time.Time, error)
+	// ClearMFAPolicySince is called when the policy no longer applies to the user.
+	ClearMFAPolicySince(userID uint64, policyName string) error
+}
+
+type MFAPreferenceStore interface {
+	SetPreferredMFA(userID uint64, extentionType string) error
+	GetPreferredMFA(userID uint64) (string, error) // "" if none
+}
+
+// LockoutStore keeps failure counters by subject, e.g. a user ID or an IP.
+type LockoutStore interface {
+	// IncrLockoutFailures atomically increments the failure counter and
+	// returns the new value. A counter whose last failure is older than
+	// window starts over.
+	IncrLockoutFailures(subjectType, subject string, now time.Time, window time.Duration) (uint, error)
+	GetLockoutState(subjectType, subject string) (failures uint, lastFailure time.Time, lockedUntil sql.NullTime, err error) // zero values if none
+	// DecrLockoutFailures takes failures off the counter, down to 0 at most
+	DecrLockoutFailures(subjectType, subject string, failures uint) error
+	SetLockedUntil(subjectType, subject string, lockedUntil time.Time) error
+	ClearLockout(subjectType, subject string) error
+}
+
+type InvitationStore interface {
+	NewInvitation(invitation *AffiliationInvitation) error // sets the ID of invitation
+	GetInvitationByTokenHash(tokenHash string) (*AffiliationInvitation, error)
+	ListInvitationsByAffiliationID(affiliationID uint64) ([]*AffiliationInvitation, error) // newest first
+	UpdateInvitationStatus(invitationID uint64, status uint8) error
+	RenewInvitation(invitationID uint64, tokenHash string, expiresAt time.Time) error
+	// AcceptInvitation sets the affiliation and applies roleDelta to the role
+	// of the user, and marks the invitation accepted atomically. It fails with
+	// ErrInvitationNotPending or ErrInvitationAlreadyAffiliated if the
+	// invitation is no longer pending or the user is in another affiliation.
+	AcceptInvitation(invitation *AffiliationInvitation, userID uint64, roleDelta RoleDelta) error
+}
+
+type RoleGrantStore interface {
+	NewRoleGrant(grant *RoleGrant) error // sets the ID of grant
+	GetRoleGrantByID(grantID uint64) (*RoleGrant, error)
+	// ActiveTemporaryRole combines all temporary grants in effect for the user
+	ActiveTemporaryRole(userID uint64, now time.Time) (Role, error)
+	ListRoleGrantsByUserID(userID uint64) ([]*RoleGrant, error) // newest first
+	// ListRoleGrantsByRole lists grants (or revocations) touching any bit of role, newest first
+	ListRoleGrantsByRole(role Role) ([]*RoleGrant, error)
+	UpdateRoleGrantStatus(grantID uint64, status uint8) error
+	// ExpireRoleGrants marks all lapsed temporary grants as expired
+	// and returns the affected user IDs
+	ExpireRoleGrants(now time.Time) ([]uint64, error)
+}
+
+// CustomRoleStore lists custom roles by name. Deleting a custom role, a user
+// or an affiliation removes the assignments with it.
+type CustomRoleStore interface {
+	NewCustomRole(customRole *CustomRole) error // sets the ID of customRole
+	GetCustomRoleByID(customRoleID uint64) (*CustomRole, error)
+	GetCustomRoleByName(name string) (*CustomRole, error)
+	ListCustomRoles() ([]*CustomRole, error)
+	// ListCustomRolesByUser lists custom roles assigned to the user directly or through the affiliation
+	ListCustomRolesByUser(userID, affiliationID uint64) ([]*CustomRole, error)
+	ListCustomRolesByAffiliation(affiliationID uint64) ([]*CustomRole, error)
+	UpdateCustomRole(customRole *CustomRole) error
+	DeleteCustomRole(customRoleID uint64) error
+	AssignCustomRoleToUser(customRoleID, userID uint64) error // no-op if assigned already
+	UnassignCustomRoleFromUser(customRoleID, userID uint64) error
+	AssignCustomRoleToAffiliation(customRoleID, affiliationID uint64) error // no-op if assigned already
+	UnassignCustomRoleFromAffiliation(customRoleID, affiliationID uint64) error
+}
+
+type UserKeyStore interface {
+	NewUserKey(key *UserKey) error // sets the ID of key
+	// ListUserKeys lists keys of the user, oldest first. Revoked keys are included if all is true.
+	ListUserKeys(userID uint64, all bool) ([]*UserKey, error)
+	UserKeyExists(userID uint64, publicKey string) (bool, error) // revoked keys included
+	TouchUserKey(keyID uint64, lastUsedAt time.Time) error
+	UpdateUserKeyLabel(keyID uint64, label string) error
+	// RevokeUserKey revokes the key and replaces the primary key of the user
+	// (User.PublicKey) with primaryKey atomically. It fails with
+	// ErrUserKeyNotFound if the key is not an unrevoked key of the user.
+	RevokeUserKey(userID, keyID uint64, revokedAt time.Time, primaryKey string) error
+}
+
+// ExternalIdentityStore compares providers and subjects case-sensitively.
+type ExternalIdentityStore interface {
+	NewExternalIdentity(identity *ExternalIdentity) error                    // sets the ID of identity
+	GetExternalIdentity(provider, subject string) (*ExternalIdentity, error) // ErrExternalIdentityNotFound if none
+	ListExternalIdentities(userID uint64) ([]*ExternalIdentity, error)       // oldest first
+	ListProviderIdentities(provider string) ([]*ExternalIdentity, error)     // oldest first
+	TouchExternalIdentity(identityID uint64, lastUsedAt time.Time) error
+	DeleteExternalIdentity(identityID uint64) error
+}
+
+type EmailVerificationStore interface {
+	// GetVerifiedEmail returns the last email address verified by the user, if any
+	GetVerifiedEmail(userID uint64) (email string, verifiedAt time.Time, err error)
+	SetVerifiedEmail(userID uint64, email string, verifiedAt time.Time) error
+	// ChangeVerifiedEmail changes the email of the user and marks it verified atomically
+	ChangeVerifiedEmail(userID uint64, email string, verifiedAt time.Time) error
+}
+
+type MFARecoveryStore interface {
+	NewMFARecovery(recovery *MFARecovery) error // sets the ID of recovery
+	GetMFARecoveryByID(recoveryID uint64) (*MFARecovery, error)
+	GetMFARecoveryByCancelTokenHash(cancelTokenHash string) (*MFARecovery, error)
+	ListMFARecoveriesByUserID(userID uint64) ([]*MFARecovery, error) // newest first
+	ListMFARecoveriesByStatus(status uint8) ([]*MFARecovery, error)  // oldest first
+	// DecideMFARecovery moves a pending recovery to status. It fails with
+	// ErrMFARecoveryNotPending if the recovery was decided concurrently.
+	DecideMFARecovery(recoveryID uint64, status uint8, deciderUserID uint64, decidedAt time.Time) error
+	NewMFARecoveryLog(entry *MFARecoveryLogEntry) error
+	ListMFARecoveryLog(recoveryID uint64) ([]*MFARecoveryLogEntry, error) // oldest first
+}
+
+type DeactivationStore interface {
+	GetDeactivation(userID uint64) (*Deactivation, error)
+	NewDeactivation(deactivation *Deactivation) error
+	UpdateErasureAt(userID uint64, erasureAt time.Time) error // no-op once erased
+	DeleteDeactivation(userID uint64) error                   // no-op once erased
+	ListUserIDsDueForErasure(now time.Time) ([]uint64, error)
+	// AnonymizeUser scrubs PII of the user atomically. The user is kept with
+	// the pseudonym in place of the email, so financial records keyed by the
+	// user ID remain valid. Invitations to the email are deleted, and notes
+	// of MFA recoveries and the audit trail are scrubbed.
+	AnonymizeUser(userID uint64, pseudonym string, erasedAt time.Time) error
+}
+
+type AuditStore interface {
+	NewAuditEvent(event *AuditEvent) error                      // sets the ID of event
+	QueryAuditEvents(filter AuditFilter) ([]*AuditEvent, error) // newest first
+	// ScrubAuditIPs clears the IP and user agent of events older than before
+	ScrubAuditIPs(before time.Time) error
+}
+
+var (
+	_ Store = (*MySQLStore)(nil)
+	_ Store = (*SQLiteStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+
+	_ SQLStore = (*MySQLStore)(nil)
+	_ SQLStore = (*SQLiteStore)(nil)
+
+	_ EphemeralStore = (*sqlEphemeralStore)(nil)
+	_ EphemeralStore = (*MemoryEphemeralStore)(nil)
+)
+
+var (
+	store Store
+
+	ErrStoreDuplicate = errors.New("auth: duplicate entry")
+)
+
+/************ MFA ************/
+
+// Create
+func InitMFA(userID uint64, extentionType, extentionData string) error {
+	return store.InitMFA(userID, extentionType, extentionData)
+}
+
+// Read
+func CheckoutMFA(userID uint64, extentionType string) (string, error) {
+	return store.CheckoutMFA(userID, extentionType)
+}
+
+// Read
+func MFAEnabled(userID uint64, extentionType string) (bool, error) {
+	return store.MFAEnabled(userID, extentionType)
+}
+
+// Update
+func ConfirmMFA(userID uint64, extentionType string) error {
+	return store.ConfirmMFA(userID, extentionType)
+}
+
+// Update
+func UpdateMFA(userID uint64, extentionType, extentionData string) error {
+	return store.UpdateMFA(userID, extentionType, extentionData)
+}
+
+// Delete
+func ClearMFA(userID uint64, extentionType string) error {
+	return store.ClearMFA(userID, extentionType)
+}
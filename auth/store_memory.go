@@ -0,0 +1,557 @@
+package auth
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryMFAKey struct {
+	userID        uint64
+	extentionType string
+}
+
+type memoryMFA struct {
+	extentionData string
+	enabled       bool
+}
+
+// MemoryStore keeps everything in memory. It is meant for unit tests.
+type MemoryStore struct {
+	mutex sync.RWMutex
+
+	lastUserID        uint64
+	users             map[uint64]User
+	userInfos         map[uint64]UserInfo
+	lastAffiliationID uint64
+	affiliations      map[uint64]Affiliation
+	mfas              map[memoryMFAKey]memoryMFA
+	ephemeral         *MemoryEphemeralStore
+
+	mfaPolicySince         map[memoryMFAPolicyKey]time.Time
+	mfaPreferences         map[uint64]string
+	lockouts               map[memoryLockoutKey]memoryLockout
+	lastInvitationID       uint64
+	invitations            map[uint64]AffiliationInvitation
+	lastRoleGrantID        uint64
+	roleGrants             map[uint64]RoleGrant
+	lastCustomRoleID       uint64
+	customRoles            map[uint64]CustomRole
+	customRoleUsers        map[memoryCustomRoleKey]bool
+	customRoleAffiliations map[memoryCustomRoleKey]bool
+	lastUserKeyID          uint64
+	userKeys               map[uint64]UserKey
+	lastExternalIdentityID uint64
+	externalIdentities     map[uint64]ExternalIdentity
+	verifiedEmails         map[uint64]memoryVerifiedEmail
+	lastMFARecoveryID      uint64
+	mfaRecoveries          map[uint64]MFARecovery
+	mfaRecoveryLog         []MFARecoveryLogEntry // oldest first
+	deactivations          map[uint64]Deactivation
+	auditEvents            []AuditEvent // oldest first, the ID is the index + 1
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:        map[uint64]User{},
+		userInfos:    map[uint64]UserInfo{},
+		affiliations: map[uint64]Affiliation{},
+		mfas:         map[memoryMFAKey]memoryMFA{},
+		ephemeral:    NewMemoryEphemeralStore(),
+
+		mfaPolicySince:         map[memoryMFAPolicyKey]time.Time{},
+		mfaPreferences:         map[uint64]string{},
+		lockouts:               map[memoryLockoutKey]memoryLockout{},
+		invitations:            map[uint64]AffiliationInvitation{},
+		roleGrants:             map[uint64]RoleGrant{},
+		customRoles:            map[uint64]CustomRole{},
+		customRoleUsers:        map[memoryCustomRoleKey]bool{},
+		customRoleAffiliations: map[memoryCustomRoleKey]bool{},
+		userKeys:               map[uint64]UserKey{},
+		externalIdentities:     map[uint64]ExternalIdentity{},
+		verifiedEmails:         map[uint64]memoryVerifiedEmail{},
+		mfaRecoveries:          map[uint64]MFARecovery{},
+		deactivations:          map[uint64]Deactivation{},
+	}
+}
+
+func (s *MemoryStore) Init() error {
+	return nil
+}
+
+func (s *MemoryStore) Ephemeral() EphemeralStore {
+	return s.ephemeral
+}
+
+/************ User ************/
+
+func (s *MemoryStore) NewUser(user *User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.emailExists(user.Email) {
+		return ErrStoreDuplicate
+	}
+	s.lastUserID++
+	user.id = s.lastUserID
+	s.users[user.id] = User{
+		id:            user.id,
+		Email:         user.Email,
+		PublicKey:     user.PublicKey,
+		Role:          user.Role,
+		AffiliationID: user.AffiliationID,
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetUserByID(userID uint64) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) GetUserByEmail(email string) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) GetUsersByAffiliationID(affiliationID uint64) ([]*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var users []*User
+	for _, userID := range s.userIDs(affiliationID, true) {
+		user := s.users[userID]
+		users = append(users, &user)
+	}
+	return users, nil
+}
+
+func (s *MemoryStore) ListUserID() ([]uint64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.userIDs(0, false), nil
+}
+
+func (s *MemoryStore) ListUserIDByAffiliationID(affiliationID uint64) ([]uint64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.userIDs(affiliationID, true), nil
+}
+
+func (s *MemoryStore) EmailExists(email string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.emailExists(email), nil
+}
+
+func (s *MemoryStore) UpdateUser(user *User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, ok := s.users[user.id]
+	if !ok {
+		return nil
+	}
+	if stored.Email != user.Email && s.emailExists(user.Email) {
+		return ErrStoreDuplicate
+	}
+	stored.Email = user.Email
+	stored.AffiliationID = user.AffiliationID
+	s.users[user.id] = stored
+	return nil
+}
+
+func (s *MemoryStore) UpdateUserRole(userID uint64, role Role) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.Role = role
+		s.users[userID] = user
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(userID uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.users, userID)
+	delete(s.userInfos, userID)
+	for key := range s.mfaPolicySince {
+		if key.userID == userID {
+			delete(s.mfaPolicySince, key)
+		}
+	}
+	delete(s.mfaPreferences, userID)
+	for grantID, grant := range s.roleGrants {
+		if grant.UserID == userID {
+			delete(s.roleGrants, grantID)
+		}
+	}
+	for key := range s.customRoleUsers {
+		if key.subjectID == userID {
+			delete(s.customRoleUsers, key)
+		}
+	}
+	for keyID, key := range s.userKeys {
+		if key.UserID == userID {
+			delete(s.userKeys, keyID)
+		}
+	}
+	for identityID, identity := range s.externalIdentities {
+		if identity.UserID == userID {
+			delete(s.externalIdentities, identityID)
+		}
+	}
+	delete(s.verifiedEmails, userID)
+	s.deleteMFARecoveries(userID)
+	delete(s.deactivations, userID)
+	return nil
+}
+
+// userIDs lists user IDs in ascending order, optionally of an affiliation only
+func (s *MemoryStore) userIDs(affiliationID uint64, byAffiliation bool) []uint64 {
+	var userIDs []uint64
+	for userID, user := range s.users {
+		if !byAffiliation || user.AffiliationID == affiliationID {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+	return userIDs
+}
+
+func (s *MemoryStore) emailExists(email string) bool {
+	for _, user := range s.users {
+		if user.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+/************ UserInfo ************/
+
+func (s *MemoryStore) NewUserInfo(userID uint64, info *UserInfo) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := s.userInfos[userID]; ok {
+		return ErrStoreDuplicate
+	}
+	s.userInfos[userID] = *info
+	return nil
+}
+
+func (s *MemoryStore) GetUserInfo(userID uint64) (*UserInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	info, ok := s.userInfos[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &info, nil
+}
+
+func (s *MemoryStore) UpdateUserInfo(userID uint64, info *UserInfo) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.userInfos[userID]; ok {
+		s.userInfos[userID] = *info
+	}
+	return nil
+}
+
+/************ Affiliation ************/
+
+func (s *MemoryStore) NewAffiliation(affiliation *Affiliation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.affiliationNameExists(affiliation.Name, 0) {
+		return ErrStoreDuplicate
+	}
+	s.lastAffiliationID++
+	affiliation.id = s.lastAffiliationID
+	s.affiliations[affiliation.id] = *affiliation
+	return nil
+}
+
+func (s *MemoryStore) GetAffiliationByID(affiliationID uint64) (*Affiliation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	affiliation, ok := s.affiliations[affiliationID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &affiliation, nil
+}
+
+func (s *MemoryStore) UpdateAffiliation(affiliation *Affiliation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.affiliations[affiliation.id]; !ok {
+		return nil
+	}
+	if s.affiliationNameExists(affiliation.Name, affiliation.id) {
+		return ErrStoreDuplicate
+	}
+	updated := *affiliation
+	updated.ParentID = s.affiliations[affiliation.id].ParentID
+	s.affiliations[affiliation.id] = updated
+	return nil
+}
+
+func (s *MemoryStore) AffiliationExists(affiliationID uint64) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, ok := s.affiliations[affiliationID]
+	return ok, nil
+}
+
+func (s *MemoryStore) ListChildAffiliations(affiliationID uint64) ([]*Affiliation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.childAffiliations(affiliationID), nil
+}
+
+// ListDescendantAffiliations walks breadth-first. Like the SQL stores, it
+// stops at depth 64 against any cycle already stored.
+func (s *MemoryStore) ListDescendantAffiliations(affiliationID uint64) ([]*Affiliation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var descendants []*Affiliation = []*Affiliation{}
+	var seen map[uint64]bool = map[uint64]bool{}
+	var level []uint64 = []uint64{affiliationID}
+	for depth := 0; depth < 64 && len(level) > 0; depth++ {
+		var nextLevel []uint64
+		for _, parentID := range level {
+			for _, child := range s.childAffiliations(parentID) {
+				if seen[child.id] {
+					continue
+				}
+				seen[child.id] = true
+				descendants = append(descendants, child)
+				nextLevel = append(nextLevel, child.id)
+			}
+		}
+		level = nextLevel
+	}
+	return descendants, nil
+}
+
+func (s *MemoryStore) ListAncestorAffiliations(affiliationID uint64) ([]*Affiliation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var ancestors []*Affiliation = []*Affiliation{}
+	var seen map[uint64]bool = map[uint64]bool{}
+	affiliation, ok := s.affiliations[affiliationID]
+	for depth := 0; ok && depth < 64; depth++ {
+		parent, found := s.affiliations[affiliation.ParentID]
+		if !found || seen[parent.id] {
+			break
+		}
+		seen[parent.id] = true
+		ancestors = append(ancestors, &parent)
+		affiliation = parent
+	}
+	return ancestors, nil
+}
+
+func (s *MemoryStore) MoveAffiliation(affiliationID, newParentID uint64) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	affiliation, ok := s.affiliations[affiliationID]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	ancestorID := newParentID
+	for depth := 0; ancestorID != 0; depth++ {
+		if ancestorID == affiliationID {
+			return 0, ErrAffiliationCycle
+		}
+		if depth >= 64 {
+			return 0, ErrAffiliationTooDeep
+		}
+		ancestor, found := s.affiliations[ancestorID]
+		if !found && ancestorID == newParentID {
+			return 0, ErrAffiliationParentNotFound
+		} else if !found {
+			break
+		}
+		ancestorID = ancestor.ParentID
+	}
+
+	oldParentID := affiliation.ParentID
+	affiliation.ParentID = newParentID
+	s.affiliations[affiliationID] = affiliation
+	return oldParentID, nil
+}
+
+func (s *MemoryStore) DeleteAffiliations(affiliationIDs []uint64, reparentTo *uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, affiliationID := range affiliationIDs {
+		if reparentTo != nil {
+			for _, child := range s.childAffiliations(affiliationID) {
+				child.ParentID = *reparentTo
+				s.affiliations[child.id] = *child
+			}
+		}
+		for userID, user := range s.users {
+			if user.AffiliationID == affiliationID {
+				user.AffiliationID = 0
+				user.Role = user.Role.RemoveRole(AFFILIATION_ROLES)
+				s.users[userID] = user
+			}
+		}
+		for invitationID, invitation := range s.invitations {
+			if invitation.AffiliationID == affiliationID {
+				delete(s.invitations, invitationID)
+			}
+		}
+		for key := range s.customRoleAffiliations {
+			if key.subjectID == affiliationID {
+				delete(s.customRoleAffiliations, key)
+			}
+		}
+		delete(s.affiliations, affiliationID)
+	}
+	return nil
+}
+
+// childAffiliations lists children in ascending order of ID
+func (s *MemoryStore) childAffiliations(affiliationID uint64) []*Affiliation {
+	var children []*Affiliation = []*Affiliation{}
+	for id, affiliation := range s.affiliations {
+		if affiliation.ParentID == affiliationID && id != affiliationID {
+			child := affiliation
+			children = append(children, &child)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].id < children[j].id })
+	return children
+}
+
+func (s *MemoryStore) affiliationNameExists(name string, exceptID uint64) bool {
+	for id, affiliation := range s.affiliations {
+		if affiliation.Name == name && id != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+/************ MFA ************/
+
+func (s *MemoryStore) InitMFA(userID uint64, extentionType, extentionData string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := memoryMFAKey{userID, extentionType}
+	if _, ok := s.mfas[key]; ok {
+		return ErrStoreDuplicate
+	}
+	s.mfas[key] = memoryMFA{extentionData: extentionData}
+	return nil
+}
+
+func (s *MemoryStore) CheckoutMFA(userID uint64, extentionType string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	mfa, ok := s.mfas[memoryMFAKey{userID, extentionType}]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return mfa.extentionData, nil
+}
+
+func (s *MemoryStore) MFAEnabled(userID uint64, extentionType string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	mfa, ok := s.mfas[memoryMFAKey{userID, extentionType}]
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	return mfa.enabled, nil
+}
+
+func (s *MemoryStore) ConfirmMFA(userID uint64, extentionType string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := memoryMFAKey{userID, extentionType}
+	if mfa, ok := s.mfas[key]; ok {
+		mfa.enabled = true
+		s.mfas[key] = mfa
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateMFA(userID uint64, extentionType, extentionData string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := memoryMFAKey{userID, extentionType}
+	if mfa, ok := s.mfas[key]; ok {
+		mfa.extentionData = extentionData
+		s.mfas[key] = mfa
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClearMFA(userID uint64, extentionType string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.mfas, memoryMFAKey{userID, extentionType})
+	return nil
+}
+
+func (s *MemoryStore) ListEnabledMFA(userID uint64) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var extentionTypes []string
+	for key, mfa := range s.mfas {
+		if key.userID == userID && mfa.enabled {
+			extentionTypes = append(extentionTypes, key.extentionType)
+		}
+	}
+	sort.Strings(extentionTypes)
+	return extentionTypes, nil
+}
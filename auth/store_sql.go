@@ -0,0 +1,708 @@
+package auth
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// sqlStore implements Store on database/sql. The queries work on both MySQL
+// (8.0+) and SQLite (3.25+): MySQLStore and SQLiteStore differ in the schema,
+// in forUpdate, which SQLite does without as it serializes transactions, and
+// in the few statements picked by dialect.
+type sqlStore struct {
+	db        *sql.DB
+	tblPrefix string
+	forUpdate string // appended to SELECTs locking the rows read in a transaction
+	sqlite    bool
+}
+
+// dialect picks the query for the database, for the few statements (e.g.
+// upserts) MySQL and SQLite spell differently.
+func (s *sqlStore) dialect(mysqlQuery, sqliteQuery string) string {
+	if s.sqlite {
+		return sqliteQuery
+	}
+	return mysqlQuery
+}
+
+func (s *sqlStore) DB() *sql.DB {
+	return s.db
+}
+
+func (s *sqlStore) TablePrefix() string {
+	return s.tblPrefix
+}
+
+func (s *sqlStore) SQLite() bool {
+	return s.sqlite
+}
+
+func (s *sqlStore) statement(query string) (*sql.Stmt, error) {
+	return s.db.Prepare(strings.ReplaceAll(query, "dbprefix_", s.tblPrefix))
+}
+
+func (s *sqlStore) txStatement(tx *sql.Tx, query string) (*sql.Stmt, error) {
+	return tx.Prepare(strings.ReplaceAll(query, "dbprefix_", s.tblPrefix))
+}
+
+func (s *sqlStore) Ephemeral() EphemeralStore {
+	return &sqlEphemeralStore{s}
+}
+
+func (s *sqlStore) initTables(tblCreations []string) error {
+	if err := s.db.Ping(); err != nil {
+		return err
+	}
+	for _, tblCreation := range tblCreations {
+		stmtCreateTableIfNotExists, err := s.statement(tblCreation)
+		if err != nil {
+			return err
+		}
+		_, err = stmtCreateTableIfNotExists.Exec()
+		stmtCreateTableIfNotExists.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/************ User Database ************/
+
+func (s *sqlStore) NewUser(user *User) error {
+	stmtInsertUser, err := s.statement(`INSERT INTO dbprefix_auth_user (email, publickey, role, affiliation) VALUES (?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertUser.Close()
+
+	result, err := stmtInsertUser.Exec(user.Email, user.PublicKey, user.Role, user.AffiliationID)
+	if err != nil {
+		return err
+	}
+	userid, err := result.LastInsertId()
+	user.id = uint64(userid)
+	return err
+}
+
+func (s *sqlStore) GetUserByID(userID uint64) (*User, error) {
+	stmtGetUserByID, err := s.statement(`SELECT id, email, publickey, role, affiliation FROM dbprefix_auth_user WHERE id = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetUserByID.Close()
+
+	var user User
+	err = stmtGetUserByID.QueryRow(userID).Scan(&user.id, &user.Email, &user.PublicKey, &user.Role, &user.AffiliationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *sqlStore) GetUserByEmail(email string) (*User, error) {
+	stmtGetUserByEmailPassword, err := s.statement(`SELECT id, email, publickey, role, affiliation FROM dbprefix_auth_user WHERE email = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetUserByEmailPassword.Close()
+
+	var user User
+	err = stmtGetUserByEmailPassword.QueryRow(email).Scan(&user.id, &user.Email, &user.PublicKey, &user.Role, &user.AffiliationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *sqlStore) GetUsersByAffiliationID(affiliationID uint64) ([]*User, error) {
+	stmtGetUsersByAffiliationID, err := s.statement(`SELECT id, email, publickey, role, affiliation FROM dbprefix_auth_user WHERE affiliation = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetUsersByAffiliationID.Close()
+
+	rows, err := stmtGetUsersByAffiliationID.Query(affiliationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var user User
+		err = rows.Scan(&user.id, &user.Email, &user.PublicKey, &user.Role, &user.AffiliationID)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (s *sqlStore) ListUserID() ([]uint64, error) {
+	stmtListUserID, err := s.statement(`SELECT id FROM dbprefix_auth_user;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListUserID.Close()
+
+	rows, err := stmtListUserID.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []uint64
+	for rows.Next() {
+		var userID uint64
+		err = rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
+
+func (s *sqlStore) ListUserIDByAffiliationID(affiliationID uint64) ([]uint64, error) {
+	stmtListUserIDByAffiliationID, err := s.statement(`SELECT id FROM dbprefix_auth_user WHERE affiliation = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListUserIDByAffiliationID.Close()
+
+	rows, err := stmtListUserIDByAffiliationID.Query(affiliationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []uint64
+	for rows.Next() {
+		var userID uint64
+		err = rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
+
+func (s *sqlStore) EmailExists(email string) (bool, error) {
+	stmtCheckEmailExists, err := s.statement(`SELECT id FROM dbprefix_auth_user WHERE email = ?;`)
+	if err != nil {
+		return false, err
+	}
+	defer stmtCheckEmailExists.Close()
+
+	var id uint64
+	err = stmtCheckEmailExists.QueryRow(email).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		} else {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (s *sqlStore) UpdateUser(user *User) error {
+	stmtUpdateUser, err := s.statement(`UPDATE dbprefix_auth_user SET email = ?, affiliation = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdateUser.Close()
+
+	_, err = stmtUpdateUser.Exec(user.Email, user.AffiliationID, user.id)
+	return err
+}
+
+func (s *sqlStore) UpdateUserRole(userID uint64, role Role) error {
+	stmtUpdateUserRole, err := s.statement(`UPDATE dbprefix_auth_user SET role = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdateUserRole.Close()
+
+	_, err = stmtUpdateUserRole.Exec(role, userID)
+	return err
+}
+
+func (s *sqlStore) DeleteUser(userID uint64) error {
+	stmtWipeUserData, err := s.statement(`DELETE FROM dbprefix_auth_user WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtWipeUserData.Close()
+
+	_, err = stmtWipeUserData.Exec(userID)
+	return err
+}
+
+/************ UserInfo Database ************/
+
+func (s *sqlStore) NewUserInfo(userID uint64, info *UserInfo) error {
+	stmtInsertUserInfo, err := s.statement(`INSERT INTO dbprefix_auth_user_info (id, first_name, last_name, street_address, suite, city, state, country_iso, zip_code) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertUserInfo.Close()
+
+	_, err = stmtInsertUserInfo.Exec(userID, info.FirstName, info.LastName, info.StreetAddress, info.Suite, info.City, info.State, info.CountryISO, info.ZipCode)
+	return err
+}
+
+func (s *sqlStore) GetUserInfo(userID uint64) (*UserInfo, error) {
+	stmtGetUserInfo, err := s.statement(`SELECT first_name, last_name, street_address, suite, city, state, country_iso, zip_code FROM dbprefix_auth_user_info WHERE id = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetUserInfo.Close()
+
+	var info UserInfo
+	err = stmtGetUserInfo.QueryRow(userID).Scan(&info.FirstName, &info.LastName, &info.StreetAddress, &info.Suite, &info.City, &info.State, &info.CountryISO, &info.ZipCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+func (s *sqlStore) UpdateUserInfo(userID uint64, info *UserInfo) error {
+	stmtUpdateUserInfo, err := s.statement(`UPDATE dbprefix_auth_user_info SET first_name = ?, last_name = ?, street_address = ?, suite = ?, city = ?, state = ?, country_iso = ?, zip_code = ? WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdateUserInfo.Close()
+
+	_, err = stmtUpdateUserInfo.Exec(info.FirstName, info.LastName, info.StreetAddress, info.Suite, info.City, info.State, info.CountryISO, info.ZipCode, userID)
+	return err
+}
+
+/************ Affiliation Database ************/
+
+func scanAffiliations(rows *sql.Rows) ([]*Affiliation, error) {
+	var affiliations []*Affiliation = []*Affiliation{}
+	for rows.Next() {
+		var affiliation Affiliation
+		err := rows.Scan(&affiliation.id, &affiliation.Name, &affiliation.ParentID, &affiliation.OwnerUserID, &affiliation.SharedWalletID, &affiliation.StreetAddress, &affiliation.Suite, &affiliation.City, &affiliation.State, &affiliation.CountryISO, &affiliation.ZipCode, &affiliation.ContactEmail)
+		if err != nil {
+			return nil, err
+		}
+		affiliations = append(affiliations, &affiliation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return affiliations, nil
+}
+
+func (s *sqlStore) GetAffiliationByID(affiliationID uint64) (*Affiliation, error) {
+	stmtGetAffiliationByID, err := s.statement(`SELECT 
+        id, 
+        name, 
+        parent_id,
+        owner_user_id,
+        shared_wallet_id,
+        street_address,
+        suite,
+        city,
+        state,
+        country_iso,
+        zip_code,
+        contact_email
+    FROM dbprefix_auth_affiliation WHERE id = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtGetAffiliationByID.Close()
+
+	var affiliation Affiliation
+	err = stmtGetAffiliationByID.QueryRow(affiliationID).Scan(&affiliation.id, &affiliation.Name, &affiliation.ParentID, &affiliation.OwnerUserID, &affiliation.SharedWalletID, &affiliation.StreetAddress, &affiliation.Suite, &affiliation.City, &affiliation.State, &affiliation.CountryISO, &affiliation.ZipCode, &affiliation.ContactEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	return &affiliation, nil
+}
+
+func (s *sqlStore) NewAffiliation(affiliation *Affiliation) error {
+	stmtInsertAffiliation, err := s.statement(`INSERT INTO dbprefix_auth_affiliation (
+        name,
+        parent_id,
+        owner_user_id,
+        shared_wallet_id,
+        street_address,
+        suite,
+        city,
+        state,
+        country_iso,
+        zip_code,
+        contact_email
+    ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertAffiliation.Close()
+
+	result, err := stmtInsertAffiliation.Exec(affiliation.Name, affiliation.ParentID, affiliation.OwnerUserID, affiliation.SharedWalletID, affiliation.StreetAddress, affiliation.Suite, affiliation.City, affiliation.State, affiliation.CountryISO, affiliation.ZipCode, affiliation.ContactEmail)
+	if err != nil {
+		return err
+	}
+	affiliationID, err := result.LastInsertId()
+	affiliation.id = uint64(affiliationID)
+	return err
+}
+
+func (s *sqlStore) UpdateAffiliation(affiliation *Affiliation) error {
+	stmtUpdateAffiliation, err := s.statement(`UPDATE dbprefix_auth_affiliation SET
+        name = ?,
+        owner_user_id = ?,
+        shared_wallet_id = ?,
+        street_address = ?,
+        suite = ?,
+        city = ?,
+        state = ?,
+        country_iso = ?,
+        zip_code = ?,
+        contact_email = ?
+    WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdateAffiliation.Close()
+
+	_, err = stmtUpdateAffiliation.Exec(affiliation.Name, affiliation.OwnerUserID, affiliation.SharedWalletID, affiliation.StreetAddress, affiliation.Suite, affiliation.City, affiliation.State, affiliation.CountryISO, affiliation.ZipCode, affiliation.ContactEmail, affiliation.id)
+	return err
+}
+
+func (s *sqlStore) AffiliationExists(affiliationID uint64) (bool, error) {
+	stmtCheckAffiliationExists, err := s.statement(`SELECT id FROM dbprefix_auth_affiliation WHERE id = ?;`)
+	if err != nil {
+		return false, err
+	}
+	defer stmtCheckAffiliationExists.Close()
+
+	var id uint64
+	err = stmtCheckAffiliationExists.QueryRow(affiliationID).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		} else {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (s *sqlStore) ListChildAffiliations(affiliationID uint64) ([]*Affiliation, error) {
+	stmtListChildAffiliations, err := s.statement(`SELECT 
+        id, name, parent_id, owner_user_id, shared_wallet_id, street_address, suite, city, state, country_iso, zip_code, contact_email
+    FROM dbprefix_auth_affiliation WHERE parent_id = ?;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListChildAffiliations.Close()
+
+	rows, err := stmtListChildAffiliations.Query(affiliationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAffiliations(rows)
+}
+
+// ListDescendantAffiliations uses a recursive CTE.
+// The depth guard protects against any cycle already in the table.
+func (s *sqlStore) ListDescendantAffiliations(affiliationID uint64) ([]*Affiliation, error) {
+	stmtListDescendantAffiliations, err := s.statement(`WITH RECURSIVE descendants (id, depth) AS (
+        SELECT id, 1 FROM dbprefix_auth_affiliation WHERE parent_id = ? AND id <> parent_id
+        UNION ALL
+        SELECT a.id, d.depth + 1 FROM dbprefix_auth_affiliation a
+        INNER JOIN descendants d ON a.parent_id = d.id
+        WHERE d.depth < 64
+    )
+    SELECT a.id, a.name, a.parent_id, a.owner_user_id, a.shared_wallet_id, a.street_address, a.suite, a.city, a.state, a.country_iso, a.zip_code, a.contact_email
+    FROM dbprefix_auth_affiliation a INNER JOIN (SELECT id, MIN(depth) AS depth FROM descendants GROUP BY id) d ON a.id = d.id
+    ORDER BY d.depth, a.id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListDescendantAffiliations.Close()
+
+	rows, err := stmtListDescendantAffiliations.Query(affiliationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAffiliations(rows)
+}
+
+// ListAncestorAffiliations uses a recursive CTE.
+// Ancestors are ordered from the direct parent to the root.
+func (s *sqlStore) ListAncestorAffiliations(affiliationID uint64) ([]*Affiliation, error) {
+	stmtListAncestorAffiliations, err := s.statement(`WITH RECURSIVE ancestors (id, parent_id, depth) AS (
+        SELECT p.id, p.parent_id, 1 FROM dbprefix_auth_affiliation p
+        INNER JOIN dbprefix_auth_affiliation c ON c.parent_id = p.id
+        WHERE c.id = ?
+        UNION ALL
+        SELECT p.id, p.parent_id, an.depth + 1 FROM dbprefix_auth_affiliation p
+        INNER JOIN ancestors an ON an.parent_id = p.id
+        WHERE an.depth < 64
+    )
+    SELECT a.id, a.name, a.parent_id, a.owner_user_id, a.shared_wallet_id, a.street_address, a.suite, a.city, a.state, a.country_iso, a.zip_code, a.contact_email
+    FROM dbprefix_auth_affiliation a INNER JOIN (SELECT id, MIN(depth) AS depth FROM ancestors GROUP BY id) an ON a.id = an.id
+    ORDER BY an.depth;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtListAncestorAffiliations.Close()
+
+	rows, err := stmtListAncestorAffiliations.Query(affiliationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAffiliations(rows)
+}
+
+// MoveAffiliation walks up from the new parent with the rows locked, and
+// moves the affiliation in the same transaction.
+func (s *sqlStore) MoveAffiliation(affiliationID, newParentID uint64) (uint64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmtGetParent, err := s.txStatement(tx, `SELECT parent_id FROM dbprefix_auth_affiliation WHERE id = ?`+s.forUpdate+`;`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmtGetParent.Close()
+
+	var oldParentID uint64
+	err = stmtGetParent.QueryRow(affiliationID).Scan(&oldParentID)
+	if err != nil {
+		return 0, err
+	}
+
+	ancestorID := newParentID
+	for depth := 0; ancestorID != 0; depth++ {
+		if ancestorID == affiliationID {
+			return 0, ErrAffiliationCycle
+		}
+		if depth >= 64 {
+			return 0, ErrAffiliationTooDeep
+		}
+		var parentID uint64
+		err = stmtGetParent.QueryRow(ancestorID).Scan(&parentID)
+		if err == sql.ErrNoRows && ancestorID == newParentID {
+			return 0, ErrAffiliationParentNotFound
+		} else if err == sql.ErrNoRows {
+			break // dangling parent_id, as left by DeleteAffiliations without reparenting
+		} else if err != nil {
+			return 0, err
+		}
+		ancestorID = parentID
+	}
+
+	stmtMoveAffiliation, err := s.txStatement(tx, `UPDATE dbprefix_auth_affiliation SET parent_id = ? WHERE id = ?;`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmtMoveAffiliation.Close()
+
+	_, err = stmtMoveAffiliation.Exec(newParentID, affiliationID)
+	if err != nil {
+		return 0, err
+	}
+	return oldParentID, tx.Commit()
+}
+
+func (s *sqlStore) DeleteAffiliations(affiliationIDs []uint64, reparentTo *uint64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmtReparent, err := s.txStatement(tx, `UPDATE dbprefix_auth_affiliation SET parent_id = ? WHERE parent_id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtReparent.Close()
+
+	stmtDetachUsers, err := s.txStatement(tx, `UPDATE dbprefix_auth_user SET affiliation = 0, role = role & ~? WHERE affiliation = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtDetachUsers.Close()
+
+	// Rows referencing the affiliation are deleted explicitly, as SQLite
+	// enforces foreign keys only with `PRAGMA foreign_keys = ON`.
+	var stmtsDelete []*sql.Stmt
+	for _, query := range []string{
+		`DELETE FROM dbprefix_auth_affiliation_invitation WHERE affiliationID = ?;`,
+		`DELETE FROM dbprefix_auth_custom_role_affiliation WHERE affiliationID = ?;`,
+		`DELETE FROM dbprefix_auth_affiliation WHERE id = ?;`,
+	} {
+		stmt, err := s.txStatement(tx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		stmtsDelete = append(stmtsDelete, stmt)
+	}
+
+	for _, affiliationID := range affiliationIDs {
+		if reparentTo != nil {
+			if _, err = stmtReparent.Exec(*reparentTo, affiliationID); err != nil {
+				return err
+			}
+		}
+		if _, err = stmtDetachUsers.Exec(uint32(AFFILIATION_ROLES), affiliationID); err != nil {
+			return err
+		}
+		for _, stmt := range stmtsDelete {
+			if _, err = stmt.Exec(affiliationID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+/************ MFA Database ************/
+
+// Create
+func (s *sqlStore) InitMFA(userID uint64, extentionType, extentionData string) error {
+	stmtInsertExtention, err := s.statement(`INSERT INTO dbprefix_auth_mfa (userID, extentionType, extentionData) VALUES (?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmtInsertExtention.Close()
+
+	_, err = stmtInsertExtention.Exec(userID, extentionType, extentionData)
+	return err
+}
+
+// Read
+func (s *sqlStore) CheckoutMFA(userID uint64, extentionType string) (string, error) {
+	stmtCheckoutExtentionData, err := s.statement(`SELECT extentionData FROM dbprefix_auth_mfa WHERE userID = ? AND extentionType = ?;`)
+	if err != nil {
+		return "", err
+	}
+	defer stmtCheckoutExtentionData.Close()
+
+	var extentionData string
+	err = stmtCheckoutExtentionData.QueryRow(userID, extentionType).Scan(&extentionData)
+	if err != nil {
+		return "", err
+	}
+
+	return extentionData, nil
+}
+
+// Read
+func (s *sqlStore) MFAEnabled(userID uint64, extentionType string) (bool, error) {
+	stmtCheckIfEnabled, err := s.statement(`SELECT enabled FROM dbprefix_auth_mfa WHERE userID = ? AND extentionType = ?;`)
+	if err != nil {
+		return false, err
+	}
+	defer stmtCheckIfEnabled.Close()
+
+	var enabled bool
+	err = stmtCheckIfEnabled.QueryRow(userID, extentionType).Scan(&enabled)
+	if err != nil {
+		return false, err
+	}
+
+	return enabled, nil
+}
+
+// Update
+func (s *sqlStore) ConfirmMFA(userID uint64, extentionType string) error {
+	stmtConfirmExtention, err := s.statement(`UPDATE dbprefix_auth_mfa SET enabled = TRUE WHERE userID = ? AND extentionType = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtConfirmExtention.Close()
+
+	_, err = stmtConfirmExtention.Exec(userID, extentionType)
+	return err
+}
+
+// Update
+func (s *sqlStore) UpdateMFA(userID uint64, extentionType, extentionData string) error {
+	stmtUpdateExtention, err := s.statement(`UPDATE dbprefix_auth_mfa SET extentionData = ? WHERE userID = ? AND extentionType = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtUpdateExtention.Close()
+
+	_, err = stmtUpdateExtention.Exec(extentionData, userID, extentionType)
+	return err
+}
+
+// Delete
+func (s *sqlStore) ClearMFA(userID uint64, extentionType string) error {
+	stmtClearExtention, err := s.statement(`DELETE FROM dbprefix_auth_mfa WHERE userID = ? AND extentionType = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmtClearExtention.Close()
+
+	_, err = stmtClearExtention.Exec(userID, extentionType)
+	return err
+}
+
+func (s *sqlStore) ListEnabledMFA(userID uint64) ([]string, error) {
+	stmtCheckEnabledMFA, err := s.statement(`SELECT extentionType FROM dbprefix_auth_mfa WHERE userID = ? AND enabled = TRUE;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmtCheckEnabledMFA.Close()
+
+	rows, err := stmtCheckEnabledMFA.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var extentionTypes []string
+	for rows.Next() {
+		var extentionType string
+		err = rows.Scan(&extentionType)
+		if err != nil {
+			return nil, err
+		}
+		extentionTypes = append(extentionTypes, extentionType)
+	}
+
+	return extentionTypes, nil
+}
@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"database/sql"
+)
+
+const (
+	sqliteUserTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_user (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        email VARCHAR(128) NOT NULL UNIQUE COLLATE NOCASE,
+        publickey VARCHAR(64) NOT NULL,
+        role INTEGER NOT NULL DEFAULT 0,
+        affiliation INTEGER NOT NULL DEFAULT 0
+    );`
+	sqliteUserInfoTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_user_info (
+        id INTEGER PRIMARY KEY REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE,
+        first_name VARCHAR(64) NOT NULL,
+        last_name VARCHAR(64) NOT NULL,
+        street_address VARCHAR(128) NOT NULL,
+        suite VARCHAR(64) NOT NULL,
+        city VARCHAR(64) NOT NULL,
+        state VARCHAR(64) NOT NULL,
+        country_iso VARCHAR(8) NOT NULL,
+        zip_code VARCHAR(16) NOT NULL
+    );`
+	sqliteAffiliationTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_affiliation (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name VARCHAR(64) NOT NULL UNIQUE,
+        parent_id INTEGER NOT NULL DEFAULT 0,
+        owner_user_id INTEGER NOT NULL,
+        shared_wallet_id INTEGER NOT NULL,
+        street_address VARCHAR(128) NOT NULL,
+        suite VARCHAR(64) NOT NULL,
+        city VARCHAR(64) NOT NULL,
+        state VARCHAR(64) NOT NULL,
+        country_iso VARCHAR(8) NOT NULL,
+        zip_code VARCHAR(16) NOT NULL,
+        contact_email VARCHAR(128) NOT NULL
+    );`
+	sqliteMfaTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_mfa (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        userID INTEGER NOT NULL,
+        extentionType VARCHAR(32) NOT NULL,
+        extentionData TEXT NOT NULL,
+        enabled BOOLEAN NOT NULL DEFAULT FALSE,
+        UNIQUE (userID, extentionType)
+    );`
+	sqliteEphemeralTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_ephemeral (
+        entryKey VARCHAR(255) NOT NULL PRIMARY KEY,
+        entryValue TEXT NOT NULL,
+        expiresAt DATETIME NOT NULL
+    );`
+	sqliteEphemeralIndexCreation    = `CREATE INDEX IF NOT EXISTS dbprefix_auth_ephemeral_expiresAt ON dbprefix_auth_ephemeral (expiresAt);`
+	sqliteMfaPolicyStateTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_mfa_policy_state (
+        userID INTEGER NOT NULL REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE,
+        policyName VARCHAR(64) NOT NULL,
+        since DATETIME NOT NULL,
+        PRIMARY KEY (userID, policyName)
+    );`
+	sqliteMfaPreferenceTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_mfa_preference (
+        userID INTEGER PRIMARY KEY REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE,
+        extentionType VARCHAR(32) NOT NULL
+    );`
+	sqliteLockoutTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_lockout (
+        subjectType VARCHAR(8) NOT NULL,
+        subject VARCHAR(128) NOT NULL,
+        failures INTEGER NOT NULL DEFAULT 0,
+        lastFailure DATETIME NOT NULL,
+        lockedUntil DATETIME NULL DEFAULT NULL,
+        PRIMARY KEY (subjectType, subject)
+    );`
+	sqliteInvitationTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_affiliation_invitation (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        affiliationID INTEGER NOT NULL REFERENCES dbprefix_auth_affiliation(id) ON DELETE CASCADE,
+        email VARCHAR(128) NOT NULL COLLATE NOCASE,
+        role INTEGER NOT NULL DEFAULT 0,
+        inviterUserID INTEGER NOT NULL,
+        token VARCHAR(64) NOT NULL UNIQUE,
+        status INTEGER NOT NULL DEFAULT 0,
+        createdAt DATETIME NOT NULL,
+        expiresAt DATETIME NOT NULL
+    );`
+	sqliteInvitationIndexCreation = `CREATE INDEX IF NOT EXISTS dbprefix_auth_affiliation_invitation_email ON dbprefix_auth_affiliation_invitation (email);`
+	sqliteRoleGrantTblCreation    = `CREATE TABLE IF NOT EXISTS dbprefix_auth_role_grant (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        userID INTEGER NOT NULL REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE,
+        granted INTEGER NOT NULL DEFAULT 0,
+        revoked INTEGER NOT NULL DEFAULT 0,
+        granterUserID INTEGER NOT NULL,
+        reason VARCHAR(255) NOT NULL,
+        createdAt DATETIME NOT NULL,
+        startsAt DATETIME NOT NULL,
+        expiresAt DATETIME NULL DEFAULT NULL,
+        status INTEGER NOT NULL DEFAULT 0
+    );`
+	sqliteRoleGrantIndexCreation = `CREATE INDEX IF NOT EXISTS dbprefix_auth_role_grant_userID ON dbprefix_auth_role_grant (userID);`
+	sqliteCustomRoleTblCreation  = `CREATE TABLE IF NOT EXISTS dbprefix_auth_custom_role (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name VARCHAR(64) NOT NULL UNIQUE,
+        description VARCHAR(255) NOT NULL,
+        permissions TEXT NOT NULL
+    );`
+	sqliteCustomRoleUserTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_custom_role_user (
+        roleID INTEGER NOT NULL REFERENCES dbprefix_auth_custom_role(id) ON DELETE CASCADE,
+        userID INTEGER NOT NULL REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE,
+        PRIMARY KEY (roleID, userID)
+    );`
+	sqliteCustomRoleAffiliationTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_custom_role_affiliation (
+        roleID INTEGER NOT NULL REFERENCES dbprefix_auth_custom_role(id) ON DELETE CASCADE,
+        affiliationID INTEGER NOT NULL REFERENCES dbprefix_auth_affiliation(id) ON DELETE CASCADE,
+        PRIMARY KEY (roleID, affiliationID)
+    );`
+	sqliteUserKeyTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_user_key (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        userID INTEGER NOT NULL REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE,
+        publicKey VARCHAR(64) NOT NULL,
+        label VARCHAR(64) NOT NULL,
+        createdAt DATETIME NOT NULL,
+        lastUsedAt DATETIME NULL DEFAULT NULL,
+        revokedAt DATETIME NULL DEFAULT NULL,
+        UNIQUE (userID, publicKey)
+    );`
+	sqliteExternalIdentityTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_external_identity (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        userID INTEGER NOT NULL REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE,
+        provider VARCHAR(64) NOT NULL,
+        subject VARCHAR(255) NOT NULL,
+        email VARCHAR(128) NOT NULL,
+        linkedAt DATETIME NOT NULL,
+        lastUsedAt DATETIME NULL DEFAULT NULL,
+        UNIQUE (provider, subject)
+    );`
+	sqliteExternalIdentityIndexCreation = `CREATE INDEX IF NOT EXISTS dbprefix_auth_external_identity_userID ON dbprefix_auth_external_identity (userID);`
+	sqliteEmailVerifiedTblCreation      = `CREATE TABLE IF NOT EXISTS dbprefix_auth_email_verified (
+        userID INTEGER PRIMARY KEY REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE,
+        email VARCHAR(128) NOT NULL COLLATE NOCASE,
+        verifiedAt DATETIME NOT NULL
+    );`
+	sqliteMfaRecoveryTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_mfa_recovery (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        userID INTEGER NOT NULL REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE,
+        status INTEGER NOT NULL DEFAULT 0,
+        cancelToken VARCHAR(64) NOT NULL UNIQUE,
+        requestedAt DATETIME NOT NULL,
+        eligibleAt DATETIME NOT NULL,
+        decidedAt DATETIME NULL DEFAULT NULL,
+        deciderUserID INTEGER NOT NULL DEFAULT 0
+    );`
+	sqliteMfaRecoveryLogTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_mfa_recovery_log (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        recoveryID INTEGER NOT NULL REFERENCES dbprefix_auth_mfa_recovery(id) ON DELETE CASCADE,
+        actorUserID INTEGER NOT NULL,
+        action VARCHAR(16) NOT NULL,
+        note VARCHAR(255) NOT NULL,
+        createdAt DATETIME NOT NULL
+    );`
+	sqliteDeactivationTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_user_deactivation (
+        userID INTEGER PRIMARY KEY REFERENCES dbprefix_auth_user(id) ON DELETE CASCADE,
+        reason VARCHAR(255) NOT NULL,
+        deactivatedAt DATETIME NOT NULL,
+        erasureAt DATETIME NOT NULL,
+        erasedAt DATETIME NULL DEFAULT NULL,
+        pseudonym VARCHAR(32) NOT NULL DEFAULT ''
+    );`
+	sqliteAuditTblCreation = `CREATE TABLE IF NOT EXISTS dbprefix_auth_audit (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        eventType VARCHAR(32) NOT NULL,
+        actorUserID INTEGER NOT NULL DEFAULT 0,
+        subjectUserID INTEGER NOT NULL DEFAULT 0,
+        affiliationID INTEGER NOT NULL DEFAULT 0,
+        ip VARCHAR(64) NOT NULL DEFAULT '',
+        userAgent VARCHAR(255) NOT NULL DEFAULT '',
+        detail TEXT NOT NULL,
+        createdAt DATETIME NOT NULL
+    );`
+	sqliteAuditSubjectIndexCreation = `CREATE INDEX IF NOT EXISTS dbprefix_auth_audit_subject ON dbprefix_auth_audit (subjectUserID, createdAt);`
+	sqliteAuditActorIndexCreation   = `CREATE INDEX IF NOT EXISTS dbprefix_auth_audit_actor ON dbprefix_auth_audit (actorUserID, createdAt);`
+)
+
+var (
+	sqliteTblCreations = []string{
+		sqliteUserTblCreation,
+		sqliteUserInfoTblCreation,
+		sqliteAffiliationTblCreation,
+		sqliteMfaTblCreation,
+		sqliteEphemeralTblCreation,
+		sqliteEphemeralIndexCreation,
+		sqliteMfaPolicyStateTblCreation,
+		sqliteMfaPreferenceTblCreation,
+		sqliteLockoutTblCreation,
+		sqliteInvitationTblCreation,
+		sqliteInvitationIndexCreation,
+		sqliteRoleGrantTblCreation,
+		sqliteRoleGrantIndexCreation,
+		sqliteCustomRoleTblCreation,
+		sqliteCustomRoleUserTblCreation,
+		sqliteCustomRoleAffiliationTblCreation,
+		sqliteUserKeyTblCreation,
+		sqliteExternalIdentityTblCreation,
+		sqliteExternalIdentityIndexCreation,
+		sqliteEmailVerifiedTblCreation,
+		sqliteMfaRecoveryTblCreation,
+		sqliteMfaRecoveryLogTblCreation,
+		sqliteDeactivationTblCreation,
+		sqliteAuditTblCreation,
+		sqliteAuditSubjectIndexCreation,
+		sqliteAuditActorIndexCreation,
+	}
+)
+
+// SQLiteStore is for single-node installs. It requires SQLite 3.25+, and
+// supports all features of this package. Emails compare case-insensitively,
+// as with the default collation of MySQL.
+//
+// The driver is not imported here: open dbConn with any database/sql driver
+// for SQLite. As SQLite allows a single writer, dbConn.SetMaxOpenConns(1) is advised.
+type SQLiteStore struct {
+	sqlStore
+}
+
+func NewSQLiteStore(dbConn *sql.DB, tblPrefix string) *SQLiteStore {
+	return &SQLiteStore{
+		sqlStore: sqlStore{
+			db:        dbConn,
+			tblPrefix: tblPrefix,
+			sqlite:    true,
+		},
+	}
+}
+
+func (s *SQLiteStore) Init() error {
+	return s.initTables(sqliteTblCreations)
+}
+
+// DeleteUser deletes the rows referencing the user explicitly, as SQLite
+// enforces foreign keys only with `PRAGMA foreign_keys = ON` on every connection.
+func (s *SQLiteStore) DeleteUser(userID uint64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM dbprefix_auth_user_info WHERE id = ?;`,
+		`DELETE FROM dbprefix_auth_mfa_policy_state WHERE userID = ?;`,
+		`DELETE FROM dbprefix_auth_mfa_preference WHERE userID = ?;`,
+		`DELETE FROM dbprefix_auth_role_grant WHERE userID = ?;`,
+		`DELETE FROM dbprefix_auth_custom_role_user WHERE userID = ?;`,
+		`DELETE FROM dbprefix_auth_user_key WHERE userID = ?;`,
+		`DELETE FROM dbprefix_auth_external_identity WHERE userID = ?;`,
+		`DELETE FROM dbprefix_auth_email_verified WHERE userID = ?;`,
+		`DELETE FROM dbprefix_auth_mfa_recovery_log WHERE recoveryID IN (SELECT id FROM dbprefix_auth_mfa_recovery WHERE userID = ?);`,
+		`DELETE FROM dbprefix_auth_mfa_recovery WHERE userID = ?;`,
+		`DELETE FROM dbprefix_auth_user_deactivation WHERE userID = ?;`,
+		`DELETE FROM dbprefix_auth_user WHERE id = ?;`,
+	} {
+		stmt, err := s.txStatement(tx, query)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(userID)
+		stmt.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
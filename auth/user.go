@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/ed25519"
 	"encoding/base64"
 	"errors"
 
@@ -15,7 +16,7 @@ type User struct {
 	// Core Functional Info
 	id            uint64
 	Email         string `json:"email"`
-	PublicKey     string `json:"public_key"` // ed25519.PublicKey in BASE64 representation
+	PublicKey     string `json:"public_key"` // Primary ed25519.PublicKey in BASE64 representation. See Keys().
 	Role          Role   `json:"role"`
 	AffiliationID uint64 `json:"affiliation"`
 
@@ -40,7 +41,7 @@ type UserInfo struct {
 // GetUserByID should be called only after
 // the user has been authenticated (Token validated)
 func GetUserByID(id uint64) (*User, error) {
-	user, err := getUserByID(id)
+	user, err := store.GetUserByID(id)
 	if err != nil {
 		return nil, err
 	}
@@ -53,7 +54,7 @@ func GetUserByID(id uint64) (*User, error) {
 // GetUserByEmail should be called for user login
 // return nil, err when error/mismatch
 func GetUserByEmail(email string) (*User, error) {
-	user, err := getUserByEmail(email)
+	user, err := store.GetUserByEmail(email)
 	if err != nil {
 		return nil, err
 	}
@@ -64,7 +65,7 @@ func GetUserByEmail(email string) (*User, error) {
 }
 
 func GetUsersByAffiliationID(affiliationID uint64) ([]*User, error) {
-	users, err := getUsersByAffiliationID(affiliationID)
+	users, err := store.GetUsersByAffiliationID(affiliationID)
 	if err != nil {
 		return nil, err
 	}
@@ -83,11 +84,11 @@ func GetUsersByAffiliationID(affiliationID uint64) ([]*User, error) {
 }
 
 func ListUserID() ([]uint64, error) {
-	return listUserID()
+	return store.ListUserID()
 }
 
 func ListUserIDByAffiliationID(affiliationID uint64) ([]uint64, error) {
-	return listUserIDByAffiliationID(affiliationID)
+	return store.ListUserIDByAffiliationID(affiliationID)
 }
 
 func (user *User) ID() uint64 {
@@ -101,7 +102,7 @@ func (user *User) Create() error {
 		return err
 	}
 	if exist {
-		return errors.New("auth: email already exists")
+		return ErrEmailExists
 	}
 
 	// Check if all fields are valid
@@ -109,36 +110,132 @@ func (user *User) Create() error {
 		return errors.New("auth: email must not be empty")
 	}
 
-	return newUser(user)
+	err = store.NewUser(user)
+	if err != nil {
+		return err
+	}
+
+	if user.PublicKey != "" {
+		_, err = user.activeKeys() // add PublicKey as the first key
+	}
+	return err
 }
 
 // UserEmailExists should be called before submitting user creation form.
 func (user *User) EmailExists() (bool, error) {
-	return emailExists(user.Email)
+	return store.EmailExists(user.Email)
 }
 
 // UpdateUser
+// Changing Email here makes it unverified: use BeginEmailChange instead.
+// PublicKey is not saved: use AddKeyWithSignature/AddKeyWithStepUp and RevokeKey.
+// Role is not saved either: use UpdateRoles or GrantRole. Role is reloaded.
+// AffiliationID may only change while the user holds no AFFILIATION_* roles,
+// see LeaveAffiliation and Invite. Temporary grants of them are revoked then.
+// MFA policies are re-evaluated, as the AffiliationID may have changed.
 func (user *User) Update() error {
-	return updateUser(user)
+	stored, err := store.GetUserByID(user.id)
+	if err != nil {
+		return err
+	}
+	if user.AffiliationID != stored.AffiliationID {
+		if stored.Role&AFFILIATION_ROLES != ROLELESS {
+			return ErrAffiliationRolesHeld
+		}
+		if err = user.revokeTemporaryGrants(0, AFFILIATION_ROLES, "affiliation changed"); err != nil {
+			return err
+		}
+	}
+
+	err = store.UpdateUser(user)
+	if err != nil {
+		return err
+	}
+	user.Role = stored.Role
+
+	_, err = user.MFAEnrollmentState()
+	return err
 }
 
 // Wipe User Data
+// The user row is hard-deleted, and wallets cascade with it: prefer
+// Deactivate(), which erases the user by anonymization.
 func (user *User) Wipe() error {
-	return wipeUserData(user)
+	return store.DeleteUser(user.id)
 }
 
 func (user *User) CreateInfo(info *UserInfo) error {
-	return newUserInfo(user, info)
+	return store.NewUserInfo(user.id, info)
 }
 
 func (user *User) Info() (*UserInfo, error) {
-	return getUserInfo(user.id)
+	return store.GetUserInfo(user.id)
 }
 
 func (user *User) UpdateInfo(info *UserInfo) error {
-	return updateUserInfo(user, info)
+	return store.UpdateUserInfo(user.id, info)
 }
 
-func (user *User) Verify(msg, signature string) error {
-	return signer.Verify(msg, signature, user.pubKey)
+// Verify accepts the signature by any active key of the user,
+// and returns the key matched. It writes nothing: VerifyLogin records
+// when the key was last used.
+func (user *User) Verify(msg, signature string) (*UserKey, error) {
+	keys, err := user.verifiableKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		pubKey, err := base64.StdEncoding.DecodeString(key.PublicKey)
+		if err != nil {
+			continue
+		}
+		if signer.Verify(msg, signature, ed25519.PublicKey(pubKey)) == nil {
+			return key, nil
+		}
+	}
+	return nil, ErrUserKeyNoMatch
+}
+
+// LoginResult of a successful sign-in
+type LoginResult struct {
+	User     *User
+	Key      *UserKey          // the key matched, by VerifyLogin()
+	Identity *ExternalIdentity // the identity signed in with, by VerifyExternalLogin()
+	// MFAEnrollmentRequired is set if an MFA policy is enforced on the user:
+	// the session must allow nothing but MFA enrollment.
+	MFAEnrollmentRequired bool
+}
+
+// VerifyLogin works like Verify, but is protected against brute-force
+// per user and per IP. See CheckLockout(). Deactivated users may not sign in.
+// The result holds the key matched.
+func (user *User) VerifyLogin(msg, signature, ip string) (*LoginResult, error) {
+	if err := CheckUserActive(user.id); err != nil {
+		return nil, err
+	}
+
+	var key *UserKey
+	err := guardAuth(user.id, ip, func() error {
+		var err error
+		key, err = user.Verify(msg, signature)
+		return err
+	})
+	if err != nil {
+		if auditErr := audit(AUDIT_LOGIN_FAILED, user.id, user.id, ip, map[string]interface{}{"error": err.Error()}); auditErr != nil {
+			return nil, auditErr
+		}
+		return nil, err
+	}
+	user.recordKeyUse(key)
+	if err = audit(AUDIT_LOGIN, user.id, user.id, ip, map[string]interface{}{"key_id": key.id}); err != nil {
+		return nil, err
+	}
+
+	result, err := user.newLoginResult()
+	if err != nil {
+		return nil, err
+	}
+	result.Key = key
+	return result, nil
 }
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// A user may hold multiple Ed25519 keys, e.g. one per device. Any active key
+// may sign in. User.PublicKey is the primary key: the oldest active one.
+//
+// A new key is added with a signature over it by an existing key
+// (AddKeyWithSignature), or after a step-up MFA (AddKeyWithStepUp).
+// To rotate a key, add the new key and revoke the old one.
+
+const (
+	DefaultUserKeyLabel = "default"
+)
+
+var (
+	ErrUserKeyInvalid  = errors.New("auth: public key is not a base64-encoded ed25519 key")
+	ErrUserKeyRepeated = errors.New("auth: public key was added to the user already")
+	ErrUserKeyNotFound = errors.New("auth: no such active key")
+	ErrUserKeyLast     = errors.New("auth: the last active key may not be revoked")
+	ErrUserKeyNoMatch  = errors.New("auth: signature matches no active key")
+)
+
+type UserKey struct {
+	id         uint64
+	UserID     uint64     `json:"user_id"`
+	PublicKey  string     `json:"public_key"` // ed25519.PublicKey in BASE64 representation
+	Label      string     `json:"label"`      // e.g. device name
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func (key *UserKey) ID() uint64 {
+	return key.id
+}
+
+func (key *UserKey) Active() bool {
+	return key.RevokedAt == nil
+}
+
+func validUserKey(publicKey string) bool {
+	rawKey, err := base64.StdEncoding.DecodeString(publicKey)
+	return err == nil && len(rawKey) == ed25519.PublicKeySize
+}
+
+// Keys lists all keys of the user including revoked ones, oldest first.
+func (user *User) Keys() ([]*UserKey, error) {
+	return store.ListUserKeys(user.id, true)
+}
+
+// activeKeys lists active keys. Users created before multiple keys were supported
+// have their PublicKey added as the first key.
+func (user *User) activeKeys() ([]*UserKey, error) {
+	keys, err := user.verifiableKeys()
+	if err != nil || len(keys) != 1 || keys[0].id != 0 {
+		return keys, err
+	}
+
+	keys[0].CreatedAt = time.Now()
+	err = store.NewUserKey(keys[0])
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// verifiableKeys lists active keys like activeKeys, but never writes:
+// the PublicKey of a user created before multiple keys were supported is
+// returned unsaved, with a zero ID.
+func (user *User) verifiableKeys() ([]*UserKey, error) {
+	keys, err := store.ListUserKeys(user.id, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) > 0 || user.PublicKey == "" {
+		return keys, nil
+	}
+
+	exists, err := store.UserKeyExists(user.id, user.PublicKey)
+	if err != nil || exists { // all revoked, PublicKey is stale
+		return keys, err
+	}
+	return []*UserKey{{
+		UserID:    user.id,
+		PublicKey: user.PublicKey,
+		Label:     DefaultUserKeyLabel,
+	}}, nil
+}
+
+// recordKeyUse saves the legacy key if it was not yet, and when the key was
+// last used. It is best-effort: the sign-in does not fail with it.
+func (user *User) recordKeyUse(key *UserKey) {
+	if key.id == 0 {
+		keys, err := user.activeKeys()
+		if err != nil || len(keys) != 1 || keys[0].PublicKey != key.PublicKey {
+			return
+		}
+		key.id, key.CreatedAt = keys[0].id, keys[0].CreatedAt
+	}
+
+	now := time.Now()
+	if store.TouchUserKey(key.id, now) == nil {
+		key.LastUsedAt = &now
+	}
+}
+
+func (user *User) addKey(publicKey, label, method string) (*UserKey, error) {
+	if !validUserKey(publicKey) {
+		return nil, ErrUserKeyInvalid
+	}
+	// Migrate the legacy key first, if any
+	if _, err := user.activeKeys(); err != nil {
+		return nil, err
+	}
+	exists, err := store.UserKeyExists(user.id, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrUserKeyRepeated
+	}
+
+	key := &UserKey{
+		UserID:    user.id,
+		PublicKey: publicKey,
+		Label:     label,
+		CreatedAt: time.Now(),
+	}
+	err = store.NewUserKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = audit(AUDIT_KEY_ADD, user.id, user.id, "", map[string]interface{}{"key_id": key.id, "label": label, "method": method})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// AddKeyWithSignature adds publicKey if signature is the signature of
+// publicKey (the base64 string) by any active key of the user.
+func (user *User) AddKeyWithSignature(publicKey, label, signature string) (*UserKey, error) {
+	if _, err := user.Verify(publicKey, signature); err != nil {
+		return nil, err
+	}
+	return user.addKey(publicKey, label, "signature")
+}
+
+// AddKeyWithStepUp adds publicKey if stepUpToken covers STEPUP_USER_KEY.
+func (user *User) AddKeyWithStepUp(publicKey, label, sessionID, stepUpToken string) (*UserKey, error) {
+	if err := VerifyStepUp(user.id, sessionID, stepUpToken, STEPUP_USER_KEY, 0); err != nil {
+		return nil, err
+	}
+	return user.addKey(publicKey, label, "step_up")
+}
+
+// RelabelKey changes the label of an active key.
+func (user *User) RelabelKey(keyID uint64, label string) error {
+	keys, err := user.activeKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.id == keyID {
+			return store.UpdateUserKeyLabel(keyID, label)
+		}
+	}
+	return ErrUserKeyNotFound
+}
+
+// RevokeKey revokes an active key. The last active key may not be revoked.
+// If the primary key is revoked, the next oldest active key becomes primary.
+func (user *User) RevokeKey(keyID uint64) error {
+	keys, err := user.activeKeys()
+	if err != nil {
+		return err
+	}
+
+	var found bool
+	var primaryKey string
+	for _, key := range keys {
+		if key.id == keyID {
+			found = true
+		} else if primaryKey == "" {
+			primaryKey = key.PublicKey
+		}
+	}
+	if !found {
+		return ErrUserKeyNotFound
+	}
+	if primaryKey == "" {
+		return ErrUserKeyLast
+	}
+
+	err = store.RevokeUserKey(user.id, keyID, time.Now(), primaryKey)
+	if err != nil {
+		return err
+	}
+	user.PublicKey = primaryKey
+	user.pubKey, err = base64.StdEncoding.DecodeString(primaryKey)
+	if err != nil {
+		return err
+	}
+	return audit(AUDIT_KEY_REVOKE, user.id, user.id, "", map[string]interface{}{"key_id": keyID})
+}
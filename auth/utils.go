@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// randomToken returns n random bytes in hex representation
+func randomToken(n int) (string, error) {
+	randBytes := make([]byte, n)
+	_, err := rand.Read(randBytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randBytes), nil
+}
+
+// hashToken returns the hex of SHA-256 of a token to be stored in place of it.
+// Tokens are random, so a fast hash suffices.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
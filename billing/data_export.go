@@ -0,0 +1,60 @@
+package billing
+
+import (
+	"database/sql"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
+)
+
+// exportUserData is registered to auth.ExportUserData by Setup().
+// It exports the products, wallet and billing records of the user.
+func exportUserData(userID uint64) (map[string][]auth.DataExportRecord, error) {
+	sections := map[string][]auth.DataExportRecord{
+		"billing.products": {},
+		"billing.wallets":  {},
+		"billing.records":  {},
+	}
+
+	products, err := ListUserProducts(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, product := range products {
+		sections["billing.products"] = append(sections["billing.products"], auth.DataExportRecord{
+			"serial_number":        product.serialNumber,
+			"product_id":           product.ProductID,
+			"owner_affiliation_id": product.OwnerAffiliationID,
+			"wallet_id":            product.WalletID,
+			"billing_cycle":        product.BillingOption.BillingCycle,
+			"price":                product.BillingOption.Price,
+			"date_creation":        product.dateCreation,
+			"date_last_bill":       product.dateLastBill,
+			"date_termination":     product.dateTermination,
+			"terminated":           product.terminated,
+		})
+	}
+
+	wallet, err := userWallet(userID)
+	if err == sql.ErrNoRows {
+		return sections, nil // never had a wallet
+	}
+	if err != nil {
+		return nil, err
+	}
+	sections["billing.wallets"] = append(sections["billing.wallets"], auth.DataExportRecord{
+		"wallet_id": wallet.walletID,
+		"balance":   wallet.balance,
+		"secured":   wallet.secured,
+		"disabled":  wallet.disabled,
+	})
+
+	records, err := ListBillingRecordsByWalletID(wallet.walletID)
+	if err != nil {
+		return nil, err
+	}
+	if sections["billing.records"], err = auth.DataExportRecords(records); err != nil {
+		return nil, err
+	}
+
+	return sections, nil
+}
@@ -0,0 +1,37 @@
+package billing
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrActiveProducts = errors.New("billing: user has active products")
+	ErrWalletNotEmpty = errors.New("billing: user wallet balance is not zero")
+)
+
+// checkErasure is registered to auth.CheckErasure by Setup().
+// Users with active products or a non-zero wallet may not be erased.
+func checkErasure(userID uint64) error {
+	products, err := ListUserProducts(userID)
+	if err != nil {
+		return err
+	}
+	for _, product := range products {
+		if !product.terminated {
+			return ErrActiveProducts
+		}
+	}
+
+	wallet, err := userWallet(userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if wallet.balance != 0 || wallet.secured != 0 {
+		return ErrWalletNotEmpty
+	}
+	return nil
+}
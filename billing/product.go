@@ -4,6 +4,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/TunnelWork/Ulysses.Lib/auth"
 	"github.com/TunnelWork/Ulysses.Lib/server"
 )
 
@@ -12,6 +13,7 @@ var (
 	ErrInvalidOwnerID      = errors.New("billing: invalid owner ID, need OwnerUserID or OwnerAffiliationID")
 	ErrInvalidProductID    = errors.New("billing: invalid product ID")
 	ErrInvalidWalletID     = errors.New("billing: invalid wallet ID")
+	ErrEmailNotVerified    = errors.New("billing: owner user must verify their email before purchasing")
 )
 
 type Product struct {
@@ -125,6 +127,19 @@ func AddProduct(product *Product) (uint64, error) {
 		return 0, ErrInvalidWalletID
 	}
 
+	// Unverified users may not purchase
+	owner, err := auth.GetUserByID(product.OwnerUserID)
+	if err != nil {
+		return 0, err
+	}
+	verified, err := owner.EmailVerified()
+	if err != nil {
+		return 0, err
+	}
+	if !verified {
+		return 0, ErrEmailNotVerified
+	}
+
 	return addProduct(product)
 }
 
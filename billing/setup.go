@@ -2,6 +2,8 @@ package billing
 
 import (
 	"database/sql"
+
+	"github.com/TunnelWork/Ulysses.Lib/auth"
 )
 
 // Setup() of billing package requires:
@@ -17,4 +19,13 @@ func Setup(d *sql.DB, sqlTblPrefix string) {
 
 	// Setup all tables
 	setupMysqlTable()
+
+	// Add billing sections to auth.ExportUserData()
+	if err := auth.RegDataExporter("billing", exportUserData); err != nil && err != auth.ErrDataExporterRepeated {
+		panic(err)
+	}
+	// Refuse auth.User.Erase() while products are active or wallets are not empty
+	if err := auth.RegErasureCheck("billing", checkErasure); err != nil && err != auth.ErrErasureCheckRepeated {
+		panic(err)
+	}
 }
@@ -0,0 +1,186 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ed25519 signing keys of Ulysses itself, e.g. for the ID tokens issued by
+// auth/idp. The latest added key which is not retired is the active key, and
+// signs. Retired keys are still published, so tokens signed before a rotation
+// remain verifiable until the key is removed.
+//
+// To rotate: AddSigningKey(NewSigningKey()), RetireSigningKey(oldKeyID), and
+// RemoveSigningKey(oldKeyID) once the tokens it signed have expired.
+
+var (
+	ErrSigningKeyNotFound = errors.New("security: signing key not found")
+	ErrSigningKeyRepeated = errors.New("security: signing key ID is in use")
+	ErrSigningKeyInvalid  = errors.New("security: signing key seed is invalid")
+	ErrNoActiveSigningKey = errors.New("security: no active signing key")
+)
+
+type SigningKey struct {
+	KeyID      string
+	PrivateKey ed25519.PrivateKey
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+}
+
+type PublicSigningKey struct {
+	KeyID     string
+	PublicKey ed25519.PublicKey
+	Active    bool
+}
+
+var (
+	signingKeyMutex sync.RWMutex
+	signingKeys     []*SigningKey = []*SigningKey{} // in order of addition
+)
+
+// NewSigningKey generates a key with a random KeyID.
+func NewSigningKey() (*SigningKey, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyID := make([]byte, 8)
+	if _, err = rand.Read(keyID); err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		KeyID:      hex.EncodeToString(keyID),
+		PrivateKey: privateKey,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// ExportSeed returns the seed of the key in hex, encrypted by the cipher of
+// SetupCipher if any, to be kept in the configuration.
+func (key *SigningKey) ExportSeed() string {
+	return EncryptPassword(hex.EncodeToString(key.PrivateKey.Seed()))
+}
+
+// ImportSigningKey restores a key from the output of ExportSeed.
+func ImportSigningKey(keyID, exportedSeed string, createdAt time.Time) (*SigningKey, error) {
+	seed, err := hex.DecodeString(DecryptPassword(exportedSeed))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrSigningKeyInvalid
+	}
+	return &SigningKey{
+		KeyID:      keyID,
+		PrivateKey: ed25519.NewKeyFromSeed(seed),
+		CreatedAt:  createdAt,
+	}, nil
+}
+
+// AddSigningKey adds the key. Unless retired, it becomes the active key.
+func AddSigningKey(key *SigningKey) error {
+	if key.KeyID == "" || len(key.PrivateKey) != ed25519.PrivateKeySize {
+		return ErrSigningKeyInvalid
+	}
+	signingKeyMutex.Lock()
+	defer signingKeyMutex.Unlock()
+
+	for _, existing := range signingKeys {
+		if existing.KeyID == key.KeyID {
+			return ErrSigningKeyRepeated
+		}
+	}
+	signingKeys = append(signingKeys, key)
+	return nil
+}
+
+// RetireSigningKey stops the key from signing. It is still published.
+func RetireSigningKey(keyID string) error {
+	signingKeyMutex.Lock()
+	defer signingKeyMutex.Unlock()
+
+	for _, key := range signingKeys {
+		if key.KeyID == keyID {
+			if key.RetiredAt == nil {
+				now := time.Now()
+				key.RetiredAt = &now
+			}
+			return nil
+		}
+	}
+	return ErrSigningKeyNotFound
+}
+
+// RemoveSigningKey stops publishing the key.
+func RemoveSigningKey(keyID string) {
+	signingKeyMutex.Lock()
+	defer signingKeyMutex.Unlock()
+
+	for i, key := range signingKeys {
+		if key.KeyID == keyID {
+			signingKeys = append(signingKeys[:i], signingKeys[i+1:]...)
+			return
+		}
+	}
+}
+
+func activeSigningKey() *SigningKey {
+	for i := len(signingKeys) - 1; i >= 0; i-- {
+		if signingKeys[i].RetiredAt == nil {
+			return signingKeys[i]
+		}
+	}
+	return nil
+}
+
+// ActiveSigningKey returns the key to sign with, e.g. to put its KeyID
+// in a token header before signing.
+func ActiveSigningKey() (*SigningKey, error) {
+	signingKeyMutex.RLock()
+	defer signingKeyMutex.RUnlock()
+
+	key := activeSigningKey()
+	if key == nil {
+		return nil, ErrNoActiveSigningKey
+	}
+	return key, nil
+}
+
+func (key *SigningKey) Sign(message []byte) []byte {
+	return ed25519.Sign(key.PrivateKey, message)
+}
+
+// VerifySignature accepts signatures by any published key, retired or not.
+func VerifySignature(keyID string, message, signature []byte) bool {
+	signingKeyMutex.RLock()
+	defer signingKeyMutex.RUnlock()
+
+	for _, key := range signingKeys {
+		if key.KeyID == keyID {
+			return ed25519.Verify(key.PrivateKey.Public().(ed25519.PublicKey), message, signature)
+		}
+	}
+	return false
+}
+
+// PublicSigningKeys lists all published keys sorted by KeyID, e.g. for a JWKS.
+func PublicSigningKeys() []PublicSigningKey {
+	signingKeyMutex.RLock()
+	defer signingKeyMutex.RUnlock()
+
+	active := activeSigningKey()
+	publicKeys := make([]PublicSigningKey, 0, len(signingKeys))
+	for _, key := range signingKeys {
+		publicKeys = append(publicKeys, PublicSigningKey{
+			KeyID:     key.KeyID,
+			PublicKey: key.PrivateKey.Public().(ed25519.PublicKey),
+			Active:    key == active,
+		})
+	}
+	sort.Slice(publicKeys, func(i, j int) bool {
+		return publicKeys[i].KeyID < publicKeys[j].KeyID
+	})
+	return publicKeys
+}